@@ -0,0 +1,66 @@
+package ciam
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	pathAccount       = "/account"
+	pathAccountExport = "/account/export"
+)
+
+// exportAccount serves the JSON archive with all data stored for the authenticated user.
+func (c client) exportAccount(w http.ResponseWriter, r *http.Request, user *User) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	found, data, err := c.clientRepository.ExportUserData(r.Context(), user.ID)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"user not found"}`))
+		return
+	}
+
+	o, err := json.Marshal(
+		struct {
+			UserID     string          `json:"user_id"`
+			ExportedAt time.Time       `json:"exported_at"`
+			Data       json.RawMessage `json:"data"`
+		}{
+			UserID:     user.ID,
+			ExportedAt: time.Now().UTC().Truncate(time.Second),
+			Data:       data,
+		},
+	)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", `attachment; filename="diagramastext-`+user.ID+`.json"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(o)
+}
+
+// deleteAccount deletes the authenticated user's data and anonymizes the user.
+// The issued access tokens are rejected right after, because the anonymized user is not active.
+func (c client) deleteAccount(w http.ResponseWriter, r *http.Request, user *User) {
+	if !allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+
+	if err := c.clientRepository.DeleteUser(r.Context(), user.ID); err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
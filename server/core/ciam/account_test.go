@@ -0,0 +1,218 @@
+package ciam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kislerdm/diagramastext/server/core/internal/utils"
+)
+
+func TestServeHTTP_Account(t *testing.T) {
+	const userID = "b1b1b1b1-0000-4000-8000-000000000000"
+
+	newEnv := func(t *testing.T) (http.Handler, *MockRepositoryCIAM, string) {
+		t.Helper()
+
+		key := GenerateCertificate()
+		repo := &MockRepositoryCIAM{}
+		role := uint8(RoleRegisteredUser)
+		if err := repo.CreateUser(context.TODO(), userID, "foo@bar.baz", "qux", true, &role); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.WriteOneTimeSecret(context.TODO(), userID, "secret", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.WriteRefreshToken(context.TODO(), userID, "t0", "f0", time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		repo.UserToken = map[string]string{"k0": userID}
+
+		handlerFn, err := HTTPHandler(repo, &MockMailer{}, NewKeySet(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iss, err := NewIssuer(NewKeySet(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := iss.NewAccessToken(User{ID: userID, Role: RoleRegisteredUser})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return handlerFn(nil), repo, token
+	}
+
+	newRequest := func(method, path, token string) *http.Request {
+		return &http.Request{
+			Method: method,
+			URL:    &url.URL{Path: path},
+			Header: http.Header{"Authorization": {"Bearer " + token}},
+		}
+	}
+
+	t.Run(
+		"shall export user's data as JSON archive", func(t *testing.T) {
+			// GIVEN
+			handler, _, token := newEnv(t)
+			w := &utils.MockWriter{}
+
+			// WHEN
+			handler.ServeHTTP(w, newRequest(http.MethodGet, "/account/export", token))
+
+			// THEN
+			if w.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status code: %d, body: %s", w.StatusCode, w.V)
+			}
+			if got := w.Headers.Get("Content-Disposition"); got !=
+				`attachment; filename="diagramastext-`+userID+`.json"` {
+				t.Errorf("unexpected Content-Disposition: %s", got)
+			}
+			if w.Headers.Get("Cache-Control") != "no-store" {
+				t.Error("the archive is not expected to be cached")
+			}
+
+			var got struct {
+				UserID     string    `json:"user_id"`
+				ExportedAt time.Time `json:"exported_at"`
+				Data       struct {
+					User struct {
+						Email       string `json:"email"`
+						Fingerprint string `json:"web_fingerprint"`
+					} `json:"user"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.V, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.UserID != userID || got.ExportedAt.IsZero() || got.Data.User.Email != "foo@bar.baz" ||
+				got.Data.User.Fingerprint != "qux" {
+				t.Errorf("unexpected archive: %s", w.V)
+			}
+		},
+	)
+
+	t.Run(
+		"shall delete the account", func(t *testing.T) {
+			// GIVEN
+			handler, repo, token := newEnv(t)
+			w := &utils.MockWriter{}
+
+			// WHEN
+			handler.ServeHTTP(w, newRequest(http.MethodDelete, "/account", token))
+
+			// THEN
+			if w.StatusCode != http.StatusNoContent {
+				t.Fatalf("unexpected status code: %d, body: %s", w.StatusCode, w.V)
+			}
+			if len(repo.DeletedUsers) != 1 || repo.DeletedUsers[0] != userID {
+				t.Errorf("user is expected to be deleted")
+			}
+			u := repo.UserID[userID]
+			if u.Email != "" || u.Fingerprint != "" || u.IsActive {
+				t.Errorf("user is expected to be anonymized: %+v", u)
+			}
+			if len(repo.Secret) != 0 || len(repo.RefreshToken) != 0 || len(repo.UserToken) != 0 {
+				t.Error("user's secrets and tokens are expected to be deleted")
+			}
+		},
+	)
+
+	t.Run(
+		"shall reject the access token issued before the account deletion", func(t *testing.T) {
+			// GIVEN
+			handler, _, token := newEnv(t)
+			handler.ServeHTTP(&utils.MockWriter{}, newRequest(http.MethodDelete, "/account", token))
+
+			for _, r := range []*http.Request{
+				newRequest(http.MethodGet, "/quotas", token),
+				newRequest(http.MethodGet, "/account/export", token),
+				newRequest(http.MethodPost, "/generate/c4", token),
+			} {
+				w := &utils.MockWriter{}
+
+				// WHEN
+				handler.ServeHTTP(w, r)
+
+				// THEN
+				if w.StatusCode != http.StatusForbidden || string(w.V) != `{"error":"user is not active"}` {
+					t.Errorf("unexpected response for %s: %d, %s", r.URL.Path, w.StatusCode, w.V)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"unhappy path", func(t *testing.T) {
+			for _, tt := range []struct {
+				name       string
+				method     string
+				path       string
+				repoErr    error
+				wantStatus int
+			}{
+				{
+					name:       "shall return 405 for export with the wrong method",
+					method:     http.MethodPost,
+					path:       "/account/export",
+					wantStatus: http.StatusMethodNotAllowed,
+				},
+				{
+					name:       "shall return 405 for deletion with the wrong method",
+					method:     http.MethodPost,
+					path:       "/account",
+					wantStatus: http.StatusMethodNotAllowed,
+				},
+				{
+					name:       "shall return 500 if export fails",
+					method:     http.MethodGet,
+					path:       "/account/export",
+					repoErr:    errors.New("foo"),
+					wantStatus: http.StatusInternalServerError,
+				},
+				{
+					name:       "shall return 500 if deletion fails",
+					method:     http.MethodDelete,
+					path:       "/account",
+					repoErr:    errors.New("foo"),
+					wantStatus: http.StatusInternalServerError,
+				},
+			} {
+				t.Run(
+					tt.name, func(t *testing.T) {
+						// GIVEN
+						handler, repo, token := newEnv(t)
+						repo.Err = tt.repoErr
+						w := &utils.MockWriter{}
+
+						// WHEN
+						handler.ServeHTTP(w, newRequest(tt.method, tt.path, token))
+
+						// THEN
+						if w.StatusCode != tt.wantStatus {
+							t.Errorf("unexpected status code. want: %d, got: %d", tt.wantStatus, w.StatusCode)
+						}
+					},
+				)
+			}
+
+			t.Run(
+				"shall return 403 if the user is not found", func(t *testing.T) {
+					handler, repo, token := newEnv(t)
+					delete(repo.UserID, userID)
+					w := &utils.MockWriter{}
+					handler.ServeHTTP(w, newRequest(http.MethodGet, "/account/export", token))
+					if w.StatusCode != http.StatusForbidden {
+						t.Errorf("unexpected status code: %d", w.StatusCode)
+					}
+				},
+			)
+		},
+	)
+}
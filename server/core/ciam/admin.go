@@ -0,0 +1,579 @@
+package ciam
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kislerdm/diagramastext/server/core/internal/utils"
+)
+
+// UserRecord defines the user's data exposed to admins.
+// The type is an alias of the unnamed struct, hence the repository can implement RepositoryAdmin
+// without importing the package.
+type UserRecord = struct {
+	ID          string
+	Email       string
+	Fingerprint string
+	Role        uint8
+	IsActive    bool
+	IsBlocked   bool
+	IsPremium   bool
+	CreatedAt   time.Time
+}
+
+// RequestRecord defines the user's request to generate a diagram.
+// The type is an alias of the unnamed struct, see UserRecord.
+type RequestRecord = struct {
+	RequestID string
+	Prompt    string
+	IsSuccess bool
+	Timestamp time.Time
+}
+
+// RepositoryAdmin defines the communication port to persistence layer to manage users.
+type RepositoryAdmin interface {
+	// SearchUsers looks up users by the case-insensitive email's substring, or by the web fingerprint.
+	SearchUsers(ctx context.Context, email, fingerprint string, limit uint16) ([]UserRecord, error)
+	// UpdateUserBlocked blocks, or unblocks the user. The blocked user is refused to sign in and to use the API.
+	UpdateUserBlocked(ctx context.Context, userID string, isBlocked bool) (found bool, err error)
+	UpdateUserRole(ctx context.Context, userID string, role uint8) (found bool, err error)
+	UpdateUserPlan(ctx context.Context, userID string, isPremium bool) (found bool, err error)
+	// RevokeUserAPITokens deactivates all user's API keys.
+	RevokeUserAPITokens(ctx context.Context, userID string) (revoked uint32, err error)
+	// ReadUserRequests reads the latest user's requests.
+	ReadUserRequests(ctx context.Context, userID string, limit uint16) ([]RequestRecord, error)
+	// WriteAuditLog records the admin's action performed successfully.
+	WriteAuditLog(ctx context.Context, adminID, action, userID, details string) error
+}
+
+// WithAdmin enables the admin API to manage users.
+// The routes with the prefix `/admin` are not served if the repository is not set.
+func WithAdmin(repository RepositoryAdmin) HTTPHandlerOps {
+	return func(c *client) {
+		if repository != nil {
+			c.adminRepository = repository
+		}
+	}
+}
+
+const (
+	pathAdmin      = "/admin/"
+	pathAdminUsers = "/admin/users"
+
+	defaultAdminListLimit uint16 = 50
+	maxAdminListLimit     uint16 = 1000
+
+	planFree    = "free"
+	planPremium = "premium"
+)
+
+// Admin's actions recorded to the audit log.
+const (
+	auditActionSearchUsers      = "users.search"
+	auditActionActivateUser     = "user.activate"
+	auditActionDeactivateUser   = "user.deactivate"
+	auditActionChangeRole       = "user.role.change"
+	auditActionChangePlan       = "user.plan.change"
+	auditActionRevokeAPIKeys    = "user.api_keys.revoke"
+	auditActionReadUserRequests = "user.requests.read"
+)
+
+// admin serves the admin API:
+//
+//	GET  /admin/users?email={email}&fingerprint={fingerprint}&limit={limit}
+//	POST /admin/users/{id}/activate       unblocks the user
+//	POST /admin/users/{id}/deactivate     blocks the user
+//	POST /admin/users/{id}/role           {"role":1}
+//	POST /admin/users/{id}/plan           {"plan":"premium"}
+//	POST /admin/users/{id}/api-keys/revoke
+//	GET  /admin/users/{id}/requests?limit={limit}
+func (c client) admin(w http.ResponseWriter, r *http.Request) {
+	if c.adminRepository == nil {
+		c.adminNotFound(w, r)
+		return
+	}
+
+	admin, ok := c.authenticateAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	if p == pathAdminUsers {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		c.adminSearchUsers(w, r, admin)
+		return
+	}
+
+	if !strings.HasPrefix(p, pathAdminUsers+"/") {
+		c.adminNotFound(w, r)
+		return
+	}
+
+	userID, action, _ := strings.Cut(strings.TrimPrefix(p, pathAdminUsers+"/"), "/")
+	if err := utils.ValidateUUID(userID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"user id is not valid"}`))
+		return
+	}
+
+	switch action {
+	case "activate":
+		if allowMethod(w, r, http.MethodPost) {
+			c.adminSetUserBlocked(w, r, admin, userID, false)
+		}
+	case "deactivate":
+		if allowMethod(w, r, http.MethodPost) {
+			c.adminSetUserBlocked(w, r, admin, userID, true)
+		}
+	case "role":
+		if allowMethod(w, r, http.MethodPost) {
+			c.adminChangeRole(w, r, admin, userID)
+		}
+	case "plan":
+		if allowMethod(w, r, http.MethodPost) {
+			c.adminChangePlan(w, r, admin, userID)
+		}
+	case "api-keys/revoke":
+		if allowMethod(w, r, http.MethodPost) {
+			c.adminRevokeAPIKeys(w, r, admin, userID)
+		}
+	case "requests":
+		if allowMethod(w, r, http.MethodGet) {
+			c.adminReadUserRequests(w, r, admin, userID)
+		}
+	default:
+		c.adminNotFound(w, r)
+	}
+}
+
+func (c client) adminNotFound(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte(`{"error":"` + r.URL.Path + ` not found"}`))
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(`{"error":"` + r.Method + ` is not allowed"}`))
+		return false
+	}
+	return true
+}
+
+// authenticateAdmin authenticates the user and verifies that the user has the admin role.
+// The role is read from the repository to take effect of its change regardless of the issued tokens' claims.
+func (c client) authenticateAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user, found, err := c.readUserFromHeader(r)
+	if err != nil || !found {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"no valid authentication token provided"}`))
+		return "", false
+	}
+
+	found, isActive, isBlocked, role, _, _, err := c.clientRepository.ReadUser(r.Context(), user.ID)
+	if err != nil {
+		c.internalError(w, err)
+		return "", false
+	}
+	if !found || !isActive || isBlocked || !Role(role).IsAdmin() {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":"admin role is required"}`))
+		return "", false
+	}
+
+	return user.ID, true
+}
+
+// audit records admin's action once it succeeded, hence the log does not list the failed actions.
+// The action's result is not returned if the action cannot be recorded.
+func (c client) audit(w http.ResponseWriter, r *http.Request, adminID, action, userID string, details any) bool {
+	var o []byte
+	if details != nil {
+		var err error
+		if o, err = json.Marshal(details); err != nil {
+			c.internalError(w, err)
+			return false
+		}
+	}
+
+	if err := c.adminRepository.WriteAuditLog(r.Context(), adminID, action, userID, string(o)); err != nil {
+		c.internalError(w, err)
+		return false
+	}
+	return true
+}
+
+func readListLimit(w http.ResponseWriter, r *http.Request) (uint16, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultAdminListLimit, true
+	}
+
+	limit, err := strconv.ParseUint(v, 10, 16)
+	if err != nil || limit == 0 || uint16(limit) > maxAdminListLimit {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(
+			[]byte(`{"error":"limit must be a number from 1 to ` + strconv.Itoa(int(maxAdminListLimit)) + `"}`),
+		)
+		return 0, false
+	}
+	return uint16(limit), true
+}
+
+type adminUser struct {
+	ID          string    `json:"user_id"`
+	Email       string    `json:"email,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Role        uint8     `json:"role"`
+	Plan        string    `json:"plan"`
+	IsActive    bool      `json:"is_active"`
+	IsBlocked   bool      `json:"is_blocked"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (c client) adminSearchUsers(w http.ResponseWriter, r *http.Request, adminID string) {
+	email := r.URL.Query().Get("email")
+	fingerprint := r.URL.Query().Get("fingerprint")
+	if email == "" && fingerprint == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"email or fingerprint must be provided"}`))
+		return
+	}
+
+	limit, ok := readListLimit(w, r)
+	if !ok {
+		return
+	}
+
+	users, err := c.adminRepository.SearchUsers(r.Context(), email, fingerprint, limit)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	if !c.audit(
+		w, r, adminID, auditActionSearchUsers, "", map[string]string{"email": email, "fingerprint": fingerprint},
+	) {
+		return
+	}
+
+	o := struct {
+		Users []adminUser `json:"users"`
+	}{
+		Users: make([]adminUser, len(users)),
+	}
+	for i, u := range users {
+		plan := planFree
+		if u.IsPremium {
+			plan = planPremium
+		}
+		o.Users[i] = adminUser{
+			ID:          u.ID,
+			Email:       u.Email,
+			Fingerprint: u.Fingerprint,
+			Role:        u.Role,
+			Plan:        plan,
+			IsActive:    u.IsActive,
+			IsBlocked:   u.IsBlocked,
+			CreatedAt:   u.CreatedAt,
+		}
+	}
+
+	c.writeJSON(w, o)
+}
+
+// adminSetUserBlocked blocks, or unblocks the user. The user's activation status is not changed,
+// hence the blocked user cannot be re-activated by signing in.
+func (c client) adminSetUserBlocked(
+	w http.ResponseWriter, r *http.Request, adminID, userID string, isBlocked bool,
+) {
+	action := auditActionActivateUser
+	if isBlocked {
+		if userID == adminID {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error":"admin cannot deactivate itself"}`))
+			return
+		}
+		action = auditActionDeactivateUser
+	}
+
+	found, err := c.adminRepository.UpdateUserBlocked(r.Context(), userID, isBlocked)
+	if !c.adminUserUpdated(w, found, err) {
+		return
+	}
+
+	if isBlocked {
+		if err := c.clientRepository.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+			c.internalError(w, err)
+			return
+		}
+	}
+
+	if !c.audit(w, r, adminID, action, userID, nil) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c client) adminChangeRole(w http.ResponseWriter, r *http.Request, adminID, userID string) {
+	var req struct {
+		Role *uint8 `json:"role"`
+	}
+	if !readAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Role == nil || !Role(*req.Role).IsValid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"error":"role is not valid"}`))
+		return
+	}
+	if userID == adminID && !Role(*req.Role).IsAdmin() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"error":"admin cannot revoke own admin role"}`))
+		return
+	}
+
+	found, err := c.adminRepository.UpdateUserRole(r.Context(), userID, *req.Role)
+	if !c.adminUserUpdated(w, found, err) {
+		return
+	}
+
+	// the sessions are revoked for the tokens to be re-issued with the new role
+	if err := c.clientRepository.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	if !c.audit(w, r, adminID, auditActionChangeRole, userID, req) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c client) adminChangePlan(w http.ResponseWriter, r *http.Request, adminID, userID string) {
+	var req struct {
+		Plan string `json:"plan"`
+	}
+	if !readAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Plan != planFree && req.Plan != planPremium {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"error":"plan must be ` + planFree + ` or ` + planPremium + `"}`))
+		return
+	}
+
+	found, err := c.adminRepository.UpdateUserPlan(r.Context(), userID, req.Plan == planPremium)
+	if !c.adminUserUpdated(w, found, err) {
+		return
+	}
+
+	if !c.audit(w, r, adminID, auditActionChangePlan, userID, req) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c client) adminRevokeAPIKeys(w http.ResponseWriter, r *http.Request, adminID, userID string) {
+	revoked, err := c.adminRepository.RevokeUserAPITokens(r.Context(), userID)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	if !c.audit(w, r, adminID, auditActionRevokeAPIKeys, userID, nil) {
+		return
+	}
+
+	c.writeJSON(
+		w, struct {
+			Revoked uint32 `json:"revoked"`
+		}{
+			Revoked: revoked,
+		},
+	)
+}
+
+func (c client) adminReadUserRequests(w http.ResponseWriter, r *http.Request, adminID, userID string) {
+	limit, ok := readListLimit(w, r)
+	if !ok {
+		return
+	}
+
+	requests, err := c.adminRepository.ReadUserRequests(r.Context(), userID, limit)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	if !c.audit(w, r, adminID, auditActionReadUserRequests, userID, nil) {
+		return
+	}
+
+	type request struct {
+		RequestID string    `json:"request_id"`
+		Prompt    string    `json:"prompt"`
+		IsSuccess bool      `json:"is_success"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+
+	o := struct {
+		Requests []request `json:"requests"`
+	}{
+		Requests: make([]request, len(requests)),
+	}
+	for i, el := range requests {
+		o.Requests[i] = request(el)
+	}
+
+	c.writeJSON(w, o)
+}
+
+func readAdminRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"request body is required"}`))
+		return false
+	}
+	defer func() { _ = r.Body.Close() }()
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"wrong request format"}`))
+		return false
+	}
+	return true
+}
+
+func (c client) adminUserUpdated(w http.ResponseWriter, found bool, err error) bool {
+	if err != nil {
+		c.internalError(w, err)
+		return false
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"user not found"}`))
+		return false
+	}
+	return true
+}
+
+func (c client) writeJSON(w http.ResponseWriter, v any) {
+	o, err := json.Marshal(v)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(o)
+}
+
+// AuditRecord defines the admin's action recorded by MockRepositoryAdmin.
+type AuditRecord struct {
+	AdminID, Action, UserID, Details string
+}
+
+type MockRepositoryAdmin struct {
+	Users    []UserRecord
+	Requests map[string][]RequestRecord
+	// APITokens maps the active API keys to the users' IDs.
+	APITokens map[string]string
+	AuditLog  []AuditRecord
+	Err       error
+	// ErrAuditLog defines the error to record the admin's action.
+	ErrAuditLog error
+}
+
+func (m *MockRepositoryAdmin) SearchUsers(_ context.Context, email, fingerprint string, limit uint16) (
+	[]UserRecord, error,
+) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var o []UserRecord
+	for _, u := range m.Users {
+		if len(o) == int(limit) {
+			break
+		}
+		if (email != "" && strings.Contains(strings.ToLower(u.Email), strings.ToLower(email))) ||
+			(fingerprint != "" && u.Fingerprint == fingerprint) {
+			o = append(o, u)
+		}
+	}
+	return o, nil
+}
+
+func (m *MockRepositoryAdmin) updateUser(userID string, fn func(u *UserRecord)) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	for i := range m.Users {
+		if m.Users[i].ID == userID {
+			fn(&m.Users[i])
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockRepositoryAdmin) UpdateUserBlocked(_ context.Context, userID string, isBlocked bool) (bool, error) {
+	return m.updateUser(userID, func(u *UserRecord) { u.IsBlocked = isBlocked })
+}
+
+func (m *MockRepositoryAdmin) UpdateUserRole(_ context.Context, userID string, role uint8) (bool, error) {
+	return m.updateUser(userID, func(u *UserRecord) { u.Role = role })
+}
+
+func (m *MockRepositoryAdmin) UpdateUserPlan(_ context.Context, userID string, isPremium bool) (bool, error) {
+	return m.updateUser(userID, func(u *UserRecord) { u.IsPremium = isPremium })
+}
+
+func (m *MockRepositoryAdmin) RevokeUserAPITokens(_ context.Context, userID string) (uint32, error) {
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	var o uint32
+	for k, v := range m.APITokens {
+		if v == userID {
+			delete(m.APITokens, k)
+			o++
+		}
+	}
+	return o, nil
+}
+
+func (m *MockRepositoryAdmin) ReadUserRequests(_ context.Context, userID string, limit uint16) (
+	[]RequestRecord, error,
+) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	o := m.Requests[userID]
+	if len(o) > int(limit) {
+		o = o[:limit]
+	}
+	return o, nil
+}
+
+func (m *MockRepositoryAdmin) WriteAuditLog(_ context.Context, adminID, action, userID, details string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if m.ErrAuditLog != nil {
+		return m.ErrAuditLog
+	}
+	m.AuditLog = append(
+		m.AuditLog, AuditRecord{AdminID: adminID, Action: action, UserID: userID, Details: details},
+	)
+	return nil
+}
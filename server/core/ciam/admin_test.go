@@ -0,0 +1,479 @@
+package ciam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kislerdm/diagramastext/server/core/internal/utils"
+)
+
+func TestServeHTTP_Admin(t *testing.T) {
+	const (
+		adminID = "a0a0a0a0-0000-4000-8000-000000000000"
+		userID  = "b1b1b1b1-0000-4000-8000-000000000000"
+	)
+
+	createdAt := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	type env struct {
+		handler   http.Handler
+		repoCIAM  *MockRepositoryCIAM
+		repoAdmin *MockRepositoryAdmin
+		token     string
+	}
+
+	newEnv := func(t *testing.T, adminRole Role) env {
+		t.Helper()
+
+		key := GenerateCertificate()
+		repoCIAM := &MockRepositoryCIAM{}
+		roleAdmin := uint8(adminRole)
+		roleUser := uint8(RoleRegisteredUser)
+		if err := repoCIAM.CreateUser(nil, adminID, "admin@bar.baz", "", true, &roleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		if err := repoCIAM.CreateUser(nil, userID, "Foo@bar.baz", "qux", true, &roleUser); err != nil {
+			t.Fatal(err)
+		}
+		if err := repoCIAM.WriteRefreshToken(nil, userID, "t0", "f0", createdAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		repoAdmin := &MockRepositoryAdmin{
+			Users: []UserRecord{
+				{ID: adminID, Email: "admin@bar.baz", Role: roleAdmin, IsActive: true, CreatedAt: createdAt},
+				{
+					ID: userID, Email: "Foo@bar.baz", Fingerprint: "qux", Role: roleUser, IsActive: true,
+					CreatedAt: createdAt,
+				},
+			},
+			Requests: map[string][]RequestRecord{
+				userID: {
+					{RequestID: "r1", Prompt: "c4 diagram", IsSuccess: true, Timestamp: createdAt},
+					{RequestID: "r0", Prompt: "foo", IsSuccess: false, Timestamp: createdAt},
+				},
+			},
+			APITokens: map[string]string{"k0": userID, "k1": userID, "k2": adminID},
+		}
+
+		handlerFn, err := HTTPHandler(repoCIAM, &MockMailer{}, NewKeySet(key), WithAdmin(repoAdmin))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iss, err := NewIssuer(NewKeySet(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the role claim is ignored, the admin role is verified against the repository
+		token, err := iss.NewAccessToken(User{ID: adminID, Role: RoleRegisteredUser})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return env{handler: handlerFn(nil), repoCIAM: repoCIAM, repoAdmin: repoAdmin, token: token}
+	}
+
+	newRequest := func(method, path, query, body, token string) *http.Request {
+		r := &http.Request{
+			Method: method,
+			URL:    &url.URL{Path: path, RawQuery: query},
+			Header: http.Header{},
+		}
+		if body != "" {
+			r.Body = io.NopCloser(bytes.NewReader([]byte(body)))
+		}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	t.Run(
+		"shall search users by email", func(t *testing.T) {
+			// GIVEN
+			e := newEnv(t, RoleAdmin)
+			w := &utils.MockWriter{}
+
+			// WHEN
+			e.handler.ServeHTTP(w, newRequest(http.MethodGet, "/admin/users", "email=foo%40", "", e.token))
+
+			// THEN
+			if w.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status code: %d, body: %s", w.StatusCode, w.V)
+			}
+			want := `{"users":[{"user_id":"` + userID + `","email":"Foo@bar.baz","fingerprint":"qux","role":1,` +
+				`"plan":"free","is_active":true,"is_blocked":false,"created_at":"2023-05-01T00:00:00Z"}]}`
+			if string(w.V) != want {
+				t.Errorf("unexpected response body: %s", w.V)
+			}
+			wantAudit := []AuditRecord{
+				{AdminID: adminID, Action: auditActionSearchUsers, Details: `{"email":"foo@","fingerprint":""}`},
+			}
+			if !reflect.DeepEqual(e.repoAdmin.AuditLog, wantAudit) {
+				t.Errorf("unexpected audit log: %+v", e.repoAdmin.AuditLog)
+			}
+		},
+	)
+
+	t.Run(
+		"shall search users by fingerprint", func(t *testing.T) {
+			// GIVEN
+			e := newEnv(t, RoleAdmin)
+			w := &utils.MockWriter{}
+
+			// WHEN
+			e.handler.ServeHTTP(w, newRequest(http.MethodGet, "/admin/users", "fingerprint=qux", "", e.token))
+
+			// THEN
+			var got struct {
+				Users []adminUser `json:"users"`
+			}
+			if err := json.Unmarshal(w.V, &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Users) != 1 || got.Users[0].ID != userID {
+				t.Errorf("unexpected users found: %s", w.V)
+			}
+		},
+	)
+
+	t.Run(
+		"shall block the user and revoke its sessions", func(t *testing.T) {
+			// GIVEN
+			e := newEnv(t, RoleAdmin)
+			w := &utils.MockWriter{}
+
+			// WHEN
+			e.handler.ServeHTTP(
+				w, newRequest(http.MethodPost, "/admin/users/"+userID+"/deactivate", "", "", e.token),
+			)
+
+			// THEN
+			if w.StatusCode != http.StatusNoContent {
+				t.Fatalf("unexpected status code: %d, body: %s", w.StatusCode, w.V)
+			}
+			if !e.repoAdmin.Users[1].IsBlocked {
+				t.Error("user is expected to be blocked")
+			}
+			if !e.repoAdmin.Users[1].IsActive {
+				t.Error("user's activation status is not expected to be changed")
+			}
+			if e.repoCIAM.RefreshToken["t0"].IsActive {
+				t.Error("user's refresh tokens are expected to be revoked")
+			}
+			if len(e.repoAdmin.AuditLog) != 1 || e.repoAdmin.AuditLog[0] != (AuditRecord{
+				AdminID: adminID, Action: auditActionDeactivateUser, UserID: userID,
+			}) {
+				t.Errorf("unexpected audit log: %+v", e.repoAdmin.AuditLog)
+			}
+
+			// WHEN
+			w = &utils.MockWriter{}
+			e.handler.ServeHTTP(
+				w, newRequest(http.MethodPost, "/admin/users/"+userID+"/activate", "", "", e.token),
+			)
+
+			// THEN
+			if w.StatusCode != http.StatusNoContent || e.repoAdmin.Users[1].IsBlocked {
+				t.Error("user is expected to be unblocked")
+			}
+		},
+	)
+
+	t.Run(
+		"shall change user's role and plan", func(t *testing.T) {
+			// GIVEN
+			e := newEnv(t, RoleAdmin)
+
+			// WHEN
+			w := &utils.MockWriter{}
+			e.handler.ServeHTTP(
+				w, newRequest(http.MethodPost, "/admin/users/"+userID+"/role", "", `{"role":2}`, e.token),
+			)
+
+			// THEN
+			if w.StatusCode != http.StatusNoContent {
+				t.Fatalf("unexpected status code: %d, body: %s", w.StatusCode, w.V)
+			}
+			if e.repoAdmin.Users[1].Role != uint8(RoleAdmin) {
+				t.Error("role is expected to be changed")
+			}
+			if e.repoCIAM.RefreshToken["t0"].IsActive {
+				t.Error("user's refresh tokens are expected to be revoked")
+			}
+
+			// WHEN
+			w = &utils.MockWriter{}
+			e.handler.ServeHTTP(
+				w, newRequest(http.MethodPost, "/admin/users/"+userID+"/plan", "", `{"plan":"premium"}`, e.token),
+			)
+
+			// THEN
+			if w.StatusCode != http.StatusNoContent {
+				t.Fatalf("unexpected status code: %d, body: %s", w.StatusCode, w.V)
+			}
+			if !e.repoAdmin.Users[1].IsPremium {
+				t.Error("plan is expected to be changed")
+			}
+
+			wantAudit := []AuditRecord{
+				{AdminID: adminID, Action: auditActionChangeRole, UserID: userID, Details: `{"role":2}`},
+				{AdminID: adminID, Action: auditActionChangePlan, UserID: userID, Details: `{"plan":"premium"}`},
+			}
+			if !reflect.DeepEqual(e.repoAdmin.AuditLog, wantAudit) {
+				t.Errorf("unexpected audit log: %+v", e.repoAdmin.AuditLog)
+			}
+		},
+	)
+
+	t.Run(
+		"shall revoke user's API keys", func(t *testing.T) {
+			// GIVEN
+			e := newEnv(t, RoleAdmin)
+			w := &utils.MockWriter{}
+
+			// WHEN
+			e.handler.ServeHTTP(
+				w, newRequest(http.MethodPost, "/admin/users/"+userID+"/api-keys/revoke", "", "", e.token),
+			)
+
+			// THEN
+			if w.StatusCode != http.StatusOK || string(w.V) != `{"revoked":2}` {
+				t.Fatalf("unexpected response: %d, %s", w.StatusCode, w.V)
+			}
+			if !reflect.DeepEqual(e.repoAdmin.APITokens, map[string]string{"k2": adminID}) {
+				t.Errorf("unexpected API keys: %v", e.repoAdmin.APITokens)
+			}
+		},
+	)
+
+	t.Run(
+		"shall read user's requests history", func(t *testing.T) {
+			// GIVEN
+			e := newEnv(t, RoleAdmin)
+			w := &utils.MockWriter{}
+
+			// WHEN
+			e.handler.ServeHTTP(
+				w, newRequest(http.MethodGet, "/admin/users/"+userID+"/requests", "limit=1", "", e.token),
+			)
+
+			// THEN
+			if w.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected status code: %d, body: %s", w.StatusCode, w.V)
+			}
+			want := `{"requests":[{"request_id":"r1","prompt":"c4 diagram","is_success":true,` +
+				`"timestamp":"2023-05-01T00:00:00Z"}]}`
+			if string(w.V) != want {
+				t.Errorf("unexpected response body: %s", w.V)
+			}
+			if len(e.repoAdmin.AuditLog) != 1 || e.repoAdmin.AuditLog[0].Action != auditActionReadUserRequests {
+				t.Errorf("unexpected audit log: %+v", e.repoAdmin.AuditLog)
+			}
+		},
+	)
+
+	t.Run(
+		"shall not audit the action failed with the repository error", func(t *testing.T) {
+			// GIVEN
+			e := newEnv(t, RoleAdmin)
+			e.repoAdmin.Err = errors.New("foo")
+			w := &utils.MockWriter{}
+
+			// WHEN
+			e.handler.ServeHTTP(
+				w, newRequest(http.MethodPost, "/admin/users/"+userID+"/deactivate", "", "", e.token),
+			)
+
+			// THEN
+			if w.StatusCode != http.StatusInternalServerError {
+				t.Errorf("unexpected status code: %d", w.StatusCode)
+			}
+			if e.repoAdmin.Users[1].IsBlocked || !e.repoCIAM.RefreshToken["t0"].IsActive {
+				t.Error("user is not expected to be blocked")
+			}
+			if len(e.repoAdmin.AuditLog) != 0 {
+				t.Errorf("failed action is not expected to be audited: %+v", e.repoAdmin.AuditLog)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail if the action cannot be audited", func(t *testing.T) {
+			// GIVEN
+			e := newEnv(t, RoleAdmin)
+			e.repoAdmin.ErrAuditLog = errors.New("foo")
+			w := &utils.MockWriter{}
+
+			// WHEN
+			e.handler.ServeHTTP(w, newRequest(http.MethodGet, "/admin/users/"+userID+"/requests", "", "", e.token))
+
+			// THEN
+			if w.StatusCode != http.StatusInternalServerError {
+				t.Errorf("unexpected status code: %d", w.StatusCode)
+			}
+			if bytes.Contains(w.V, []byte("c4 diagram")) {
+				t.Error("user's requests are not expected to be returned")
+			}
+		},
+	)
+
+	t.Run(
+		"unhappy path", func(t *testing.T) {
+			for _, tt := range []struct {
+				name       string
+				role       Role
+				method     string
+				path       string
+				query      string
+				body       string
+				noToken    bool
+				wantStatus int
+			}{
+				{
+					name:       "shall return 401 for missing token",
+					role:       RoleAdmin,
+					method:     http.MethodGet,
+					path:       "/admin/users",
+					query:      "email=foo",
+					noToken:    true,
+					wantStatus: http.StatusUnauthorized,
+				},
+				{
+					name:       "shall return 403 for non-admin user",
+					role:       RoleRegisteredUser,
+					method:     http.MethodGet,
+					path:       "/admin/users",
+					query:      "email=foo",
+					wantStatus: http.StatusForbidden,
+				},
+				{
+					name:       "shall return 400 if neither email nor fingerprint is set",
+					role:       RoleAdmin,
+					method:     http.MethodGet,
+					path:       "/admin/users",
+					wantStatus: http.StatusBadRequest,
+				},
+				{
+					name:       "shall return 400 for invalid limit",
+					role:       RoleAdmin,
+					method:     http.MethodGet,
+					path:       "/admin/users",
+					query:      "email=foo&limit=0",
+					wantStatus: http.StatusBadRequest,
+				},
+				{
+					name:       "shall return 405 for wrong method",
+					role:       RoleAdmin,
+					method:     http.MethodGet,
+					path:       "/admin/users/" + userID + "/deactivate",
+					wantStatus: http.StatusMethodNotAllowed,
+				},
+				{
+					name:       "shall return 400 for invalid user id",
+					role:       RoleAdmin,
+					method:     http.MethodPost,
+					path:       "/admin/users/foo/deactivate",
+					wantStatus: http.StatusBadRequest,
+				},
+				{
+					name:       "shall return 404 for unknown user",
+					role:       RoleAdmin,
+					method:     http.MethodPost,
+					path:       "/admin/users/c2c2c2c2-0000-4000-8000-000000000000/activate",
+					wantStatus: http.StatusNotFound,
+				},
+				{
+					name:       "shall return 404 for unknown action",
+					role:       RoleAdmin,
+					method:     http.MethodPost,
+					path:       "/admin/users/" + userID + "/foo",
+					wantStatus: http.StatusNotFound,
+				},
+				{
+					name:       "shall return 422 for invalid role",
+					role:       RoleAdmin,
+					method:     http.MethodPost,
+					path:       "/admin/users/" + userID + "/role",
+					body:       `{"role":10}`,
+					wantStatus: http.StatusUnprocessableEntity,
+				},
+				{
+					name:       "shall return 422 for invalid plan",
+					role:       RoleAdmin,
+					method:     http.MethodPost,
+					path:       "/admin/users/" + userID + "/plan",
+					body:       `{"plan":"gold"}`,
+					wantStatus: http.StatusUnprocessableEntity,
+				},
+				{
+					name:       "shall return 422 if admin deactivates itself",
+					role:       RoleAdmin,
+					method:     http.MethodPost,
+					path:       "/admin/users/" + adminID + "/deactivate",
+					wantStatus: http.StatusUnprocessableEntity,
+				},
+				{
+					name:       "shall return 422 if admin revokes own admin role",
+					role:       RoleAdmin,
+					method:     http.MethodPost,
+					path:       "/admin/users/" + adminID + "/role",
+					body:       `{"role":1}`,
+					wantStatus: http.StatusUnprocessableEntity,
+				},
+			} {
+				t.Run(
+					tt.name, func(t *testing.T) {
+						// GIVEN
+						e := newEnv(t, tt.role)
+						token := e.token
+						if tt.noToken {
+							token = ""
+						}
+						w := &utils.MockWriter{}
+
+						// WHEN
+						e.handler.ServeHTTP(w, newRequest(tt.method, tt.path, tt.query, tt.body, token))
+
+						// THEN
+						if w.StatusCode != tt.wantStatus {
+							t.Errorf(
+								"unexpected status code. want: %d, got: %d, body: %s", tt.wantStatus, w.StatusCode, w.V,
+							)
+						}
+						if len(e.repoAdmin.AuditLog) != 0 {
+							t.Errorf("failed action is not expected to be audited: %+v", e.repoAdmin.AuditLog)
+						}
+					},
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"shall return 404 if admin API is not enabled", func(t *testing.T) {
+			// GIVEN
+			handlerFn, err := HTTPHandler(&MockRepositoryCIAM{}, &MockMailer{}, NewKeySet(GenerateCertificate()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := &utils.MockWriter{}
+
+			// WHEN
+			handlerFn(nil).ServeHTTP(w, newRequest(http.MethodGet, "/admin/users", "email=foo", "", ""))
+
+			// THEN
+			if w.StatusCode != http.StatusNotFound {
+				t.Errorf("unexpected status code: %d", w.StatusCode)
+			}
+		},
+	)
+}
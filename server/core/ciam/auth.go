@@ -1,6 +1,8 @@
 package ciam
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -26,3 +28,66 @@ func readAPIKey(header http.Header) (key string, found bool) {
 	}
 	return
 }
+
+// readClientIP reads the client's IP address.
+// The X-Forwarded-For header is read only if the request is sent by the trusted proxy. The header's addresses
+// are read from right to left skipping the trusted proxies, because the leftmost addresses are set by the client.
+func readClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	if !isTrustedProxy(ip, trustedProxies) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrustedProxy(hop, trustedProxies) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	v := net.ParseIP(ip)
+	if v == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses the proxies' IP addresses, or CIDR ranges.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	o := make([]*net.IPNet, len(proxies))
+	for i, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, errors.New("trusted proxy " + p + " is not valid IP address")
+			}
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			o[i] = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, errors.New("trusted proxy " + p + " is not valid CIDR")
+		}
+		o[i] = n
+	}
+	return o, nil
+}
@@ -129,3 +129,116 @@ func Test_readAuthHeaderValue(t *testing.T) {
 		)
 	}
 }
+
+func Test_readClientIP(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"10.0.0.0/24", "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		want    string
+	}{
+		{
+			name: "shall ignore the forwarded address given the request is not sent by the trusted proxy",
+			request: &http.Request{
+				Header:     http.Header{"X-Forwarded-For": []string{"10.0.0.1"}},
+				RemoteAddr: "203.0.113.1:8080",
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name: "shall read the address appended by the trusted proxy",
+			request: &http.Request{
+				Header:     http.Header{"X-Forwarded-For": []string{"198.51.100.1, 203.0.113.1"}},
+				RemoteAddr: "10.0.0.3:8080",
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name: "shall skip the trusted proxies in the chain",
+			request: &http.Request{
+				Header: http.Header{
+					"X-Forwarded-For": []string{"198.51.100.1, 203.0.113.1", "192.168.0.1"},
+				},
+				RemoteAddr: "10.0.0.3:8080",
+			},
+			want: "203.0.113.1",
+		},
+		{
+			name: "shall read the leftmost address given all addresses are trusted proxies",
+			request: &http.Request{
+				Header:     http.Header{"X-Forwarded-For": []string{"10.0.0.1, 10.0.0.2"}},
+				RemoteAddr: "10.0.0.3:8080",
+			},
+			want: "10.0.0.1",
+		},
+		{
+			name:    "shall read the proxy's address given no forwarded address",
+			request: &http.Request{RemoteAddr: "10.0.0.3:8080"},
+			want:    "10.0.0.3",
+		},
+		{
+			name:    "shall read the remote address",
+			request: &http.Request{RemoteAddr: "203.0.113.1:8080"},
+			want:    "203.0.113.1",
+		},
+		{
+			name:    "shall read the remote address without port",
+			request: &http.Request{RemoteAddr: "203.0.113.1"},
+			want:    "203.0.113.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := readClientIP(tt.request, trustedProxies); got != tt.want {
+					t.Errorf("readClientIP() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_parseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "shall parse IP addresses and CIDR ranges",
+			proxies: []string{"10.0.0.0/8", "192.168.0.1", "::1"},
+			want:    []string{"10.0.0.0/8", "192.168.0.1/32", "::1/128"},
+		},
+		{
+			name:    "shall fail given invalid IP address",
+			proxies: []string{"foo"},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail given invalid CIDR",
+			proxies: []string{"10.0.0.0/99"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := parseTrustedProxies(tt.proxies)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("parseTrustedProxies() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				for i, n := range got {
+					if n.String() != tt.want[i] {
+						t.Errorf("parseTrustedProxies() got = %v, want %v", n, tt.want[i])
+					}
+				}
+			},
+		)
+	}
+}
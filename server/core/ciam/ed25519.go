@@ -45,3 +45,36 @@ func MarshalKey(key ed25519.PrivateKey) ([]byte, error) {
 		},
 	), nil
 }
+
+func readPublicKey(s string) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("cannot read the key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	o, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("wrong key type")
+	}
+
+	return o, nil
+}
+
+func marshalPublicKey(key ed25519.PublicKey) ([]byte, error) {
+	v, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: v,
+		},
+	), nil
+}
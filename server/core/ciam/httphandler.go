@@ -3,14 +3,15 @@ package ciam
 
 import (
 	"context"
-	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,9 +20,66 @@ import (
 
 type HTTPHandlerFn func(next http.Handler) http.Handler
 
+// HTTPHandlerOps defines the optional settings of the CIAM client.
+type HTTPHandlerOps func(c *client)
+
+// WithRateLimiter sets the limiter of user's requests rate.
+// The rate limiter with in-memory counters is used by default.
+func WithRateLimiter(rateLimiter RateLimiter) HTTPHandlerOps {
+	return func(c *client) {
+		if rateLimiter != nil {
+			c.rateLimiter = rateLimiter
+		}
+	}
+}
+
+// WithMagicLink sets the URLs of the magic link sign-in flow:
+// verifyURL is the URL of the `/auth/verify` endpoint the emailed link points to,
+// redirectURL is the URL of the web client which receives the tokens in the URL fragment.
+// The defaults are defaultMagicLinkVerifyURL and defaultWebClientURL.
+// Note that redirectURL is also used to complete the sign-in with the OIDC providers.
+func WithMagicLink(verifyURL, redirectURL string) HTTPHandlerOps {
+	return func(c *client) {
+		if verifyURL != "" {
+			c.magicLinkVerifyURL = verifyURL
+		}
+		if redirectURL != "" {
+			c.webClientURL = redirectURL
+		}
+	}
+}
+
+// WithOIDCProviders enables sign-in with the OIDC/OAuth2 identity providers.
+// The state of the authorization flows in progress is stored in memory if the repository is nil.
+func WithOIDCProviders(repository RepositoryOIDCState, providers ...OIDCProviderConfig) HTTPHandlerOps {
+	return func(c *client) {
+		if repository != nil {
+			c.oidcStateRepository = repository
+		}
+		c.oidcProviderConfigs = append(c.oidcProviderConfigs, providers...)
+	}
+}
+
+// WithHTTPClient sets the client to communicate with the identity providers.
+func WithHTTPClient(httpClient HTTPClient) HTTPHandlerOps {
+	return func(c *client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// WithTrustedProxies sets the IP addresses, or CIDR ranges of the proxies which append the client's IP
+// to the X-Forwarded-For header. The header is ignored by default, and the connection's address is used.
+func WithTrustedProxies(proxies ...string) HTTPHandlerOps {
+	return func(c *client) {
+		c.trustedProxiesConfigs = append(c.trustedProxiesConfigs, proxies...)
+	}
+}
+
 // HTTPHandler initializes the CIAM client.
 func HTTPHandler(
-	clientRepository RepositoryCIAM, clientEmail SMTPClient, privateKey ed25519.PrivateKey,
+	clientRepository RepositoryCIAM, clientEmail Mailer, keySet KeySet, fnOps ...HTTPHandlerOps,
 ) (HTTPHandlerFn, error) {
 	if clientRepository == nil {
 		return nil, errors.New("repo client is required")
@@ -29,18 +87,50 @@ func HTTPHandler(
 	if clientEmail == nil {
 		return nil, errors.New("email client is required")
 	}
-	issuer, err := NewIssuer(privateKey)
+	issuer, err := NewIssuer(keySet)
 	if err != nil {
 		return nil, err
 	}
-	return func(next http.Handler) http.Handler {
-		return client{
-			clientRepository: clientRepository,
-			clientEmail:      clientEmail,
-			tokenIssuer:      issuer,
-			logger:           log.New(os.Stderr, "", log.Lmicroseconds|log.LUTC|log.Lshortfile),
-			next:             next,
+	rateLimiter, err := NewRateLimiter(NewRepositoryRateLimiterInMemory())
+	if err != nil {
+		return nil, err
+	}
+
+	c := client{
+		clientRepository: clientRepository,
+		clientEmail:      clientEmail,
+		tokenIssuer:      issuer,
+		secretHashKey:    onetimeSecretHashKey(keySet),
+		rateLimiter:      rateLimiter,
+		logger:           log.New(os.Stderr, "", log.Lmicroseconds|log.LUTC|log.Lshortfile),
+
+		magicLinkVerifyURL: defaultMagicLinkVerifyURL,
+		webClientURL:       defaultWebClientURL,
+
+		oidcStateRepository: NewRepositoryOIDCStateInMemory(),
+		httpClient:          &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, fn := range fnOps {
+		fn(&c)
+	}
+
+	if c.trustedProxies, err = parseTrustedProxies(c.trustedProxiesConfigs); err != nil {
+		return nil, err
+	}
+
+	c.oidcProviders = make(map[string]*oidcProvider, len(c.oidcProviderConfigs))
+	for _, cfg := range c.oidcProviderConfigs {
+		if _, ok := c.oidcProviders[cfg.Name]; ok {
+			return nil, errors.New("oidc provider " + cfg.Name + " is duplicated")
+		}
+		if c.oidcProviders[cfg.Name], err = newOIDCProvider(cfg, c.httpClient); err != nil {
+			return nil, err
 		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		c.next = next
+		return c
 	}, nil
 }
 
@@ -49,18 +139,46 @@ type client struct {
 
 	logger           *log.Logger
 	clientRepository RepositoryCIAM
-	clientEmail      SMTPClient
+	clientEmail      Mailer
 	tokenIssuer      Issuer
+	secretHashKey    []byte
+	rateLimiter      RateLimiter
+
+	magicLinkVerifyURL string
+	webClientURL       string
+
+	trustedProxiesConfigs []string
+	trustedProxies        []*net.IPNet
+
+	httpClient          HTTPClient
+	oidcStateRepository RepositoryOIDCState
+	oidcProviderConfigs []OIDCProviderConfig
+	oidcProviders       map[string]*oidcProvider
+
+	adminRepository RepositoryAdmin
 }
 
 func (c client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if strings.HasPrefix(r.URL.Path, "/auth") && r.Method != http.MethodPost {
+	if strings.HasPrefix(r.URL.Path, "/auth") && !isRedirectFlowPath(r.URL.Path) && r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		_, _ = w.Write([]byte(`{"error":"` + r.Method + ` is not allowed"}`))
 		return
 	}
 
+	if strings.HasPrefix(r.URL.Path, pathOIDC) {
+		c.signinOIDC(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, pathAdmin) {
+		c.admin(w, r)
+		return
+	}
+
 	switch p := r.URL.Path; p {
+	case pathJWKS:
+		c.publishJWKS(w, r)
+		return
 	case "/auth/anonym":
 		c.signinAnonym(w, r)
 		return
@@ -70,11 +188,25 @@ func (c client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/auth/confirm":
 		c.signinUserInitSecretConfirmation(w, r)
 		return
+	case pathMagicLinkVerify:
+		c.signinMagicLinkVerification(w, r)
+		return
 	case "/auth/refresh":
 		c.refreshAccessToken(w, r)
 		return
+	case "/auth/logout":
+		c.logout(w, r)
+		return
+	case "/auth/logout-all":
+		c.logoutAll(w, r)
+		return
 	default:
 		user, found, err := c.readUserFromHeader(r)
+		if errors.Is(err, errUserBlocked) || errors.Is(err, errUserNotActive) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":"` + err.Error() + `"}`))
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(`{"error":"internal error"}`))
@@ -88,11 +220,19 @@ func (c client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if p == "/quotas" {
+		switch p {
+		case "/quotas":
 			c.getQuotaUsage(w, r, user)
 			return
+		case pathAccountExport:
+			c.exportAccount(w, r, user)
+			return
+		case pathAccount:
+			c.deleteAccount(w, r, user)
+			return
 		}
 
+		// the requests to all other routes consume the quota, including the diagrams' diff and explanation
 		if ok := c.validateRequestsQuotaUsage(w, r, user); !ok {
 			return
 		}
@@ -100,11 +240,69 @@ func (c client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r = r.WithContext(NewContext(r.Context(), user))
 
 		if c.next != nil {
-			c.next.ServeHTTP(w, r)
+			sw := &statusWriter{ResponseWriter: w}
+			c.next.ServeHTTP(sw, r)
+			if sw.isSuccess() {
+				if err := recordRequestSuccess(r.Context(), c.rateLimiter, user); err != nil {
+					c.logger.Println(err)
+				}
+			}
 		}
 	}
 }
 
+// statusWriter captures the status code written by the downstream handler.
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(v []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.ResponseWriter.Write(v)
+}
+
+func (w *statusWriter) isSuccess() bool {
+	return w.statusCode >= http.StatusOK && w.statusCode < http.StatusMultipleChoices
+}
+
+// pathJWKS defines the path of the public keys to verify the issued JWT.
+// The OpenID configuration is not published because the CIAM is not the OpenID provider:
+// it does not expose the authorization endpoint to the relying parties.
+const pathJWKS = "/.well-known/jwks.json"
+
+// publishJWKS publishes the public keys to verify the issued JWT.
+func (c client) publishJWKS(w http.ResponseWriter, r *http.Request) {
+	c.writePublicDocument(w, r, c.tokenIssuer.JWKS())
+}
+
+func (c client) writePublicDocument(w http.ResponseWriter, r *http.Request, v any) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(`{"error":"` + r.Method + ` is not allowed"}`))
+		return
+	}
+
+	o, err := json.Marshal(v)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(o)
+}
+
 // getQuotaUsage reads current usage of the quota.
 func (c client) getQuotaUsage(w http.ResponseWriter, r *http.Request, user *User) {
 	if r.Method != http.MethodGet {
@@ -113,12 +311,14 @@ func (c client) getQuotaUsage(w http.ResponseWriter, r *http.Request, user *User
 		return
 	}
 
-	quotas, err := getQuotaUsage(r.Context(), c.clientRepository, user)
+	quotas, err := getQuotaUsage(r.Context(), c.rateLimiter, user)
 	if err != nil {
 		c.internalError(w, err)
 		return
 	}
 
+	setRateLimitHeaders(w.Header(), quotas, time.Now().UTC())
+
 	o, err := json.Marshal(quotas)
 	if err != nil {
 		c.internalError(w, err)
@@ -130,31 +330,103 @@ func (c client) getQuotaUsage(w http.ResponseWriter, r *http.Request, user *User
 	return
 }
 
-// checks if the requests' quota was exceeded.
+// checks if the requests' quota was exceeded, and registers the request attempt given the quotas are not exceeded.
+// The attempts quota is checked and consumed atomically, hence it limits the number of the concurrent requests
+// which can pass the check of the successful requests' quotas.
 func (c client) validateRequestsQuotaUsage(w http.ResponseWriter, r *http.Request, user *User) bool {
-	quotasUsage, err := getQuotaUsage(r.Context(), c.clientRepository, user)
+	quotasUsage, err := getQuotaUsage(r.Context(), c.rateLimiter, user)
 	if err != nil {
 		c.internalError(w, err)
 		return false
 	}
 
+	now := time.Now().UTC()
+
 	if quotasUsage.RateDay.Used >= quotasUsage.RateDay.Limit {
-		w.WriteHeader(http.StatusTooManyRequests)
-		_, _ = w.Write([]byte(`{"error":"daily quota exceeded"}`))
+		tooManyRequests(w, quotasUsage, quotasUsage.RateDay, now, "daily quota exceeded")
 		c.logger.Printf("quota exceeded for user %s", user.ID)
 		return false
 	}
 
 	if quotasUsage.RateMinute.Used >= quotasUsage.RateMinute.Limit {
-		w.WriteHeader(http.StatusTooManyRequests)
-		_, _ = w.Write([]byte(`{"error":"throttling quota exceeded"}`))
+		tooManyRequests(w, quotasUsage, quotasUsage.RateMinute, now, "throttling quota exceeded")
 		c.logger.Printf("throttling quota exceeded for user %s", user.ID)
 		return false
 	}
 
+	ok, err := recordRequestAttempt(r.Context(), c.rateLimiter, user)
+	if err != nil {
+		c.internalError(w, err)
+		return false
+	}
+
+	if !ok {
+		// the attempts quota is re-read because it could be consumed by the concurrent requests
+		quotasUsage.RateMinuteAttempts, err = readRequestsConsumption(
+			r.Context(), c.rateLimiter, keyRequestsAttempt(user.ID), windowMinute,
+			quotasUsage.RateMinuteAttempts.Limit,
+		)
+		if err != nil {
+			c.internalError(w, err)
+			return false
+		}
+		quotasUsage.RateMinuteAttempts.Used = quotasUsage.RateMinuteAttempts.Limit
+		tooManyRequests(w, quotasUsage, quotasUsage.RateMinuteAttempts, now, "attempts quota exceeded")
+		c.logger.Printf("attempts quota exceeded for user %s", user.ID)
+		return false
+	}
+
+	// the current request is accounted for in the quotas reported to the client
+	quotasUsage.RateMinuteAttempts.Used++
+	quotasUsage.RateMinute.Used++
+	quotasUsage.RateDay.Used++
+	setRateLimitHeaders(w.Header(), quotasUsage, now)
+
 	return true
 }
 
+// tooManyRequests responds with the rate limit headers, and with Retry-After defined by the exceeded quota.
+func tooManyRequests(
+	w http.ResponseWriter, quotasUsage QuotasUsage, exceeded QuotaRequestsConsumption, now time.Time, msg string,
+) {
+	setRateLimitHeaders(w.Header(), quotasUsage, now)
+	w.Header().Set("Retry-After", resetSeconds(exceeded, now))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(`{"error":"` + msg + `"}`))
+}
+
+// setRateLimitHeaders sets the rate limit headers following the IETF draft
+// https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+// The headers describe the quota closest to exhaustion.
+func setRateLimitHeaders(header http.Header, quotasUsage QuotasUsage, now time.Time) {
+	quota := quotasUsage.RateMinute
+	for _, q := range []QuotaRequestsConsumption{quotasUsage.RateDay, quotasUsage.RateMinuteAttempts} {
+		if remaining(q) < remaining(quota) || (remaining(q) == remaining(quota) && q.Reset >= quota.Reset) {
+			quota = q
+		}
+	}
+
+	header.Set("RateLimit-Limit", strconv.Itoa(int(quota.Limit)))
+	header.Set("RateLimit-Remaining", strconv.Itoa(int(remaining(quota))))
+	header.Set("RateLimit-Reset", resetSeconds(quota, now))
+}
+
+// resetSeconds returns the number of seconds until the quota's reset.
+func resetSeconds(quota QuotaRequestsConsumption, now time.Time) string {
+	v := quota.Reset - now.Unix()
+	if v < 0 {
+		v = 0
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func remaining(q QuotaRequestsConsumption) uint16 {
+	if q.Used >= q.Limit {
+		return 0
+	}
+	return q.Limit - q.Used
+}
+
 // anonym's authentication flow:
 //
 //	Fingerprint found in DB -> No  -> Create \
@@ -210,18 +482,21 @@ func (c client) signinAnonym(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(o)
+	_, _ = w.Write(o.bytes())
 }
 
 // signinUserInit executes user's authentication flow:
 //
 //	Email found in DB -> No  -> Create \
 //			 	   	  -> Yes ->	--	  -> Generate secret and id JWT -> Send secret to email -> Return id JWT
+//
+// The secret is sent either as the code to type in, or as the magic link if the method "link" is requested.
 func (c client) signinUserInit(w http.ResponseWriter, r *http.Request) {
 	defer func() { _ = r.Body.Close() }()
 	var req struct {
 		Email       string `json:"email"`
 		Fingerprint string `json:"fingerprint"`
+		Method      string `json:"method"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -234,8 +509,19 @@ func (c client) signinUserInit(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`{"error":"email must be provided"}`))
 		return
 	}
+	if req.Method != "" && req.Method != signinMethodCode && req.Method != signinMethodLink {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"error":"method must be either 'code', or 'link'"}`))
+		return
+	}
 
-	const defaultExpirationSecret = 10 * time.Minute
+	clientIP := readClientIP(r, c.trustedProxies)
+	if limited, err := isRateLimited(
+		r.Context(), c.rateLimiter, keySecretResendIP(clientIP), windowSecretResendIP, maxSecretResendPerIP,
+	); err != nil || limited {
+		c.tooManySecretRequests(w, err)
+		return
+	}
 
 	userID, _, err := c.clientRepository.LookupUserByEmail(r.Context(), req.Email)
 	if err != nil {
@@ -243,6 +529,15 @@ func (c client) signinUserInit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if userID != "" {
+		if limited, err := isRateLimited(
+			r.Context(), c.rateLimiter, keySecretResendUser(userID), windowSecretResend, maxSecretResendPerUser,
+		); err != nil || limited {
+			c.tooManySecretRequests(w, err)
+			return
+		}
+	}
+
 	if userID == "" {
 		userID = utils.NewUUID()
 		role := uint8(RoleRegisteredUser)
@@ -254,22 +549,46 @@ func (c client) signinUserInit(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	secret := generateOnetimeSecret()
+	generateSecret := generateOnetimeSecret
+	if req.Method == signinMethodLink {
+		generateSecret = generateMagicLinkSecret
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
 	iat := time.Now().UTC()
 
-	if err := c.clientRepository.WriteOneTimeSecret(r.Context(), userID, secret, iat); err != nil {
+	tkn, err := c.tokenIssuer.NewIDToken(
+		userID, req.Email, req.Fingerprint, WithCustomIat(iat), WithValidityDuration(defaultExpirationSecret),
+	)
+	if err != nil {
 		c.internalError(w, err)
 		return
 	}
 
-	if err := c.clientEmail.SendSignInEmail(req.Email, secret); err != nil {
+	if err := c.clientRepository.WriteOneTimeSecret(
+		r.Context(), userID, hashOnetimeSecret(c.secretHashKey, userID, secret), iat,
+	); err != nil {
 		c.internalError(w, err)
 		return
 	}
 
-	tkn, err := c.tokenIssuer.NewIDToken(
-		userID, req.Email, req.Fingerprint, WithCustomIat(iat), WithValidityDuration(defaultExpirationSecret),
-	)
+	if err := c.rateLimiter.Increment(r.Context(), keySecretResendUser(userID), windowSecretResend); err != nil {
+		c.internalError(w, err)
+		return
+	}
+	if err := c.rateLimiter.Increment(r.Context(), keySecretResendIP(clientIP), windowSecretResendIP); err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	if req.Method == signinMethodLink {
+		err = c.clientEmail.Send(NewSignInLinkMessage(req.Email, newMagicLink(c.magicLinkVerifyURL, tkn, secret)))
+	} else {
+		err = c.clientEmail.Send(NewSignInCodeMessage(req.Email, secret))
+	}
 	if err != nil {
 		c.internalError(w, err)
 		return
@@ -286,8 +605,19 @@ func (c client) internalError(w http.ResponseWriter, err error) {
 	c.logger.Println(err)
 }
 
+func (c client) tooManySecretRequests(w http.ResponseWriter, err error) {
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(`{"error":"too many requests"}`))
+}
+
 // signinUserInit executes user's authentication flow:
 // compare secret provided by user against the reference.
+// The anonymous user is upgraded to the registered user if its access token is presented in the Authorization header,
+// i.e. the history of the anonymous user is transferred to the registered user.
 func (c client) signinUserInitSecretConfirmation(w http.ResponseWriter, r *http.Request) {
 	defer func() { _ = r.Body.Close() }()
 	var req struct {
@@ -305,76 +635,434 @@ func (c client) signinUserInitSecretConfirmation(w http.ResponseWriter, r *http.
 		_, _ = w.Write([]byte(`{"error":"token and secret must be provided"}`))
 		return
 	}
-	userID, email, fingerprint, err := c.tokenIssuer.ParseIDToken(req.Token)
+	anonymUserID := c.readAnonymUserID(r)
+
+	o, errSignin := c.completeSignin(r.Context(), req.Token, req.Secret, readClientIP(r, c.trustedProxies), anonymUserID)
+	if errSignin != nil {
+		w.WriteHeader(errSignin.status)
+		_, _ = w.Write([]byte(`{"error":"` + errSignin.msg + `"}`))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(o.bytes())
+}
+
+const (
+	signinMethodCode = "code"
+	signinMethodLink = "link"
+
+	pathMagicLinkVerify = "/auth/verify"
+	pathOIDC            = "/auth/oidc/"
+
+	defaultMagicLinkVerifyURL = "https://api.diagramastext.dev" + pathMagicLinkVerify
+	defaultWebClientURL       = "https://diagramastext.dev/"
+)
+
+// newMagicLink generates the sign-in link which carries the signed id JWT and the one-time secret.
+func newMagicLink(verifyURL, idToken, secret string) string {
+	return verifyURL + "?" + url.Values{"id_token": {idToken}, "secret": {secret}}.Encode()
+}
+
+// signinMagicLinkVerification executes user's authentication flow initiated with the magic link:
+// compare the secret from the link against the reference, and redirect to the web client.
+// The tokens, or the error are passed to the web client in the URL fragment to prevent them from being
+// sent to the web server and leaking to its logs.
+func (c client) signinMagicLinkVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(`{"error":"` + r.Method + ` is not allowed"}`))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	query := r.URL.Query()
+	token, secret := query.Get("id_token"), query.Get("secret")
+	if token == "" || secret == "" {
+		c.redirectToWebClient(w, url.Values{"error": {"token and secret must be provided"}})
+		return
+	}
+
+	o, errSignin := c.completeSignin(r.Context(), token, secret, readClientIP(r, c.trustedProxies), "")
+	if errSignin != nil {
+		c.redirectToWebClient(w, url.Values{"error": {errSignin.msg}})
+		return
+	}
+
+	c.redirectToWebClient(w, url.Values{"id": {o.ID}, "access": {o.Access}, "refresh": {o.Refresh}})
+}
+
+// isRedirectFlowPath defines the sign-in endpoints which are navigated to by the browser.
+func isRedirectFlowPath(p string) bool {
+	return p == pathMagicLinkVerify || strings.HasPrefix(p, pathOIDC)
+}
+
+func (c client) redirectToWebClient(w http.ResponseWriter, fragment url.Values) {
+	w.Header().Set("Location", c.webClientURL+"#"+fragment.Encode())
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// signinOIDC executes user's authentication flow with the identity provider:
+//
+//	GET /auth/oidc/{provider}          -> Store state, nonce and PKCE verifier -> Set state cookie ->
+//										  Redirect to provider
+//	GET /auth/oidc/{provider}/callback -> Match state cookie -> Consume state -> Exchange code -> Verify email ->
+//										  Lookup user by email -> No  -> Create \
+//														   -> Yes -> -- -> Redirect with tokens to web client
+func (c client) signinOIDC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(`{"error":"` + r.Method + ` is not allowed"}`))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, pathOIDC)
+	isCallback := strings.HasSuffix(name, "/callback")
+	name = strings.TrimSuffix(name, "/callback")
+	provider, ok := c.oidcProviders[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"identity provider not found"}`))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	if isCallback {
+		c.signinOIDCCallback(w, r, provider)
+		return
+	}
+
+	state, err := generateRandomSegment()
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+	nonce, err := generateRandomSegment()
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+	codeVerifier, codeChallenge, err := newPKCE()
 	if err != nil {
 		c.internalError(w, err)
 		return
 	}
 
-	found, secretRef, _, err := c.clientRepository.ReadOneTimeSecret(r.Context(), userID)
+	if err := c.oidcStateRepository.WriteOIDCState(
+		r.Context(), state, name, nonce, codeVerifier, time.Now().UTC().Add(defaultExpirationOIDCState),
+	); err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	u, err := provider.authCodeURL(r.Context(), state, nonce, codeChallenge)
 	if err != nil {
 		c.internalError(w, err)
 		return
 	}
 
-	if !found {
-		c.internalError(w, errors.New("no secret was sent"))
+	http.SetCookie(w, newOIDCStateCookie(name, state))
+	w.Header().Set("Location", u)
+	w.WriteHeader(http.StatusFound)
+}
+
+func (c client) signinOIDCCallback(w http.ResponseWriter, r *http.Request, provider *oidcProvider) {
+	// the state cookie is single-use like the state itself
+	expiredCookie := newOIDCStateCookie(provider.cfg.Name, "")
+	expiredCookie.Value, expiredCookie.MaxAge = "", -1
+	http.SetCookie(w, expiredCookie)
+
+	query := r.URL.Query()
+	if v := query.Get("error"); v != "" {
+		c.redirectToWebClient(w, url.Values{"error": {"identity provider error: " + v}})
 		return
 	}
 
-	if req.Secret != secretRef {
-		w.WriteHeader(http.StatusForbidden)
-		_, _ = w.Write([]byte(`{"error":"secret is wrong"}`))
+	code, state := query.Get("code"), query.Get("state")
+	if code == "" || state == "" {
+		c.redirectToWebClient(w, url.Values{"error": {"code and state must be provided"}})
 		return
 	}
 
-	if err := c.clientRepository.UpdateUserSetActive(r.Context(), userID); err != nil {
-		c.internalError(w, err)
+	if !isOIDCStateBoundToBrowser(r, state) {
+		c.redirectToWebClient(w, url.Values{"error": {"state is not valid"}})
 		return
 	}
 
-	_ = c.clientRepository.DeleteOneTimeSecret(r.Context(), userID)
+	found, name, nonce, codeVerifier, expiresAt, err := c.oidcStateRepository.ConsumeOIDCState(r.Context(), state)
+	if err != nil {
+		c.logger.Println(err)
+		c.redirectToWebClient(w, url.Values{"error": {"internal error"}})
+		return
+	}
+	if !found || name != provider.cfg.Name || time.Now().After(expiresAt) {
+		c.redirectToWebClient(w, url.Values{"error": {"state is not valid"}})
+		return
+	}
 
-	o, err := c.issueTokens(
-		r.Context(), User{ID: userID, Role: RoleRegisteredUser}, email, fingerprint,
-	)
+	email, err := provider.readVerifiedEmail(r.Context(), code, codeVerifier, nonce)
 	if err != nil {
-		c.internalError(w, err)
+		c.logger.Printf("oidc provider %s: %v\n", name, err)
+		msg := "authentication failed"
+		if errors.Is(err, errEmailNotVerified) {
+			msg = err.Error()
+		}
+		c.redirectToWebClient(w, url.Values{"error": {msg}})
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(o)
+	o, err := c.signinFederatedUser(r.Context(), email)
+	if err != nil {
+		c.logger.Println(err)
+		msg := "internal error"
+		if errors.Is(err, errUserBlocked) {
+			msg = err.Error()
+		}
+		c.redirectToWebClient(w, url.Values{"error": {msg}})
+		return
+	}
+
+	c.redirectToWebClient(w, url.Values{"id": {o.ID}, "access": {o.Access}, "refresh": {o.Refresh}})
 }
 
-func (c client) issueTokens(_ context.Context, user User, email, fingerprint string) (
-	[]byte, error,
+// signinFederatedUser links the identity verified by the identity provider to the user by email.
+// The user is created if not found, and activated because the email ownership was verified by the provider.
+// The user blocked by the admin is refused.
+func (c client) signinFederatedUser(ctx context.Context, email string) (authTokens, error) {
+	userID, isActive, err := c.clientRepository.LookupUserByEmail(ctx, email)
+	if err != nil {
+		return authTokens{}, err
+	}
+
+	role := RoleRegisteredUser
+	if userID == "" {
+		userID = utils.NewUUID()
+		roleID := uint8(role)
+		if err := c.clientRepository.CreateUser(ctx, userID, email, "", true, &roleID); err != nil {
+			return authTokens{}, err
+		}
+	} else {
+		// the role is read because the user can be promoted, e.g. to admin
+		found, _, isBlocked, roleID, _, _, err := c.clientRepository.ReadUser(ctx, userID)
+		if err != nil {
+			return authTokens{}, err
+		}
+		if !found {
+			return authTokens{}, errors.New("user not found")
+		}
+		if isBlocked {
+			return authTokens{}, errUserBlocked
+		}
+		if !isActive {
+			if err := c.clientRepository.UpdateUserSetActive(ctx, userID); err != nil {
+				return authTokens{}, err
+			}
+		}
+		role = Role(roleID)
+	}
+
+	return c.issueTokens(ctx, User{ID: userID, Role: role}, email, "")
+}
+
+var errUserBlocked = errors.New("user was deactivated")
+
+// errUserNotActive defines the user who was deleted, or upgraded after the access token was issued.
+var errUserNotActive = errors.New("user is not active")
+
+type signinError struct {
+	status int
+	msg    string
+}
+
+// readAnonymUserID reads the anonymous user's ID from the access token in the Authorization header.
+// It returns empty string if no token is presented, or the token belongs to the registered user.
+// The invalid token, e.g. expired after the user's return, does not upgrade the anonymous user,
+// but it does not block the sign-in either: the upgrade is not required to sign in.
+func (c client) readAnonymUserID(r *http.Request) string {
+	key, found := readAuthHeaderValue(r.Header)
+	if !found {
+		return ""
+	}
+	user, err := c.tokenIssuer.ParseAccessToken(key)
+	if err != nil {
+		c.logger.Printf("anonymous user is not upgraded: %v\n", err)
+		return ""
+	}
+	if user.Role != RoleAnonymUser {
+		return ""
+	}
+	return user.ID
+}
+
+// completeSignin validates the one-time secret against the reference,
+// activates the user and issues the tokens. The user blocked by the admin is refused.
+// The anonymous user identified by anonymUserID is upgraded to the registered user, unless anonymUserID is empty.
+func (c client) completeSignin(ctx context.Context, idToken, secret, clientIP, anonymUserID string) (
+	authTokens, *signinError,
 ) {
+	userID, email, fingerprint, err := c.tokenIssuer.ParseIDToken(idToken)
+	if err != nil {
+		return authTokens{}, &signinError{status: http.StatusForbidden, msg: "invalid id_token"}
+	}
+
+	for _, l := range []struct {
+		key    string
+		window time.Duration
+		limit  uint32
+	}{
+		{keySecretAttemptIP(clientIP), windowSecretAttempts, maxSecretAttemptsPerIP},
+		{keySecretAttemptUser(userID), windowSecretAttempts, maxSecretAttemptsPerUser},
+	} {
+		limited, err := isRateLimited(ctx, c.rateLimiter, l.key, l.window, l.limit)
+		if err != nil {
+			return authTokens{}, c.signinInternalError(err)
+		}
+		if limited {
+			return authTokens{}, &signinError{status: http.StatusTooManyRequests, msg: "too many requests"}
+		}
+	}
+
+	found, secretRef, issuedAt, err := c.clientRepository.ReadOneTimeSecret(ctx, userID)
+	if err != nil {
+		return authTokens{}, c.signinInternalError(err)
+	}
+
+	if !found {
+		return authTokens{}, &signinError{status: http.StatusForbidden, msg: "no secret was sent"}
+	}
+
+	if time.Since(issuedAt) > defaultExpirationSecret {
+		_ = c.clientRepository.DeleteOneTimeSecret(ctx, userID)
+		return authTokens{}, &signinError{status: http.StatusForbidden, msg: "secret expired"}
+	}
+
+	if !isOnetimeSecretValid(c.secretHashKey, userID, secret, secretRef) {
+		c.registerFailedSecretAttempt(ctx, userID, clientIP)
+		return authTokens{}, &signinError{status: http.StatusForbidden, msg: "secret is wrong"}
+	}
+
+	// the role is read because the user can be promoted, e.g. to admin
+	found, _, isBlocked, roleID, _, _, err := c.clientRepository.ReadUser(ctx, userID)
+	if err != nil {
+		return authTokens{}, c.signinInternalError(err)
+	}
+	if !found {
+		return authTokens{}, c.signinInternalError(errors.New("user not found"))
+	}
+	if isBlocked {
+		_ = c.clientRepository.DeleteOneTimeSecret(ctx, userID)
+		return authTokens{}, &signinError{status: http.StatusForbidden, msg: errUserBlocked.Error()}
+	}
+
+	if err := c.clientRepository.UpdateUserSetActive(ctx, userID); err != nil {
+		return authTokens{}, c.signinInternalError(err)
+	}
+
+	if anonymUserID != "" && anonymUserID != userID {
+		if err := c.clientRepository.UpgradeAnonymUser(ctx, anonymUserID, userID); err != nil {
+			return authTokens{}, c.signinInternalError(err)
+		}
+		// the anonymous user's fingerprint is attached to the user upon the upgrade
+		if _, _, _, _, _, fingerprint, err = c.clientRepository.ReadUser(ctx, userID); err != nil {
+			return authTokens{}, c.signinInternalError(err)
+		}
+	}
+
+	_ = c.clientRepository.DeleteOneTimeSecret(ctx, userID)
+
+	o, err := c.issueTokens(ctx, User{ID: userID, Role: Role(roleID)}, email, fingerprint)
+	if err != nil {
+		return authTokens{}, c.signinInternalError(err)
+	}
+	return o, nil
+}
+
+func (c client) signinInternalError(err error) *signinError {
+	c.logger.Println(err)
+	return &signinError{status: http.StatusInternalServerError, msg: "internal error"}
+}
+
+// registerFailedSecretAttempt counts the failed attempt to confirm the one-time secret.
+// The user's secret is invalidated once the attempts limit is reached.
+func (c client) registerFailedSecretAttempt(ctx context.Context, userID, clientIP string) {
+	if err := c.rateLimiter.Increment(ctx, keySecretAttemptIP(clientIP), windowSecretAttempts); err != nil {
+		c.logger.Println(err)
+	}
+	if err := c.rateLimiter.Increment(ctx, keySecretAttemptUser(userID), windowSecretAttempts); err != nil {
+		c.logger.Println(err)
+	}
+
+	limited, err := isRateLimited(
+		ctx, c.rateLimiter, keySecretAttemptUser(userID), windowSecretAttempts, maxSecretAttemptsPerUser,
+	)
+	if err != nil {
+		c.logger.Println(err)
+		return
+	}
+	if limited {
+		c.logger.Printf("user %s is locked out after %d failed attempts\n", userID, maxSecretAttemptsPerUser)
+		if err := c.clientRepository.DeleteOneTimeSecret(ctx, userID); err != nil {
+			c.logger.Println(err)
+		}
+	}
+}
+
+type authTokens struct {
+	ID, Access, Refresh string
+}
+
+func (t authTokens) bytes() []byte {
+	return []byte(`{"id":"` + t.ID + `","access":"` + t.Access + `","refresh":"` + t.Refresh + `"}`)
+}
+
+func (c client) issueTokens(ctx context.Context, user User, email, fingerprint string) (authTokens, error) {
 	iat := time.Now().UTC()
 
 	idToken, err := c.tokenIssuer.NewIDToken(user.ID, email, fingerprint, WithCustomIat(iat))
 	if err != nil {
-		return nil, err
+		return authTokens{}, err
 	}
 
 	accessToken, err := c.tokenIssuer.NewAccessToken(user, WithCustomIat(iat))
 	if err != nil {
-		return nil, err
+		return authTokens{}, err
 	}
 
-	refreshToken, err := c.tokenIssuer.NewRefreshToken(user.ID, WithCustomIat(iat))
+	refreshToken, err := c.issueRefreshToken(ctx, user.ID, utils.NewUUID(), iat)
 	if err != nil {
-		return nil, err
+		return authTokens{}, err
 	}
 
-	return []byte(`{"id":"` + idToken + `","access":"` + accessToken + `","refresh":"` + refreshToken + `"}`), nil
+	return authTokens{ID: idToken, Access: accessToken, Refresh: refreshToken}, nil
 }
 
 func (c client) ParseAccessToken(_ context.Context, token string) (User, error) {
 	return c.tokenIssuer.ParseAccessToken(token)
 }
 
-func (c client) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
+// issueRefreshToken issues the refresh token, and registers it as the active token of the family.
+func (c client) issueRefreshToken(ctx context.Context, userID, familyID string, iat time.Time) (string, error) {
+	tokenID := utils.NewUUID()
+	refreshToken, err := c.tokenIssuer.NewRefreshToken(userID, tokenID, WithCustomIat(iat))
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.clientRepository.WriteRefreshToken(
+		ctx, userID, tokenID, familyID, iat.Add(defaultExpirationDurationRefresh),
+	); err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// readRefreshToken reads and parses the refresh token from the request's body.
+func (c client) readRefreshToken(w http.ResponseWriter, r *http.Request) (userID, tokenID string, ok bool) {
 	defer func() { _ = r.Body.Close() }()
 	var req struct {
 		Token string `json:"refresh_token"`
@@ -383,23 +1071,51 @@ func (c client) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte(`{"error":"request parsing error"}`))
 		c.logger.Println(err)
-		return
+		return "", "", false
 	}
 	if req.Token == "" {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		_, _ = w.Write([]byte(`{"error":"token must be provided"}`))
-		return
+		return "", "", false
 	}
 
-	userID, err := c.tokenIssuer.ParseRefreshToken(req.Token)
+	userID, tokenID, err := c.tokenIssuer.ParseRefreshToken(req.Token)
 	if err != nil {
 		w.WriteHeader(http.StatusForbidden)
 		_, _ = w.Write([]byte(`{"error":"token is not valid"}`))
 		c.logger.Println(err)
+		return "", "", false
+	}
+
+	return userID, tokenID, true
+}
+
+// refreshAccessToken issues new id and access tokens, and rotates the refresh token.
+// Presenting the refresh token which was already rotated is treated as the token's theft,
+// hence all tokens of its family are revoked.
+func (c client) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, tokenID, ok := c.readRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	familyID, found, err := c.clientRepository.DeactivateRefreshToken(r.Context(), userID, tokenID)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+	if !found {
+		if err := c.clientRepository.RevokeRefreshTokenFamily(r.Context(), userID, tokenID); err != nil {
+			c.internalError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":"token is not valid"}`))
+		c.logger.Printf("inactive refresh token %s was presented by user %s\n", tokenID, userID)
 		return
 	}
 
-	found, isActive, roleID, email, fingerprint, err := c.clientRepository.ReadUser(r.Context(), userID)
+	found, isActive, isBlocked, roleID, email, fingerprint, err := c.clientRepository.ReadUser(r.Context(), userID)
 	if err != nil {
 		c.internalError(w, err)
 		return
@@ -408,7 +1124,7 @@ func (c client) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
 		c.internalError(w, errors.New("user not found"))
 		return
 	}
-	if !isActive {
+	if !isActive || isBlocked {
 		w.WriteHeader(http.StatusForbidden)
 		_, _ = w.Write([]byte(`{"error":"user was deactivated"}`))
 		c.logger.Printf("user %s was deactivated\n", userID)
@@ -429,8 +1145,58 @@ func (c client) refreshAccessToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	refreshToken, err := c.issueRefreshToken(r.Context(), userID, familyID, iat)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"id":"` + idToken + `","access":"` + accToken + `"}`))
+	_, _ = w.Write(authTokens{ID: idToken, Access: accToken, Refresh: refreshToken}.bytes())
+}
+
+// logout revokes the session, i.e. the family of the presented refresh token.
+// Note that the issued access tokens stay valid until they expire.
+func (c client) logout(w http.ResponseWriter, r *http.Request) {
+	userID, tokenID, ok := c.readRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.clientRepository.RevokeRefreshTokenFamily(r.Context(), userID, tokenID); err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutAll revokes all user's sessions.
+// Note that the issued access tokens stay valid until they expire.
+func (c client) logoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, tokenID, ok := c.readRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	// the token must be active to prevent revocation using the leaked token
+	_, found, err := c.clientRepository.DeactivateRefreshToken(r.Context(), userID, tokenID)
+	if err != nil {
+		c.internalError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":"token is not valid"}`))
+		return
+	}
+
+	if err := c.clientRepository.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+		c.internalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (c client) readUserFromHeader(r *http.Request) (*User, bool, error) {
@@ -445,6 +1211,18 @@ func (c client) readUserFromHeader(r *http.Request) (*User, bool, error) {
 		return nil, false, err
 	}
 
+	// the access token's claims are trusted, except the user's blocking and deletion which take effect immediately
+	found, isActive, isBlocked, _, _, _, err := c.clientRepository.ReadUser(r.Context(), user.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	if isBlocked {
+		return nil, false, errUserBlocked
+	}
+	if !found || !isActive {
+		return nil, false, errUserNotActive
+	}
+
 	return &user, true, nil
 }
 
@@ -459,15 +1237,18 @@ func (c client) readUserFromApiKey(r *http.Request) (*User, bool, error) {
 		return nil, false, err
 	}
 
-	found, isActive, roleID, _, _, err := c.clientRepository.ReadUser(r.Context(), userID)
+	found, isActive, isBlocked, roleID, _, _, err := c.clientRepository.ReadUser(r.Context(), userID)
 	if err != nil {
 		return nil, false, err
 	}
 	if !found {
 		return nil, false, errors.New("user database integrity problem")
 	}
+	if isBlocked {
+		return nil, false, errUserBlocked
+	}
 	if !isActive {
-		return nil, false, errors.New("user " + userID + " is deactivated")
+		return nil, false, errUserNotActive
 	}
 
 	return &User{
@@ -476,16 +1257,3 @@ func (c client) readUserFromApiKey(r *http.Request) (*User, bool, error) {
 		Role:     Role(roleID),
 	}, true, nil
 }
-
-func generateOnetimeSecret() string {
-	const (
-		charset = "0123456789abcdef"
-		length  = 6
-	)
-	var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
-	var b = make([]byte, length)
-	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
-	}
-	return string(b)
-}
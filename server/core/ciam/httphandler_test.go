@@ -2,6 +2,7 @@ package ciam
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"encoding/json"
 	"errors"
@@ -9,6 +10,8 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,10 +29,10 @@ func TestServeHTTP(t *testing.T) {
 				http.Handler, *utils.MockWriter, ed25519.PrivateKey,
 			) {
 				clientRepo := &MockRepositoryCIAM{}
-				smtpClient := &MockSMTPClient{}
+				smtpClient := &MockMailer{}
 				key := GenerateCertificate()
 
-				h, err := HTTPHandler(clientRepo, smtpClient, key)
+				h, err := HTTPHandler(clientRepo, smtpClient, NewKeySet(key))
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -70,7 +73,7 @@ func TestServeHTTP(t *testing.T) {
 				"shall successfully process signin anonym request", func(t *testing.T) {
 					// GIVEN
 					handler, writer, key := init(t)
-					iss, err := NewIssuer(key)
+					iss, err := NewIssuer(NewKeySet(key))
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -110,7 +113,7 @@ func TestServeHTTP(t *testing.T) {
 						if _, err := iss.ParseAccessToken(o.Acc); err != nil {
 							t.Errorf("faulty Access token: %v", err)
 						}
-						if _, err := iss.ParseRefreshToken(o.Ref); err != nil {
+						if _, _, err := iss.ParseRefreshToken(o.Ref); err != nil {
 							t.Errorf("faulty Refresh token: %v", err)
 						}
 					}
@@ -127,7 +130,7 @@ func TestServeHTTP(t *testing.T) {
 				"shall successfully perform user signin init request", func(t *testing.T) {
 					// GIVEN
 					handler, writer, key := init(t)
-					iss, err := NewIssuer(key)
+					iss, err := NewIssuer(NewKeySet(key))
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -174,7 +177,7 @@ func TestServeHTTP(t *testing.T) {
 						wantEmail  = "foo@bar.baz"
 					)
 
-					smtpClient := &MockSMTPClient{}
+					smtpClient := &MockMailer{}
 					key := GenerateCertificate()
 
 					clientRepo := &MockRepositoryCIAM{
@@ -182,24 +185,24 @@ func TestServeHTTP(t *testing.T) {
 							wantUserID: {
 								ID:     wantUserID,
 								Email:  wantEmail,
-								RoleID: uint8(RoleAnonymUser),
+								RoleID: uint8(RoleRegisteredUser),
 							},
 						},
 						Secret: map[string]Secret{
 							wantUserID: {
-								Secret:   wantSecret,
+								Secret:   hashOnetimeSecret(onetimeSecretHashKey(NewKeySet(key)), wantUserID, wantSecret),
 								IssuedAt: time.Now(),
 							},
 						},
 					}
 
-					handlerFn, err := HTTPHandler(clientRepo, smtpClient, key)
+					handlerFn, err := HTTPHandler(clientRepo, smtpClient, NewKeySet(key))
 					if err != nil {
 						t.Fatal(err)
 					}
 					handler := handlerFn(nil)
 
-					iss, err := NewIssuer(key)
+					iss, err := NewIssuer(NewKeySet(key))
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -265,7 +268,7 @@ func TestServeHTTP(t *testing.T) {
 							)
 						}
 
-						if _, err := iss.ParseRefreshToken(o.Ref); err != nil {
+						if _, _, err := iss.ParseRefreshToken(o.Ref); err != nil {
 							t.Errorf("faulty Refresh token: %v", err)
 						}
 
@@ -285,6 +288,11 @@ func TestServeHTTP(t *testing.T) {
 					wantUserID := utils.NewUUID()
 					const wantEmail = "foo@bar.baz"
 
+					const (
+						tokenID  = "9d45d7ea-0b55-4a3a-87b6-1fa0ae1a1a8a"
+						familyID = "2f0d0b1e-7d4a-4a5b-9f3e-1c1b0b6d2a11"
+					)
+
 					clientRepo := &MockRepositoryCIAM{
 						UserID: map[string]*userContainer{
 							wantUserID: {
@@ -294,85 +302,927 @@ func TestServeHTTP(t *testing.T) {
 								RoleID:   uint8(RoleRegisteredUser),
 							},
 						},
+						RefreshToken: map[string]*refreshTokenContainer{
+							tokenID: {UserID: wantUserID, FamilyID: familyID, IsActive: true},
+						},
+					}
+
+					smtpClient := &MockMailer{}
+					key := GenerateCertificate()
+
+					handlerFn, err := HTTPHandler(clientRepo, smtpClient, NewKeySet(key))
+					if err != nil {
+						t.Fatal(err)
+					}
+					handler := handlerFn(nil)
+
+					iss, err := NewIssuer(NewKeySet(key))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					refToken, err := iss.NewRefreshToken(wantUserID, tokenID)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					request := &http.Request{
+						Method: http.MethodPost,
+						URL: &url.URL{
+							Path: "/auth/refresh",
+						},
+						Body: io.NopCloser(
+							bytes.NewReader(
+								[]byte(
+									`{"refresh_token":"` + refToken + `"}`,
+								),
+							),
+						),
+					}
+
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					wantStatus := http.StatusOK
+					var wantBodyValid = func(v []byte) {
+						var o struct {
+							ID  string `json:"id"`
+							Acc string `json:"access"`
+							Ref string `json:"refresh"`
+						}
+						if err := json.Unmarshal(v, &o); err != nil {
+							t.Fatal(err)
+						}
+
+						if _, _, _, err := iss.ParseIDToken(o.ID); err != nil {
+							t.Errorf("faulty ID token: %v", err)
+						}
+
+						_, newTokenID, err := iss.ParseRefreshToken(o.Ref)
+						if err != nil {
+							t.Errorf("faulty Refresh token: %v", err)
+						}
+						if v, ok := clientRepo.RefreshToken[newTokenID]; !ok || !v.IsActive || v.FamilyID != familyID {
+							t.Errorf("rotated refresh token is expected to be active and to belong to the same family")
+						}
+						if clientRepo.RefreshToken[tokenID].IsActive {
+							t.Errorf("presented refresh token is expected to be deactivated")
+						}
+
+						user, err := iss.ParseAccessToken(o.Acc)
+						if err != nil {
+							t.Errorf("faulty Access token: %v", err)
+						}
+
+						if user.ID != wantUserID {
+							t.Errorf(
+								"faulty Access token, userID does not match. want: %s, got: %s",
+								wantUserID, user.ID,
+							)
+						}
+
+						if user.Role != RoleRegisteredUser {
+							t.Errorf(
+								"faulty Access token, user's role does not match. want: %d, got: %d",
+								RoleRegisteredUser, user.Role,
+							)
+						}
+					}
+
+					if writer.StatusCode != wantStatus {
+						t.Errorf("wrong status code. want: %d, got: %d", wantStatus, writer.StatusCode)
+					}
+
+					wantBodyValid(writer.V)
+				},
+			)
+
+			var initSecretConfirmationWithKey = func(
+				t *testing.T, key ed25519.PrivateKey, issuedAt time.Time, fnOps ...HTTPHandlerOps,
+			) (
+				http.Handler, *MockRepositoryCIAM, func(secret string) *http.Request,
+			) {
+				userID := utils.NewUUID()
+				const email = "foo@bar.baz"
+
+				clientRepo := &MockRepositoryCIAM{
+					UserID: map[string]*userContainer{
+						userID: {ID: userID, Email: email, RoleID: uint8(RoleRegisteredUser)},
+					},
+					Secret: map[string]Secret{
+						userID: {
+							Secret:   hashOnetimeSecret(onetimeSecretHashKey(NewKeySet(key)), userID, "foobar"),
+							IssuedAt: issuedAt,
+						},
+					},
+				}
+
+				handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(key), fnOps...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				iss, err := NewIssuer(NewKeySet(key))
+				if err != nil {
+					t.Fatal(err)
+				}
+				idToken, err := iss.NewIDToken(userID, email, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return handlerFn(nil), clientRepo, func(secret string) *http.Request {
+					return &http.Request{
+						Method: http.MethodPost,
+						URL:    &url.URL{Path: "/auth/confirm"},
+						Header: http.Header{"X-Forwarded-For": []string{"10.0.0.1, 10.0.0.2"}},
+						Body: io.NopCloser(
+							bytes.NewReader([]byte(`{"secret":"` + secret + `","id_token":"` + idToken + `"}`)),
+						),
+					}
+				}
+			}
+
+			var initSecretConfirmation = func(t *testing.T, issuedAt time.Time, fnOps ...HTTPHandlerOps) (
+				http.Handler, *MockRepositoryCIAM, func(secret string) *http.Request,
+			) {
+				return initSecretConfirmationWithKey(t, GenerateCertificate(), issuedAt, fnOps...)
+			}
+
+			t.Run(
+				"shall reject expired secret", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, newRequest := initSecretConfirmation(
+						t, time.Now().Add(-defaultExpirationSecret-time.Second),
+					)
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, newRequest("foobar"))
+
+					// THEN
+					if writer.StatusCode != http.StatusForbidden {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusForbidden, writer.StatusCode)
+					}
+					if string(writer.V) != `{"error":"secret expired"}` {
+						t.Errorf("unexpected response: %s", writer.V)
+					}
+					if len(clientRepo.Secret) != 0 {
+						t.Error("expired secret is expected to be deleted")
+					}
+				},
+			)
+
+			t.Run(
+				"shall reject invalid id_token", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, _ := initSecretConfirmation(t, time.Now())
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(
+						writer, &http.Request{
+							Method: http.MethodPost,
+							URL:    &url.URL{Path: "/auth/confirm"},
+							Body: io.NopCloser(
+								bytes.NewReader([]byte(`{"secret":"foobar","id_token":"foo.bar.baz"}`)),
+							),
+						},
+					)
+
+					// THEN
+					if writer.StatusCode != http.StatusForbidden {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusForbidden, writer.StatusCode)
+					}
+					if string(writer.V) != `{"error":"invalid id_token"}` {
+						t.Errorf("unexpected response: %s", writer.V)
+					}
+					if len(clientRepo.Secret) != 1 {
+						t.Error("secret is expected to be kept")
+					}
+				},
+			)
+
+			t.Run(
+				"shall lock out the user after too many failed attempts", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, newRequest := initSecretConfirmation(t, time.Now())
+
+					// WHEN
+					for i := 0; i < maxSecretAttemptsPerUser; i++ {
+						writer := &utils.MockWriter{}
+						handler.ServeHTTP(writer, newRequest("wrong"))
+						if writer.StatusCode != http.StatusForbidden {
+							t.Fatalf("wrong status code. want: %d, got: %d", http.StatusForbidden, writer.StatusCode)
+						}
+					}
+
+					writer := &utils.MockWriter{}
+					handler.ServeHTTP(writer, newRequest("foobar"))
+
+					// THEN
+					if writer.StatusCode != http.StatusTooManyRequests {
+						t.Errorf(
+							"wrong status code. want: %d, got: %d", http.StatusTooManyRequests, writer.StatusCode,
+						)
+					}
+					if len(clientRepo.Secret) != 0 {
+						t.Error("secret is expected to be invalidated upon lockout")
+					}
+				},
+			)
+
+			t.Run(
+				"shall issue the access token with the user's stored role upon confirmation", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, newRequest := initSecretConfirmation(t, time.Now())
+					for _, u := range clientRepo.UserID {
+						u.RoleID = uint8(RoleAdmin)
+					}
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, newRequest("foobar"))
+
+					// THEN
+					if writer.StatusCode != http.StatusOK {
+						t.Fatalf("wrong status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+					var tokens struct {
+						Access string `json:"access"`
+					}
+					if err := json.Unmarshal(writer.V, &tokens); err != nil {
+						t.Fatal(err)
+					}
+					claims, err := decodeSegment(strings.Split(tokens.Access, ".")[1])
+					if err != nil {
+						t.Fatal(err)
+					}
+					var got accessTokenClaims
+					if err := json.Unmarshal(claims, &got); err != nil {
+						t.Fatal(err)
+					}
+					if got.Role != RoleAdmin {
+						t.Errorf("unexpected user's role. want: %d, got: %d", RoleAdmin, got.Role)
+					}
+				},
+			)
+
+			t.Run(
+				"shall not reset the client's IP lockout given spoofed X-Forwarded-For", func(t *testing.T) {
+					// GIVEN
+					const clientIP = "203.0.113.1"
+					now := time.Now().UTC()
+					rateLimiter := &slidingWindowRateLimiter{
+						repository: &mockRepositoryRateLimiter{
+							Counters: map[string]map[time.Time]uint32{
+								counterKey(keySecretAttemptIP(clientIP), windowSecretAttempts): {
+									now.Truncate(windowSecretAttempts): maxSecretAttemptsPerIP,
+								},
+								counterKey(keySecretResendIP(clientIP), windowSecretResendIP): {
+									now.Truncate(windowSecretResendIP): maxSecretResendPerIP,
+								},
+							},
+						},
+						now: func() time.Time { return now },
+					}
+
+					handler, _, newRequest := initSecretConfirmation(t, now, WithRateLimiter(rateLimiter))
+
+					requestConfirm := newRequest("foobar")
+					requestConfirm.RemoteAddr = clientIP + ":8080"
+
+					requestInit := &http.Request{
+						Method:     http.MethodPost,
+						URL:        &url.URL{Path: "/auth/init"},
+						RemoteAddr: clientIP + ":8080",
+						Header:     http.Header{"X-Forwarded-For": []string{"198.51.100.1"}},
+						Body:       io.NopCloser(bytes.NewReader([]byte(`{"email":"qux@bar.baz"}`))),
+					}
+
+					for _, request := range []*http.Request{requestConfirm, requestInit} {
+						writer := &utils.MockWriter{}
+
+						// WHEN
+						handler.ServeHTTP(writer, request)
+
+						// THEN
+						if writer.StatusCode != http.StatusTooManyRequests {
+							t.Errorf(
+								"wrong status code for %s. want: %d, got: %d", request.URL.Path,
+								http.StatusTooManyRequests, writer.StatusCode,
+							)
+						}
+					}
+				},
+			)
+
+			t.Run(
+				"shall upgrade the anonymous user given its access token upon confirmation", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, newRequest := initSecretConfirmation(t, time.Now())
+					var userID string
+					for id := range clientRepo.UserID {
+						userID = id
+					}
+
+					const fingerprint = "c9a7e9ba4b1f6d2c5e8b0a3f7d1c4e6b9a2f5d8c"
+					anonymUserID := utils.NewUUID()
+					role := uint8(RoleAnonymUser)
+					if err := clientRepo.CreateUser(
+						context.TODO(), anonymUserID, "", fingerprint, true, &role,
+					); err != nil {
+						t.Fatal(err)
+					}
+
+					request := newRequest("foobar")
+					writer := &utils.MockWriter{}
+					handler.ServeHTTP(
+						writer, &http.Request{
+							Method: http.MethodPost,
+							URL:    &url.URL{Path: "/auth/anonym"},
+							Body:   io.NopCloser(bytes.NewReader([]byte(`{"fingerprint":"` + fingerprint + `"}`))),
+						},
+					)
+					var anonymTokens struct {
+						Access string `json:"access"`
+					}
+					if err := json.Unmarshal(writer.V, &anonymTokens); err != nil {
+						t.Fatal(err)
+					}
+					request.Header.Set("Authorization", "Bearer "+anonymTokens.Access)
+
+					// WHEN
+					writer = &utils.MockWriter{}
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusOK {
+						t.Fatalf("wrong status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+					if clientRepo.UpgradedUsers[anonymUserID] != userID {
+						t.Errorf("anonymous user is expected to be upgraded to the user %s", userID)
+					}
+					if clientRepo.UserID[userID].Fingerprint != "" {
+						t.Error("fingerprint is not expected to be attached to the registered user")
+					}
+					if clientRepo.UserID[anonymUserID].IsActive {
+						t.Error("anonymous user is expected to be deactivated")
+					}
+				},
+			)
+
+			t.Run(
+				"shall not sign in the upgraded user anonymously given the fingerprint", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, newRequest := initSecretConfirmation(t, time.Now())
+					var userID string
+					for id := range clientRepo.UserID {
+						userID = id
+					}
+
+					const fingerprint = "c9a7e9ba4b1f6d2c5e8b0a3f7d1c4e6b9a2f5d8c"
+					newAnonymRequest := func() *http.Request {
+						return &http.Request{
+							Method: http.MethodPost,
+							URL:    &url.URL{Path: "/auth/anonym"},
+							Body:   io.NopCloser(bytes.NewReader([]byte(`{"fingerprint":"` + fingerprint + `"}`))),
+						}
+					}
+
+					writer := &utils.MockWriter{}
+					handler.ServeHTTP(writer, newAnonymRequest())
+					anonymUserID := clientRepo.UserFingerprint[fingerprint].ID
+					var anonymTokens struct {
+						Access string `json:"access"`
+					}
+					if err := json.Unmarshal(writer.V, &anonymTokens); err != nil {
+						t.Fatal(err)
+					}
+					request := newRequest("foobar")
+					request.Header.Set("Authorization", "Bearer "+anonymTokens.Access)
+					handler.ServeHTTP(&utils.MockWriter{}, request)
+					if clientRepo.UpgradedUsers[anonymUserID] != userID {
+						t.Fatalf("anonymous user is expected to be upgraded to the user %s", userID)
+					}
+
+					// WHEN
+					writer = &utils.MockWriter{}
+					handler.ServeHTTP(writer, newAnonymRequest())
+
+					// THEN
+					if writer.StatusCode != http.StatusOK {
+						t.Fatalf("wrong status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+					got, ok := clientRepo.UserFingerprint[fingerprint]
+					if !ok || got.ID == userID || got.ID == anonymUserID || Role(got.RoleID) != RoleAnonymUser {
+						t.Error("new anonymous user is expected to be signed in instead of the registered user")
+					}
+				},
+			)
+
+			t.Run(
+				"shall not sign in the registered user anonymously given its fingerprint", func(t *testing.T) {
+					// GIVEN
+					const fingerprint = "c9a7e9ba4b1f6d2c5e8b0a3f7d1c4e6b9a2f5d8c"
+					userID := utils.NewUUID()
+					user := &userContainer{
+						ID: userID, Email: "foo@bar.baz", Fingerprint: fingerprint, IsActive: true,
+						RoleID: uint8(RoleRegisteredUser),
+					}
+					clientRepo := &MockRepositoryCIAM{
+						UserID:          map[string]*userContainer{userID: user},
+						UserFingerprint: map[string]*userContainer{fingerprint: user},
+					}
+					handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()))
+					if err != nil {
+						t.Fatal(err)
+					}
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handlerFn(nil).ServeHTTP(
+						writer, &http.Request{
+							Method: http.MethodPost,
+							URL:    &url.URL{Path: "/auth/anonym"},
+							Body:   io.NopCloser(bytes.NewReader([]byte(`{"fingerprint":"` + fingerprint + `"}`))),
+						},
+					)
+
+					// THEN
+					if writer.StatusCode != http.StatusOK {
+						t.Fatalf("wrong status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+					if got := clientRepo.UserFingerprint[fingerprint]; got.ID == userID {
+						t.Error("new anonymous user is expected to be signed in instead of the registered user")
+					}
+				},
+			)
+
+			t.Run(
+				"shall confirm without upgrade given faulty access token", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, newRequest := initSecretConfirmation(t, time.Now())
+					request := newRequest("foobar")
+					request.Header.Set("Authorization", "Bearer foo.bar.baz")
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusOK {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+					if len(clientRepo.UpgradedUsers) != 0 {
+						t.Error("no user is expected to be upgraded")
+					}
+				},
+			)
+
+			t.Run(
+				"shall confirm without upgrade given expired anonymous user's access token", func(t *testing.T) {
+					// GIVEN
+					key := GenerateCertificate()
+					handler, clientRepo, newRequest := initSecretConfirmationWithKey(t, key, time.Now())
+					iss, err := NewIssuer(NewKeySet(key))
+					if err != nil {
+						t.Fatal(err)
+					}
+					anonymUserID := utils.NewUUID()
+					role := uint8(RoleAnonymUser)
+					if err := clientRepo.CreateUser(context.TODO(), anonymUserID, "", "", true, &role); err != nil {
+						t.Fatal(err)
+					}
+					expired, err := iss.NewAccessToken(
+						User{ID: anonymUserID, Role: RoleAnonymUser}, WithCustomIat(time.Now().Add(-2*time.Hour)),
+					)
+					if err != nil {
+						t.Fatal(err)
+					}
+					request := newRequest("foobar")
+					request.Header.Set("Authorization", "Bearer "+expired)
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusOK {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+					if len(clientRepo.UpgradedUsers) != 0 {
+						t.Error("no user is expected to be upgraded")
+					}
+					if len(clientRepo.Secret) != 0 {
+						t.Error("secret is expected to be consumed")
+					}
+				},
+			)
+
+			t.Run(
+				"shall throttle secret resend requests", func(t *testing.T) {
+					// GIVEN
+					handler, _, _ := init(t)
+					newRequest := func() *http.Request {
+						return &http.Request{
+							Method: http.MethodPost,
+							URL:    &url.URL{Path: "/auth/init"},
+							Body:   io.NopCloser(bytes.NewReader([]byte(`{"email":"foo@bar.baz"}`))),
+						}
+					}
+
+					writer := &utils.MockWriter{}
+					handler.ServeHTTP(writer, newRequest())
+					if writer.StatusCode != http.StatusOK {
+						t.Fatalf("wrong status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+
+					// WHEN
+					writer = &utils.MockWriter{}
+					handler.ServeHTTP(writer, newRequest())
+
+					// THEN
+					if writer.StatusCode != http.StatusTooManyRequests {
+						t.Errorf(
+							"wrong status code. want: %d, got: %d", http.StatusTooManyRequests, writer.StatusCode,
+						)
+					}
+				},
+			)
+
+			t.Run(
+				"shall sign in with the single-use magic link", func(t *testing.T) {
+					// GIVEN
+					clientRepo := &MockRepositoryCIAM{}
+					smtpClient := &MockMailer{}
+					key := GenerateCertificate()
+
+					handlerFn, err := HTTPHandler(
+						clientRepo, smtpClient, NewKeySet(key),
+						WithMagicLink("https://api.foo.bar/auth/verify", "https://foo.bar/signin"),
+					)
+					if err != nil {
+						t.Fatal(err)
+					}
+					handler := handlerFn(nil)
+
+					writer := &utils.MockWriter{}
+					handler.ServeHTTP(
+						writer, &http.Request{
+							Method: http.MethodPost,
+							URL:    &url.URL{Path: "/auth/init"},
+							Body: io.NopCloser(
+								bytes.NewReader([]byte(`{"email":"foo@bar.baz","method":"link"}`)),
+							),
+						},
+					)
+					if writer.StatusCode != http.StatusOK {
+						t.Fatalf("wrong status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+					msg := smtpClient.LastMessage()
+					if msg.Type != MessageSignInLink || msg.Data["link"] == "" {
+						t.Fatal("the magic link is expected to be sent instead of the code")
+					}
+
+					link, err := url.Parse(msg.Data["link"])
+					if err != nil {
+						t.Fatal(err)
+					}
+					if link.Host != "api.foo.bar" || link.Path != pathMagicLinkVerify {
+						t.Fatalf("unexpected link: %s", msg.Data["link"])
+					}
+					newRequest := func() *http.Request {
+						return &http.Request{Method: http.MethodGet, URL: &url.URL{Path: link.Path, RawQuery: link.RawQuery}}
+					}
+
+					// WHEN
+					writer = &utils.MockWriter{}
+					handler.ServeHTTP(writer, newRequest())
+
+					// THEN
+					if writer.StatusCode != http.StatusSeeOther {
+						t.Fatalf("wrong status code. want: %d, got: %d", http.StatusSeeOther, writer.StatusCode)
+					}
+					location, err := url.Parse(writer.Header().Get("Location"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if location.Host != "foo.bar" || location.Path != "/signin" {
+						t.Errorf("unexpected redirect location: %s", location)
+					}
+					fragment, err := url.ParseQuery(location.Fragment)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					iss, err := NewIssuer(NewKeySet(key))
+					if err != nil {
+						t.Fatal(err)
+					}
+					user, err := iss.ParseAccessToken(fragment.Get("access"))
+					if err != nil {
+						t.Fatalf("faulty Access token: %v", err)
+					}
+					if user.Role != RoleRegisteredUser {
+						t.Errorf("unexpected user's role: %d", user.Role)
+					}
+					if _, _, _, err := iss.ParseIDToken(fragment.Get("id")); err != nil {
+						t.Errorf("faulty ID token: %v", err)
+					}
+					if _, _, err := iss.ParseRefreshToken(fragment.Get("refresh")); err != nil {
+						t.Errorf("faulty Refresh token: %v", err)
+					}
+					if !clientRepo.UserID[user.ID].IsActive {
+						t.Error("user is expected to be activated")
+					}
+
+					t.Run(
+						"shall reject the magic link used twice", func(t *testing.T) {
+							writer := &utils.MockWriter{}
+							handler.ServeHTTP(writer, newRequest())
+
+							if writer.StatusCode != http.StatusSeeOther {
+								t.Fatalf("wrong status code. want: %d, got: %d", http.StatusSeeOther, writer.StatusCode)
+							}
+							want := "https://foo.bar/signin#error=no+secret+was+sent"
+							if got := writer.Header().Get("Location"); got != want {
+								t.Errorf("unexpected redirect location. want: %s, got: %s", want, got)
+							}
+						},
+					)
+				},
+			)
+
+			t.Run(
+				"shall redirect with error given the magic link with wrong secret", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, newRequest := initSecretConfirmation(t, time.Now())
+					confirmationRequest := newRequest("wrong")
+					var req struct {
+						Token string `json:"id_token"`
+					}
+					if err := json.NewDecoder(confirmationRequest.Body).Decode(&req); err != nil {
+						t.Fatal(err)
+					}
+
+					link, err := url.Parse(newMagicLink(defaultMagicLinkVerifyURL, req.Token, "wrong"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(
+						writer, &http.Request{
+							Method: http.MethodGet,
+							URL:    &url.URL{Path: link.Path, RawQuery: link.RawQuery},
+						},
+					)
+
+					// THEN
+					if writer.StatusCode != http.StatusSeeOther {
+						t.Fatalf("wrong status code. want: %d, got: %d", http.StatusSeeOther, writer.StatusCode)
+					}
+					want := defaultWebClientURL + "#error=secret+is+wrong"
+					if got := writer.Header().Get("Location"); got != want {
+						t.Errorf("unexpected redirect location. want: %s, got: %s", want, got)
+					}
+					if len(clientRepo.Secret) != 1 {
+						t.Error("secret is expected to be kept until the attempts limit is reached")
+					}
+				},
+			)
+
+			t.Run(
+				"shall fail on POST request method for the magic link verification", func(t *testing.T) {
+					// GIVEN
+					handler, writer, _ := init(t)
+
+					// WHEN
+					handler.ServeHTTP(
+						writer, &http.Request{Method: http.MethodPost, URL: &url.URL{Path: pathMagicLinkVerify}},
+					)
+
+					// THEN
+					if writer.StatusCode != http.StatusMethodNotAllowed {
+						t.Errorf(
+							"wrong status code. want: %d, got: %d", http.StatusMethodNotAllowed, writer.StatusCode,
+						)
+					}
+				},
+			)
+
+			t.Run(
+				"shall fail on unknown sign-in method", func(t *testing.T) {
+					// GIVEN
+					handler, writer, _ := init(t)
+
+					// WHEN
+					handler.ServeHTTP(
+						writer, &http.Request{
+							Method: http.MethodPost,
+							URL:    &url.URL{Path: "/auth/init"},
+							Body: io.NopCloser(
+								bytes.NewReader([]byte(`{"email":"foo@bar.baz","method":"pigeon"}`)),
+							),
+						},
+					)
+
+					// THEN
+					if writer.StatusCode != http.StatusUnprocessableEntity {
+						t.Errorf(
+							"wrong status code. want: %d, got: %d", http.StatusUnprocessableEntity, writer.StatusCode,
+						)
+					}
+				},
+			)
+
+			t.Run(
+				"shall refuse to sign in the user blocked by the admin", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, newRequest := initSecretConfirmation(t, time.Now())
+					for _, u := range clientRepo.UserID {
+						u.IsBlocked = true
+					}
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, newRequest("foobar"))
+
+					// THEN
+					if writer.StatusCode != http.StatusForbidden {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusForbidden, writer.StatusCode)
+					}
+					if string(writer.V) != `{"error":"user was deactivated"}` {
+						t.Errorf("unexpected response: %s", writer.V)
+					}
+					for _, u := range clientRepo.UserID {
+						if u.IsActive {
+							t.Error("blocked user is not expected to be activated")
+						}
+					}
+					if len(clientRepo.Secret) != 0 {
+						t.Error("secret is expected to be invalidated")
+					}
+				},
+			)
+
+			var initRefreshTokenFamily = func(t *testing.T) (
+				http.Handler, *MockRepositoryCIAM, Issuer, string,
+			) {
+				userID := utils.NewUUID()
+				clientRepo := &MockRepositoryCIAM{
+					UserID: map[string]*userContainer{
+						userID: {
+							ID:       userID,
+							Email:    "foo@bar.baz",
+							IsActive: true,
+							RoleID:   uint8(RoleRegisteredUser),
+						},
+					},
+					RefreshToken: map[string]*refreshTokenContainer{
+						"rotated": {UserID: userID, FamilyID: "foo", IsActive: false},
+						"active":  {UserID: userID, FamilyID: "foo", IsActive: true},
+						"other":   {UserID: userID, FamilyID: "bar", IsActive: true},
+					},
+				}
+
+				key := GenerateCertificate()
+				handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(key))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				iss, err := NewIssuer(NewKeySet(key))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return handlerFn(nil), clientRepo, iss, userID
+			}
+
+			var newRefreshTokenRequest = func(t *testing.T, iss Issuer, path, userID, tokenID string) *http.Request {
+				tkn, err := iss.NewRefreshToken(userID, tokenID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return &http.Request{
+					Method: http.MethodPost,
+					URL:    &url.URL{Path: path},
+					Body:   io.NopCloser(bytes.NewReader([]byte(`{"refresh_token":"` + tkn + `"}`))),
+				}
+			}
+
+			t.Run(
+				"shall revoke the tokens family given rotated refresh token is reused", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, iss, userID := initRefreshTokenFamily(t)
+					request := newRefreshTokenRequest(t, iss, "/auth/refresh", userID, "rotated")
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusForbidden {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusForbidden, writer.StatusCode)
+					}
+					if clientRepo.RefreshToken["active"].IsActive {
+						t.Error("active token of the family is expected to be revoked")
+					}
+					if !clientRepo.RefreshToken["other"].IsActive {
+						t.Error("token of other family is expected to stay active")
+					}
+				},
+			)
+
+			t.Run(
+				"shall refuse to refresh the access token of the user blocked by the admin", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, iss, userID := initRefreshTokenFamily(t)
+					clientRepo.UserID[userID].IsBlocked = true
+					request := newRefreshTokenRequest(t, iss, "/auth/refresh", userID, "active")
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusForbidden {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusForbidden, writer.StatusCode)
+					}
+					if string(writer.V) != `{"error":"user was deactivated"}` {
+						t.Errorf("unexpected response: %s", writer.V)
 					}
+				},
+			)
 
-					smtpClient := &MockSMTPClient{}
-					key := GenerateCertificate()
+			t.Run(
+				"shall revoke the session on logout", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, iss, userID := initRefreshTokenFamily(t)
+					request := newRefreshTokenRequest(t, iss, "/auth/logout", userID, "active")
+					writer := &utils.MockWriter{}
 
-					handlerFn, err := HTTPHandler(clientRepo, smtpClient, key)
-					if err != nil {
-						t.Fatal(err)
-					}
-					handler := handlerFn(nil)
+					// WHEN
+					handler.ServeHTTP(writer, request)
 
-					iss, err := NewIssuer(key)
-					if err != nil {
-						t.Fatal(err)
+					// THEN
+					if writer.StatusCode != http.StatusNoContent {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusNoContent, writer.StatusCode)
 					}
-
-					refToken, err := iss.NewRefreshToken(wantUserID)
-					if err != nil {
-						t.Fatal(err)
+					if clientRepo.RefreshToken["active"].IsActive {
+						t.Error("token is expected to be revoked")
 					}
-
-					request := &http.Request{
-						Method: http.MethodPost,
-						URL: &url.URL{
-							Path: "/auth/refresh",
-						},
-						Body: io.NopCloser(
-							bytes.NewReader(
-								[]byte(
-									`{"refresh_token":"` + refToken + `"}`,
-								),
-							),
-						),
+					if !clientRepo.RefreshToken["other"].IsActive {
+						t.Error("token of other session is expected to stay active")
 					}
+				},
+			)
 
+			t.Run(
+				"shall revoke all sessions on logout from all sessions", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, iss, userID := initRefreshTokenFamily(t)
+					request := newRefreshTokenRequest(t, iss, "/auth/logout-all", userID, "active")
 					writer := &utils.MockWriter{}
 
 					// WHEN
 					handler.ServeHTTP(writer, request)
 
 					// THEN
-					wantStatus := http.StatusOK
-					var wantBodyValid = func(v []byte) {
-						var o struct {
-							ID  string `json:"id"`
-							Acc string `json:"access"`
-						}
-						if err := json.Unmarshal(v, &o); err != nil {
-							t.Fatal(err)
+					if writer.StatusCode != http.StatusNoContent {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusNoContent, writer.StatusCode)
+					}
+					for id, v := range clientRepo.RefreshToken {
+						if v.IsActive {
+							t.Errorf("token %s is expected to be revoked", id)
 						}
+					}
+				},
+			)
 
-						if _, _, _, err := iss.ParseIDToken(o.ID); err != nil {
-							t.Errorf("faulty ID token: %v", err)
-						}
-						user, err := iss.ParseAccessToken(o.Acc)
-						if err != nil {
-							t.Errorf("faulty Access token: %v", err)
-						}
+			t.Run(
+				"shall not log out from all sessions given inactive refresh token", func(t *testing.T) {
+					// GIVEN
+					handler, clientRepo, iss, userID := initRefreshTokenFamily(t)
+					request := newRefreshTokenRequest(t, iss, "/auth/logout-all", userID, "rotated")
+					writer := &utils.MockWriter{}
 
-						if user.ID != wantUserID {
-							t.Errorf(
-								"faulty Access token, userID does not match. want: %s, got: %s",
-								wantUserID, user.ID,
-							)
-						}
+					// WHEN
+					handler.ServeHTTP(writer, request)
 
-						if user.Role != RoleRegisteredUser {
-							t.Errorf(
-								"faulty Access token, user's role does not match. want: %d, got: %d",
-								RoleRegisteredUser, user.Role,
-							)
-						}
+					// THEN
+					if writer.StatusCode != http.StatusForbidden {
+						t.Errorf("wrong status code. want: %d, got: %d", http.StatusForbidden, writer.StatusCode)
 					}
-
-					if writer.StatusCode != wantStatus {
-						t.Errorf("wrong status code. want: %d, got: %d", wantStatus, writer.StatusCode)
+					if !clientRepo.RefreshToken["other"].IsActive {
+						t.Error("token is expected to stay active")
 					}
-
-					wantBodyValid(writer.V)
 				},
 			)
 		},
@@ -409,13 +1259,68 @@ func TestServeHTTP(t *testing.T) {
 				return clientRepo, header, userID
 			}
 
+			t.Run(
+				"shall refuse the API call of the user blocked by the admin", func(t *testing.T) {
+					key := GenerateCertificate()
+					iss, err := NewIssuer(NewKeySet(key))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					for name, fn := range map[string]func(header http.Header, userID string){
+						"API-KEY": func(http.Header, string) {},
+						"access token": func(header http.Header, userID string) {
+							header.Del("X-API-KEY")
+							tkn, err := iss.NewAccessToken(User{ID: userID, Role: RoleRegisteredUser})
+							if err != nil {
+								t.Fatal(err)
+							}
+							header.Set("Authorization", "Bearer "+tkn)
+						},
+					} {
+						t.Run(
+							name, func(t *testing.T) {
+								// GIVEN
+								clientRepo, header, userID := initApiCallByRegisteredUser()
+								clientRepo.(*MockRepositoryCIAM).UserID[userID].IsBlocked = true
+								fn(header, userID)
+
+								handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(key))
+								if err != nil {
+									t.Fatal(err)
+								}
+								writer := &utils.MockWriter{}
+
+								// WHEN
+								handlerFn(mockHandlerAPIcall{userID: userID}).ServeHTTP(
+									writer, &http.Request{
+										Method: http.MethodPost, URL: &url.URL{Path: "/foo"}, Header: header,
+									},
+								)
+
+								// THEN
+								if writer.StatusCode != http.StatusForbidden {
+									t.Errorf(
+										"unexpected status code. want: %d, got: %d", http.StatusForbidden,
+										writer.StatusCode,
+									)
+								}
+								if string(writer.V) != `{"error":"user was deactivated"}` {
+									t.Errorf("unexpected response: %s", writer.V)
+								}
+							},
+						)
+					}
+				},
+			)
+
 			t.Run(
 				"shall return quotas upon an API call given a valid API-KEY, the used has not used the service yet",
 				func(t *testing.T) {
 					// GIVEN
 					clientRepo, header, _ := initApiCallByRegisteredUser()
 
-					handlerFn, err := HTTPHandler(clientRepo, &MockSMTPClient{}, GenerateCertificate())
+					handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()))
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -474,7 +1379,7 @@ func TestServeHTTP(t *testing.T) {
 					// GIVEN
 					clientRepo, header, userID := initApiCallByRegisteredUser()
 
-					handlerFn, err := HTTPHandler(clientRepo, &MockSMTPClient{}, GenerateCertificate())
+					handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()))
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -505,12 +1410,15 @@ func TestServeHTTP(t *testing.T) {
 			t.Run(
 				"shall return throttling error on API calls given a valid API-KEY", func(t *testing.T) {
 					// GIVEN
-					clientRepo, header, _ := initApiCallByRegisteredUser()
-					clientRepo.(*MockRepositoryCIAM).Timestamps = repeatTimestamp(
-						time.Now(), RoleRegisteredUser.Quotas().RequestsPerMinute+1,
+					clientRepo, header, userID := initApiCallByRegisteredUser()
+					rateLimiter := newRateLimiterWithCounters(
+						time.Now().UTC(), keyRequestsSuccess(userID), windowMinute,
+						uint32(RoleRegisteredUser.Quotas().RequestsPerMinute),
 					)
 
-					handlerFn, err := HTTPHandler(clientRepo, &MockSMTPClient{}, GenerateCertificate())
+					handlerFn, err := HTTPHandler(
+						clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()), WithRateLimiter(rateLimiter),
+					)
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -538,11 +1446,180 @@ func TestServeHTTP(t *testing.T) {
 				},
 			)
 
+			assertRateLimitHeaders := func(
+				t *testing.T, header http.Header, wantLimit, wantRemaining uint16, wantResetMax int64,
+				wantRetryAfter bool,
+			) {
+				t.Helper()
+				if v := header.Get("RateLimit-Limit"); v != strconv.Itoa(int(wantLimit)) {
+					t.Errorf("unexpected RateLimit-Limit. want: %d, got: %s", wantLimit, v)
+				}
+				if v := header.Get("RateLimit-Remaining"); v != strconv.Itoa(int(wantRemaining)) {
+					t.Errorf("unexpected RateLimit-Remaining. want: %d, got: %s", wantRemaining, v)
+				}
+				reset, err := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64)
+				if err != nil || reset < 0 || reset > wantResetMax {
+					t.Errorf("unexpected RateLimit-Reset. want: [0, %d], got: %s", wantResetMax, header.Get("RateLimit-Reset"))
+				}
+				if v := header.Get("Retry-After"); (v != "") != wantRetryAfter {
+					t.Errorf("unexpected Retry-After. want set: %v, got: %s", wantRetryAfter, v)
+				}
+				if wantRetryAfter {
+					retryAfter, err := strconv.ParseInt(header.Get("Retry-After"), 10, 64)
+					if err != nil || retryAfter < 0 || retryAfter > wantResetMax {
+						t.Errorf(
+							"unexpected Retry-After. want: [0, %d], got: %s", wantResetMax, header.Get("Retry-After"),
+						)
+					}
+				}
+			}
+
+			t.Run(
+				"shall return rate limit headers and Retry-After given throttling quota exceeded", func(t *testing.T) {
+					// GIVEN
+					clientRepo, header, userID := initApiCallByRegisteredUser()
+					quotas := RoleRegisteredUser.Quotas()
+					rateLimiter := newRateLimiterWithCounters(
+						time.Now().UTC(), keyRequestsSuccess(userID), windowMinute, uint32(quotas.RequestsPerMinute),
+					)
+
+					handlerFn, err := HTTPHandler(
+						clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()), WithRateLimiter(rateLimiter),
+					)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					request := &http.Request{
+						Method: http.MethodPost,
+						URL:    &url.URL{Path: "/foo"},
+						Header: header,
+					}
+
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handlerFn(nil).ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusTooManyRequests {
+						t.Errorf(
+							"unexpected status code. want: %d, got: %d", http.StatusTooManyRequests, writer.StatusCode,
+						)
+					}
+					assertRateLimitHeaders(t, writer.Headers, quotas.RequestsPerMinute, 0, 60, true)
+				},
+			)
+
+			t.Run(
+				"shall return rate limit headers and Retry-After given daily quota exceeded", func(t *testing.T) {
+					// GIVEN
+					clientRepo, header, userID := initApiCallByRegisteredUser()
+					quotas := RoleRegisteredUser.Quotas()
+					rateLimiter := newRateLimiterWithCounters(
+						time.Now().UTC(), keyRequestsSuccess(userID), windowDay, uint32(quotas.RequestsPerDay),
+					)
+
+					handlerFn, err := HTTPHandler(
+						clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()), WithRateLimiter(rateLimiter),
+					)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					request := &http.Request{
+						Method: http.MethodPost,
+						URL:    &url.URL{Path: "/foo"},
+						Header: header,
+					}
+
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handlerFn(nil).ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusTooManyRequests {
+						t.Errorf(
+							"unexpected status code. want: %d, got: %d", http.StatusTooManyRequests, writer.StatusCode,
+						)
+					}
+					assertRateLimitHeaders(t, writer.Headers, quotas.RequestsPerDay, 0, 24*60*60, true)
+				},
+			)
+
+			t.Run(
+				"shall return rate limit headers accounting for the current request given quotas not exceeded",
+				func(t *testing.T) {
+					// GIVEN
+					clientRepo, header, _ := initApiCallByRegisteredUser()
+					quotas := RoleRegisteredUser.Quotas()
+
+					handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					request := &http.Request{
+						Method: http.MethodPost,
+						URL:    &url.URL{Path: "/foo"},
+						Header: header,
+					}
+
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handlerFn(nil).ServeHTTP(writer, request)
+
+					// THEN
+					assertRateLimitHeaders(
+						t, writer.Headers, quotas.RequestsPerMinute, quotas.RequestsPerMinute-1, 60, false,
+					)
+				},
+			)
+
+			t.Run(
+				"shall not return Retry-After given the current request consumes the remaining quota",
+				func(t *testing.T) {
+					// GIVEN
+					clientRepo, header, userID := initApiCallByRegisteredUser()
+					quotas := RoleRegisteredUser.Quotas()
+					rateLimiter := newRateLimiterWithCounters(
+						time.Now().UTC(), keyRequestsSuccess(userID), windowMinute,
+						uint32(quotas.RequestsPerMinute)-1,
+					)
+
+					handlerFn, err := HTTPHandler(
+						clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()), WithRateLimiter(rateLimiter),
+					)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					request := &http.Request{
+						Method: http.MethodPost,
+						URL:    &url.URL{Path: "/foo"},
+						Header: header,
+					}
+
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handlerFn(nil).ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode == http.StatusTooManyRequests {
+						t.Errorf("unexpected status code: %d", writer.StatusCode)
+					}
+					assertRateLimitHeaders(t, writer.Headers, quotas.RequestsPerMinute, 0, 60, false)
+				},
+			)
+
 			t.Run(
 				"shall shall return access forbidden on no token", func(t *testing.T) {
 					// GIVEN
 					clientRepo, _, _ := initApiCallByRegisteredUser()
-					handlerFn, err := HTTPHandler(clientRepo, &MockSMTPClient{}, GenerateCertificate())
+					handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()))
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -578,7 +1655,7 @@ func TestServeHTTP(t *testing.T) {
 					clientRepo, header, _ := initApiCallByRegisteredUser()
 					clientRepo.(*MockRepositoryCIAM).Err = errors.New("foo")
 
-					handlerFn, err := HTTPHandler(clientRepo, &MockSMTPClient{}, GenerateCertificate())
+					handlerFn, err := HTTPHandler(clientRepo, &MockMailer{}, NewKeySet(GenerateCertificate()))
 					if err != nil {
 						t.Fatal(err)
 					}
@@ -610,6 +1687,89 @@ func TestServeHTTP(t *testing.T) {
 			)
 		},
 	)
+
+	t.Run(
+		"public keys discovery", func(t *testing.T) {
+			t.Parallel()
+
+			key := GenerateCertificate()
+			handlerFn, err := HTTPHandler(&MockRepositoryCIAM{}, &MockMailer{}, NewKeySet(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := handlerFn(nil)
+
+			t.Run(
+				"shall publish JWKS with the key used to sign tokens", func(t *testing.T) {
+					// GIVEN
+					request := &http.Request{
+						Method: http.MethodGet,
+						URL:    &url.URL{Path: "/.well-known/jwks.json"},
+					}
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusOK {
+						t.Errorf("unexpected status code. want: %d, got: %d", http.StatusOK, writer.StatusCode)
+					}
+
+					var got JWKS
+					if err := json.Unmarshal(writer.V, &got); err != nil {
+						t.Fatal(err)
+					}
+
+					pubKey := key.Public().(ed25519.PublicKey)
+					want := JWKS{Keys: []JWK{newJWK(keyID(pubKey), pubKey)}}
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("unexpected JWKS. want: %v, got: %v", want, got)
+					}
+				},
+			)
+
+			t.Run(
+				"shall not publish OpenID configuration", func(t *testing.T) {
+					// GIVEN
+					request := &http.Request{
+						Method: http.MethodGet,
+						URL:    &url.URL{Path: "/.well-known/openid-configuration"},
+					}
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode == http.StatusOK {
+						t.Errorf("unexpected status code: %d", writer.StatusCode)
+					}
+				},
+			)
+
+			t.Run(
+				"shall return method not allowed given POST request", func(t *testing.T) {
+					// GIVEN
+					request := &http.Request{
+						Method: http.MethodPost,
+						URL:    &url.URL{Path: "/.well-known/jwks.json"},
+					}
+					writer := &utils.MockWriter{}
+
+					// WHEN
+					handler.ServeHTTP(writer, request)
+
+					// THEN
+					if writer.StatusCode != http.StatusMethodNotAllowed {
+						t.Errorf(
+							"unexpected status code. want: %d, got: %d", http.StatusMethodNotAllowed, writer.StatusCode,
+						)
+					}
+				},
+			)
+		},
+	)
 }
 
 type mockHandlerAPIcall struct {
@@ -12,9 +12,10 @@ type User struct {
 }
 
 type Quotas struct {
-	PromptLengthMax   uint16 `json:"prompt_length_max"`
-	RequestsPerMinute uint16 `json:"rpm"`
-	RequestsPerDay    uint16 `json:"rpd"`
+	PromptLengthMax           uint16 `json:"prompt_length_max"`
+	RequestsPerMinute         uint16 `json:"rpm"`
+	RequestsPerDay            uint16 `json:"rpd"`
+	RequestsAttemptsPerMinute uint16 `json:"rpm_attempts"`
 }
 
 type Role uint8
@@ -23,9 +24,14 @@ func (r Role) IsRegisteredUser() bool {
 	return r == RoleRegisteredUser
 }
 
+// IsAdmin defines if the user is allowed to manage other users.
+func (r Role) IsAdmin() bool {
+	return r == RoleAdmin
+}
+
 func (r Role) IsValid() bool {
 	switch r {
-	case RoleAnonymUser, RoleRegisteredUser:
+	case RoleAnonymUser, RoleRegisteredUser, RoleAdmin:
 		return true
 	default:
 		return false
@@ -36,15 +42,17 @@ func (r Role) Quotas() Quotas {
 	switch r {
 	case RoleAnonymUser:
 		return Quotas{
-			PromptLengthMax:   100,
-			RequestsPerMinute: 1,
-			RequestsPerDay:    5,
+			PromptLengthMax:           100,
+			RequestsPerMinute:         1,
+			RequestsPerDay:            5,
+			RequestsAttemptsPerMinute: 5,
 		}
-	case RoleRegisteredUser:
+	case RoleRegisteredUser, RoleAdmin:
 		return Quotas{
-			PromptLengthMax:   300,
-			RequestsPerMinute: 3,
-			RequestsPerDay:    20,
+			PromptLengthMax:           300,
+			RequestsPerMinute:         3,
+			RequestsPerDay:            20,
+			RequestsAttemptsPerMinute: 10,
 		}
 	default:
 		return Quotas{}
@@ -54,6 +62,7 @@ func (r Role) Quotas() Quotas {
 const (
 	RoleAnonymUser Role = iota
 	RoleRegisteredUser
+	RoleAdmin
 )
 
 type QuotaRequestsConsumption struct {
@@ -62,104 +71,98 @@ type QuotaRequestsConsumption struct {
 	Reset int64  `json:"reset"`
 }
 
-func (v quotaIssuer) quotaRPM(user *User) QuotaRequestsConsumption {
-	return QuotaRequestsConsumption{
-		Limit: user.Role.Quotas().RequestsPerMinute,
-		Reset: v.minuteNext.Unix(),
-	}
+type QuotasUsage struct {
+	PromptLengthMax    uint16                   `json:"prompt_length_max"`
+	RateMinute         QuotaRequestsConsumption `json:"rate_minute"`
+	RateDay            QuotaRequestsConsumption `json:"rate_day"`
+	RateMinuteAttempts QuotaRequestsConsumption `json:"rate_minute_attempts"`
 }
 
-func (v quotaIssuer) quotaRPD(user *User) QuotaRequestsConsumption {
-	return QuotaRequestsConsumption{
-		Limit: user.Role.Quotas().RequestsPerDay,
-		Reset: v.dayNext.Unix(),
-	}
-}
+const (
+	windowMinute = time.Minute
+	windowDay    = 24 * time.Hour
+)
 
-func (v quotaIssuer) quotaUsage(user *User) QuotasUsage {
-	return QuotasUsage{
-		PromptLengthMax: user.Role.Quotas().PromptLengthMax,
-		RateMinute:      v.quotaRPM(user),
-		RateDay:         v.quotaRPD(user),
-	}
+func keyRequestsSuccess(userID string) string {
+	return "requests:success:" + userID
 }
 
-type quotaIssuer struct {
-	dayNow     time.Time
-	dayNext    time.Time
-	minuteNow  time.Time
-	minuteNext time.Time
+func keyRequestsAttempt(userID string) string {
+	return "requests:attempt:" + userID
 }
 
-func genNowDate() time.Time {
-	now := time.Now().UTC()
-	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+func readRequestsConsumption(
+	ctx context.Context, rateLimiter RateLimiter, key string, window time.Duration, limit uint16,
+) (QuotaRequestsConsumption, error) {
+	used, reset, err := rateLimiter.Count(ctx, key, window, uint32(limit))
+	if err != nil {
+		return QuotaRequestsConsumption{}, err
+	}
+	if used > uint32(limit) {
+		used = uint32(limit)
+	}
+	return QuotaRequestsConsumption{
+		Limit: limit,
+		Used:  uint16(used),
+		// the reset is rounded up to not let the client retry before the request is allowed
+		Reset: reset.Add(time.Second - time.Nanosecond).Unix(),
+	}, nil
 }
 
-func genNowMinute() time.Time {
-	now := time.Now().UTC()
-	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, time.UTC)
-}
+// getQuotaUsage read current usage of the quota. The quotas are counted within the sliding windows
+// which end at the current moment, i.e. the daily quota is counted over the last 24 hours, not the calendar day.
+func getQuotaUsage(ctx context.Context, rateLimiter RateLimiter, user *User) (QuotasUsage, error) {
+	limits := user.Role.Quotas()
 
-func newQuotaIssuer() quotaIssuer {
-	const (
-		day    = 24 * time.Hour
-		minute = 1 * time.Minute
+	rateDay, err := readRequestsConsumption(
+		ctx, rateLimiter, keyRequestsSuccess(user.ID), windowDay, limits.RequestsPerDay,
 	)
-	return quotaIssuer{
-		dayNow:     genNowDate(),
-		dayNext:    genNowDate().Add(day),
-		minuteNow:  genNowMinute(),
-		minuteNext: genNowMinute().Add(minute),
+	if err != nil {
+		return QuotasUsage{}, err
 	}
-}
 
-type QuotasUsage struct {
-	PromptLengthMax uint16                   `json:"prompt_length_max"`
-	RateMinute      QuotaRequestsConsumption `json:"rate_minute"`
-	RateDay         QuotaRequestsConsumption `json:"rate_day"`
-}
-
-func sliceWithinWindow(ts []time.Time, tsMin, tsMax time.Time) []time.Time {
-	var o []time.Time
-	for _, t := range ts {
-		if t.After(tsMin) && t.Before(tsMax) || t == tsMin || t == tsMax {
-			o = append(o, t)
-		}
+	rateMinute, err := readRequestsConsumption(
+		ctx, rateLimiter, keyRequestsSuccess(user.ID), windowMinute, limits.RequestsPerMinute,
+	)
+	if err != nil {
+		return QuotasUsage{}, err
 	}
-	return o
-}
 
-// getQuotaUsage read current usage of the quota.
-func getQuotaUsage(ctx context.Context, clientRepository RepositoryCIAM, user *User) (
-	QuotasUsage, error,
-) {
-	requestsTimestamps, err := clientRepository.GetDailySuccessfulResultsTimestampsByUserID(ctx, user.ID)
+	rateMinuteAttempts, err := readRequestsConsumption(
+		ctx, rateLimiter, keyRequestsAttempt(user.ID), windowMinute, limits.RequestsAttemptsPerMinute,
+	)
 	if err != nil {
 		return QuotasUsage{}, err
 	}
 
-	quotasController := newQuotaIssuer()
-
-	quotas := quotasController.quotaUsage(user)
-
-	if len(requestsTimestamps) == 0 {
-		return quotas, nil
+	// by transitivity, the RPM/throttling quota is exceeded if the daily quota is exceeded
+	if rateDay.Used >= rateDay.Limit {
+		rateMinute.Used = rateMinute.Limit
+		rateMinute.Reset = rateDay.Reset
 	}
 
-	requestsDaily := sliceWithinWindow(requestsTimestamps, quotasController.dayNow, quotasController.dayNext)
-	quotas.RateDay.Used = uint16(len(requestsDaily))
-
-	requestsMinute := sliceWithinWindow(requestsTimestamps, quotasController.minuteNow, quotasController.minuteNext)
-	quotas.RateMinute.Used = uint16(len(requestsMinute))
+	return QuotasUsage{
+		PromptLengthMax:    limits.PromptLengthMax,
+		RateMinute:         rateMinute,
+		RateDay:            rateDay,
+		RateMinuteAttempts: rateMinuteAttempts,
+	}, nil
+}
+
+// recordRequestAttempt registers user's request attempt regardless of its outcome given the attempts quota
+// is not exceeded. It reports whether the attempt was registered, i.e. the request is allowed.
+func recordRequestAttempt(ctx context.Context, rateLimiter RateLimiter, user *User) (bool, error) {
+	return rateLimiter.IncrementIfBelow(
+		ctx, keyRequestsAttempt(user.ID), windowMinute, uint32(user.Role.Quotas().RequestsAttemptsPerMinute),
+	)
+}
 
-	// by transitivity, the RPM/throttling quota is exceeded if the daily quota is exceeded
-	if quotas.RateDay.Used >= quotas.RateDay.Limit {
-		quotas.RateMinute.Used = quotas.RateMinute.Limit
-		quotas.RateMinute.Reset = quotas.RateDay.Reset
+// recordRequestSuccess registers user's successful request.
+func recordRequestSuccess(ctx context.Context, rateLimiter RateLimiter, user *User) error {
+	if err := rateLimiter.Increment(ctx, keyRequestsSuccess(user.ID), windowMinute); err != nil {
+		return err
 	}
-
-	return quotas, nil
+	return rateLimiter.Increment(ctx, keyRequestsSuccess(user.ID), windowDay)
 }
 
 var userKey = struct{}{}
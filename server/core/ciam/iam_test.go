@@ -11,188 +11,135 @@ import (
 	"github.com/kislerdm/diagramastext/server/core/internal/utils"
 )
 
-func mustGenerateTimestamps(tsStr ...string) []time.Time {
-	o := make([]time.Time, len(tsStr))
-
-	for i, ts := range tsStr {
-		t, err := time.Parse(time.RFC3339, ts)
-		if err != nil {
-			panic(err)
+// newRateLimiterWithCounters initialises the rate limiter with the fixed clock
+// and with n events registered for the key within the window.
+func newRateLimiterWithCounters(now time.Time, key string, window time.Duration, n uint32) RateLimiter {
+	repository := &mockRepositoryRateLimiter{}
+	if n > 0 {
+		repository.Counters = map[string]map[time.Time]uint32{
+			counterKey(key, window): {now.Truncate(window): n},
 		}
-		o[i] = t
 	}
-
-	return o
-}
-
-func repeatTimestamp(ts time.Time, nElements uint16) []time.Time {
-	o := make([]time.Time, nElements)
-	var i uint16
-	for i < nElements {
-		o[i] = ts
-		i++
+	return &slidingWindowRateLimiter{
+		repository: repository,
+		now:        func() time.Time { return now },
 	}
-	return o
 }
 
-func Test_sliceWithinWindow(t *testing.T) {
-	type args struct {
-		ts    []time.Time
-		tsMin time.Time
-		tsMax time.Time
-	}
-	tests := []struct {
-		name string
-		args args
-		want []time.Time
-	}{
-		{
-			name: "non-empty slice",
-			args: args{
-				ts: mustGenerateTimestamps(
-					"2023-01-01T00:00:00Z", "2023-01-01T10:00:00Z", "2023-01-01T11:00:00Z", "2023-01-02T00:00:00Z",
-					"2023-01-04T00:00:00Z",
-				),
-				tsMin: mustGenerateTimestamps("2023-01-01T00:00:00Z")[0],
-				tsMax: mustGenerateTimestamps("2023-01-02T00:00:00Z")[0],
-			},
-			want: mustGenerateTimestamps(
-				"2023-01-01T00:00:00Z", "2023-01-01T10:00:00Z", "2023-01-01T11:00:00Z", "2023-01-02T00:00:00Z",
-			),
-		},
-		{
-			name: "input empty slice",
-			args: args{
-				ts:    nil,
-				tsMin: mustGenerateTimestamps("2023-01-01T00:00:00Z")[0],
-				tsMax: mustGenerateTimestamps("2023-01-02T00:00:00Z")[0],
-			},
-			want: nil,
-		},
-		{
-			name: "non-empty input, empty output",
-			args: args{
-				ts: mustGenerateTimestamps(
-					"2023-01-01T00:00:00Z", "2023-01-01T01:00:00Z", "2023-01-01T02:00:00Z",
-				),
-				tsMin: mustGenerateTimestamps("2023-01-02T00:00:00Z")[0],
-				tsMax: mustGenerateTimestamps("2023-01-03T00:00:00Z")[0],
-			},
-			want: nil,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(
-			tt.name, func(t *testing.T) {
-				if got := sliceWithinWindow(tt.args.ts, tt.args.tsMin, tt.args.tsMax); !reflect.DeepEqual(
-					got, tt.want,
-				) {
-					t.Errorf("sliceWithinWindow() = %v, want %v", got, tt.want)
-				}
-			},
-		)
-	}
-}
-
-var quotasController = newQuotaIssuer()
-
 func Test_getQuotaUsage(t *testing.T) {
-	type args struct {
-		ctx              context.Context
-		clientRepository RepositoryCIAM
-	}
+	user := &User{ID: "foo"}
+	quotas := user.Role.Quotas()
 
-	user := &User{}
+	now := time.Date(2023, 1, 1, 10, 0, 30, 0, time.UTC)
+	minuteNext := time.Date(2023, 1, 1, 10, 1, 0, 0, time.UTC).Unix()
+	dayNext := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC).Unix()
+	// the requests are allowed at the current moment given the quota is not exceeded
+	allowedNow := now.Unix()
 
 	tests := []struct {
-		name    string
-		args    args
-		want    QuotasUsage
-		wantErr bool
+		name        string
+		rateLimiter RateLimiter
+		want        QuotasUsage
+		wantErr     bool
 	}{
 		{
-			name: "no previous requests",
-			args: args{
-				ctx:              context.TODO(),
-				clientRepository: &MockRepositoryCIAM{},
-			},
-			want:    quotasController.quotaUsage(user),
-			wantErr: false,
-		},
-		{
-			name: "a single requests",
-			args: args{
-				ctx: context.TODO(),
-				clientRepository: &MockRepositoryCIAM{
-					Timestamps: repeatTimestamp(quotasController.minuteNow, 1),
-				},
-			},
+			name:        "no previous requests",
+			rateLimiter: newRateLimiterWithCounters(now, "", windowMinute, 0),
 			want: QuotasUsage{
-				PromptLengthMax: user.Role.Quotas().PromptLengthMax,
+				PromptLengthMax: quotas.PromptLengthMax,
 				RateMinute: QuotaRequestsConsumption{
-					Limit: user.Role.Quotas().RequestsPerMinute,
-					Used:  1,
-					Reset: quotasController.minuteNext.Unix(),
+					Limit: quotas.RequestsPerMinute,
+					Reset: allowedNow,
 				},
 				RateDay: QuotaRequestsConsumption{
-					Limit: user.Role.Quotas().RequestsPerDay,
-					Used:  1,
-					Reset: quotasController.dayNext.Unix(),
+					Limit: quotas.RequestsPerDay,
+					Reset: allowedNow,
+				},
+				RateMinuteAttempts: QuotaRequestsConsumption{
+					Limit: quotas.RequestsAttemptsPerMinute,
+					Reset: allowedNow,
 				},
 			},
-			wantErr: false,
 		},
 		{
-			name: "daily quota exceeded",
-			args: args{
-				ctx: context.TODO(),
-				clientRepository: &MockRepositoryCIAM{
-					Timestamps: repeatTimestamp(quotasController.minuteNow, user.Role.Quotas().RequestsPerDay),
-				},
-			},
+			name:        "daily quota exceeded",
+			rateLimiter: newRateLimiterWithCounters(now, keyRequestsSuccess(user.ID), windowDay, uint32(quotas.RequestsPerDay)),
 			want: QuotasUsage{
-				PromptLengthMax: user.Role.Quotas().PromptLengthMax,
+				PromptLengthMax: quotas.PromptLengthMax,
 				RateMinute: QuotaRequestsConsumption{
-					Limit: user.Role.Quotas().RequestsPerMinute,
-					Used:  user.Role.Quotas().RequestsPerMinute,
-					Reset: quotasController.dayNext.Unix(),
+					Limit: quotas.RequestsPerMinute,
+					Used:  quotas.RequestsPerMinute,
+					Reset: dayNext,
 				},
 				RateDay: QuotaRequestsConsumption{
-					Limit: user.Role.Quotas().RequestsPerDay,
-					Used:  user.Role.Quotas().RequestsPerDay,
-					Reset: quotasController.dayNext.Unix(),
+					Limit: quotas.RequestsPerDay,
+					Used:  quotas.RequestsPerDay,
+					Reset: dayNext,
+				},
+				RateMinuteAttempts: QuotaRequestsConsumption{
+					Limit: quotas.RequestsAttemptsPerMinute,
+					Reset: allowedNow,
 				},
 			},
-			wantErr: false,
 		},
 		{
 			name: "throttling quota exceeded",
-			args: args{
-				ctx: context.TODO(),
-				clientRepository: &MockRepositoryCIAM{
-					Timestamps: repeatTimestamp(quotasController.minuteNow, user.Role.Quotas().RequestsPerMinute),
+			rateLimiter: newRateLimiterWithCounters(
+				now, keyRequestsSuccess(user.ID), windowMinute, uint32(quotas.RequestsPerMinute),
+			),
+			want: QuotasUsage{
+				PromptLengthMax: quotas.PromptLengthMax,
+				RateMinute: QuotaRequestsConsumption{
+					Limit: quotas.RequestsPerMinute,
+					Used:  quotas.RequestsPerMinute,
+					Reset: minuteNext,
+				},
+				RateDay: QuotaRequestsConsumption{
+					Limit: quotas.RequestsPerDay,
+					Reset: allowedNow,
+				},
+				RateMinuteAttempts: QuotaRequestsConsumption{
+					Limit: quotas.RequestsAttemptsPerMinute,
+					Reset: allowedNow,
 				},
 			},
+		},
+		{
+			name: "attempts quota exceeded, usage is capped by the limit",
+			rateLimiter: newRateLimiterWithCounters(
+				now, keyRequestsAttempt(user.ID), windowMinute, uint32(quotas.RequestsAttemptsPerMinute)+10,
+			),
 			want: QuotasUsage{
-				PromptLengthMax: user.Role.Quotas().PromptLengthMax,
+				PromptLengthMax: quotas.PromptLengthMax,
 				RateMinute: QuotaRequestsConsumption{
-					Limit: user.Role.Quotas().RequestsPerMinute,
-					Used:  user.Role.Quotas().RequestsPerMinute,
-					Reset: quotasController.minuteNext.Unix(),
+					Limit: quotas.RequestsPerMinute,
+					Reset: allowedNow,
 				},
 				RateDay: QuotaRequestsConsumption{
-					Limit: user.Role.Quotas().RequestsPerDay,
-					Used:  user.Role.Quotas().RequestsPerMinute,
-					Reset: quotasController.dayNext.Unix(),
+					Limit: quotas.RequestsPerDay,
+					Reset: allowedNow,
 				},
+				RateMinuteAttempts: QuotaRequestsConsumption{
+					Limit: quotas.RequestsAttemptsPerMinute,
+					Used:  quotas.RequestsAttemptsPerMinute,
+					// 15 * weight < 5 given weight < 2/3
+					Reset: minuteNext + 40,
+				},
+			},
+		},
+		{
+			name: "unhappy path",
+			rateLimiter: &slidingWindowRateLimiter{
+				repository: &mockRepositoryRateLimiter{Err: errors.New("foo")},
+				now:        func() time.Time { return now },
 			},
-			wantErr: false,
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				got, err := getQuotaUsage(tt.args.ctx, tt.args.clientRepository, user)
+				got, err := getQuotaUsage(context.TODO(), tt.rateLimiter, user)
 				if (err != nil) != tt.wantErr {
 					t.Errorf("getQuotaUsage() error = %v, wantErr %v", err, tt.wantErr)
 					return
@@ -205,6 +152,29 @@ func Test_getQuotaUsage(t *testing.T) {
 	}
 }
 
+func Test_recordRequestSuccess(t *testing.T) {
+	// GIVEN
+	user := &User{ID: "foo"}
+	rateLimiter, _ := NewRateLimiter(NewRepositoryRateLimiterInMemory())
+
+	// WHEN
+	if err := recordRequestSuccess(context.TODO(), rateLimiter, user); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := recordRequestAttempt(context.TODO(), rateLimiter, user); err != nil || !ok {
+		t.Fatalf("attempt is expected to be registered, err: %v", err)
+	}
+
+	// THEN
+	got, err := getQuotaUsage(context.TODO(), rateLimiter, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RateMinute.Used != 1 || got.RateDay.Used != 1 || got.RateMinuteAttempts.Used != 1 {
+		t.Errorf("unexpected quotas usage: %+v", got)
+	}
+}
+
 func TestRole_IsRegisteredUser(t *testing.T) {
 	tests := []struct {
 		name string
@@ -235,12 +205,13 @@ func TestRole_IsRegisteredUser(t *testing.T) {
 
 func Test_client_validateRequestsQuotaUsage(t *testing.T) {
 	type args struct {
-		clientRepository RepositoryCIAM
-		user             *User
-		writer           http.ResponseWriter
+		rateLimiter RateLimiter
+		user        *User
+		writer      http.ResponseWriter
 	}
 
 	certificate := GenerateCertificate()
+	now := time.Now().UTC()
 
 	tests := []struct {
 		name          string
@@ -253,9 +224,9 @@ func Test_client_validateRequestsQuotaUsage(t *testing.T) {
 		{
 			name: "no request made so far",
 			args: args{
-				clientRepository: &MockRepositoryCIAM{},
-				user:             &User{},
-				writer:           &utils.MockWriter{},
+				rateLimiter: newRateLimiterWithCounters(now, "", windowMinute, 0),
+				user:        &User{},
+				writer:      &utils.MockWriter{},
 			},
 			wantStatuCode: 0,
 			wantBody:      nil,
@@ -264,9 +235,9 @@ func Test_client_validateRequestsQuotaUsage(t *testing.T) {
 		{
 			name: "throttling quota exceeded",
 			args: args{
-				clientRepository: &MockRepositoryCIAM{
-					Timestamps: repeatTimestamp(genNowMinute(), RoleRegisteredUser.Quotas().RequestsPerMinute+1),
-				},
+				rateLimiter: newRateLimiterWithCounters(
+					now, keyRequestsSuccess(""), windowMinute, uint32(RoleAnonymUser.Quotas().RequestsPerMinute),
+				),
 				user:   &User{},
 				writer: &utils.MockWriter{},
 			},
@@ -277,9 +248,9 @@ func Test_client_validateRequestsQuotaUsage(t *testing.T) {
 		{
 			name: "daily quota exceeded",
 			args: args{
-				clientRepository: &MockRepositoryCIAM{
-					Timestamps: repeatTimestamp(genNowDate(), RoleRegisteredUser.Quotas().RequestsPerDay+1),
-				},
+				rateLimiter: newRateLimiterWithCounters(
+					now, keyRequestsSuccess(""), windowDay, uint32(RoleAnonymUser.Quotas().RequestsPerDay),
+				),
 				user:   &User{},
 				writer: &utils.MockWriter{},
 			},
@@ -287,11 +258,25 @@ func Test_client_validateRequestsQuotaUsage(t *testing.T) {
 			wantBody:      []byte(`{"error":"daily quota exceeded"}`),
 			want:          false,
 		},
+		{
+			name: "attempts quota exceeded",
+			args: args{
+				rateLimiter: newRateLimiterWithCounters(
+					now, keyRequestsAttempt(""), windowMinute, uint32(RoleAnonymUser.Quotas().RequestsAttemptsPerMinute),
+				),
+				user:   &User{},
+				writer: &utils.MockWriter{},
+			},
+			wantStatuCode: http.StatusTooManyRequests,
+			wantBody:      []byte(`{"error":"attempts quota exceeded"}`),
+			want:          false,
+		},
 		{
 			name: "unhappy path",
 			args: args{
-				clientRepository: &MockRepositoryCIAM{
-					Err: errors.New("foo"),
+				rateLimiter: &slidingWindowRateLimiter{
+					repository: &mockRepositoryRateLimiter{Err: errors.New("foo")},
+					now:        time.Now,
 				},
 				user:   &User{},
 				writer: &utils.MockWriter{},
@@ -304,7 +289,9 @@ func Test_client_validateRequestsQuotaUsage(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				c, err := HTTPHandler(tt.args.clientRepository, &MockSMTPClient{}, certificate)
+				c, err := HTTPHandler(
+					&MockRepositoryCIAM{}, &MockMailer{}, NewKeySet(certificate), WithRateLimiter(tt.args.rateLimiter),
+				)
 				if err != nil {
 					t.Fatal(err)
 				}
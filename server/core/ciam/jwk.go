@@ -0,0 +1,51 @@
+package ciam
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+)
+
+// JWK defines the public JSON Web Key, see https://www.rfc-editor.org/rfc/rfc8037#section-2
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// JWKS defines the JSON Web Key Set, see https://www.rfc-editor.org/rfc/rfc7517#section-5
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func newJWK(kid string, key ed25519.PublicKey) JWK {
+	return JWK{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   encodeSegment(key),
+		Kid: kid,
+		Use: "sig",
+		Alg: "EdDSA",
+	}
+}
+
+// keyID generates the key's ID as its JWK thumbprint, see https://www.rfc-editor.org/rfc/rfc7638
+func keyID(key ed25519.PublicKey) string {
+	// the required members of the OKP key sorted lexicographically
+	v, _ := json.Marshal(
+		struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{
+			Crv: "Ed25519",
+			Kty: "OKP",
+			X:   encodeSegment(key),
+		},
+	)
+	h := sha256.Sum256(v)
+	return encodeSegment(h[:])
+}
@@ -0,0 +1,24 @@
+package ciam
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func Test_keyID(t *testing.T) {
+	// GIVEN
+	// the key and its thumbprint from https://www.rfc-editor.org/rfc/rfc8037#appendix-A.3
+	key, err := decodeSegment("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"
+
+	// WHEN
+	got := keyID(ed25519.PublicKey(key))
+
+	// THEN
+	if got != want {
+		t.Errorf("unexpected key ID. want: %s, got: %s", want, got)
+	}
+}
@@ -0,0 +1,138 @@
+package ciam
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+)
+
+// KeySet defines the keys to sign and verify JWT.
+type KeySet struct {
+	// SigningKeyID the ID of the key used to sign JWT.
+	SigningKeyID string
+	// SigningKey the key used to sign JWT.
+	SigningKey ed25519.PrivateKey
+	// VerificationKeys the keys used to verify JWT which were signed by previously active signing keys.
+	// The keys are sorted from the most recent to the oldest.
+	VerificationKeys []VerificationKey
+}
+
+// VerificationKey defines the public key to verify JWT.
+type VerificationKey struct {
+	ID  string
+	Key ed25519.PublicKey
+}
+
+// NewKeySet defines the KeySet with a single signing key identified by its JWK thumbprint.
+func NewKeySet(signingKey ed25519.PrivateKey) KeySet {
+	o := KeySet{SigningKey: signingKey}
+	if len(signingKey) == ed25519.PrivateKeySize {
+		o.SigningKeyID = keyID(signingKey.Public().(ed25519.PublicKey))
+	}
+	return o
+}
+
+// Validate validates the KeySet.
+func (ks KeySet) Validate() error {
+	if len(ks.SigningKey) != ed25519.PrivateKeySize {
+		return errors.New("no valid ed25519 private key provided")
+	}
+	if ks.SigningKeyID == "" {
+		return errors.New("signing key id must be provided")
+	}
+	ids := map[string]struct{}{ks.SigningKeyID: {}}
+	for _, k := range ks.VerificationKeys {
+		if len(k.Key) != ed25519.PublicKeySize {
+			return errors.New("verification key " + k.ID + " is invalid")
+		}
+		if k.ID == "" {
+			return errors.New("verification key id must be provided")
+		}
+		if _, ok := ids[k.ID]; ok {
+			return errors.New("key id " + k.ID + " is not unique")
+		}
+		ids[k.ID] = struct{}{}
+	}
+	return nil
+}
+
+// Rotate sets the newKey as the signing key, and keeps the current signing key to verify JWT.
+// maxVerificationKeys defines the max number of verification keys to keep, the oldest keys are dropped.
+func (ks KeySet) Rotate(newKey ed25519.PrivateKey, maxVerificationKeys int) KeySet {
+	o := NewKeySet(newKey)
+	if len(ks.SigningKey) == ed25519.PrivateKeySize {
+		o.VerificationKeys = append(
+			o.VerificationKeys,
+			VerificationKey{ID: ks.SigningKeyID, Key: ks.SigningKey.Public().(ed25519.PublicKey)},
+		)
+	}
+	o.VerificationKeys = append(o.VerificationKeys, ks.VerificationKeys...)
+	if maxVerificationKeys >= 0 && len(o.VerificationKeys) > maxVerificationKeys {
+		o.VerificationKeys = o.VerificationKeys[:maxVerificationKeys]
+	}
+	return o
+}
+
+type keySetDocumentKey struct {
+	ID  string `json:"kid"`
+	Key string `json:"key"`
+}
+
+type keySetDocument struct {
+	SigningKey       keySetDocumentKey   `json:"signing_key"`
+	VerificationKeys []keySetDocumentKey `json:"verification_keys,omitempty"`
+}
+
+// ReadKeySet reads the KeySet from the JSON document.
+// The signing key is expected as PEM encoded PKCS #8 private key,
+// the verification keys are expected as PEM encoded PKIX public keys.
+func ReadKeySet(v []byte) (KeySet, error) {
+	var doc keySetDocument
+	if err := json.Unmarshal(v, &doc); err != nil {
+		return KeySet{}, errors.New("cannot deserialize the key set")
+	}
+
+	signingKey, err := ReadPrivateKey(doc.SigningKey.Key)
+	if err != nil {
+		return KeySet{}, err
+	}
+
+	o := KeySet{
+		SigningKeyID: doc.SigningKey.ID,
+		SigningKey:   signingKey,
+	}
+	for _, el := range doc.VerificationKeys {
+		key, err := readPublicKey(el.Key)
+		if err != nil {
+			return KeySet{}, err
+		}
+		o.VerificationKeys = append(o.VerificationKeys, VerificationKey{ID: el.ID, Key: key})
+	}
+
+	return o, o.Validate()
+}
+
+// MarshalKeySet serializes the KeySet to the JSON document.
+func MarshalKeySet(ks KeySet) ([]byte, error) {
+	if err := ks.Validate(); err != nil {
+		return nil, err
+	}
+
+	signingKey, err := MarshalKey(ks.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := keySetDocument{
+		SigningKey: keySetDocumentKey{ID: ks.SigningKeyID, Key: string(signingKey)},
+	}
+	for _, el := range ks.VerificationKeys {
+		key, err := marshalPublicKey(el.Key)
+		if err != nil {
+			return nil, err
+		}
+		doc.VerificationKeys = append(doc.VerificationKeys, keySetDocumentKey{ID: el.ID, Key: string(key)})
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,155 @@
+package ciam
+
+import (
+	"crypto/ed25519"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func mustGenerateKey(seed int64) ed25519.PrivateKey {
+	_, o, err := ed25519.GenerateKey(rand.New(rand.NewSource(seed)))
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+func TestKeySet_Rotate(t *testing.T) {
+	keys := []ed25519.PrivateKey{mustGenerateKey(0), mustGenerateKey(1), mustGenerateKey(2)}
+	pubKey := func(i int) VerificationKey {
+		k := keys[i].Public().(ed25519.PublicKey)
+		return VerificationKey{ID: keyID(k), Key: k}
+	}
+
+	tests := []struct {
+		name                string
+		keySet              KeySet
+		maxVerificationKeys int
+		want                KeySet
+	}{
+		{
+			name:                "shall keep the previous signing key for verification",
+			keySet:              NewKeySet(keys[0]),
+			maxVerificationKeys: 2,
+			want: KeySet{
+				SigningKeyID:     keyID(keys[1].Public().(ed25519.PublicKey)),
+				SigningKey:       keys[1],
+				VerificationKeys: []VerificationKey{pubKey(0)},
+			},
+		},
+		{
+			name:                "shall drop the oldest verification key",
+			keySet:              NewKeySet(keys[0]).Rotate(keys[1], 1),
+			maxVerificationKeys: 1,
+			want: KeySet{
+				SigningKeyID:     keyID(keys[2].Public().(ed25519.PublicKey)),
+				SigningKey:       keys[2],
+				VerificationKeys: []VerificationKey{pubKey(1)},
+			},
+		},
+		{
+			name:                "shall keep no verification keys",
+			keySet:              NewKeySet(keys[0]),
+			maxVerificationKeys: 0,
+			want: KeySet{
+				SigningKeyID:     keyID(keys[1].Public().(ed25519.PublicKey)),
+				SigningKey:       keys[1],
+				VerificationKeys: []VerificationKey{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := tt.keySet.Rotate(tt.want.SigningKey, tt.maxVerificationKeys)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Rotate() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestReadMarshalKeySet(t *testing.T) {
+	t.Run(
+		"shall read the marshalled key set", func(t *testing.T) {
+			// GIVEN
+			want := NewKeySet(mustGenerateKey(0)).Rotate(mustGenerateKey(1), 2).Rotate(mustGenerateKey(2), 2)
+
+			// WHEN
+			v, err := MarshalKeySet(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadKeySet(v)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadKeySet() got = %v, want %v", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail to read the key set with duplicated key IDs", func(t *testing.T) {
+			// GIVEN
+			key := mustGenerateKey(0)
+			keySet := NewKeySet(key)
+			keySet.VerificationKeys = []VerificationKey{
+				{ID: keySet.SigningKeyID, Key: key.Public().(ed25519.PublicKey)},
+			}
+
+			// WHEN
+			_, err := MarshalKeySet(keySet)
+
+			// THEN
+			if err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail to read malformed document", func(t *testing.T) {
+			if _, err := ReadKeySet([]byte(`{"signing_key":{"kid":"foo","key":"bar"}}`)); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+}
+
+func TestIssuer_verifiesTokensSignedByPreviousKey(t *testing.T) {
+	// GIVEN
+	keySetPrevious := NewKeySet(mustGenerateKey(0))
+	issuerPrevious, err := NewIssuer(keySetPrevious)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tkn, err := issuerPrevious.NewRefreshToken("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuerRotated, err := NewIssuer(keySetPrevious.Rotate(mustGenerateKey(1), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// WHEN
+	userID, _, err := issuerRotated.ParseRefreshToken(tkn)
+
+	// THEN
+	if err != nil {
+		t.Fatalf("token signed by the previous key is expected to be valid: %v", err)
+	}
+	if userID != "foo" {
+		t.Errorf("unexpected user ID: %s", userID)
+	}
+	if jwks := issuerRotated.JWKS(); len(jwks.Keys) != 2 {
+		t.Errorf("both keys are expected to be published, got: %v", jwks)
+	}
+}
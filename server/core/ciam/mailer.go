@@ -0,0 +1,263 @@
+package ciam
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmlTemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	textTemplate "text/template"
+	"time"
+
+	"github.com/kislerdm/diagramastext/server/core/internal/utils"
+)
+
+// Mailer defines the client to deliver emails.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// MessageType defines the type of the templated email.
+type MessageType string
+
+const (
+	MessageSignInCode   MessageType = "signin-code"
+	MessageSignInLink   MessageType = "signin-link"
+	MessageInvitation   MessageType = "invitation"
+	MessageQuotaWarning MessageType = "quota-warning"
+)
+
+// Message defines the email to render using the template of its type.
+type Message struct {
+	Type      MessageType
+	Recipient string
+	// Data defines the values to render the template with.
+	Data map[string]string
+}
+
+// NewSignInCodeMessage defines the email with the one-time secret to type in to complete authentication.
+func NewSignInCodeMessage(recipient, code string) Message {
+	return Message{Type: MessageSignInCode, Recipient: recipient, Data: map[string]string{"code": code}}
+}
+
+// NewSignInLinkMessage defines the email with the magic link to complete authentication.
+func NewSignInLinkMessage(recipient, link string) Message {
+	return Message{
+		Type:      MessageSignInLink,
+		Recipient: recipient,
+		Data:      map[string]string{"link": link, "validity": defaultExpirationSecret.String()},
+	}
+}
+
+// NewInvitationMessage defines the email to invite the recipient to sign up.
+func NewInvitationMessage(recipient, inviter, link string) Message {
+	return Message{
+		Type:      MessageInvitation,
+		Recipient: recipient,
+		Data:      map[string]string{"inviter": inviter, "link": link},
+	}
+}
+
+// NewQuotaWarningMessage defines the email to warn the recipient that the requests quota is almost used.
+func NewQuotaWarningMessage(recipient string, used, limit uint16, reset time.Time) Message {
+	return Message{
+		Type:      MessageQuotaWarning,
+		Recipient: recipient,
+		Data: map[string]string{
+			"used":  strconv.Itoa(int(used)),
+			"limit": strconv.Itoa(int(limit)),
+			"reset": reset.UTC().Format(time.RFC1123),
+		},
+	}
+}
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+type messageTemplate struct {
+	subject *textTemplate.Template
+	text    *textTemplate.Template
+	html    *htmlTemplate.Template
+}
+
+var messageSubjects = map[MessageType]string{
+	MessageSignInCode:   "diagramastext.dev authentication code: {{.code}}",
+	MessageSignInLink:   "diagramastext.dev sign-in link",
+	MessageInvitation:   "{{.inviter}} invited you to diagramastext.dev",
+	MessageQuotaWarning: "diagramastext.dev quota warning: {{.used}} of {{.limit}} requests used",
+}
+
+var messageTemplates = func() map[MessageType]messageTemplate {
+	o := make(map[MessageType]messageTemplate, len(messageSubjects))
+	for t, subject := range messageSubjects {
+		o[t] = messageTemplate{
+			subject: textTemplate.Must(textTemplate.New("subject").Parse(subject)),
+			text:    textTemplate.Must(textTemplate.ParseFS(templatesFS, "templates/"+string(t)+".txt.tmpl")),
+			html: htmlTemplate.Must(
+				htmlTemplate.ParseFS(templatesFS, "templates/layout.html.tmpl", "templates/"+string(t)+".html.tmpl"),
+			),
+		}
+	}
+	return o
+}()
+
+// renderMessage renders the email as multipart/alternative MIME message with plain text and html parts.
+func renderMessage(sender string, msg Message, now time.Time) ([]byte, error) {
+	tmpl, ok := messageTemplates[msg.Type]
+	if !ok {
+		return nil, errors.New("message type " + string(msg.Type) + " is not supported")
+	}
+
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, errors.New("sender is not valid: " + err.Error())
+	}
+	to, err := mail.ParseAddress(msg.Recipient)
+	if err != nil {
+		return nil, errors.New("recipient is not valid: " + err.Error())
+	}
+
+	var subject strings.Builder
+	if err := tmpl.subject.Execute(&subject, msg.Data); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(subject.String(), "\r\n") {
+		return nil, errors.New("subject must not contain line breaks")
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	if err := writeQuotedPrintablePart(
+		w, "text/plain", func(buf *bytes.Buffer) error { return tmpl.text.Execute(buf, msg.Data) },
+	); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintablePart(
+		w, "text/html", func(buf *bytes.Buffer) error {
+			return tmpl.html.ExecuteTemplate(
+				buf, "layout.html.tmpl", struct {
+					Subject string
+					Data    map[string]string
+				}{
+					Subject: subject.String(),
+					Data:    msg.Data,
+				},
+			)
+		},
+	); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var o bytes.Buffer
+	for _, h := range [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject.String())},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", "<" + utils.NewUUID() + "@" + from.Address[strings.LastIndex(from.Address, "@")+1:] + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": w.Boundary()})},
+	} {
+		o.WriteString(h[0])
+		o.WriteString(": ")
+		o.WriteString(h[1])
+		o.WriteString("\r\n")
+	}
+	o.WriteString("\r\n")
+	o.Write(body.Bytes())
+
+	return o.Bytes(), nil
+}
+
+func writeQuotedPrintablePart(w *multipart.Writer, contentType string, render func(buf *bytes.Buffer) error) error {
+	var buf bytes.Buffer
+	if err := render(&buf); err != nil {
+		return err
+	}
+
+	part, err := w.CreatePart(
+		textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"charset": "UTF-8"})},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+			"Content-Disposition":       {"inline"},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// NewFileMailer initialises the Mailer which writes emails as .eml files to the directory.
+// It's meant to be used for local development to read the emails without the SMTP server.
+func NewFileMailer(dir, sender string) (Mailer, error) {
+	if dir == "" {
+		return nil, errors.New("directory must be provided")
+	}
+	if _, err := mail.ParseAddress(sender); err != nil {
+		return nil, errors.New("sender is not valid: " + err.Error())
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir, sender: sender}, nil
+}
+
+type fileMailer struct {
+	mu     sync.Mutex
+	dir    string
+	sender string
+}
+
+func (m *fileMailer) Send(msg Message) error {
+	now := time.Now().UTC()
+	o, err := renderMessage(m.sender, msg, now)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return os.WriteFile(
+		filepath.Join(m.dir, now.Format("20060102T150405.000000000Z")+"-"+string(msg.Type)+".eml"), o, 0o600,
+	)
+}
+
+type MockMailer struct {
+	Messages []Message
+	Err      error
+}
+
+func (m *MockMailer) Send(msg Message) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Messages = append(m.Messages, msg)
+	return nil
+}
+
+// LastMessage returns the last sent message.
+func (m *MockMailer) LastMessage() Message {
+	if len(m.Messages) == 0 {
+		return Message{}
+	}
+	return m.Messages[len(m.Messages)-1]
+}
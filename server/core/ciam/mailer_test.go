@@ -0,0 +1,281 @@
+package ciam
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readRenderedMessage(t *testing.T, message []byte) (header mail.Header, boundary string, parts map[string]string) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type: %s", mediaType)
+	}
+
+	parts = map[string]string{}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the reader decodes quoted-printable transparently
+		content, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[contentType] = string(content)
+	}
+
+	return msg.Header, params["boundary"], parts
+}
+
+func Test_renderMessage(t *testing.T) {
+	const sender = "diagramastext.dev <support@diagramastext.dev>"
+	now := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run(
+		"shall render the sign-in code email", func(t *testing.T) {
+			// GIVEN
+			msg := NewSignInCodeMessage("foo@bar.baz", "qux")
+
+			// WHEN
+			got, err := renderMessage(sender, msg, now)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(bytes.ReplaceAll(got, []byte("\r\n"), nil), []byte("\n")) {
+				t.Error("headers and parts are expected to be separated with CRLF")
+			}
+
+			header, _, parts := readRenderedMessage(t, got)
+			for k, want := range map[string]string{
+				"From":         `"diagramastext.dev" <support@diagramastext.dev>`,
+				"To":           "<foo@bar.baz>",
+				"Subject":      "diagramastext.dev authentication code: qux",
+				"Date":         "Mon, 01 May 2023 10:00:00 +0000",
+				"Mime-Version": "1.0",
+			} {
+				if got := header.Get(k); got != want {
+					t.Errorf("unexpected header %s. want: %s, got: %s", k, want, got)
+				}
+			}
+			if !strings.HasSuffix(header.Get("Message-Id"), "@diagramastext.dev>") {
+				t.Errorf("unexpected Message-Id: %s", header.Get("Message-Id"))
+			}
+
+			if !strings.Contains(parts["text/plain"], "copy the code qux and paste it") {
+				t.Errorf("unexpected plain text part: %s", parts["text/plain"])
+			}
+			for _, want := range []string{
+				"<title>diagramastext.dev authentication code: qux</title>",
+				"<div class=box>qux</div>",
+			} {
+				if !strings.Contains(parts["text/html"], want) {
+					t.Errorf("html part is expected to contain %s", want)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"shall render the sign-in link email with the escaped link", func(t *testing.T) {
+			// GIVEN
+			const link = "https://api.diagramastext.dev/auth/verify?id_token=foo&secret=bar"
+			msg := NewSignInLinkMessage("foo@bar.baz", link)
+
+			// WHEN
+			got, err := renderMessage(sender, msg, now)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, _, parts := readRenderedMessage(t, got)
+			if header.Get("Subject") != "diagramastext.dev sign-in link" {
+				t.Errorf("unexpected subject: %s", header.Get("Subject"))
+			}
+			if !strings.Contains(parts["text/plain"], "open the link "+link+" in your browser") {
+				t.Errorf("unexpected plain text part: %s", parts["text/plain"])
+			}
+			if !strings.Contains(
+				parts["text/html"], `href="https://api.diagramastext.dev/auth/verify?id_token=foo&amp;secret=bar"`,
+			) {
+				t.Error("html part is expected to contain the escaped link")
+			}
+			if !strings.Contains(parts["text/html"], "valid for "+defaultExpirationSecret.String()) {
+				t.Error("html part is expected to contain the link validity")
+			}
+		},
+	)
+
+	t.Run(
+		"shall render the invitation and the quota warning emails", func(t *testing.T) {
+			for _, tt := range []struct {
+				msg         Message
+				wantSubject string
+				wantText    string
+			}{
+				{
+					msg:         NewInvitationMessage("foo@bar.baz", "Jane <b>", "https://diagramastext.dev/"),
+					wantSubject: "Jane <b> invited you to diagramastext.dev",
+					wantText:    "Jane <b> invited you",
+				},
+				{
+					msg: NewQuotaWarningMessage(
+						"foo@bar.baz", 45, 50, time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC),
+					),
+					wantSubject: "diagramastext.dev quota warning: 45 of 50 requests used",
+					wantText:    "Tue, 02 May 2023 00:00:00 UTC",
+				},
+			} {
+				got, err := renderMessage(sender, tt.msg, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				header, _, parts := readRenderedMessage(t, got)
+				subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if subject != tt.wantSubject {
+					t.Errorf("unexpected subject. want: %s, got: %s", tt.wantSubject, subject)
+				}
+				if !strings.Contains(parts["text/plain"], tt.wantText) {
+					t.Errorf("unexpected plain text part: %s", parts["text/plain"])
+				}
+				if strings.Contains(parts["text/html"], "<b>") {
+					t.Error("html part is expected to escape the data")
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"shall generate random boundaries", func(t *testing.T) {
+			// GIVEN
+			msg := NewSignInCodeMessage("foo@bar.baz", "qux")
+
+			// WHEN
+			got0, err := renderMessage(sender, msg, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got1, err := renderMessage(sender, msg, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// THEN
+			_, boundary0, _ := readRenderedMessage(t, got0)
+			_, boundary1, _ := readRenderedMessage(t, got1)
+			if boundary0 == "" || boundary0 == boundary1 {
+				t.Errorf("boundaries are expected to be random: %s, %s", boundary0, boundary1)
+			}
+		},
+	)
+
+	t.Run(
+		"unhappy path", func(t *testing.T) {
+			for name, tt := range map[string]struct {
+				sender string
+				msg    Message
+			}{
+				"shall fail for unknown message type": {
+					sender: sender,
+					msg:    Message{Type: "foo", Recipient: "foo@bar.baz"},
+				},
+				"shall fail for invalid recipient": {
+					sender: sender,
+					msg:    NewSignInCodeMessage("foo@bar.baz\r\nBcc: qux@quux.com", "qux"),
+				},
+				"shall fail for invalid sender": {
+					sender: "foo",
+					msg:    NewSignInCodeMessage("foo@bar.baz", "qux"),
+				},
+				"shall fail for subject with line breaks": {
+					sender: sender,
+					msg:    NewSignInCodeMessage("foo@bar.baz", "qux\r\nBcc: qux@quux.com"),
+				},
+			} {
+				t.Run(
+					name, func(t *testing.T) {
+						if _, err := renderMessage(tt.sender, tt.msg, now); err == nil {
+							t.Error("error expected")
+						}
+					},
+				)
+			}
+		},
+	)
+}
+
+func TestNewFileMailer(t *testing.T) {
+	t.Run(
+		"shall write the email to the directory as .eml file", func(t *testing.T) {
+			// GIVEN
+			dir := filepath.Join(t.TempDir(), "mail")
+			mailer, err := NewFileMailer(dir, "support@diagramastext.dev")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			if err := mailer.Send(NewSignInCodeMessage("foo@bar.baz", "qux")); err != nil {
+				t.Fatal(err)
+			}
+
+			// THEN
+			files, err := filepath.Glob(filepath.Join(dir, "*-signin-code.eml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("one file expected, got: %d", len(files))
+			}
+			got, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, _, _ := readRenderedMessage(t, got)
+			if header.Get("To") != "<foo@bar.baz>" {
+				t.Errorf("unexpected recipient: %s", header.Get("To"))
+			}
+		},
+	)
+
+	t.Run(
+		"unhappy path", func(t *testing.T) {
+			if _, err := NewFileMailer("", "support@diagramastext.dev"); err == nil {
+				t.Error("error expected for missing directory")
+			}
+			if _, err := NewFileMailer(t.TempDir(), "foo"); err == nil {
+				t.Error("error expected for invalid sender")
+			}
+		},
+	)
+}
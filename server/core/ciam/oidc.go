@@ -0,0 +1,645 @@
+package ciam
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// OIDCProviderKindOIDC defines the generic OpenID Connect provider, e.g. corporate IdP.
+	OIDCProviderKindOIDC = "oidc"
+	// OIDCProviderKindGoogle defines Google as the OpenID Connect provider.
+	OIDCProviderKindGoogle = "google"
+	// OIDCProviderKindGitHub defines GitHub as the OAuth2 provider.
+	// GitHub does not issue id_token, hence the user's verified email is read from its API.
+	OIDCProviderKindGitHub = "github"
+
+	issuerGoogle = "https://accounts.google.com"
+
+	// pathOpenIDConfiguration defines the path of the identity provider's metadata relative to its issuer.
+	pathOpenIDConfiguration = "/.well-known/openid-configuration"
+
+	defaultGitHubAuthorizationEndpoint = "https://github.com/login/oauth/authorize"
+	defaultGitHubTokenEndpoint         = "https://github.com/login/oauth/access_token"
+	defaultGitHubUserEmailsEndpoint    = "https://api.github.com/user/emails"
+
+	// defaultExpirationOIDCState defines the time for user to authenticate with the identity provider.
+	defaultExpirationOIDCState = 10 * time.Minute
+
+	// cookieNameOIDCState defines the cookie binding the flow's state to the browser which started the flow.
+	cookieNameOIDCState = "oidc_state"
+
+	// oidcClockSkew defines the tolerated clock difference between the identity provider and the server.
+	oidcClockSkew = time.Minute
+)
+
+// OIDCProviderConfig defines the identity provider to sign in with using the authorization code flow with PKCE.
+type OIDCProviderConfig struct {
+	// Name identifies the provider in the sign-in path /auth/oidc/{Name}.
+	Name string `json:"name"`
+	// Kind defines the provider's type: "oidc" (default), "google", or "github".
+	Kind         string `json:"kind,omitempty"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// RedirectURL defines the URL of the callback endpoint /auth/oidc/{Name}/callback
+	// registered with the identity provider.
+	RedirectURL string   `json:"redirect_url"`
+	Scopes      []string `json:"scopes,omitempty"`
+
+	// Issuer defines the OIDC issuer, the endpoints are discovered from its OpenID configuration unless set.
+	Issuer                string `json:"issuer,omitempty"`
+	AuthorizationEndpoint string `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint         string `json:"token_endpoint,omitempty"`
+	JWKSURI               string `json:"jwks_uri,omitempty"`
+	// UserEmailsEndpoint defines GitHub's endpoint to read the user's emails.
+	UserEmailsEndpoint string `json:"user_emails_endpoint,omitempty"`
+}
+
+// Validate validates the provider's configuration.
+func (cfg OIDCProviderConfig) Validate() error {
+	if f, _ := regexp.MatchString(`^[a-z0-9-]+$`, cfg.Name); !f {
+		return errors.New("oidc provider name must consist of lower case latin letters, digits and hyphens")
+	}
+	if cfg.ClientID == "" {
+		return errors.New("oidc provider " + cfg.Name + ": client_id must be provided")
+	}
+	if cfg.RedirectURL == "" {
+		return errors.New("oidc provider " + cfg.Name + ": redirect_url must be provided")
+	}
+	switch cfg.Kind {
+	case "", OIDCProviderKindOIDC:
+		if cfg.Issuer == "" {
+			return errors.New("oidc provider " + cfg.Name + ": issuer must be provided")
+		}
+	case OIDCProviderKindGoogle, OIDCProviderKindGitHub:
+	default:
+		return errors.New("oidc provider " + cfg.Name + ": kind " + cfg.Kind + " is not supported")
+	}
+	return nil
+}
+
+func (cfg OIDCProviderConfig) withDefaults() OIDCProviderConfig {
+	switch cfg.Kind {
+	case "":
+		cfg.Kind = OIDCProviderKindOIDC
+	case OIDCProviderKindGoogle:
+		if cfg.Issuer == "" {
+			cfg.Issuer = issuerGoogle
+		}
+	case OIDCProviderKindGitHub:
+		if cfg.AuthorizationEndpoint == "" {
+			cfg.AuthorizationEndpoint = defaultGitHubAuthorizationEndpoint
+		}
+		if cfg.TokenEndpoint == "" {
+			cfg.TokenEndpoint = defaultGitHubTokenEndpoint
+		}
+		if cfg.UserEmailsEndpoint == "" {
+			cfg.UserEmailsEndpoint = defaultGitHubUserEmailsEndpoint
+		}
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"user:email"}
+		}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email"}
+	}
+	return cfg
+}
+
+// HTTPClient defines the client to communicate with the identity providers.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// RepositoryOIDCState defines the communication port to persistence layer hosting
+// the state of the authorization code flows in progress.
+type RepositoryOIDCState interface {
+	// WriteOIDCState registers the flow identified by the state.
+	WriteOIDCState(ctx context.Context, state, provider, nonce, codeVerifier string, expiresAt time.Time) error
+	// ConsumeOIDCState atomically reads and deletes the flow's state, i.e. the state can be used once.
+	ConsumeOIDCState(ctx context.Context, state string) (
+		found bool, provider, nonce, codeVerifier string, expiresAt time.Time, err error,
+	)
+}
+
+// NewRepositoryOIDCStateInMemory initialises the in-memory storage of the authorization code flows' state.
+// Note that the state is not shared across the application's instances.
+func NewRepositoryOIDCStateInMemory() RepositoryOIDCState {
+	return &repositoryOIDCStateInMemory{states: map[string]oidcState{}}
+}
+
+type oidcState struct {
+	Provider, Nonce, CodeVerifier string
+	ExpiresAt                     time.Time
+}
+
+type repositoryOIDCStateInMemory struct {
+	mu     sync.Mutex
+	states map[string]oidcState
+}
+
+func (r *repositoryOIDCStateInMemory) WriteOIDCState(
+	_ context.Context, state, provider, nonce, codeVerifier string, expiresAt time.Time,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range r.states {
+		if v.ExpiresAt.Before(now) {
+			delete(r.states, k)
+		}
+	}
+
+	r.states[state] = oidcState{Provider: provider, Nonce: nonce, CodeVerifier: codeVerifier, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (r *repositoryOIDCStateInMemory) ConsumeOIDCState(_ context.Context, state string) (
+	bool, string, string, string, time.Time, error,
+) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.states[state]
+	if !ok {
+		return false, "", "", "", time.Time{}, nil
+	}
+	delete(r.states, state)
+	return true, v.Provider, v.Nonce, v.CodeVerifier, v.ExpiresAt, nil
+}
+
+// newOIDCStateCookie binds the flow's state to the browser which started the flow.
+// The cookie holds the state's hash, and is sent back only to the provider's callback.
+func newOIDCStateCookie(provider, state string) *http.Cookie {
+	return &http.Cookie{
+		Name:     cookieNameOIDCState,
+		Value:    hashOIDCState(state),
+		Path:     pathOIDC + provider,
+		MaxAge:   int(defaultExpirationOIDCState.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// isOIDCStateBoundToBrowser checks that the callback is requested by the browser which started the flow.
+// It prevents the login CSRF: the victim navigated to the attacker's callback URL is not signed in.
+func isOIDCStateBoundToBrowser(r *http.Request, state string) bool {
+	cookie, err := r.Cookie(cookieNameOIDCState)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(hashOIDCState(state))) == 1
+}
+
+func hashOIDCState(state string) string {
+	h := sha256.Sum256([]byte(state))
+	return encodeSegment(h[:])
+}
+
+// newPKCE generates the PKCE code verifier and its S256 challenge, see https://www.rfc-editor.org/rfc/rfc7636
+func newPKCE() (verifier, challenge string, err error) {
+	verifier, err = generateRandomSegment()
+	if err != nil {
+		return "", "", err
+	}
+	h := sha256.Sum256([]byte(verifier))
+	return verifier, encodeSegment(h[:]), nil
+}
+
+func generateRandomSegment() (string, error) {
+	var b = make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encodeSegment(b), nil
+}
+
+// oidcProvider executes the relying party's side of the authorization code flow with the identity provider.
+type oidcProvider struct {
+	cfg        OIDCProviderConfig
+	httpClient HTTPClient
+
+	mu         sync.Mutex
+	discovered bool
+	keys       map[string]crypto.PublicKey
+}
+
+func newOIDCProvider(cfg OIDCProviderConfig, httpClient HTTPClient) (*oidcProvider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &oidcProvider{cfg: cfg.withDefaults(), httpClient: httpClient}, nil
+}
+
+func (p *oidcProvider) isOIDC() bool {
+	return p.cfg.Kind != OIDCProviderKindGitHub
+}
+
+// discover reads the provider's endpoints from its OpenID configuration,
+// see https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
+func (p *oidcProvider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered || !p.isOIDC() ||
+		(p.cfg.AuthorizationEndpoint != "" && p.cfg.TokenEndpoint != "" && p.cfg.JWKSURI != "") {
+		return nil
+	}
+
+	var cfg struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(
+		ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+pathOpenIDConfiguration, "", &cfg,
+	); err != nil {
+		return err
+	}
+	if cfg.Issuer != p.cfg.Issuer {
+		return errors.New("issuer " + cfg.Issuer + " does not match the configured issuer " + p.cfg.Issuer)
+	}
+
+	if p.cfg.AuthorizationEndpoint == "" {
+		p.cfg.AuthorizationEndpoint = cfg.AuthorizationEndpoint
+	}
+	if p.cfg.TokenEndpoint == "" {
+		p.cfg.TokenEndpoint = cfg.TokenEndpoint
+	}
+	if p.cfg.JWKSURI == "" {
+		p.cfg.JWKSURI = cfg.JWKSURI
+	}
+	p.discovered = true
+	return nil
+}
+
+// authCodeURL generates the URL to redirect user to authenticate with the identity provider.
+func (p *oidcProvider) authCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	if p.isOIDC() {
+		q.Set("nonce", nonce)
+	}
+
+	sep := "?"
+	if strings.Contains(p.cfg.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.cfg.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// readVerifiedEmail exchanges the authorization code for tokens and reads user's verified email.
+func (p *oidcProvider) readVerifiedEmail(ctx context.Context, code, codeVerifier, nonce string) (string, error) {
+	tokens, err := p.exchange(ctx, code, codeVerifier)
+	if err != nil {
+		return "", err
+	}
+	if p.isOIDC() {
+		return p.verifyIDToken(ctx, tokens.IDToken, nonce)
+	}
+	return p.readGitHubVerifiedEmail(ctx, tokens.AccessToken)
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+func (p *oidcProvider) exchange(ctx context.Context, code, codeVerifier string) (oidcTokenResponse, error) {
+	if err := p.discover(ctx); err != nil {
+		return oidcTokenResponse{}, err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, p.cfg.TokenEndpoint, strings.NewReader(
+			url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {code},
+				"redirect_uri":  {p.cfg.RedirectURL},
+				"client_id":     {p.cfg.ClientID},
+				"client_secret": {p.cfg.ClientSecret},
+				"code_verifier": {codeVerifier},
+			}.Encode(),
+		),
+	)
+	if err != nil {
+		return oidcTokenResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var o oidcTokenResponse
+	if err := p.doJSON(req, &o); err != nil {
+		return oidcTokenResponse{}, err
+	}
+	if o.Error != "" {
+		return oidcTokenResponse{}, errors.New("token exchange error: " + o.Error)
+	}
+	if p.isOIDC() && o.IDToken == "" {
+		return oidcTokenResponse{}, errors.New("no id_token returned")
+	}
+	if !p.isOIDC() && o.AccessToken == "" {
+		return oidcTokenResponse{}, errors.New("no access_token returned")
+	}
+	return o, nil
+}
+
+type oidcIDTokenClaims struct {
+	Iss   string   `json:"iss"`
+	Aud   audience `json:"aud"`
+	Exp   int64    `json:"exp"`
+	Iat   int64    `json:"iat"`
+	Nonce string   `json:"nonce"`
+	Email string   `json:"email"`
+	// EmailVerified is parsed leniently because some providers encode it as string.
+	EmailVerified any `json:"email_verified"`
+}
+
+// audience defines the aud claim which can be either a string, or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(v []byte) error {
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var o []string
+	if err := json.Unmarshal(v, &o); err != nil {
+		return err
+	}
+	*a = o
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, el := range a {
+		if el == v {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyIDToken verifies the id_token's signature against the provider's JWKS, and its claims.
+// It returns the user's email if it was verified by the provider.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, token, nonce string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("id_token is malformed")
+	}
+
+	var header jwtHeader
+	if err := decodeSegmentJSON(parts[0], &header); err != nil {
+		return "", err
+	}
+
+	key, err := p.verificationKey(ctx, header.Kid)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return "", err
+	}
+
+	var claims oidcIDTokenClaims
+	if err := decodeSegmentJSON(parts[1], &claims); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Iss != p.cfg.Issuer:
+		return "", errors.New("id_token issuer is not valid")
+	case !claims.Aud.contains(p.cfg.ClientID):
+		return "", errors.New("id_token audience is not valid")
+	case time.Unix(claims.Exp, 0).Add(oidcClockSkew).Before(now):
+		return "", errors.New("id_token expired")
+	case time.Unix(claims.Iat, 0).Add(-oidcClockSkew).After(now):
+		return "", errors.New("id_token issued in future")
+	case claims.Nonce != nonce:
+		return "", errors.New("id_token nonce is not valid")
+	case claims.Email == "":
+		return "", errors.New("id_token does not contain email")
+	case claims.EmailVerified != true && claims.EmailVerified != "true":
+		return "", errEmailNotVerified
+	}
+
+	return claims.Email, nil
+}
+
+var errEmailNotVerified = errors.New("email is not verified")
+
+func decodeSegmentJSON(seg string, v any) error {
+	b, err := decodeSegment(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signingInput, signature []byte) error {
+	h := sha256.Sum256(signingInput)
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match RS256")
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], signature)
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("key type does not match ES256")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(k, h[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return errors.New("signing algorithm " + alg + " is not supported")
+	}
+}
+
+// verificationKey returns the provider's public key identified by kid.
+// The provider's JWKS is refreshed if the key is not found to follow the provider's keys rotation.
+func (p *oidcProvider) verificationKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := p.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("key " + kid + " not found in the provider's JWKS")
+}
+
+type providerJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *oidcProvider) fetchKeys(ctx context.Context) error {
+	if err := p.discover(ctx); err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []providerJWK `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.cfg.JWKSURI, "", &jwks); err != nil {
+		return err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// the keys of unsupported types are skipped
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (k providerJWK) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.New("curve " + k.Crv + " is not supported")
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return key, nil
+	default:
+		return nil, errors.New("key type " + k.Kty + " is not supported")
+	}
+}
+
+// readGitHubVerifiedEmail reads user's primary verified email,
+// see https://docs.github.com/en/rest/users/emails#list-email-addresses-for-the-authenticated-user
+func (p *oidcProvider) readGitHubVerifiedEmail(ctx context.Context, accessToken string) (string, error) {
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(ctx, p.cfg.UserEmailsEndpoint, accessToken, &emails); err != nil {
+		return "", err
+	}
+	for _, el := range emails {
+		if el.Primary && el.Verified {
+			return el.Email, nil
+		}
+	}
+	return "", errEmailNotVerified
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return p.doJSON(req, v)
+}
+
+func (p *oidcProvider) doJSON(req *http.Request, v any) error {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(req.URL.String() + " responded with status " + resp.Status + ": " + string(b))
+	}
+	return json.Unmarshal(b, v)
+}
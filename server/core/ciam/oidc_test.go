@@ -0,0 +1,704 @@
+package ciam
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kislerdm/diagramastext/server/core/internal/utils"
+)
+
+// fakeIdP defines the identity provider which supports OIDC and GitHub-like OAuth2 flows.
+type fakeIdP struct {
+	server       *httptest.Server
+	key          *rsa.PrivateKey
+	kid          string
+	clientID     string
+	clientSecret string
+
+	mu    sync.Mutex
+	codes map[string]fakeAuthorization
+
+	// claimsOverride modifies the id_token claims before signing.
+	claimsOverride func(claims map[string]any)
+}
+
+type fakeAuthorization struct {
+	nonce, codeChallenge, email string
+	emailVerified               bool
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &fakeIdP{
+		key:          key,
+		kid:          "fake-key",
+		clientID:     "client-id",
+		clientSecret: "client-secret",
+		codes:        map[string]fakeAuthorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		pathOpenIDConfiguration, func(w http.ResponseWriter, _ *http.Request) {
+			_ = json.NewEncoder(w).Encode(
+				map[string]string{
+					"issuer":                 idp.server.URL,
+					"authorization_endpoint": idp.server.URL + "/authorize",
+					"token_endpoint":         idp.server.URL + "/token",
+					"jwks_uri":               idp.server.URL + "/jwks",
+				},
+			)
+		},
+	)
+	mux.HandleFunc(
+		"/jwks", func(w http.ResponseWriter, _ *http.Request) {
+			_ = json.NewEncoder(w).Encode(
+				map[string]any{
+					"keys": []map[string]string{
+						{
+							"kty": "RSA",
+							"kid": idp.kid,
+							"use": "sig",
+							"alg": "RS256",
+							"n":   encodeSegment(key.N.Bytes()),
+							"e":   encodeSegment(big.NewInt(int64(key.E)).Bytes()),
+						},
+					},
+				},
+			)
+		},
+	)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc(
+		"/user/emails", func(w http.ResponseWriter, r *http.Request) {
+			idp.mu.Lock()
+			authorization, ok := idp.codes[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer gh-")]
+			idp.mu.Unlock()
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(
+				[]map[string]any{
+					{"email": "secondary@bar.baz", "primary": false, "verified": true},
+					{"email": authorization.email, "primary": true, "verified": authorization.emailVerified},
+				},
+			)
+		},
+	)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("client_id") != idp.clientID || r.PostForm.Get("client_secret") != idp.clientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	idp.mu.Lock()
+	authorization, ok := idp.codes[code]
+	idp.mu.Unlock()
+	h := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || encodeSegment(h[:]) != authorization.codeChallenge {
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            idp.server.URL,
+		"sub":            "fake-subject",
+		"aud":            idp.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          authorization.nonce,
+		"email":          authorization.email,
+		"email_verified": authorization.emailVerified,
+	}
+	if idp.claimsOverride != nil {
+		idp.claimsOverride(claims)
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		map[string]string{
+			"access_token": "gh-" + code,
+			"token_type":   "bearer",
+			"id_token":     idp.signIDToken(claims),
+		},
+	)
+}
+
+func (idp *fakeIdP) signIDToken(claims map[string]any) string {
+	header, _ := json.Marshal(jwtHeader{Alg: "RS256", Typ: "JWT", Kid: idp.kid})
+	payload, _ := json.Marshal(claims)
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	h := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, h[:])
+	if err != nil {
+		panic(err)
+	}
+	return signingInput + "." + encodeSegment(signature)
+}
+
+// authorize emulates the user's consent: it registers the authorization code,
+// and returns the request to the callback endpoint the user's browser is redirected to.
+func (idp *fakeIdP) authorize(t *testing.T, start *utils.MockWriter, email string, emailVerified bool) *http.Request {
+	t.Helper()
+	authCodeURL := start.Header().Get("Location")
+	u, err := url.Parse(authCodeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("PKCE challenge is expected: %s", authCodeURL)
+	}
+
+	code := utils.NewUUID()
+	idp.mu.Lock()
+	idp.codes[code] = fakeAuthorization{
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		email:         email,
+		emailVerified: emailVerified,
+	}
+	idp.mu.Unlock()
+
+	callback, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path:     callback.Path,
+			RawQuery: url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(),
+		},
+		Header: http.Header{},
+	}
+	for _, cookie := range (&http.Response{Header: start.Header()}).Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func (idp *fakeIdP) providerConfig(kind string) OIDCProviderConfig {
+	cfg := OIDCProviderConfig{
+		Name:         "fake",
+		Kind:         kind,
+		ClientID:     idp.clientID,
+		ClientSecret: idp.clientSecret,
+		RedirectURL:  "https://api.diagramastext.dev/auth/oidc/fake/callback",
+		Issuer:       idp.server.URL,
+	}
+	if kind == OIDCProviderKindGitHub {
+		cfg.Issuer = ""
+		cfg.AuthorizationEndpoint = idp.server.URL + "/authorize"
+		cfg.TokenEndpoint = idp.server.URL + "/token"
+		cfg.UserEmailsEndpoint = idp.server.URL + "/user/emails"
+	}
+	return cfg
+}
+
+func initOIDCSignin(t *testing.T, idp *fakeIdP, kind string, clientRepo *MockRepositoryCIAM) (http.Handler, Issuer) {
+	t.Helper()
+	key := GenerateCertificate()
+	handlerFn, err := HTTPHandler(
+		clientRepo, &MockMailer{}, NewKeySet(key),
+		WithOIDCProviders(nil, idp.providerConfig(kind)),
+		WithHTTPClient(idp.server.Client()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss, err := NewIssuer(NewKeySet(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handlerFn(nil), iss
+}
+
+// startOIDCSignin emulates the browser starting the flow, the response redirects it to the provider.
+func startOIDCSignin(t *testing.T, handler http.Handler) *utils.MockWriter {
+	t.Helper()
+	writer := &utils.MockWriter{}
+	handler.ServeHTTP(writer, &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/auth/oidc/fake"}})
+	if writer.StatusCode != http.StatusFound {
+		t.Fatalf("wrong status code. want: %d, got: %d, body: %s", http.StatusFound, writer.StatusCode, writer.V)
+	}
+	return writer
+}
+
+func readRedirectFragment(t *testing.T, writer *utils.MockWriter) url.Values {
+	t.Helper()
+	if writer.StatusCode != http.StatusSeeOther {
+		t.Fatalf("wrong status code. want: %d, got: %d", http.StatusSeeOther, writer.StatusCode)
+	}
+	location, err := url.Parse(writer.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestServeHTTP_OIDC(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	t.Run(
+		"shall sign in with OIDC provider and create the user", func(t *testing.T) {
+			// GIVEN
+			clientRepo := &MockRepositoryCIAM{}
+			handler, iss := initOIDCSignin(t, idp, OIDCProviderKindOIDC, clientRepo)
+			start := startOIDCSignin(t, handler)
+			if authCodeURL := start.Header().Get("Location"); !strings.HasPrefix(
+				authCodeURL, idp.server.URL+"/authorize?",
+			) {
+				t.Fatalf("unexpected authorization URL: %s", authCodeURL)
+			}
+			if cookie := start.Header().Get("Set-Cookie"); !strings.Contains(cookie, "HttpOnly") ||
+				!strings.Contains(cookie, "Secure") || !strings.Contains(cookie, "SameSite=Lax") {
+				t.Fatalf("unexpected state cookie: %s", cookie)
+			}
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, idp.authorize(t, start, "foo@bar.baz", true))
+
+			// THEN
+			fragment := readRedirectFragment(t, writer)
+			user, err := iss.ParseAccessToken(fragment.Get("access"))
+			if err != nil {
+				t.Fatalf("faulty Access token: %v, fragment: %v", err, fragment)
+			}
+			if user.Role != RoleRegisteredUser {
+				t.Errorf("unexpected user's role: %d", user.Role)
+			}
+			created, ok := clientRepo.UserEmail["foo@bar.baz"]
+			if !ok || created.ID != user.ID || !created.IsActive {
+				t.Errorf("active user is expected to be created with the verified email")
+			}
+		},
+	)
+
+	t.Run(
+		"shall link the existing user by verified email", func(t *testing.T) {
+			// GIVEN
+			userID := utils.NewUUID()
+			existing := &userContainer{ID: userID, Email: "qux@bar.baz", RoleID: uint8(RoleRegisteredUser)}
+			clientRepo := &MockRepositoryCIAM{
+				UserID:    map[string]*userContainer{userID: existing},
+				UserEmail: map[string]*userContainer{existing.Email: existing},
+			}
+			handler, iss := initOIDCSignin(t, idp, OIDCProviderKindOIDC, clientRepo)
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, idp.authorize(t, startOIDCSignin(t, handler), existing.Email, true))
+
+			// THEN
+			user, err := iss.ParseAccessToken(readRedirectFragment(t, writer).Get("access"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != userID {
+				t.Errorf("user is expected to be linked. want: %s, got: %s", userID, user.ID)
+			}
+			if !existing.IsActive {
+				t.Error("user is expected to be activated")
+			}
+		},
+	)
+
+	t.Run(
+		"shall issue the access token with the linked user's stored role", func(t *testing.T) {
+			// GIVEN
+			userID := utils.NewUUID()
+			existing := &userContainer{
+				ID: userID, Email: "admin@bar.baz", IsActive: true, RoleID: uint8(RoleAdmin),
+			}
+			clientRepo := &MockRepositoryCIAM{
+				UserID:    map[string]*userContainer{userID: existing},
+				UserEmail: map[string]*userContainer{existing.Email: existing},
+			}
+			handler, iss := initOIDCSignin(t, idp, OIDCProviderKindOIDC, clientRepo)
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, idp.authorize(t, startOIDCSignin(t, handler), existing.Email, true))
+
+			// THEN
+			user, err := iss.ParseAccessToken(readRedirectFragment(t, writer).Get("access"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != RoleAdmin {
+				t.Errorf("unexpected user's role. want: %d, got: %d", RoleAdmin, user.Role)
+			}
+		},
+	)
+
+	t.Run(
+		"shall refuse to sign in the user blocked by the admin", func(t *testing.T) {
+			// GIVEN
+			userID := utils.NewUUID()
+			existing := &userContainer{
+				ID: userID, Email: "qux@bar.baz", IsBlocked: true, RoleID: uint8(RoleRegisteredUser),
+			}
+			clientRepo := &MockRepositoryCIAM{
+				UserID:    map[string]*userContainer{userID: existing},
+				UserEmail: map[string]*userContainer{existing.Email: existing},
+			}
+			handler, _ := initOIDCSignin(t, idp, OIDCProviderKindOIDC, clientRepo)
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, idp.authorize(t, startOIDCSignin(t, handler), existing.Email, true))
+
+			// THEN
+			fragment := readRedirectFragment(t, writer)
+			if got := fragment.Get("error"); got != "user was deactivated" {
+				t.Errorf("unexpected error: %s", got)
+			}
+			if fragment.Get("access") != "" {
+				t.Error("no access token is expected to be issued")
+			}
+			if existing.IsActive {
+				t.Error("blocked user is not expected to be activated")
+			}
+		},
+	)
+
+	t.Run(
+		"shall reject the unverified email", func(t *testing.T) {
+			// GIVEN
+			clientRepo := &MockRepositoryCIAM{}
+			handler, _ := initOIDCSignin(t, idp, OIDCProviderKindOIDC, clientRepo)
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, idp.authorize(t, startOIDCSignin(t, handler), "foo@bar.baz", false))
+
+			// THEN
+			if got := readRedirectFragment(t, writer).Get("error"); got != "email is not verified" {
+				t.Errorf("unexpected error: %s", got)
+			}
+			if len(clientRepo.UserEmail) != 0 {
+				t.Error("no user is expected to be created")
+			}
+		},
+	)
+
+	t.Run(
+		"shall reject the reused state", func(t *testing.T) {
+			// GIVEN
+			handler, _ := initOIDCSignin(t, idp, OIDCProviderKindOIDC, &MockRepositoryCIAM{})
+			callback := idp.authorize(t, startOIDCSignin(t, handler), "foo@bar.baz", true)
+			handler.ServeHTTP(&utils.MockWriter{}, callback)
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, callback)
+
+			// THEN
+			if got := readRedirectFragment(t, writer).Get("error"); got != "state is not valid" {
+				t.Errorf("unexpected error: %s", got)
+			}
+		},
+	)
+
+	t.Run(
+		"shall reject the callback without the state cookie", func(t *testing.T) {
+			// GIVEN
+			clientRepo := &MockRepositoryCIAM{}
+			handler, _ := initOIDCSignin(t, idp, OIDCProviderKindOIDC, clientRepo)
+			// the attacker lures the victim to the callback URL of the flow started by the attacker
+			callback := idp.authorize(t, startOIDCSignin(t, handler), "attacker@bar.baz", true)
+			callback.Header.Del("Cookie")
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, callback)
+
+			// THEN
+			fragment := readRedirectFragment(t, writer)
+			if got := fragment.Get("error"); got != "state is not valid" {
+				t.Errorf("unexpected error: %s", got)
+			}
+			if fragment.Get("access") != "" || len(clientRepo.UserEmail) != 0 {
+				t.Error("the user is not expected to be signed in")
+			}
+		},
+	)
+
+	t.Run(
+		"shall reject the callback with the state cookie of another flow", func(t *testing.T) {
+			// GIVEN
+			clientRepo := &MockRepositoryCIAM{}
+			handler, _ := initOIDCSignin(t, idp, OIDCProviderKindOIDC, clientRepo)
+			callback := idp.authorize(t, startOIDCSignin(t, handler), "attacker@bar.baz", true)
+			// the victim's browser holds the cookie of the flow it started
+			victim := idp.authorize(t, startOIDCSignin(t, handler), "victim@bar.baz", true)
+			callback.Header.Set("Cookie", victim.Header.Get("Cookie"))
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, callback)
+
+			// THEN
+			fragment := readRedirectFragment(t, writer)
+			if got := fragment.Get("error"); got != "state is not valid" {
+				t.Errorf("unexpected error: %s", got)
+			}
+			if fragment.Get("access") != "" || len(clientRepo.UserEmail) != 0 {
+				t.Error("the user is not expected to be signed in")
+			}
+		},
+	)
+
+	t.Run(
+		"shall reject the id_token with faulty nonce", func(t *testing.T) {
+			// GIVEN
+			handler, _ := initOIDCSignin(t, idp, OIDCProviderKindOIDC, &MockRepositoryCIAM{})
+			idp.claimsOverride = func(claims map[string]any) { claims["nonce"] = "foo" }
+			defer func() { idp.claimsOverride = nil }()
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, idp.authorize(t, startOIDCSignin(t, handler), "foo@bar.baz", true))
+
+			// THEN
+			if got := readRedirectFragment(t, writer).Get("error"); got != "authentication failed" {
+				t.Errorf("unexpected error: %s", got)
+			}
+		},
+	)
+
+	t.Run(
+		"shall sign in with GitHub using the primary verified email", func(t *testing.T) {
+			// GIVEN
+			clientRepo := &MockRepositoryCIAM{}
+			handler, iss := initOIDCSignin(t, idp, OIDCProviderKindGitHub, clientRepo)
+
+			// WHEN
+			writer := &utils.MockWriter{}
+			handler.ServeHTTP(writer, idp.authorize(t, startOIDCSignin(t, handler), "foo@bar.baz", true))
+
+			// THEN
+			user, err := iss.ParseAccessToken(readRedirectFragment(t, writer).Get("access"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if clientRepo.UserEmail["foo@bar.baz"].ID != user.ID {
+				t.Error("user is expected to be identified by the primary email")
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail given unknown provider", func(t *testing.T) {
+			// GIVEN
+			handler, _ := initOIDCSignin(t, idp, OIDCProviderKindOIDC, &MockRepositoryCIAM{})
+			writer := &utils.MockWriter{}
+
+			// WHEN
+			handler.ServeHTTP(writer, &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/auth/oidc/bar"}})
+
+			// THEN
+			if writer.StatusCode != http.StatusNotFound {
+				t.Errorf("wrong status code. want: %d, got: %d", http.StatusNotFound, writer.StatusCode)
+			}
+		},
+	)
+}
+
+func Test_oidcProvider_verifyIDToken(t *testing.T) {
+	idp := newFakeIdP(t)
+	provider, err := newOIDCProvider(idp.providerConfig(OIDCProviderKindOIDC), idp.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newClaims := func(override func(claims map[string]any)) map[string]any {
+		now := time.Now()
+		o := map[string]any{
+			"iss":            idp.server.URL,
+			"aud":            []string{"foo", idp.clientID},
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+			"nonce":          "nonce",
+			"email":          "foo@bar.baz",
+			"email_verified": "true",
+		}
+		if override != nil {
+			override(o)
+		}
+		return o
+	}
+
+	otherIdP := newFakeIdP(t)
+
+	tests := []struct {
+		name    string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "happy path",
+			token: idp.signIDToken(newClaims(nil)),
+			want:  "foo@bar.baz",
+		},
+		{
+			name:    "unhappy path: wrong issuer",
+			token:   idp.signIDToken(newClaims(func(c map[string]any) { c["iss"] = "https://foo.bar" })),
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: wrong audience",
+			token:   idp.signIDToken(newClaims(func(c map[string]any) { c["aud"] = "foo" })),
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: expired",
+			token: idp.signIDToken(
+				newClaims(func(c map[string]any) { c["exp"] = time.Now().Add(-2 * oidcClockSkew).Unix() }),
+			),
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: wrong nonce",
+			token:   idp.signIDToken(newClaims(func(c map[string]any) { c["nonce"] = "bar" })),
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: email not verified",
+			token:   idp.signIDToken(newClaims(func(c map[string]any) { c["email_verified"] = false })),
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: signed by unknown key",
+			token:   otherIdP.signIDToken(newClaims(nil)),
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: unsigned token",
+			token: func() string {
+				header, _ := json.Marshal(jwtHeader{Alg: "none", Kid: idp.kid})
+				payload, _ := json.Marshal(newClaims(nil))
+				return encodeSegment(header) + "." + encodeSegment(payload) + "."
+			}(),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := provider.verifyIDToken(context.TODO(), tt.token, "nonce")
+				if (err != nil) != tt.wantErr {
+					t.Errorf("verifyIDToken() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if got != tt.want {
+					t.Errorf("verifyIDToken() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestOIDCProviderConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     OIDCProviderConfig
+		wantErr bool
+	}{
+		{
+			name: "happy path: google",
+			cfg: OIDCProviderConfig{
+				Name: "google", Kind: OIDCProviderKindGoogle, ClientID: "foo", RedirectURL: "https://foo.bar",
+			},
+		},
+		{
+			name: "happy path: generic oidc",
+			cfg: OIDCProviderConfig{
+				Name: "corp", ClientID: "foo", RedirectURL: "https://foo.bar", Issuer: "https://idp.foo.bar",
+			},
+		},
+		{
+			name:    "unhappy path: generic oidc without issuer",
+			cfg:     OIDCProviderConfig{Name: "corp", ClientID: "foo", RedirectURL: "https://foo.bar"},
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: invalid name",
+			cfg:     OIDCProviderConfig{Name: "Foo/Bar", Kind: OIDCProviderKindGitHub, ClientID: "foo"},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: unknown kind",
+			cfg: OIDCProviderConfig{
+				Name: "foo", Kind: "saml", ClientID: "foo", RedirectURL: "https://foo.bar",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func TestRepositoryOIDCStateInMemory(t *testing.T) {
+	// GIVEN
+	repo := NewRepositoryOIDCStateInMemory()
+	expiresAt := time.Now().Add(time.Minute)
+	if err := repo.WriteOIDCState(context.TODO(), "state", "fake", "nonce", "verifier", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// WHEN
+	found, provider, nonce, verifier, gotExpiresAt, err := repo.ConsumeOIDCState(context.TODO(), "state")
+
+	// THEN
+	if err != nil || !found || provider != "fake" || nonce != "nonce" || verifier != "verifier" ||
+		!gotExpiresAt.Equal(expiresAt) {
+		t.Errorf("unexpected state read")
+	}
+	if found, _, _, _, _, _ := repo.ConsumeOIDCState(context.TODO(), "state"); found {
+		t.Error("state is expected to be consumed once")
+	}
+}
@@ -0,0 +1,109 @@
+package ciam
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math/big"
+	"time"
+)
+
+const (
+	// defaultExpirationSecret defines the validity duration of the one-time secret.
+	defaultExpirationSecret = 10 * time.Minute
+
+	// windowSecretAttempts defines the window to count failed attempts to confirm the one-time secret.
+	windowSecretAttempts = 15 * time.Minute
+	// maxSecretAttemptsPerUser defines max number of failed attempts per user,
+	// the user's secret is invalidated once the limit is reached.
+	maxSecretAttemptsPerUser = 5
+	// maxSecretAttemptsPerIP defines max number of failed attempts per client's IP.
+	maxSecretAttemptsPerIP = 20
+
+	// windowSecretResend defines the window to throttle the one-time secret's issuing.
+	windowSecretResend = time.Minute
+	// maxSecretResendPerUser defines max number of secrets sent to the user within the window.
+	maxSecretResendPerUser = 1
+	// windowSecretResendIP defines the window to throttle the one-time secret's issuing per client's IP.
+	windowSecretResendIP = time.Hour
+	// maxSecretResendPerIP defines max number of secrets sent upon requests from the client's IP within the window.
+	maxSecretResendPerIP = 20
+)
+
+func keySecretAttemptUser(userID string) string {
+	return "signin:attempt:user:" + userID
+}
+
+func keySecretAttemptIP(ip string) string {
+	return "signin:attempt:ip:" + ip
+}
+
+func keySecretResendUser(userID string) string {
+	return "signin:init:user:" + userID
+}
+
+func keySecretResendIP(ip string) string {
+	return "signin:init:ip:" + ip
+}
+
+// generateOnetimeSecret generates the secret using CSPRNG.
+func generateOnetimeSecret() (string, error) {
+	const (
+		charset = "0123456789abcdef"
+		length  = 6
+	)
+	var b = make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = charset[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// generateMagicLinkSecret generates the secret embedded into the magic link using CSPRNG.
+// The secret is longer than the code typed in by user because it's not meant to be read by human.
+func generateMagicLinkSecret() (string, error) {
+	var b = make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encodeSegment(b), nil
+}
+
+// onetimeSecretHashKey derives the key to hash one-time secrets from the signing key.
+// Note that the secrets issued before the signing key's rotation become invalid.
+func onetimeSecretHashKey(keySet KeySet) []byte {
+	h := sha256.New()
+	_, _ = h.Write([]byte("one-time-secret:"))
+	_, _ = h.Write(keySet.SigningKey.Seed())
+	return h.Sum(nil)
+}
+
+// hashOnetimeSecret hashes the secret with HMAC-SHA256 to persist it.
+func hashOnetimeSecret(key []byte, userID, secret string) string {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(userID + ":" + secret))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isOnetimeSecretValid compares the hash of the secret provided by user against the reference in constant time.
+func isOnetimeSecretValid(key []byte, userID, secret, secretHashRef string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashOnetimeSecret(key, userID, secret)), []byte(secretHashRef)) == 1
+}
+
+// isRateLimited checks if the number of events identified by the key within the window reached the limit.
+func isRateLimited(ctx context.Context, rateLimiter RateLimiter, key string, window time.Duration, limit uint32) (
+	bool, error,
+) {
+	cnt, _, err := rateLimiter.Count(ctx, key, window, limit)
+	if err != nil {
+		return false, err
+	}
+	return cnt >= limit, nil
+}
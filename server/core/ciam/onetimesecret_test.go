@@ -0,0 +1,56 @@
+package ciam
+
+import (
+	"regexp"
+	"testing"
+)
+
+func Test_generateOnetimeSecret(t *testing.T) {
+	got, err := generateOnetimeSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{6}$`).MatchString(got) {
+		t.Errorf("unexpected secret format: %s", got)
+	}
+}
+
+func Test_isOnetimeSecretValid(t *testing.T) {
+	key := onetimeSecretHashKey(NewKeySet(GenerateCertificate()))
+	ref := hashOnetimeSecret(key, "foo", "123456")
+
+	tests := []struct {
+		name   string
+		userID string
+		secret string
+		want   bool
+	}{
+		{
+			name:   "shall match the secret",
+			userID: "foo",
+			secret: "123456",
+			want:   true,
+		},
+		{
+			name:   "shall not match wrong secret",
+			userID: "foo",
+			secret: "123457",
+			want:   false,
+		},
+		{
+			name:   "shall not match the secret of other user",
+			userID: "bar",
+			secret: "123456",
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := isOnetimeSecretValid(key, tt.userID, tt.secret, ref); got != tt.want {
+					t.Errorf("isOnetimeSecretValid() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
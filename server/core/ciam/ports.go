@@ -2,6 +2,7 @@ package ciam
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -9,36 +10,66 @@ import (
 // RepositoryCIAM defines the communication port to persistence layer hosting users' data.
 type RepositoryCIAM interface {
 	CreateUser(ctx context.Context, id, email, fingerprint string, isActive bool, role *uint8) error
+	// ReadUser reads the user's record. The user is blocked by the admin regardless of its activation status
+	// which reflects confirmation of the user's identity upon sign-in.
 	ReadUser(ctx context.Context, id string) (
-		found, isActive bool, role uint8, email, fingerprint string, err error,
+		found, isActive, isBlocked bool, role uint8, email, fingerprint string, err error,
 	)
 
 	LookupUserByEmail(ctx context.Context, email string) (id string, isActive bool, err error)
+	// LookupUserByFingerprint finds the anonymous user by the fingerprint.
+	// The registered users are not matched because the fingerprint is not a secret.
 	LookupUserByFingerprint(ctx context.Context, fingerprint string) (id string, isActive bool, err error)
 
 	// UpdateUserSetActive user active.
 	UpdateUserSetActive(ctx context.Context, userID string) error
 
+	// UpgradeAnonymUser re-parents the anonymous user's prompts, predictions, successful requests,
+	// flagged prompts and rate limit counters to the registered user, detaches the fingerprint from
+	// the anonymous user and deactivates it. All changes are applied in one transaction.
+	// The fingerprint is not attached to the registered user, it must not grant access to the user's account.
+	UpgradeAnonymUser(ctx context.Context, anonymUserID, userID string) error
+
+	// ExportUserData reads all user's data as JSON document.
+	// It returns found=false if the user does not exist.
+	ExportUserData(ctx context.Context, userID string) (found bool, data []byte, err error)
+	// DeleteUser deletes the user's prompts, model predictions, successful requests, API keys, one-time secrets,
+	// refresh tokens, flagged prompts and rate limit counters, and anonymizes the user's record.
+	// All changes are applied in one transaction.
+	DeleteUser(ctx context.Context, userID string) error
+
 	// WriteOneTimeSecret creates a new, or updates existing one-time secret.
 	WriteOneTimeSecret(ctx context.Context, userID, secret string, createdAt time.Time) error
 	ReadOneTimeSecret(ctx context.Context, userID string) (found bool, secret string, issuedAt time.Time, err error)
 	DeleteOneTimeSecret(ctx context.Context, userID string) error
 
-	// GetDailySuccessfulResultsTimestampsByUserID reads the timestamps of all user's successful requests
-	// which led to successful diagrams generation over the last 24 hours / day.
-	GetDailySuccessfulResultsTimestampsByUserID(ctx context.Context, userID string) ([]time.Time, error)
-
 	// GetActiveUserIDByActiveTokenID reads userID from the repository given the tokenID.
-	// It returns a non-empty value if and only if the token and user are active.
+	// It returns a non-empty value if and only if the token and user are active, and the user is not blocked.
 	GetActiveUserIDByActiveTokenID(ctx context.Context, token string) (userID string, err error)
+
+	// WriteRefreshToken registers the active refresh token which belongs to the family of rotated tokens.
+	WriteRefreshToken(ctx context.Context, userID, tokenID, familyID string, expiresAt time.Time) error
+	// DeactivateRefreshToken atomically deactivates the active refresh token.
+	// It returns found=false if no active token was found, i.e. the token was rotated, revoked, or it is unknown.
+	DeactivateRefreshToken(ctx context.Context, userID, tokenID string) (familyID string, found bool, err error)
+	// RevokeRefreshTokenFamily deactivates all refresh tokens of the family the token belongs to.
+	RevokeRefreshTokenFamily(ctx context.Context, userID, tokenID string) error
+	// RevokeUserRefreshTokens deactivates all user's refresh tokens.
+	RevokeUserRefreshTokens(ctx context.Context, userID string) error
 }
 
 type userContainer struct {
 	ID, Email, Fingerprint string
-	IsActive               bool
+	IsActive, IsBlocked    bool
 	RoleID                 uint8
 }
 
+type refreshTokenContainer struct {
+	UserID, FamilyID string
+	IsActive         bool
+	ExpiresAt        time.Time
+}
+
 type Secret struct {
 	Secret   string
 	IssuedAt time.Time
@@ -50,8 +81,12 @@ type MockRepositoryCIAM struct {
 	UserFingerprint map[string]*userContainer
 	Secret          map[string]Secret
 	Err             error
-	Timestamps      []time.Time
 	UserToken       map[string]string
+	RefreshToken    map[string]*refreshTokenContainer
+	// UpgradedUsers maps the upgraded anonymous users to the registered users.
+	UpgradedUsers map[string]string
+	// DeletedUsers lists the deleted users.
+	DeletedUsers []string
 }
 
 func (m *MockRepositoryCIAM) CreateUser(
@@ -83,6 +118,85 @@ func (m *MockRepositoryCIAM) UpdateUserSetActive(_ context.Context, userID strin
 	return nil
 }
 
+func (m *MockRepositoryCIAM) UpgradeAnonymUser(_ context.Context, anonymUserID, userID string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	anonym, ok := m.UserID[anonymUserID]
+	if !ok {
+		return errors.New("anonymous user not found")
+	}
+	if _, ok := m.UserID[userID]; !ok {
+		return errors.New("user not found")
+	}
+
+	delete(m.UserFingerprint, anonym.Fingerprint)
+	anonym.Fingerprint = ""
+	anonym.IsActive = false
+
+	if m.UpgradedUsers == nil {
+		m.UpgradedUsers = map[string]string{}
+	}
+	m.UpgradedUsers[anonymUserID] = userID
+	return nil
+}
+
+func (m *MockRepositoryCIAM) ExportUserData(_ context.Context, userID string) (
+	found bool, data []byte, err error,
+) {
+	if m.Err != nil {
+		return false, nil, m.Err
+	}
+	u, ok := m.UserID[userID]
+	if !ok {
+		return false, nil, nil
+	}
+	data, err = json.Marshal(
+		map[string]any{
+			"user": map[string]any{
+				"user_id":         u.ID,
+				"email":           u.Email,
+				"web_fingerprint": u.Fingerprint,
+				"role":            u.RoleID,
+				"is_active":       u.IsActive,
+				"is_blocked":      u.IsBlocked,
+			},
+		},
+	)
+	return true, data, err
+}
+
+func (m *MockRepositoryCIAM) DeleteUser(_ context.Context, userID string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	u, ok := m.UserID[userID]
+	if !ok {
+		return nil
+	}
+
+	delete(m.UserEmail, u.Email)
+	delete(m.UserFingerprint, u.Fingerprint)
+	u.Email = ""
+	u.Fingerprint = ""
+	u.IsActive = false
+
+	delete(m.Secret, userID)
+	for k, v := range m.UserToken {
+		if v == userID {
+			delete(m.UserToken, k)
+		}
+	}
+	for k, v := range m.RefreshToken {
+		if v.UserID == userID {
+			delete(m.RefreshToken, k)
+		}
+	}
+
+	m.DeletedUsers = append(m.DeletedUsers, userID)
+	return nil
+}
+
 func (m *MockRepositoryCIAM) setUser(u *userContainer) {
 	if m.UserEmail == nil {
 		m.UserEmail = map[string]*userContainer{}
@@ -101,15 +215,15 @@ func (m *MockRepositoryCIAM) setUser(u *userContainer) {
 }
 
 func (m *MockRepositoryCIAM) ReadUser(_ context.Context, id string) (
-	found, isActive bool, role uint8, email, fingerprint string, err error,
+	found, isActive, isBlocked bool, role uint8, email, fingerprint string, err error,
 ) {
 	if m.Err != nil {
-		return false, false, 0, "", "", m.Err
+		return false, false, false, 0, "", "", m.Err
 	}
 	if u, ok := m.UserID[id]; ok {
-		return true, u.IsActive, u.RoleID, u.Email, u.Fingerprint, nil
+		return true, u.IsActive, u.IsBlocked, u.RoleID, u.Email, u.Fingerprint, nil
 	}
-	return false, false, 0, "", "", nil
+	return false, false, false, 0, "", "", nil
 }
 
 func (m *MockRepositoryCIAM) LookupUserByEmail(_ context.Context, email string) (id string, isActive bool, err error) {
@@ -128,7 +242,7 @@ func (m *MockRepositoryCIAM) LookupUserByFingerprint(_ context.Context, fingerpr
 	if m.Err != nil {
 		return "", false, m.Err
 	}
-	if u, ok := m.UserFingerprint[fingerprint]; ok {
+	if u, ok := m.UserFingerprint[fingerprint]; ok && Role(u.RoleID) == RoleAnonymUser {
 		return u.ID, u.IsActive, nil
 	}
 	return "", false, nil
@@ -168,15 +282,6 @@ func (m *MockRepositoryCIAM) DeleteOneTimeSecret(_ context.Context, userID strin
 	return nil
 }
 
-func (m *MockRepositoryCIAM) GetDailySuccessfulResultsTimestampsByUserID(_ context.Context, _ string) (
-	[]time.Time, error,
-) {
-	if m.Err != nil {
-		return nil, m.Err
-	}
-	return m.Timestamps, nil
-}
-
 func (m *MockRepositoryCIAM) GetActiveUserIDByActiveTokenID(_ context.Context, token string) (string, error) {
 	if m.Err != nil {
 		return "", m.Err
@@ -186,3 +291,62 @@ func (m *MockRepositoryCIAM) GetActiveUserIDByActiveTokenID(_ context.Context, t
 	}
 	return "", errors.New("token not found")
 }
+
+func (m *MockRepositoryCIAM) WriteRefreshToken(
+	_ context.Context, userID, tokenID, familyID string, expiresAt time.Time,
+) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if m.RefreshToken == nil {
+		m.RefreshToken = map[string]*refreshTokenContainer{}
+	}
+	m.RefreshToken[tokenID] = &refreshTokenContainer{
+		UserID:    userID,
+		FamilyID:  familyID,
+		IsActive:  true,
+		ExpiresAt: expiresAt,
+	}
+	return nil
+}
+
+func (m *MockRepositoryCIAM) DeactivateRefreshToken(_ context.Context, userID, tokenID string) (
+	familyID string, found bool, err error,
+) {
+	if m.Err != nil {
+		return "", false, m.Err
+	}
+	if v, ok := m.RefreshToken[tokenID]; ok && v.UserID == userID && v.IsActive {
+		v.IsActive = false
+		return v.FamilyID, true, nil
+	}
+	return "", false, nil
+}
+
+func (m *MockRepositoryCIAM) RevokeRefreshTokenFamily(_ context.Context, userID, tokenID string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	v, ok := m.RefreshToken[tokenID]
+	if !ok || v.UserID != userID {
+		return nil
+	}
+	for _, el := range m.RefreshToken {
+		if el.FamilyID == v.FamilyID {
+			el.IsActive = false
+		}
+	}
+	return nil
+}
+
+func (m *MockRepositoryCIAM) RevokeUserRefreshTokens(_ context.Context, userID string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, el := range m.RefreshToken {
+		if el.UserID == userID {
+			el.IsActive = false
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package ciam
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RepositoryRateLimiter defines the communication port to persistence layer hosting the rate limiter's counters.
+type RepositoryRateLimiter interface {
+	// IncrementRateLimitCounter atomically increments the counter identified by the key
+	// for the window starting at windowStart. The counter is not read after expiresAt, hence it can be deleted.
+	IncrementRateLimitCounter(ctx context.Context, key string, windowStart, expiresAt time.Time) error
+
+	// IncrementRateLimitCounterIfBelow atomically increments the counter given its value is below max.
+	// It reports whether the counter was incremented.
+	IncrementRateLimitCounterIfBelow(
+		ctx context.Context, key string, windowStart, expiresAt time.Time, max uint32,
+	) (bool, error)
+
+	// ReadRateLimitCounters reads the counters identified by the key for the windows starting at windowStarts.
+	// The output's elements order follows the order of windowStarts, zero is returned for missing counters.
+	ReadRateLimitCounters(ctx context.Context, key string, windowStarts ...time.Time) ([]uint32, error)
+}
+
+// RateLimiter counts events using the sliding window counters.
+type RateLimiter interface {
+	// Increment registers the event identified by the key within the window.
+	Increment(ctx context.Context, key string, window time.Duration) error
+
+	// IncrementIfBelow registers the event identified by the key within the window given the estimated
+	// number of events is below the limit. The check and the registration are atomic.
+	// It reports whether the event was registered.
+	IncrementIfBelow(ctx context.Context, key string, window time.Duration, limit uint32) (bool, error)
+
+	// Count estimates the number of events identified by the key within the sliding window
+	// which ends at the current moment. It also returns the moment when the estimate drops below the limit,
+	// i.e. when the next event is allowed, it's the current moment given the limit is not reached.
+	Count(ctx context.Context, key string, window time.Duration, limit uint32) (
+		cnt uint32, reset time.Time, err error,
+	)
+}
+
+// NewRateLimiter initialises the sliding window RateLimiter.
+func NewRateLimiter(repository RepositoryRateLimiter) (RateLimiter, error) {
+	if repository == nil {
+		return nil, errors.New("rate limiter repository is required")
+	}
+	return &slidingWindowRateLimiter{
+		repository: repository,
+		now:        func() time.Time { return time.Now().UTC() },
+	}, nil
+}
+
+// slidingWindowRateLimiter estimates the number of events within the sliding window as
+// the number of events in the current fixed window, plus the number of events in the previous
+// fixed window weighted by the share of the sliding window overlapping with it.
+type slidingWindowRateLimiter struct {
+	repository RepositoryRateLimiter
+	now        func() time.Time
+}
+
+func counterKey(key string, window time.Duration) string {
+	return key + "/" + strconv.FormatInt(int64(window.Seconds()), 10)
+}
+
+func (l *slidingWindowRateLimiter) Increment(ctx context.Context, key string, window time.Duration) error {
+	if window <= 0 {
+		return errors.New("window must be positive")
+	}
+	windowCurrent := l.now().Truncate(window)
+	return l.repository.IncrementRateLimitCounter(
+		ctx, counterKey(key, window), windowCurrent, counterExpiresAt(windowCurrent, window),
+	)
+}
+
+func (l *slidingWindowRateLimiter) IncrementIfBelow(
+	ctx context.Context, key string, window time.Duration, limit uint32,
+) (bool, error) {
+	if window <= 0 {
+		return false, errors.New("window must be positive")
+	}
+
+	now := l.now()
+	windowCurrent := now.Truncate(window)
+	windowPrevious := windowCurrent.Add(-window)
+
+	// the previous window's counter is no longer incremented, hence it's read separately
+	v, err := l.repository.ReadRateLimitCounters(ctx, counterKey(key, window), windowPrevious)
+	if err != nil {
+		return false, err
+	}
+	if len(v) != 1 {
+		return false, errors.New("unexpected number of counters read")
+	}
+
+	weightPrevious := 1 - float64(now.Sub(windowCurrent))/float64(window)
+	cntPrevious := uint32(float64(v[0]) * weightPrevious)
+	if cntPrevious >= limit {
+		return false, nil
+	}
+
+	return l.repository.IncrementRateLimitCounterIfBelow(
+		ctx, counterKey(key, window), windowCurrent, counterExpiresAt(windowCurrent, window), limit-cntPrevious,
+	)
+}
+
+// counterExpiresAt returns the moment when the counter of the window is no longer read:
+// the counter is read as the current window's counter, and then as the previous window's counter.
+func counterExpiresAt(windowStart time.Time, window time.Duration) time.Time {
+	return windowStart.Add(2 * window)
+}
+
+func (l *slidingWindowRateLimiter) Count(ctx context.Context, key string, window time.Duration, limit uint32) (
+	uint32, time.Time, error,
+) {
+	if window <= 0 {
+		return 0, time.Time{}, errors.New("window must be positive")
+	}
+
+	now := l.now()
+	windowCurrent := now.Truncate(window)
+	windowPrevious := windowCurrent.Add(-window)
+
+	v, err := l.repository.ReadRateLimitCounters(ctx, counterKey(key, window), windowCurrent, windowPrevious)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(v) != 2 {
+		return 0, time.Time{}, errors.New("unexpected number of counters read")
+	}
+
+	weightPrevious := 1 - float64(now.Sub(windowCurrent))/float64(window)
+	cnt := v[0] + uint32(float64(v[1])*weightPrevious)
+
+	return cnt, slidingWindowReset(now, windowCurrent, window, v[0], v[1], cnt, limit), nil
+}
+
+// slidingWindowReset returns the moment when the estimate drops below the limit.
+// The weight of the previous window's events decreases linearly until the end of the current window,
+// after that the current window's events become the previous window's events.
+func slidingWindowReset(
+	now, windowCurrent time.Time, window time.Duration, current, previous, cnt, limit uint32,
+) time.Time {
+	switch {
+	case cnt < limit:
+		return now
+	case limit == 0:
+		return counterExpiresAt(windowCurrent, window)
+	case current < limit:
+		// current + previous * weight < limit within the current window
+		share := 1 - float64(limit-current)/float64(previous)
+		return windowCurrent.Add(time.Duration(share * float64(window)))
+	default:
+		// current * weight < limit within the next window
+		share := 1 - float64(limit)/float64(current)
+		return windowCurrent.Add(window + time.Duration(share*float64(window)))
+	}
+}
+
+// NewRepositoryRateLimiterInMemory initialises the in-memory storage of the rate limiter's counters.
+// Note that the counters are not shared across the application's instances.
+func NewRepositoryRateLimiterInMemory() RepositoryRateLimiter {
+	return &repositoryRateLimiterInMemory{
+		counters:  map[string]map[time.Time]uint32{},
+		expiresAt: map[string]time.Time{},
+		now:       time.Now,
+	}
+}
+
+type repositoryRateLimiterInMemory struct {
+	mu       sync.Mutex
+	counters map[string]map[time.Time]uint32
+	// expiresAt defines the moment when all counters of the key expire.
+	expiresAt map[string]time.Time
+	sweptAt   time.Time
+	now       func() time.Time
+}
+
+const (
+	// inMemoryCountersRetention defines the number of windows per key kept in memory.
+	inMemoryCountersRetention = 2
+	// inMemorySweepInterval defines how often the expired keys are deleted.
+	inMemorySweepInterval = time.Minute
+)
+
+func (r *repositoryRateLimiterInMemory) IncrementRateLimitCounter(
+	_ context.Context, key string, windowStart, expiresAt time.Time,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.increment(key, windowStart, expiresAt)
+
+	return nil
+}
+
+func (r *repositoryRateLimiterInMemory) IncrementRateLimitCounterIfBelow(
+	_ context.Context, key string, windowStart, expiresAt time.Time, max uint32,
+) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.counters[key][windowStart] >= max {
+		return false, nil
+	}
+
+	r.increment(key, windowStart, expiresAt)
+
+	return true, nil
+}
+
+func (r *repositoryRateLimiterInMemory) increment(key string, windowStart, expiresAt time.Time) {
+	if _, ok := r.counters[key]; !ok {
+		r.counters[key] = map[time.Time]uint32{}
+	}
+	r.counters[key][windowStart]++
+	if expiresAt.After(r.expiresAt[key]) {
+		r.expiresAt[key] = expiresAt
+	}
+
+	if len(r.counters[key]) > inMemoryCountersRetention {
+		r.dropOldestWindow(key)
+	}
+
+	r.sweepExpired()
+}
+
+// sweepExpired deletes the counters of the keys which are no longer incremented.
+func (r *repositoryRateLimiterInMemory) sweepExpired() {
+	now := r.now()
+	if now.Sub(r.sweptAt) < inMemorySweepInterval {
+		return
+	}
+	r.sweptAt = now
+	for key, ts := range r.expiresAt {
+		if ts.Before(now) {
+			delete(r.counters, key)
+			delete(r.expiresAt, key)
+		}
+	}
+}
+
+func (r *repositoryRateLimiterInMemory) dropOldestWindow(key string) {
+	var oldest time.Time
+	for ts := range r.counters[key] {
+		if oldest.IsZero() || ts.Before(oldest) {
+			oldest = ts
+		}
+	}
+	delete(r.counters[key], oldest)
+}
+
+func (r *repositoryRateLimiterInMemory) ReadRateLimitCounters(
+	_ context.Context, key string, windowStarts ...time.Time,
+) ([]uint32, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o := make([]uint32, len(windowStarts))
+	for i, ts := range windowStarts {
+		o[i] = r.counters[key][ts]
+	}
+	return o, nil
+}
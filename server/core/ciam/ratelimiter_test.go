@@ -0,0 +1,441 @@
+package ciam
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	t.Run(
+		"shall fail given no repository", func(t *testing.T) {
+			if _, err := NewRateLimiter(nil); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+
+	t.Run(
+		"shall init the rate limiter", func(t *testing.T) {
+			if _, err := NewRateLimiter(NewRepositoryRateLimiterInMemory()); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		},
+	)
+}
+
+func Test_slidingWindowRateLimiter_Count(t *testing.T) {
+	const (
+		key    = "foo"
+		window = time.Minute
+	)
+
+	windowCurrent := time.Date(2023, 1, 1, 10, 1, 0, 0, time.UTC)
+	windowPrevious := windowCurrent.Add(-window)
+
+	type fields struct {
+		repository RepositoryRateLimiter
+		now        func() time.Time
+	}
+
+	tests := []struct {
+		name      string
+		fields    fields
+		limit     uint32
+		want      uint32
+		wantReset time.Time
+		wantErr   bool
+	}{
+		{
+			name: "shall count events in the current window only given no events in the previous window",
+			fields: fields{
+				repository: &mockRepositoryRateLimiter{
+					Counters: map[string]map[time.Time]uint32{
+						counterKey(key, window): {windowCurrent: 3},
+					},
+				},
+				now: func() time.Time { return windowCurrent.Add(45 * time.Second) },
+			},
+			limit:     5,
+			want:      3,
+			wantReset: windowCurrent.Add(45 * time.Second),
+		},
+		{
+			name: "shall weight the events of the previous window by the overlap with the sliding window",
+			fields: fields{
+				repository: &mockRepositoryRateLimiter{
+					Counters: map[string]map[time.Time]uint32{
+						counterKey(key, window): {windowCurrent: 1, windowPrevious: 4},
+					},
+				},
+				now: func() time.Time { return windowCurrent.Add(15 * time.Second) },
+			},
+			limit:     5,
+			want:      4,
+			wantReset: windowCurrent.Add(15 * time.Second),
+		},
+		{
+			name: "shall reset once the weight of the previous window's events decreases enough",
+			fields: fields{
+				repository: &mockRepositoryRateLimiter{
+					Counters: map[string]map[time.Time]uint32{
+						counterKey(key, window): {windowCurrent: 1, windowPrevious: 4},
+					},
+				},
+				now: func() time.Time { return windowCurrent.Add(15 * time.Second) },
+			},
+			// 1 + 4 * weight < 3 given weight < 0.5
+			limit:     3,
+			want:      4,
+			wantReset: windowCurrent.Add(30 * time.Second),
+		},
+		{
+			name: "shall reset within the next window given the limit is reached in the current window",
+			fields: fields{
+				repository: &mockRepositoryRateLimiter{
+					Counters: map[string]map[time.Time]uint32{
+						counterKey(key, window): {windowCurrent: 6},
+					},
+				},
+				now: func() time.Time { return windowCurrent.Add(45 * time.Second) },
+			},
+			// 6 * weight < 3 given weight < 0.5
+			limit:     3,
+			want:      6,
+			wantReset: windowCurrent.Add(window + 30*time.Second),
+		},
+		{
+			name: "shall reset at the beginning of the next window given the limit is reached exactly",
+			fields: fields{
+				repository: &mockRepositoryRateLimiter{
+					Counters: map[string]map[time.Time]uint32{
+						counterKey(key, window): {windowCurrent: 3},
+					},
+				},
+				now: func() time.Time { return windowCurrent.Add(45 * time.Second) },
+			},
+			limit:     3,
+			want:      3,
+			wantReset: windowCurrent.Add(window),
+		},
+		{
+			name: "shall reset when the events expire given zero limit",
+			fields: fields{
+				repository: &mockRepositoryRateLimiter{
+					Counters: map[string]map[time.Time]uint32{
+						counterKey(key, window): {windowCurrent: 1},
+					},
+				},
+				now: func() time.Time { return windowCurrent },
+			},
+			want:      1,
+			wantReset: windowCurrent.Add(2 * window),
+		},
+		{
+			name: "shall return zero given no events",
+			fields: fields{
+				repository: &mockRepositoryRateLimiter{},
+				now:        func() time.Time { return windowCurrent },
+			},
+			limit:     1,
+			want:      0,
+			wantReset: windowCurrent,
+		},
+		{
+			name: "shall return the repository error",
+			fields: fields{
+				repository: &mockRepositoryRateLimiter{Err: errors.New("foo")},
+				now:        func() time.Time { return windowCurrent },
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				l := &slidingWindowRateLimiter{
+					repository: tt.fields.repository,
+					now:        tt.fields.now,
+				}
+				got, gotReset, err := l.Count(context.TODO(), key, window, tt.limit)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Count() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if got != tt.want {
+					t.Errorf("Count() got = %v, want %v", got, tt.want)
+				}
+				if !gotReset.Equal(tt.wantReset) {
+					t.Errorf("Count() gotReset = %v, want %v", gotReset, tt.wantReset)
+				}
+			},
+		)
+	}
+}
+
+func Test_slidingWindowRateLimiter_Increment(t *testing.T) {
+	t.Run(
+		"shall increment the counter of the current window", func(t *testing.T) {
+			// GIVEN
+			now := time.Date(2023, 1, 1, 10, 1, 30, 0, time.UTC)
+			repository := &mockRepositoryRateLimiter{}
+			l := &slidingWindowRateLimiter{
+				repository: repository,
+				now:        func() time.Time { return now },
+			}
+
+			// WHEN
+			for i := 0; i < 2; i++ {
+				if err := l.Increment(context.TODO(), "foo", time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// THEN
+			want := map[string]map[time.Time]uint32{
+				"foo/60": {time.Date(2023, 1, 1, 10, 1, 0, 0, time.UTC): 2},
+			}
+			wantExpiresAt := time.Date(2023, 1, 1, 10, 3, 0, 0, time.UTC)
+			if !repository.ExpiresAt.Equal(wantExpiresAt) {
+				t.Errorf("unexpected expiration. want: %v, got: %v", wantExpiresAt, repository.ExpiresAt)
+			}
+			if !reflect.DeepEqual(repository.Counters, want) {
+				t.Errorf("unexpected counters. want: %v, got: %v", want, repository.Counters)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail given non-positive window", func(t *testing.T) {
+			l, _ := NewRateLimiter(&mockRepositoryRateLimiter{})
+			if err := l.Increment(context.TODO(), "foo", 0); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+}
+
+func Test_slidingWindowRateLimiter_IncrementIfBelow(t *testing.T) {
+	const (
+		key    = "foo"
+		window = time.Minute
+	)
+
+	windowCurrent := time.Date(2023, 1, 1, 10, 1, 0, 0, time.UTC)
+	windowPrevious := windowCurrent.Add(-window)
+
+	tests := []struct {
+		name       string
+		repository *mockRepositoryRateLimiter
+		limit      uint32
+		want       bool
+		wantCnt    uint32
+		wantErr    bool
+	}{
+		{
+			name:       "shall register the event given the limit is not reached",
+			repository: &mockRepositoryRateLimiter{},
+			limit:      1,
+			want:       true,
+			wantCnt:    1,
+		},
+		{
+			name: "shall not register the event given the limit is reached in the current window",
+			repository: &mockRepositoryRateLimiter{
+				Counters: map[string]map[time.Time]uint32{
+					counterKey(key, window): {windowCurrent: 1, windowPrevious: 4},
+				},
+			},
+			// 1 + 4 * 0.5 = 3
+			limit:   3,
+			want:    false,
+			wantCnt: 1,
+		},
+		{
+			name: "shall not register the event given the limit is reached in the previous window",
+			repository: &mockRepositoryRateLimiter{
+				Counters: map[string]map[time.Time]uint32{
+					counterKey(key, window): {windowPrevious: 4},
+				},
+			},
+			limit:   2,
+			want:    false,
+			wantCnt: 0,
+		},
+		{
+			name:       "shall return the repository error",
+			repository: &mockRepositoryRateLimiter{Err: errors.New("foo")},
+			limit:      1,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				l := &slidingWindowRateLimiter{
+					repository: tt.repository,
+					now:        func() time.Time { return windowCurrent.Add(30 * time.Second) },
+				}
+				got, err := l.IncrementIfBelow(context.TODO(), key, window, tt.limit)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("IncrementIfBelow() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if got != tt.want {
+					t.Errorf("IncrementIfBelow() got = %v, want %v", got, tt.want)
+				}
+				if gotCnt := tt.repository.Counters[counterKey(key, window)][windowCurrent]; gotCnt != tt.wantCnt {
+					t.Errorf("IncrementIfBelow() counter = %v, want %v", gotCnt, tt.wantCnt)
+				}
+			},
+		)
+	}
+
+	t.Run(
+		"shall not exceed the limit given concurrent events", func(t *testing.T) {
+			// GIVEN
+			const limit = 5
+			l, _ := NewRateLimiter(NewRepositoryRateLimiterInMemory())
+
+			// WHEN
+			var (
+				wg         sync.WaitGroup
+				registered uint32
+			)
+			for i := 0; i < 4*limit; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if ok, err := l.IncrementIfBelow(context.TODO(), key, time.Hour, limit); err == nil && ok {
+						atomic.AddUint32(&registered, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// THEN
+			if registered != limit {
+				t.Errorf("unexpected number of registered events. want: %d, got: %d", limit, registered)
+			}
+		},
+	)
+}
+
+func Test_repositoryRateLimiterInMemory(t *testing.T) {
+	t.Run(
+		"shall delete the expired counters", func(t *testing.T) {
+			// GIVEN
+			ts := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+			now := ts
+			r := &repositoryRateLimiterInMemory{
+				counters:  map[string]map[time.Time]uint32{},
+				expiresAt: map[string]time.Time{},
+				now:       func() time.Time { return now },
+			}
+			if err := r.IncrementRateLimitCounter(
+				context.TODO(), "foo", ts, counterExpiresAt(ts, time.Minute),
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			now = ts.Add(3 * time.Minute)
+			if err := r.IncrementRateLimitCounter(
+				context.TODO(), "bar", now, counterExpiresAt(now, time.Minute),
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			// THEN
+			if _, ok := r.counters["foo"]; ok {
+				t.Error("the expired counters shall be deleted")
+			}
+			if _, ok := r.counters["bar"]; !ok {
+				t.Error("the active counters shall be kept")
+			}
+		},
+	)
+
+	t.Run(
+		"shall keep the counters of the latest windows only", func(t *testing.T) {
+			// GIVEN
+			r := NewRepositoryRateLimiterInMemory()
+			ts := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+			// WHEN
+			for i := 0; i < 3; i++ {
+				windowStart := ts.Add(time.Duration(i) * time.Minute)
+				if err := r.IncrementRateLimitCounter(
+					context.TODO(), "foo", windowStart, counterExpiresAt(windowStart, time.Minute),
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// THEN
+			got, err := r.ReadRateLimitCounters(
+				context.TODO(), "foo", ts, ts.Add(time.Minute), ts.Add(2*time.Minute),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []uint32{0, 1, 1}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected counters. want: %v, got: %v", want, got)
+			}
+		},
+	)
+}
+
+type mockRepositoryRateLimiter struct {
+	Counters map[string]map[time.Time]uint32
+	// ExpiresAt defines the expiration of the latest incremented counter.
+	ExpiresAt time.Time
+	Err       error
+}
+
+func (m *mockRepositoryRateLimiter) IncrementRateLimitCounter(
+	_ context.Context, key string, windowStart, expiresAt time.Time,
+) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.ExpiresAt = expiresAt
+	if m.Counters == nil {
+		m.Counters = map[string]map[time.Time]uint32{}
+	}
+	if _, ok := m.Counters[key]; !ok {
+		m.Counters[key] = map[time.Time]uint32{}
+	}
+	m.Counters[key][windowStart]++
+	return nil
+}
+
+func (m *mockRepositoryRateLimiter) IncrementRateLimitCounterIfBelow(
+	ctx context.Context, key string, windowStart, expiresAt time.Time, max uint32,
+) (bool, error) {
+	if m.Err != nil {
+		return false, m.Err
+	}
+	if m.Counters[key][windowStart] >= max {
+		return false, nil
+	}
+	return true, m.IncrementRateLimitCounter(ctx, key, windowStart, expiresAt)
+}
+
+func (m *mockRepositoryRateLimiter) ReadRateLimitCounters(
+	_ context.Context, key string, windowStarts ...time.Time,
+) ([]uint32, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	o := make([]uint32, len(windowStarts))
+	for i, ts := range windowStarts {
+		o[i] = m.Counters[key][ts]
+	}
+	return o, nil
+}
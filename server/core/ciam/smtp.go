@@ -1,116 +1,155 @@
 package ciam
 
 import (
-	"bytes"
-	_ "embed"
-	"html/template"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"strings"
+	"time"
 )
 
-type SMTPClient interface {
-	SendSignInEmail(recipient, authSecret string) error
+const (
+	// SMTPTLSModeStartTLS defines the connection upgraded to TLS using STARTTLS, usually on port 587.
+	SMTPTLSModeStartTLS = "starttls"
+	// SMTPTLSModeImplicit defines the connection established over TLS, usually on port 465.
+	SMTPTLSModeImplicit = "tls"
+	// SMTPTLSModeNone defines plain text connection, it's meant to be used for local development only.
+	SMTPTLSModeNone = "none"
+
+	defaultSMTPTimeout = 10 * time.Second
+)
+
+// SMTPConfig defines the configuration of the SMTP Mailer.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Sender   string
+
+	// TLSMode defines how to secure the connection: SMTPTLSModeStartTLS, SMTPTLSModeImplicit, or SMTPTLSModeNone.
+	// Defaults to SMTPTLSModeImplicit for port 465, and to SMTPTLSModeStartTLS otherwise.
+	TLSMode string
+
+	// Timeout defines the timeout to establish connection. Defaults to 10 sec.
+	Timeout time.Duration
 }
 
-func NewSMTPClient(user, password, host, port, senderEmail string) SMTPClient {
-	return &smtClient{
-		auth:   smtp.PlainAuth("", user, password, host),
-		addr:   host + ":" + port,
-		sender: senderEmail,
+// NewSMTPMailer initialises the Mailer to deliver emails using the SMTP server.
+func NewSMTPMailer(cfg SMTPConfig) (Mailer, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("smtp host must be provided")
+	}
+	if cfg.Port == "" {
+		return nil, errors.New("smtp port must be provided")
+	}
+	if _, err := mail.ParseAddress(cfg.Sender); err != nil {
+		return nil, errors.New("sender is not valid: " + err.Error())
+	}
+
+	switch cfg.TLSMode = strings.ToLower(cfg.TLSMode); cfg.TLSMode {
+	case SMTPTLSModeStartTLS, SMTPTLSModeImplicit, SMTPTLSModeNone:
+	case "":
+		cfg.TLSMode = SMTPTLSModeStartTLS
+		if cfg.Port == "465" {
+			cfg.TLSMode = SMTPTLSModeImplicit
+		}
+	default:
+		return nil, errors.New("smtp tls mode " + cfg.TLSMode + " is not supported")
 	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultSMTPTimeout
+	}
+
+	return &smtpMailer{cfg: cfg, tlsConfig: &tls.Config{ServerName: cfg.Host, MinVersion: tls.VersionTLS12}}, nil
 }
 
-type smtClient struct {
-	auth   smtp.Auth
-	addr   string
-	sender string
+type smtpMailer struct {
+	cfg       SMTPConfig
+	tlsConfig *tls.Config
 }
 
-func (s smtClient) SendSignInEmail(recipient, authSecret string) error {
-	message, err := generateMessage(recipient, authSecret)
+func (s smtpMailer) Send(msg Message) error {
+	message, err := renderMessage(s.cfg.Sender, msg, time.Now().UTC())
 	if err != nil {
 		return err
 	}
-	return smtp.SendMail(s.addr, s.auth, s.sender, []string{recipient}, message)
-}
 
-//go:embed email-signin.html.tmpl
-var emailTemplate string
+	client, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
 
-func generateMessage(recipient, authSecret string) ([]byte, error) {
-	const (
-		mimeHeaders   = "Content-Transfer-Encoding: quoted-printable\nContent-Disposition: inline\n"
-		mimeHTML      = "Content-Type: text/html; charset=\"UTF-8\";\n"
-		mimePlainText = "Content-Type: text/plain; charset=\"UTF-8\";\n"
-		mimeBoundary  = "00"
-	)
+	if s.cfg.User != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.cfg.User, s.cfg.Password, s.cfg.Host)); err != nil {
+			return err
+		}
+	}
 
-	var o bytes.Buffer
-	// headers
-	// recipient
-	o.WriteString("To: ")
-	o.WriteString(recipient)
-	o.WriteString("\n")
-
-	// subject
-	o.WriteString("Subject: ")
-	o.WriteString("diagramastext.dev authentication code: ")
-	o.WriteString(authSecret)
-	o.WriteString("\n")
-
-	// multipart-mime
-	o.WriteString("Content-Type: multipart/alternative; boundary=")
-	o.WriteString("\"")
-	o.WriteString(mimeBoundary)
-	o.WriteString("\"\n\n")
-
-	// plain text
-	o.WriteString("--")
-	o.WriteString(mimeBoundary)
-	o.WriteString("\n")
-	o.WriteString(mimePlainText)
-	o.WriteString(mimeHeaders)
-	o.WriteString("\n")
-	o.WriteString("Complete authentication: copy the code ")
-	o.WriteString(authSecret)
-	o.WriteString(
-		` and paste it in your browser with https://diagramastext.dev opened. 
-Please ignore the email if you feel that it was received by mistake.`,
-	)
-	o.WriteString("\n\n")
-
-	// html text
-	o.WriteString("--")
-	o.WriteString(mimeBoundary)
-	o.WriteString("\n")
-	o.WriteString(mimeHTML)
-	o.WriteString(mimeHeaders)
-	o.WriteString("\n")
-
-	if err := template.Must(template.New("email").Parse(emailTemplate)).Execute(
-		&o, authSecret,
-	); err != nil {
-		return nil, err
+	from, _ := mail.ParseAddress(s.cfg.Sender)
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(msg.Recipient)
+	if err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
 	}
 
-	o.WriteString("\n")
-	o.WriteString("--")
-	o.WriteString(mimeBoundary)
-	o.WriteString("--")
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
 
-	return o.Bytes(), nil
+	return client.Quit()
 }
 
-type MockSMTPClient struct {
-	Recipient string
-	Secret    string
-	Err       error
-}
+func (s smtpMailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.cfg.Host, s.cfg.Port)
+	dialer := &net.Dialer{Timeout: s.cfg.Timeout}
 
-func (m *MockSMTPClient) SendSignInEmail(recipient, authSecret string) error {
-	if m.Err != nil {
-		return m.Err
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.cfg.TLSMode == SMTPTLSModeImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
 	}
-	m.Recipient = recipient
-	m.Secret = authSecret
-	return nil
+
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if s.cfg.TLSMode == SMTPTLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			_ = client.Close()
+			return nil, errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(s.tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
 }
@@ -1,96 +1,300 @@
 package ciam
 
 import (
-	"net/smtp"
-	"reflect"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
 	"testing"
 )
 
-func TestNewSMTClient(t *testing.T) {
-	// GIVEN
-	const (
-		user        = "foo"
-		password    = "bar"
-		host        = "localhost"
-		port        = "1025"
-		senderEmail = "baz@qux.com"
-	)
+// fakeSMTPServer defines minimal SMTP server to test the client's dialog.
+type fakeSMTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	rootCAs   *x509.CertPool
+	implicit  bool
+	startTLS  bool
 
-	// WHEN
-	got := NewSMTPClient(user, password, host, port, senderEmail)
-	var c *smtClient
+	mu       sync.Mutex
+	auth     string
+	from     string
+	rcpt     []string
+	data     string
+	usedTLS  bool
+	commands []string
+}
 
-	// THEN
-	t.Parallel()
-	t.Run(
-		"shall initiate the client of *smtClient/SMTPClient type", func(t *testing.T) {
-			var ok bool
-			if c, ok = got.(*smtClient); !ok {
-				t.Errorf("unexpected client")
+func newFakeSMTPServer(t *testing.T, implicit, startTLS bool) *fakeSMTPServer {
+	t.Helper()
+
+	// the httptest TLS server is used to borrow the self-signed certificate issued for 127.0.0.1
+	httpsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(httpsServer.Close)
+
+	s := &fakeSMTPServer{
+		tlsConfig: &tls.Config{Certificates: httpsServer.TLS.Certificates, MinVersion: tls.VersionTLS12},
+		rootCAs:   httpsServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+		implicit:  implicit,
+		startTLS:  startTLS,
+	}
+
+	var err error
+	if implicit {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.listener.Close() })
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
 			}
-		},
-	)
-	t.Run(
-		"shall initiate struct with the server address", func(t *testing.T) {
-			if c.addr != host+":"+port {
-				t.Errorf("unexpected addr: got = %s, want = %s", c.addr, host+":"+port)
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTPServer) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	s.mu.Lock()
+	s.usedTLS = s.implicit
+	s.mu.Unlock()
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		s.mu.Unlock()
+
+		switch cmd {
+		case "EHLO", "HELO":
+			ext := []string{"250-localhost", "250-AUTH PLAIN"}
+			if s.startTLS {
+				ext = append(ext, "250-STARTTLS")
 			}
-		},
-	)
+			for _, l := range append(ext, "250 8BITMIME") {
+				_ = tp.PrintfLine(l)
+			}
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			s.mu.Lock()
+			s.usedTLS = true
+			s.mu.Unlock()
+		case "AUTH":
+			v, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			s.mu.Lock()
+			s.auth = string(v)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = line
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			s.mu.Lock()
+			s.rcpt = append(s.rcpt, line)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			v, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(v)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestNewSMTPMailer(t *testing.T) {
 	t.Run(
-		"shall initiate struct with the sender email", func(t *testing.T) {
-			if c.sender != senderEmail {
-				t.Errorf("unexpected sender email: got = %s, want = %s", c.sender, senderEmail)
+		"shall default the tls mode using the port", func(t *testing.T) {
+			for port, want := range map[string]string{
+				"465": SMTPTLSModeImplicit,
+				"587": SMTPTLSModeStartTLS,
+				"25":  SMTPTLSModeStartTLS,
+			} {
+				got, err := NewSMTPMailer(
+					SMTPConfig{Host: "smtp.foo.bar", Port: port, Sender: "support@diagramastext.dev"},
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.(*smtpMailer).cfg.TLSMode != want {
+					t.Errorf("unexpected tls mode for port %s: %s", port, got.(*smtpMailer).cfg.TLSMode)
+				}
+				if got.(*smtpMailer).cfg.Timeout != defaultSMTPTimeout {
+					t.Error("unexpected default timeout")
+				}
 			}
 		},
 	)
+
 	t.Run(
-		"shall initiate struct with plain authentication", func(t *testing.T) {
-			if !reflect.DeepEqual(c.auth, smtp.PlainAuth("", user, password, host)) {
-				t.Errorf("unexpected auth")
+		"unhappy path", func(t *testing.T) {
+			for name, cfg := range map[string]SMTPConfig{
+				"shall fail for missing host": {Port: "587", Sender: "support@diagramastext.dev"},
+				"shall fail for missing port": {Host: "smtp.foo.bar", Sender: "support@diagramastext.dev"},
+				"shall fail for invalid sender": {
+					Host: "smtp.foo.bar", Port: "587", Sender: "foo",
+				},
+				"shall fail for unknown tls mode": {
+					Host: "smtp.foo.bar", Port: "587", Sender: "support@diagramastext.dev", TLSMode: "ssl3",
+				},
+			} {
+				t.Run(
+					name, func(t *testing.T) {
+						if _, err := NewSMTPMailer(cfg); err == nil {
+							t.Error("error expected")
+						}
+					},
+				)
 			}
 		},
 	)
 }
 
-func Test_generateMessage(t *testing.T) {
+func Test_smtpMailer_Send(t *testing.T) {
+	newMailer := func(t *testing.T, srv *fakeSMTPServer, tlsMode string) Mailer {
+		t.Helper()
+		m, err := NewSMTPMailer(
+			SMTPConfig{
+				Host:     "127.0.0.1",
+				Port:     srv.port(),
+				User:     "foo",
+				Password: "bar",
+				Sender:   "diagramastext.dev <support@diagramastext.dev>",
+				TLSMode:  tlsMode,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.(*smtpMailer).tlsConfig.RootCAs = srv.rootCAs
+		return m
+	}
+
+	for _, tt := range []struct {
+		name     string
+		tlsMode  string
+		implicit bool
+		startTLS bool
+		wantTLS  bool
+	}{
+		{
+			name:     "shall deliver the email upgrading connection with STARTTLS",
+			tlsMode:  SMTPTLSModeStartTLS,
+			startTLS: true,
+			wantTLS:  true,
+		},
+		{
+			name:     "shall deliver the email over implicit TLS connection",
+			tlsMode:  SMTPTLSModeImplicit,
+			implicit: true,
+			wantTLS:  true,
+		},
+		{
+			name:    "shall deliver the email over plain text connection",
+			tlsMode: SMTPTLSModeNone,
+		},
+	} {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// GIVEN
+				srv := newFakeSMTPServer(t, tt.implicit, tt.startTLS)
+				mailer := newMailer(t, srv, tt.tlsMode)
+
+				// WHEN
+				err := mailer.Send(NewSignInCodeMessage("foo@bar.baz", "qux"))
+
+				// THEN
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				srv.mu.Lock()
+				defer srv.mu.Unlock()
+				if srv.usedTLS != tt.wantTLS {
+					t.Errorf("unexpected connection security. want tls: %v", tt.wantTLS)
+				}
+				if srv.auth != "\x00foo\x00bar" {
+					t.Errorf("unexpected auth: %q", srv.auth)
+				}
+				if srv.from != "MAIL FROM:<support@diagramastext.dev> BODY=8BITMIME" {
+					t.Errorf("unexpected sender: %s", srv.from)
+				}
+				if len(srv.rcpt) != 1 || srv.rcpt[0] != "RCPT TO:<foo@bar.baz>" {
+					t.Errorf("unexpected recipients: %v", srv.rcpt)
+				}
+				if !strings.Contains(srv.data, "Subject: diagramastext.dev authentication code: qux") {
+					t.Errorf("unexpected message: %s", srv.data)
+				}
+			},
+		)
+	}
+
 	t.Run(
-		"happy path", func(t *testing.T) {
+		"shall fail if the server does not support STARTTLS", func(t *testing.T) {
 			// GIVEN
-			const (
-				recipient  = "foo@bar.baz"
-				authSecret = "qux"
-			)
-			want := []byte(`To: foo@bar.baz
-Subject: diagramastext.dev authentication code: qux
-Content-Type: multipart/alternative; boundary="00"
-
---00
-Content-Type: text/plain; charset="UTF-8";
-Content-Transfer-Encoding: quoted-printable
-Content-Disposition: inline
-
-Complete authentication: copy the code qux and paste it in your browser with https://diagramastext.dev opened. 
-Please ignore the email if you feel that it was received by mistake.
-
---00
-Content-Type: text/html; charset="UTF-8";
-Content-Transfer-Encoding: quoted-printable
-Content-Disposition: inline
-
-<!doctypehtml><html lang=en><title>diagramastext.dev authentication code: qux</title><meta content="width=device-width,initial-scale=1" name=viewport><style>*,:after,:before{box-sizing:border-box;border:0 solid #e5e7eb}html{line-height:1.5;-webkit-text-size-adjust:100%;tab-size:4;font-family:ui-sans-serif,system-ui,-apple-system,BlinkMacSystemFont,Segoe UI,Roboto,Helvetica Neue,Arial,sans-serif}body{display:flex;flex-direction:column;align-items:center;background-color:#e8e5e5;margin:0}main{width:600px}@media only screen and (max-width:600px){main{width:100%}}h1{font-size:30px;font-weight:700}a{color:#000}a:link{text-decoration:underline}a:active,a:hover,a:visited{text-decoration:none}.box{border-radius:1.2rem;padding:.8rem;border:#ccc9c9 solid 5px;box-shadow:0 0 5px 5px #ccc9c9;background:#263950;text-align:center;font-weight:700;font-size:25px;color:#fff}footer{margin-top:40px;align-content:center;text-align:center}p{font-size:14px}</style><main><h1>Complete authentication</h1><p>Copy the code below and paste it in your browser with <a href=https://diagramastext.dev target=_blank>diagramastext.dev</a> opened.<div class=box>qux</div><p>Please ignore the email if you feel that it was received by mistake.<footer><a href=https://diagramastext.dev target=_blank><svg fill=none preserveAspectRatio=true viewBox="0 0 128 93" width=80 xmlns="http://www.w3.org/2000/svg"><g filter=url(#a)><path d="M46.8 88.5 71.4 63l-12-63L128 88.5H46.8Z" fill=#B9CFE4 /></g><path d="M76 71.8v.4a7.3 7.3 0 0 1-14.5 0v-.4a7.3 7.3 0 0 1 14.5 0z" fill=#aaa stroke=#888 /><path d="m72 65 8.5-7.9-11-3.4L72 65zm7-26.3-5.3 17.4 1.9.6L81 39.3l-2-.6z" fill=#000 /><g filter=url(#b)><path d="M0 .6h59.5L72 63.1 46.7 88.5 0 .6z" fill=#084580 /><path d="M0 .6h59.5L72 63.1H0V.6Z" fill=#1168BD /></g><path d="M108 71.8v.4a7.3 7.3 0 0 1-14.5 0v-.4a7.3 7.3 0 0 1 14.5 0z" fill=#aaa stroke=#888 /><path d="m98 65 1.4-11.5L88.8 58l9.2 7zM86 39.4 93.7 57l1.8-.8L88 38.6l-1.8.8z" fill=#000 /><path d="M91 33.8v.4a7.3 7.3 0 0 1-14.5 0v-.4a7.3 7.3 0 0 1 14.5 0z" fill=#aaa stroke=#888 /><g filter=url(#c)><path d="m8 42.4 5.7-21.9h4.2l5.6 22h-3.3L19 36.8h-6.2l-1.3 5.5H8zm5.3-8.2h5L16.8 28a253 253 0 0 1-1-4.6 230.9 230.9 0 0 0-1 4.6l-1.5 6.3zm12.5 8.2V20.5h6.5c2 0 3.7.5 4.9 1.5s1.7 2.4 1.7 4.2a5 5 0 0 1-.6 2.6c-.4.7-1 1.3-1.8 1.7s-1.6.6-2.7.6v-.3a6 6 0 0 1 3 .6c.8.4 1.5 1 2 1.9s.7 1.8.7 3-.3 2.3-.9 3.3c-.5.9-1.3 1.6-2.3 2-1 .6-2.2.8-3.6.8h-6.9zm3.2-2.8h3.4c1.2 0 2.1-.3 2.8-.9s1-1.5 1-2.6c0-1-.3-2-1-2.7s-1.6-1-2.8-1H29v7.2zm0-9.9h3.3c1 0 1.9-.3 2.5-.9s1-1.3 1-2.3-.4-1.7-1-2.3c-.6-.6-1.5-.9-2.5-.9H29v6.4zm19.9 13a8 8 0 0 1-3.6-.7c-1-.5-1.8-1.3-2.3-2.2a7 7 0 0 1-.8-3.5v-9.7c0-1.3.2-2.4.8-3.4.5-1 1.3-1.7 2.3-2.2 1-.5 2.2-.8 3.6-.8s2.5.3 3.5.8 1.8 1.3 2.3 2.2c.6 1 .9 2.1.9 3.4h-3.3c0-1.1-.3-2-.9-2.6s-1.4-.9-2.5-.9-2 .3-2.6 1c-.6.5-.9 1.4-.9 2.5v9.7c0 1.2.3 2 1 2.7.5.6 1.4.9 2.5.9s2-.3 2.5-1c.6-.6 1-1.4 1-2.6h3.2c0 1.3-.3 2.5-.9 3.4-.5 1-1.3 1.7-2.3 2.3s-2.1.7-3.5.7z" fill=#fff /></g><defs><filter color-interpolation-filters=sRGB filterUnits=userSpaceOnUse height=96.5 id=a width=89.2 x=42.8 y=-4><feFlood flood-opacity=0 result=BackgroundImageFix /><feBlend in2=BackgroundImageFix result=shape in=SourceGraphic /><feGaussianBlur stdDeviation=2 result=effect1_foregroundBlur_10_107 /></filter><filter color-interpolation-filters=sRGB filterUnits=userSpaceOnUse height=95.9 id=b width=80.1 x=-4 y=-3.4><feFlood flood-opacity=0 result=BackgroundImageFix /><feBlend in2=BackgroundImageFix result=shape in=SourceGraphic /><feGaussianBlur stdDeviation=2 result=effect1_foregroundBlur_10_107 /></filter><filter color-interpolation-filters=sRGB filterUnits=userSpaceOnUse height=26.5 id=c width=47.5 x=8.1 y=20.2><feFlood flood-opacity=0 result=BackgroundImageFix /><feBlend in2=BackgroundImageFix result=shape in=SourceGraphic /><feColorMatrix values="0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 127 0" in=SourceAlpha result=hardAlpha /><feOffset dy=4 /><feGaussianBlur stdDeviation=2 /><feComposite in2=hardAlpha k2=-1 k3=1 operator=arithmetic /><feColorMatrix values="0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0.25 0"/><feBlend in2=shape result=effect1_innerShadow_10_107 /></filter></defs></svg></a><p style=margin-top:-5px><a href=https://diagramastext.dev target=_blank style=text-decoration:none>diagramastext.dev</a> &copy; 2023</footer></main>
---00--`)
+			srv := newFakeSMTPServer(t, false, false)
+			mailer := newMailer(t, srv, SMTPTLSModeStartTLS)
 
 			// WHEN
-			got, err := generateMessage(recipient, authSecret)
+			err := mailer.Send(NewSignInCodeMessage("foo@bar.baz", "qux"))
 
 			// THEN
-			if err != nil {
-				t.Errorf("unexpected error")
-				return
+			if err == nil || err.Error() != "smtp server does not support STARTTLS" {
+				t.Errorf("unexpected error: %v", err)
 			}
-			if !reflect.DeepEqual(got, want) {
-				t.Errorf("unexpected email template rendering result")
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			for _, cmd := range srv.commands {
+				if cmd == "AUTH" || cmd == "MAIL" {
+					t.Fatal("no credentials nor message shall be sent over plain text connection")
+				}
 			}
 		},
 	)
@@ -69,6 +69,7 @@ type accessTokenClaims struct {
 
 type refreshTokenClaims struct {
 	stdClaims
+	Jti string `json:"jti"`
 }
 
 func setExp(claims *stdClaims, d time.Duration) {
@@ -95,47 +96,82 @@ type Issuer interface {
 	NewIDToken(userID, email, fingerprint string, fnOps ...ClaimsOps) (string, error)
 	// NewAccessToken issuer access JWT.
 	NewAccessToken(user User, fnOps ...ClaimsOps) (string, error)
-	// NewRefreshToken issuer refresh JWT.
-	NewRefreshToken(userID string, fnOps ...ClaimsOps) (string, error)
+	// NewRefreshToken issuer refresh JWT identified by tokenID.
+	NewRefreshToken(userID, tokenID string, fnOps ...ClaimsOps) (string, error)
 	// ParseIDToken parses id JWT.
 	ParseIDToken(token string) (userID, email, fingerprint string, err error)
 	// ParseRefreshToken parses refresh JWT.
-	ParseRefreshToken(token string) (userID string, err error)
+	ParseRefreshToken(token string) (userID, tokenID string, err error)
 	// ParseAccessToken parses access JWT.
 	ParseAccessToken(token string) (user User, err error)
+	// JWKS returns the set of public keys to verify JWT.
+	JWKS() JWKS
 }
 
-func NewIssuer(key ed25519.PrivateKey) (Issuer, error) {
-	if key == nil {
-		return nil, errors.New("no valid ed25519 private key provided")
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// NewIssuer initialises the Issuer which signs JWT with the KeySet's signing key,
+// and verifies JWT using the key selected by the kid header.
+func NewIssuer(keySet KeySet) (Issuer, error) {
+	if err := keySet.Validate(); err != nil {
+		return nil, err
 	}
-	pubKey, ok := key.Public().(ed25519.PublicKey)
+	pubKey, ok := keySet.SigningKey.Public().(ed25519.PublicKey)
 	if !ok || len(pubKey) != ed25519.PublicKeySize {
 		return nil, errors.New("key is invalid")
 	}
 
-	h := struct {
-		Alg string `json:"alg"`
-		Typ string `json:"typ"`
-	}{
-		Alg: "EdDSA",
-		Typ: "JWT",
+	header, _ := json.Marshal(
+		jwtHeader{
+			Alg: "EdDSA",
+			Typ: "JWT",
+			Kid: keySet.SigningKeyID,
+		},
+	)
+
+	o := issuer{
+		privKey: keySet.SigningKey,
+		pubKeys: []VerificationKey{{ID: keySet.SigningKeyID, Key: pubKey}},
+		header:  encodeSegment(header),
 	}
-	header, _ := json.Marshal(h)
+	o.pubKeys = append(o.pubKeys, keySet.VerificationKeys...)
 
-	return issuer{
-		privKey: key,
-		pubKey:  pubKey,
-		header:  encodeSegment(header),
-	}, nil
+	return o, nil
 }
 
 type issuer struct {
 	privKey ed25519.PrivateKey
-	pubKey  ed25519.PublicKey
+	// pubKeys the keys to verify JWT, the first key corresponds to the signing key
+	pubKeys []VerificationKey
 	header  string
 }
 
+func (i issuer) JWKS() JWKS {
+	o := JWKS{Keys: make([]JWK, len(i.pubKeys))}
+	for j, k := range i.pubKeys {
+		o.Keys[j] = newJWK(k.ID, k.Key)
+	}
+	return o
+}
+
+// verificationKey selects the key to verify JWT by its ID.
+// The signing key is selected for the tokens issued before the key ID was introduced.
+func (i issuer) verificationKey(kid string) (ed25519.PublicKey, error) {
+	if kid == "" {
+		return i.pubKeys[0].Key, nil
+	}
+	for _, k := range i.pubKeys {
+		if k.ID == kid {
+			return k.Key, nil
+		}
+	}
+	return nil, errors.New("unknown key id")
+}
+
 func (i issuer) serializeAndSign(tkn interface{}) (string, error) {
 	payload, err := json.Marshal(tkn)
 	if err != nil {
@@ -170,9 +206,13 @@ func (i issuer) NewAccessToken(user User, fnOps ...ClaimsOps) (string, error) {
 	return i.serializeAndSign(tkn)
 }
 
-func (i issuer) NewRefreshToken(userID string, fnOps ...ClaimsOps) (string, error) {
+func (i issuer) NewRefreshToken(userID, tokenID string, fnOps ...ClaimsOps) (string, error) {
+	if tokenID == "" {
+		return "", errors.New("token id is required")
+	}
 	tkn := refreshTokenClaims{
 		stdClaims: newStdClaims(userID, defaultExpirationDurationRefresh, fnOps...),
+		Jti:       tokenID,
 	}
 	return i.serializeAndSign(tkn)
 }
@@ -188,9 +228,22 @@ func (i issuer) parseToken(token string, tkn interface{}) error {
 		return errors.New("wrong signature format")
 	}
 
+	header, err := decodeSegment(els[0])
+	if err != nil {
+		return errors.New("wrong header format")
+	}
+	var h jwtHeader
+	if err := json.Unmarshal(header, &h); err != nil {
+		return errors.New("cannot deserialize header")
+	}
+	pubKey, err := i.verificationKey(h.Kid)
+	if err != nil {
+		return err
+	}
+
 	signingStr := els[0] + "." + els[1]
 
-	if !ed25519.Verify(i.pubKey, []byte(signingStr), sig) {
+	if !ed25519.Verify(pubKey, []byte(signingStr), sig) {
 		return errors.New("wrong signature")
 	}
 
@@ -217,15 +270,19 @@ func (i issuer) ParseIDToken(token string) (userID, email, fingerprint string, e
 	return tkn.Sub, tkn.Email, tkn.Fingerprint, nil
 }
 
-func (i issuer) ParseRefreshToken(token string) (userID string, err error) {
+func (i issuer) ParseRefreshToken(token string) (userID, tokenID string, err error) {
 	var tkn refreshTokenClaims
 	if err := i.parseToken(token, &tkn); err != nil {
-		return "", err
+		return "", "", err
 	}
 	if err := tkn.IsValidToken(); err != nil {
-		return "", err
+		return "", "", err
+	}
+	// the tokens issued before the rotation was introduced cannot be revoked, hence they are rejected
+	if tkn.Jti == "" {
+		return "", "", errors.New("token id is missing")
 	}
-	return tkn.Sub, nil
+	return tkn.Sub, tkn.Jti, nil
 }
 
 func (i issuer) ParseAccessToken(token string) (user User, err error) {
@@ -237,6 +294,11 @@ func (i issuer) ParseAccessToken(token string) (user User, err error) {
 		return
 	}
 
+	// the tokens issued before the attempts quota was introduced are valid until they expire
+	if tkn.Quotas.RequestsAttemptsPerMinute == 0 {
+		tkn.Quotas.RequestsAttemptsPerMinute = tkn.Role.Quotas().RequestsAttemptsPerMinute
+	}
+
 	if !reflect.DeepEqual(tkn.Quotas, tkn.Role.Quotas()) {
 		err = errors.New("quotas from the token are not up to date")
 		return
@@ -2,9 +2,12 @@ package ciam
 
 import (
 	"crypto/ed25519"
+	"encoding/json"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kislerdm/diagramastext/server/core/internal/utils"
 )
@@ -15,7 +18,7 @@ func TestCircular(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	issuer, err := NewIssuer(priv)
+	issuer, err := NewIssuer(NewKeySet(priv))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -77,14 +80,50 @@ func TestCircular(t *testing.T) {
 		},
 	)
 
+	t.Run(
+		"shall parse the access token issued before the attempts quota was introduced", func(t *testing.T) {
+			quotas := userWant.Role.Quotas()
+			signer := issuer.(interface {
+				serializeAndSign(tkn interface{}) (string, error)
+			})
+			tknStr, err := signer.serializeAndSign(
+				map[string]interface{}{
+					"sub":  userWant.ID,
+					"iss":  iss,
+					"aud":  aud,
+					"iat":  time.Now().Unix(),
+					"exp":  time.Now().Add(time.Hour).Unix(),
+					"role": userWant.Role,
+					"quotas": map[string]interface{}{
+						"prompt_length_max": quotas.PromptLengthMax,
+						"rpm":               quotas.RequestsPerMinute,
+						"rpd":               quotas.RequestsPerDay,
+					},
+				},
+			)
+			if err != nil {
+				t.Fatalf("failed to generate token: %v", err)
+			}
+
+			got, err := issuer.ParseAccessToken(tknStr)
+			if err != nil {
+				t.Fatalf("failed to parse token: %v", err)
+			}
+
+			if !reflect.DeepEqual(userWant, got) {
+				t.Errorf("wront user data extracted from the token. want: %v, got: %v", userWant, got)
+			}
+		},
+	)
+
 	t.Run(
 		"shall parse generated refresh token", func(t *testing.T) {
-			tknStr, err := issuer.NewRefreshToken(userWant.ID)
+			tknStr, err := issuer.NewRefreshToken(userWant.ID, "foo")
 			if err != nil {
 				t.Fatalf("failed to generate token: %v", err)
 			}
 
-			userIDGot, err := issuer.ParseRefreshToken(tknStr)
+			userIDGot, tokenIDGot, err := issuer.ParseRefreshToken(tknStr)
 			if err != nil {
 				t.Fatalf("failed to parse generated token: %v", err)
 			}
@@ -92,6 +131,52 @@ func TestCircular(t *testing.T) {
 			if userWant.ID != userIDGot {
 				t.Errorf("wront userIDWant extracted from the token. want: %s, got: %s", userWant.ID, userIDGot)
 			}
+
+			if tokenIDGot != "foo" {
+				t.Errorf("wront tokenID extracted from the token. want: foo, got: %s", tokenIDGot)
+			}
+		},
+	)
+
+	t.Run(
+		"shall set the key ID published in JWKS to the token's header", func(t *testing.T) {
+			tknStr, err := issuer.NewAccessToken(userWant)
+			if err != nil {
+				t.Fatalf("failed to generate token: %v", err)
+			}
+
+			header, err := decodeSegment(strings.Split(tknStr, ".")[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var h jwtHeader
+			if err := json.Unmarshal(header, &h); err != nil {
+				t.Fatal(err)
+			}
+
+			jwks := issuer.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != h.Kid || h.Kid == "" {
+				t.Errorf("token's kid %s does not match JWKS %v", h.Kid, jwks)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail to parse the token signed by unknown key", func(t *testing.T) {
+			_, otherKey, _ := ed25519.GenerateKey(rand.New(rand.NewSource(1)))
+			otherIssuer, err := NewIssuer(NewKeySet(otherKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tknStr, err := otherIssuer.NewAccessToken(userWant)
+			if err != nil {
+				t.Fatalf("failed to generate token: %v", err)
+			}
+
+			if _, err := issuer.ParseAccessToken(tknStr); err == nil {
+				t.Error("error expected")
+			}
 		},
 	)
 }
@@ -2,21 +2,61 @@ package main
 
 import (
 	"crypto/ed25519"
+	"crypto/rand"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
-	"time"
+	"os"
 
 	"github.com/kislerdm/diagramastext/server/core/ciam"
 )
 
 func main() {
-	_, key, _ := ed25519.GenerateKey(rand.New(rand.NewSource(time.Now().Unix())))
+	var (
+		keySetFlag          bool
+		rotatePath          string
+		maxVerificationKeys int
+	)
+	flag.BoolVar(&keySetFlag, "keyset", false, "generate the key set document instead of a single private key")
+	flag.StringVar(
+		&rotatePath, "rotate", "", "path to the current key set document to rotate; implies -keyset",
+	)
+	flag.IntVar(
+		&maxVerificationKeys, "max-verification-keys", 2,
+		"max number of previous keys to keep for JWT verification upon rotation",
+	)
+	flag.Parse()
 
-	o, err := ciam.MarshalKey(key)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		log.Fatalf("cannot marshal generated key: %v", err)
+		log.Fatalf("cannot generate key: %v", err)
 	}
 
+	if !keySetFlag && rotatePath == "" {
+		o, err := ciam.MarshalKey(key)
+		if err != nil {
+			log.Fatalf("cannot marshal generated key: %v", err)
+		}
+		fmt.Print(string(o))
+		return
+	}
+
+	keySet := ciam.NewKeySet(key)
+	if rotatePath != "" {
+		v, err := os.ReadFile(rotatePath)
+		if err != nil {
+			log.Fatalf("cannot read the key set document: %v", err)
+		}
+		current, err := ciam.ReadKeySet(v)
+		if err != nil {
+			log.Fatalf("cannot read the key set: %v", err)
+		}
+		keySet = current.Rotate(key, maxVerificationKeys)
+	}
+
+	o, err := ciam.MarshalKeySet(keySet)
+	if err != nil {
+		log.Fatalf("cannot marshal the key set: %v", err)
+	}
 	fmt.Print(string(o))
 }
@@ -20,11 +20,14 @@ import (
 	"github.com/kislerdm/diagramastext/server/core/pkg/httpclient"
 	"github.com/kislerdm/diagramastext/server/core/pkg/openai"
 	"github.com/kislerdm/diagramastext/server/core/pkg/postgres"
+	"github.com/kislerdm/diagramastext/server/core/retention"
 )
 
 var (
 	postgresClient *postgres.Client
 	handler        http.Handler
+	purger         *retention.Purger
+	purgeInterval  time.Duration
 )
 
 func init() {
@@ -58,17 +61,22 @@ func init() {
 
 	postgresClient, err = postgres.NewPostgresClient(
 		context.Background(), postgres.Config{
-			DBHost:             cfg.RepositoryPredictionConfig.DBHost,
-			DBName:             cfg.RepositoryPredictionConfig.DBName,
-			DBUser:             cfg.RepositoryPredictionConfig.DBUser,
-			DBPassword:         cfg.RepositoryPredictionConfig.DBPassword,
-			TablePrompt:        cfg.RepositoryPredictionConfig.TablePrompt,
-			TablePrediction:    cfg.RepositoryPredictionConfig.TablePrediction,
-			TableSuccessStatus: cfg.RepositoryPredictionConfig.TableSuccessStatus,
-			TableUsers:         cfg.RepositoryPredictionConfig.TableUsers,
-			TableTokens:        cfg.RepositoryPredictionConfig.TableAPITokens,
-			TableOneTimeSecret: cfg.CIAM.TableOneTimeSecret,
-			SSLMode:            cfg.RepositoryPredictionConfig.SSLMode,
+			DBHost:                 cfg.RepositoryPredictionConfig.DBHost,
+			DBName:                 cfg.RepositoryPredictionConfig.DBName,
+			DBUser:                 cfg.RepositoryPredictionConfig.DBUser,
+			DBPassword:             cfg.RepositoryPredictionConfig.DBPassword,
+			TablePrompt:            cfg.RepositoryPredictionConfig.TablePrompt,
+			TablePrediction:        cfg.RepositoryPredictionConfig.TablePrediction,
+			TableSuccessStatus:     cfg.RepositoryPredictionConfig.TableSuccessStatus,
+			TableUsers:             cfg.RepositoryPredictionConfig.TableUsers,
+			TableTokens:            cfg.RepositoryPredictionConfig.TableAPITokens,
+			TableOneTimeSecret:     cfg.CIAM.TableOneTimeSecret,
+			TableRateLimitCounters: cfg.CIAM.TableRateLimitCounters,
+			TableRefreshTokens:     cfg.CIAM.TableRefreshTokens,
+			TableOIDCStates:        cfg.CIAM.TableOIDCStates,
+			TableAuditLog:          cfg.CIAM.TableAuditLog,
+			TableFlaggedPrompts:    cfg.Diagram.TableFlaggedPrompts,
+			SSLMode:                cfg.RepositoryPredictionConfig.SSLMode,
 		},
 	)
 	if err != nil {
@@ -82,35 +90,122 @@ func init() {
 		}
 	}
 
-	ciamSMTPClient := ciam.NewSMTPClient(
-		cfg.CIAM.SmtpUser, cfg.CIAM.SmtpPassword, cfg.CIAM.SmtpHost, cfg.CIAM.SmtpPort, cfg.CIAM.SmtpSenderEmail,
+	var ciamMailer ciam.Mailer
+	if cfg.CIAM.MailDir != "" {
+		ciamMailer, err = ciam.NewFileMailer(cfg.CIAM.MailDir, cfg.CIAM.SmtpSenderEmail)
+	} else {
+		ciamMailer, err = ciam.NewSMTPMailer(
+			ciam.SMTPConfig{
+				Host:     cfg.CIAM.SmtpHost,
+				Port:     cfg.CIAM.SmtpPort,
+				User:     cfg.CIAM.SmtpUser,
+				Password: cfg.CIAM.SmtpPassword,
+				Sender:   cfg.CIAM.SmtpSenderEmail,
+				TLSMode:  cfg.CIAM.SmtpTLSMode,
+			},
+		)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ciamRateLimiter, err := ciam.NewRateLimiter(postgresClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ciamHandler, err := ciam.HTTPHandler(
+		postgresClient, ciamMailer, cfg.CIAM.KeySet,
+		ciam.WithRateLimiter(ciamRateLimiter),
+		ciam.WithMagicLink(cfg.CIAM.MagicLinkVerifyURL, cfg.CIAM.MagicLinkRedirectURL),
+		ciam.WithOIDCProviders(postgresClient, cfg.CIAM.OIDCProviders...),
+		ciam.WithAdmin(postgresClient),
+		ciam.WithTrustedProxies(cfg.CIAM.TrustedProxies...),
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	ciamHandler, err := ciam.HTTPHandler(postgresClient, ciamSMTPClient, cfg.CIAM.PrivateKey)
+	var promptClassifier diagram.PromptClassifier
+	if cfg.Diagram.GuardClassifier {
+		promptClassifier, err = diagram.NewModelPromptClassifier(modelInferenceClient)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	promptGuard, err := diagram.NewGuard(cfg.Diagram.GuardPolicy, promptClassifier, postgresClient)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	c4DiagramHandler, err := c4container.NewC4ContainersHTTPHandler(
-		modelInferenceClient, postgresClient, httpclient.NewHTTPClient(
-			httpclient.Config{
-				Timeout: 1 * time.Minute,
-				Backoff: httpclient.Backoff{
-					MaxIterations:             2,
-					BackoffTimeMinMillisecond: 10,
-					BackoffTimeMaxMillisecond: 50,
-				},
+	c4PlantUML, err := c4container.NewC4PlantUML(cfg.Diagram.C4PlantUMLInclude, cfg.Diagram.C4PlantUMLVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	plantUMLClient := httpclient.NewHTTPClient(
+		httpclient.Config{
+			Timeout: 1 * time.Minute,
+			Backoff: httpclient.Backoff{
+				MaxIterations:             2,
+				BackoffTimeMinMillisecond: 10,
+				BackoffTimeMaxMillisecond: 50,
 			},
-		),
+		},
+	)
+
+	c4DiagramHandler, err := c4container.NewC4ContainersHTTPHandler(
+		modelInferenceClient, postgresClient, plantUMLClient,
+		c4container.WithRedactor(diagram.NewRedactor(cfg.Diagram.RedactionDenyList...)),
+		c4container.WithGuard(promptGuard),
+		c4container.WithC4PlantUML(c4PlantUML),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c4FromInfraDiagramHandler, err := c4container.NewC4ContainersFromInfraHTTPHandler(
+		modelInferenceClient, postgresClient, plantUMLClient,
+		c4container.WithRedactor(diagram.NewRedactor(cfg.Diagram.RedactionDenyList...)),
+		c4container.WithGuard(promptGuard),
+		c4container.WithC4PlantUML(c4PlantUML),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c4DiffHandler, err := c4container.NewC4ContainersDiffHTTPHandler(
+		plantUMLClient, c4container.WithC4PlantUML(c4PlantUML),
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	c4ExplainHandler, err := c4container.NewC4ContainersExplainHTTPHandler(
+		modelInferenceClient, postgresClient,
+		c4container.WithRedactor(diagram.NewRedactor(cfg.Diagram.RedactionDenyList...)),
+		c4container.WithGuard(promptGuard),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Retention.PurgeInterval > 0 {
+		purger, err = retention.NewPurger(postgresClient, cfg.Retention.Policy, cfg.Retention.BatchSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		purgeInterval = cfg.Retention.PurgeInterval
+	}
+
 	handler = handlerPkg.NewHandler(
 		ciamHandler, corsHeaders,
 		map[string]diagram.HTTPHandler{
-			"/c4": c4DiagramHandler,
+			handlerPkg.RouteGenerateC4:          c4DiagramHandler,
+			handlerPkg.RouteGenerateC4FromInfra: c4FromInfraDiagramHandler,
+			handlerPkg.RouteDiffC4:              c4DiffHandler,
+			handlerPkg.RouteExplainC4:           c4ExplainHandler,
 		},
 	)
 }
@@ -118,6 +213,20 @@ func init() {
 func main() {
 	defer func() { _ = postgresClient.Close(context.Background()) }()
 
+	if purger != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go purger.Run(
+			ctx, purgeInterval, func(report retention.Report, err error) {
+				if err != nil {
+					log.Printf("retention purge failed, %s: %v", report, err)
+					return
+				}
+				log.Printf("retention purge: %s", report)
+			},
+		)
+	}
+
 	portServe := "9000"
 	if v := os.Getenv("PORT"); v != "" {
 		portServe = v
@@ -0,0 +1,80 @@
+//go:build !unittest
+// +build !unittest
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/kislerdm/diagramastext/server/core/config"
+	"github.com/kislerdm/diagramastext/server/core/pkg/gcpsecretsmanager"
+	"github.com/kislerdm/diagramastext/server/core/pkg/postgres"
+	"github.com/kislerdm/diagramastext/server/core/retention"
+)
+
+func main() {
+	var batchSize uint
+	flag.UintVar(&batchSize, "batch-size", 0, "max number of rows to purge by a single statement; overrides config")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	secretsmanagerClient, err := gcpsecretsmanager.NewSecretmanager(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := config.LoadDefaultConfig(ctx, secretsmanagerClient)
+	if batchSize > 0 {
+		cfg.Retention.BatchSize = uint32(batchSize)
+	}
+
+	postgresClient, err := postgres.NewPostgresClient(
+		ctx, postgres.Config{
+			DBHost:                 cfg.RepositoryPredictionConfig.DBHost,
+			DBName:                 cfg.RepositoryPredictionConfig.DBName,
+			DBUser:                 cfg.RepositoryPredictionConfig.DBUser,
+			DBPassword:             cfg.RepositoryPredictionConfig.DBPassword,
+			TablePrompt:            cfg.RepositoryPredictionConfig.TablePrompt,
+			TablePrediction:        cfg.RepositoryPredictionConfig.TablePrediction,
+			TableSuccessStatus:     cfg.RepositoryPredictionConfig.TableSuccessStatus,
+			TableUsers:             cfg.RepositoryPredictionConfig.TableUsers,
+			TableTokens:            cfg.RepositoryPredictionConfig.TableAPITokens,
+			TableOneTimeSecret:     cfg.CIAM.TableOneTimeSecret,
+			TableRateLimitCounters: cfg.CIAM.TableRateLimitCounters,
+			TableRefreshTokens:     cfg.CIAM.TableRefreshTokens,
+			TableOIDCStates:        cfg.CIAM.TableOIDCStates,
+			TableAuditLog:          cfg.CIAM.TableAuditLog,
+			TableFlaggedPrompts:    cfg.Diagram.TableFlaggedPrompts,
+			SSLMode:                cfg.RepositoryPredictionConfig.SSLMode,
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = postgresClient.Close(context.Background()) }()
+
+	purger, err := retention.NewPurger(postgresClient, cfg.Retention.Policy, cfg.Retention.BatchSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := purger.Purge(ctx)
+
+	o, errMarshal := json.Marshal(report)
+	if errMarshal != nil {
+		log.Fatal(errMarshal)
+	}
+	fmt.Println(string(o))
+
+	if err != nil {
+		log.Fatalf("retention purge failed: %v", err)
+	}
+}
@@ -2,13 +2,16 @@ package config
 
 import (
 	"context"
-	"crypto/ed25519"
+	"encoding/json"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/kislerdm/diagramastext/server/core/ciam"
 	"github.com/kislerdm/diagramastext/server/core/diagram"
+	"github.com/kislerdm/diagramastext/server/core/diagram/c4container"
 	"github.com/kislerdm/diagramastext/server/core/internal/utils"
+	"github.com/kislerdm/diagramastext/server/core/retention"
 )
 
 const (
@@ -20,6 +23,11 @@ const (
 	tableLookupUser           = "users"
 	tableLookupApiTokens      = "api_tokens"
 	tableOneTimeSecret        = "user_auth_secrets"
+	tableRateLimitCounters    = "rate_limit_counters"
+	tableRefreshTokens        = "refresh_tokens"
+	tableOIDCStates           = "oidc_states"
+	tableAuditLog             = "admin_audit_log"
+	tableFlaggedPrompts       = "flagged_prompts"
 
 	defaultSenderEmail = "support@diagramastext.dev"
 	defaultSMPTPort    = "587"
@@ -39,13 +47,17 @@ type repositoryPredictionConfig struct {
 }
 
 type ciamConfigStore struct {
-	PrivateKey         string `json:"private_key"`
-	SmtpUser           string `json:"smtp_user"`
-	SmtpPassword       string `json:"smtp_password"`
-	SmtpHost           string `json:"smtp_host"`
-	SmtpPort           string `json:"smtp_port"`
-	SmtpSenderEmail    string `json:"smtp_sender_email"`
-	TableOneTimeSecret string `json:"table_one_time_secret"`
+	PrivateKey         string          `json:"private_key"`
+	KeySet             json.RawMessage `json:"key_set,omitempty"`
+	SmtpUser           string          `json:"smtp_user"`
+	SmtpPassword       string          `json:"smtp_password"`
+	SmtpHost           string          `json:"smtp_host"`
+	SmtpPort           string          `json:"smtp_port"`
+	SmtpSenderEmail    string          `json:"smtp_sender_email"`
+	SmtpTLSMode        string          `json:"smtp_tls_mode"`
+	TableOneTimeSecret string          `json:"table_one_time_secret"`
+	// OIDCProviders defines the identity providers to sign in with, it includes the clients' secrets.
+	OIDCProviders []ciam.OIDCProviderConfig `json:"oidc_providers,omitempty"`
 }
 
 type secret struct {
@@ -60,19 +72,54 @@ type modelInferenceConfig struct {
 }
 
 type ciamCfg struct {
-	PrivateKey         ed25519.PrivateKey
-	TableOneTimeSecret string
-	SmtpUser           string
-	SmtpPassword       string
-	SmtpHost           string
-	SmtpPort           string
-	SmtpSenderEmail    string
+	KeySet                 ciam.KeySet
+	TableOneTimeSecret     string
+	TableRateLimitCounters string
+	TableRefreshTokens     string
+	TableOIDCStates        string
+	TableAuditLog          string
+	SmtpUser               string
+	SmtpPassword           string
+	SmtpHost               string
+	SmtpPort               string
+	SmtpSenderEmail        string
+	SmtpTLSMode            string
+	// MailDir defines the directory to write emails to as .eml files instead of sending them via SMTP.
+	MailDir              string
+	MagicLinkVerifyURL   string
+	MagicLinkRedirectURL string
+	OIDCProviders        []ciam.OIDCProviderConfig
+	// TrustedProxies defines the IP addresses, or CIDR ranges of the proxies setting X-Forwarded-For.
+	TrustedProxies []string
+}
+
+type retentionCfg struct {
+	Policy    retention.Policy
+	BatchSize uint32
+	// PurgeInterval defines the interval to purge the data in-process, the purge is disabled if zero.
+	PurgeInterval time.Duration
+}
+
+type diagramCfg struct {
+	// RedactionDenyList defines the terms to redact from the prompts in addition to the detected sensitive data.
+	RedactionDenyList []string
+	// GuardPolicy defines how to handle the flagged prompts: block, warn, or log.
+	GuardPolicy string
+	// GuardClassifier defines whether to classify prompts with the model in addition to the heuristics.
+	GuardClassifier     bool
+	TableFlaggedPrompts string
+	// C4PlantUMLInclude defines how to include the C4-PlantUML library: stdlib, or inline.
+	C4PlantUMLInclude string
+	// C4PlantUMLVersion defines the version of the vendored C4-PlantUML library to inline.
+	C4PlantUMLVersion string
 }
 
 type Config struct {
 	RepositoryPredictionConfig repositoryPredictionConfig
 	CIAM                       ciamCfg
 	ModelInferenceConfig       modelInferenceConfig
+	Retention                  retentionCfg
+	Diagram                    diagramCfg
 }
 
 func LoadDefaultConfig(ctx context.Context, clientSecretsManager diagram.RepositorySecretsVault) *Config {
@@ -87,9 +134,23 @@ func LoadDefaultConfig(ctx context.Context, clientSecretsManager diagram.Reposit
 			SSLMode:            defaultSSLMode,
 		},
 		CIAM: ciamCfg{
-			TableOneTimeSecret: tableOneTimeSecret,
-			SmtpSenderEmail:    defaultSenderEmail,
-			SmtpPort:           defaultSMPTPort,
+			TableOneTimeSecret:     tableOneTimeSecret,
+			TableRateLimitCounters: tableRateLimitCounters,
+			TableRefreshTokens:     tableRefreshTokens,
+			TableOIDCStates:        tableOIDCStates,
+			TableAuditLog:          tableAuditLog,
+			SmtpSenderEmail:        defaultSenderEmail,
+			SmtpPort:               defaultSMPTPort,
+		},
+		Retention: retentionCfg{
+			Policy:    retention.DefaultPolicy(),
+			BatchSize: retention.DefaultBatchSize,
+		},
+		Diagram: diagramCfg{
+			GuardPolicy:         diagram.GuardPolicyWarn,
+			TableFlaggedPrompts: tableFlaggedPrompts,
+			C4PlantUMLInclude:   c4container.C4PlantUMLIncludeStdlib,
+			C4PlantUMLVersion:   c4container.DefaultC4PlantUMLVersion,
 		},
 	}
 
@@ -113,12 +174,19 @@ func loadFromSecretsManager(
 		cfg.RepositoryPredictionConfig.DBUser = s.DBUser
 		cfg.RepositoryPredictionConfig.DBPassword = s.DBPassword
 
-		if s.PrivateKey != "" {
+		switch {
+		case len(s.KeySet) > 0:
 			var err error
-			cfg.CIAM.PrivateKey, err = ciam.ReadPrivateKey(s.PrivateKey)
+			cfg.CIAM.KeySet, err = ciam.ReadKeySet(s.KeySet)
+			if err != nil {
+				panic("cannot read key set from secret: " + err.Error())
+			}
+		case s.PrivateKey != "":
+			key, err := ciam.ReadPrivateKey(s.PrivateKey)
 			if err != nil {
 				panic("cannot read key from secret: " + err.Error())
 			}
+			cfg.CIAM.KeySet = ciam.NewKeySet(key)
 		}
 
 		cfg.CIAM.SmtpUser = s.SmtpUser
@@ -132,6 +200,12 @@ func loadFromSecretsManager(
 		if s.SmtpPort != "" {
 			cfg.CIAM.SmtpPort = s.SmtpPort
 		}
+
+		cfg.CIAM.SmtpTLSMode = s.SmtpTLSMode
+
+		if len(s.OIDCProviders) > 0 {
+			cfg.CIAM.OIDCProviders = s.OIDCProviders
+		}
 	}
 }
 
@@ -171,8 +245,24 @@ func loadEnvVarConfig(cfg *Config) {
 		cfg.CIAM.TableOneTimeSecret = v
 	}
 
+	if v := os.Getenv("TABLE_RATE_LIMIT_COUNTERS"); v != "" {
+		cfg.CIAM.TableRateLimitCounters = v
+	}
+
+	if v := os.Getenv("TABLE_REFRESH_TOKENS"); v != "" {
+		cfg.CIAM.TableRefreshTokens = v
+	}
+
+	if v := os.Getenv("TABLE_OIDC_STATES"); v != "" {
+		cfg.CIAM.TableOIDCStates = v
+	}
+
+	if v := os.Getenv("TABLE_AUDIT_LOG"); v != "" {
+		cfg.CIAM.TableAuditLog = v
+	}
+
 	if v := os.Getenv("ENV"); strings.HasPrefix(strings.ToLower(v), "dev") {
-		cfg.CIAM.PrivateKey = ciam.GenerateCertificate()
+		cfg.CIAM.KeySet = ciam.NewKeySet(ciam.GenerateCertificate())
 	}
 
 	if v := os.Getenv("CIAM_SMTP_USER"); v != "" {
@@ -194,4 +284,74 @@ func loadEnvVarConfig(cfg *Config) {
 	if v := os.Getenv("CIAM_SMTP_SENDER_EMAIL"); v != "" {
 		cfg.CIAM.SmtpSenderEmail = v
 	}
+
+	if v := os.Getenv("CIAM_SMTP_TLS_MODE"); v != "" {
+		cfg.CIAM.SmtpTLSMode = v
+	}
+
+	if v := os.Getenv("CIAM_MAIL_DIR"); v != "" {
+		cfg.CIAM.MailDir = v
+	}
+
+	if v := os.Getenv("CIAM_MAGIC_LINK_VERIFY_URL"); v != "" {
+		cfg.CIAM.MagicLinkVerifyURL = v
+	}
+
+	if v := os.Getenv("CIAM_MAGIC_LINK_REDIRECT_URL"); v != "" {
+		cfg.CIAM.MagicLinkRedirectURL = v
+	}
+
+	if v := os.Getenv("CIAM_TRUSTED_PROXIES"); v != "" {
+		cfg.CIAM.TrustedProxies = strings.Split(v, ",")
+	}
+
+	if v := os.Getenv("CIAM_OIDC_PROVIDERS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &cfg.CIAM.OIDCProviders); err != nil {
+			panic("cannot read oidc providers: " + err.Error())
+		}
+	}
+
+	if v := os.Getenv("PROMPT_REDACTION_DENY_LIST"); v != "" {
+		cfg.Diagram.RedactionDenyList = strings.Split(v, ",")
+	}
+
+	if v := os.Getenv("PROMPT_GUARD_POLICY"); v != "" {
+		cfg.Diagram.GuardPolicy = v
+	}
+
+	if v := os.Getenv("PROMPT_GUARD_CLASSIFIER"); v != "" {
+		cfg.Diagram.GuardClassifier = strings.ToLower(v) == "true"
+	}
+
+	if v := os.Getenv("TABLE_FLAGGED_PROMPTS"); v != "" {
+		cfg.Diagram.TableFlaggedPrompts = v
+	}
+
+	if v := os.Getenv("C4_PLANTUML_INCLUDE"); v != "" {
+		cfg.Diagram.C4PlantUMLInclude = v
+	}
+
+	if v := os.Getenv("C4_PLANTUML_VERSION"); v != "" {
+		cfg.Diagram.C4PlantUMLVersion = v
+	}
+
+	if v := os.Getenv("RETENTION_POLICY"); v != "" {
+		var policy retention.Policy
+		if err := json.Unmarshal([]byte(v), &policy); err != nil {
+			panic("cannot read retention policy: " + err.Error())
+		}
+		cfg.Retention.Policy = policy
+	}
+
+	if v := utils.MustParseInt(os.Getenv("RETENTION_BATCH_SIZE")); v > 0 {
+		cfg.Retention.BatchSize = uint32(v)
+	}
+
+	if v := os.Getenv("RETENTION_PURGE_INTERVAL"); v != "" {
+		var err error
+		cfg.Retention.PurgeInterval, err = time.ParseDuration(v)
+		if err != nil {
+			panic("cannot read retention purge interval: " + err.Error())
+		}
+	}
 }
@@ -3,12 +3,16 @@ package config
 import (
 	"context"
 	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/kislerdm/diagramastext/server/core/ciam"
 	"github.com/kislerdm/diagramastext/server/core/diagram"
+	"github.com/kislerdm/diagramastext/server/core/diagram/c4container"
+	"github.com/kislerdm/diagramastext/server/core/retention"
 )
 
 func mustSerialize(v interface{}) []byte {
@@ -53,6 +57,16 @@ func Test_loadDefaultConfig(t *testing.T) {
 								SmtpHost:        "smtphost",
 								SmtpPort:        "573",
 								SmtpSenderEmail: "support@bar.baz",
+								SmtpTLSMode:     "tls",
+								OIDCProviders: []ciam.OIDCProviderConfig{
+									{
+										Name:         "google",
+										Kind:         ciam.OIDCProviderKindGoogle,
+										ClientID:     "foo",
+										ClientSecret: "bar",
+										RedirectURL:  "https://api.bar.baz/auth/oidc/google/callback",
+									},
+								},
 							},
 							APIKey: "foobar",
 						},
@@ -79,13 +93,37 @@ func Test_loadDefaultConfig(t *testing.T) {
 					Token: "foobar",
 				},
 				CIAM: ciamCfg{
-					TableOneTimeSecret: tableOneTimeSecret,
-					SmtpUser:           "foo@bar.baz",
-					SmtpPassword:       "qux",
-					SmtpHost:           "smtphost",
-					SmtpPort:           "573",
-					SmtpSenderEmail:    "support@bar.baz",
-					PrivateKey:         certificate,
+					TableOneTimeSecret:     tableOneTimeSecret,
+					TableRateLimitCounters: tableRateLimitCounters,
+					TableRefreshTokens:     tableRefreshTokens,
+					TableOIDCStates:        tableOIDCStates,
+					TableAuditLog:          tableAuditLog,
+					SmtpUser:               "foo@bar.baz",
+					SmtpPassword:           "qux",
+					SmtpHost:               "smtphost",
+					SmtpPort:               "573",
+					SmtpSenderEmail:        "support@bar.baz",
+					SmtpTLSMode:            "tls",
+					KeySet:                 ciam.NewKeySet(certificate),
+					OIDCProviders: []ciam.OIDCProviderConfig{
+						{
+							Name:         "google",
+							Kind:         ciam.OIDCProviderKindGoogle,
+							ClientID:     "foo",
+							ClientSecret: "bar",
+							RedirectURL:  "https://api.bar.baz/auth/oidc/google/callback",
+						},
+					},
+				},
+				Retention: retentionCfg{
+					Policy:    retention.DefaultPolicy(),
+					BatchSize: retention.DefaultBatchSize,
+				},
+				Diagram: diagramCfg{
+					GuardPolicy:         diagram.GuardPolicyWarn,
+					TableFlaggedPrompts: tableFlaggedPrompts,
+					C4PlantUMLInclude:   c4container.C4PlantUMLIncludeStdlib,
+					C4PlantUMLVersion:   c4container.DefaultC4PlantUMLVersion,
 				},
 			},
 		},
@@ -115,25 +153,29 @@ func Test_loadDefaultConfig(t *testing.T) {
 				},
 			},
 			envVars: map[string]string{
-				"ACCESS_CREDENTIALS_URI": "bazz",
-				"MODEL_API_KEY":          "key",
-				"DB_HOST":                "dbh",
-				"DB_DBNAME":              "dbn",
-				"DB_USER":                "dbu",
-				"DB_PASSWORD":            "dbpass",
-				"MODEL_MAX_TOKENS":       "100",
-				"TABLE_PROMPT":           "foo",
-				"TABLE_PREDICTION":       "bar",
-				"TABLE_SUCCESS_STATUS":   "qux",
-				"TABLE_USERS":            "u",
-				"TABLE_API_TOKENS":       "t",
-				"TABLE_ONE_TIME_SECRET":  "s",
-				"SSL_MODE":               "disable",
-				"CIAM_SMTP_USER":         "r",
-				"CIAM_SMTP_PASSWORD":     "t",
-				"CIAM_SMTP_HOST":         "yy",
-				"CIAM_SMTP_PORT":         "44",
-				"CIAM_SMTP_SENDER_EMAIL": "dfdf",
+				"ACCESS_CREDENTIALS_URI":    "bazz",
+				"MODEL_API_KEY":             "key",
+				"DB_HOST":                   "dbh",
+				"DB_DBNAME":                 "dbn",
+				"DB_USER":                   "dbu",
+				"DB_PASSWORD":               "dbpass",
+				"MODEL_MAX_TOKENS":          "100",
+				"TABLE_PROMPT":              "foo",
+				"TABLE_PREDICTION":          "bar",
+				"TABLE_SUCCESS_STATUS":      "qux",
+				"TABLE_USERS":               "u",
+				"TABLE_API_TOKENS":          "t",
+				"TABLE_ONE_TIME_SECRET":     "s",
+				"TABLE_RATE_LIMIT_COUNTERS": "rl",
+				"TABLE_REFRESH_TOKENS":      "rt",
+				"TABLE_OIDC_STATES":         "os",
+				"TABLE_AUDIT_LOG":           "al",
+				"SSL_MODE":                  "disable",
+				"CIAM_SMTP_USER":            "r",
+				"CIAM_SMTP_PASSWORD":        "t",
+				"CIAM_SMTP_HOST":            "yy",
+				"CIAM_SMTP_PORT":            "44",
+				"CIAM_SMTP_SENDER_EMAIL":    "dfdf",
 			},
 			want: &Config{
 				RepositoryPredictionConfig: repositoryPredictionConfig{
@@ -149,17 +191,31 @@ func Test_loadDefaultConfig(t *testing.T) {
 					SSLMode:            "disable",
 				},
 				CIAM: ciamCfg{
-					TableOneTimeSecret: "s",
-					SmtpUser:           "foo@bar.baz",
-					SmtpPassword:       "qux",
-					SmtpHost:           "smtphost",
-					SmtpPort:           "573",
-					SmtpSenderEmail:    "support@bar.baz",
+					TableOneTimeSecret:     "s",
+					TableRateLimitCounters: "rl",
+					TableRefreshTokens:     "rt",
+					TableOIDCStates:        "os",
+					TableAuditLog:          "al",
+					SmtpUser:               "foo@bar.baz",
+					SmtpPassword:           "qux",
+					SmtpHost:               "smtphost",
+					SmtpPort:               "573",
+					SmtpSenderEmail:        "support@bar.baz",
 				},
 				ModelInferenceConfig: modelInferenceConfig{
 					Token:     "foobar",
 					MaxTokens: 100,
 				},
+				Retention: retentionCfg{
+					Policy:    retention.DefaultPolicy(),
+					BatchSize: retention.DefaultBatchSize,
+				},
+				Diagram: diagramCfg{
+					GuardPolicy:         diagram.GuardPolicyWarn,
+					TableFlaggedPrompts: tableFlaggedPrompts,
+					C4PlantUMLInclude:   c4container.C4PlantUMLIncludeStdlib,
+					C4PlantUMLVersion:   c4container.DefaultC4PlantUMLVersion,
+				},
 			},
 		},
 		{
@@ -168,24 +224,45 @@ func Test_loadDefaultConfig(t *testing.T) {
 				ctx: context.TODO(),
 			},
 			envVars: map[string]string{
-				"MODEL_API_KEY":          "foobar",
-				"MODEL_MAX_TOKENS":       "100",
-				"DB_HOST":                "localhost",
-				"DB_DBNAME":              "postgres",
-				"DB_USER":                "postgres",
-				"DB_PASSWORD":            "postgres",
-				"TABLE_PROMPT":           "foo",
-				"TABLE_PREDICTION":       "bar",
-				"TABLE_SUCCESS_STATUS":   "qux",
-				"TABLE_USERS":            "u",
-				"TABLE_ONE_TIME_SECRET":  "s",
-				"TABLE_API_TOKENS":       "t",
-				"CIAM_SMTP_USER":         "r",
-				"CIAM_SMTP_PASSWORD":     "t",
-				"CIAM_SMTP_HOST":         "yy",
-				"CIAM_SMTP_PORT":         "44",
-				"CIAM_SMTP_SENDER_EMAIL": "dfdf",
-				"CIAM_KEY":               "projects/my-project/locations/us-east1/keyRings/my-key-ring/cryptoKeys/my-key",
+				"MODEL_API_KEY":                "foobar",
+				"MODEL_MAX_TOKENS":             "100",
+				"DB_HOST":                      "localhost",
+				"DB_DBNAME":                    "postgres",
+				"DB_USER":                      "postgres",
+				"DB_PASSWORD":                  "postgres",
+				"TABLE_PROMPT":                 "foo",
+				"TABLE_PREDICTION":             "bar",
+				"TABLE_SUCCESS_STATUS":         "qux",
+				"TABLE_USERS":                  "u",
+				"TABLE_ONE_TIME_SECRET":        "s",
+				"TABLE_RATE_LIMIT_COUNTERS":    "rl",
+				"TABLE_REFRESH_TOKENS":         "rt",
+				"TABLE_OIDC_STATES":            "os",
+				"TABLE_AUDIT_LOG":              "al",
+				"TABLE_API_TOKENS":             "t",
+				"CIAM_SMTP_USER":               "r",
+				"CIAM_SMTP_PASSWORD":           "t",
+				"CIAM_SMTP_HOST":               "yy",
+				"CIAM_SMTP_PORT":               "44",
+				"CIAM_SMTP_SENDER_EMAIL":       "dfdf",
+				"CIAM_SMTP_TLS_MODE":           "starttls",
+				"CIAM_MAIL_DIR":                "/tmp/mail",
+				"CIAM_MAGIC_LINK_VERIFY_URL":   "https://api.foo.bar/auth/verify",
+				"CIAM_MAGIC_LINK_REDIRECT_URL": "https://foo.bar",
+				"CIAM_TRUSTED_PROXIES":         "10.0.0.0/8,192.168.0.1",
+				"CIAM_OIDC_PROVIDERS": `[{"name":"corp","issuer":"https://idp.foo.bar","client_id":"foo",` +
+					`"client_secret":"bar","redirect_url":"https://api.foo.bar/auth/oidc/corp/callback"}]`,
+				"CIAM_KEY": "projects/my-project/locations/us-east1/keyRings/my-key-ring/cryptoKeys/my-key",
+				"RETENTION_POLICY": `[{"target":"model_predictions_raw","days":30},` +
+					`{"target":"prompts","plan":"premium","days":365}]`,
+				"RETENTION_BATCH_SIZE":       "500",
+				"PROMPT_REDACTION_DENY_LIST": "acme,Project X",
+				"PROMPT_GUARD_POLICY":        "block",
+				"PROMPT_GUARD_CLASSIFIER":    "true",
+				"TABLE_FLAGGED_PROMPTS":      "fp",
+				"C4_PLANTUML_INCLUDE":        "inline",
+				"C4_PLANTUML_VERSION":        "v2.7.0",
+				"RETENTION_PURGE_INTERVAL":   "24h",
 			},
 			want: &Config{
 				RepositoryPredictionConfig: repositoryPredictionConfig{
@@ -205,12 +282,46 @@ func Test_loadDefaultConfig(t *testing.T) {
 					MaxTokens: 100,
 				},
 				CIAM: ciamCfg{
-					TableOneTimeSecret: "s",
-					SmtpUser:           "r",
-					SmtpPassword:       "t",
-					SmtpHost:           "yy",
-					SmtpPort:           "44",
-					SmtpSenderEmail:    "dfdf",
+					TableOneTimeSecret:     "s",
+					TableRateLimitCounters: "rl",
+					TableRefreshTokens:     "rt",
+					TableOIDCStates:        "os",
+					TableAuditLog:          "al",
+					SmtpUser:               "r",
+					SmtpPassword:           "t",
+					SmtpHost:               "yy",
+					SmtpPort:               "44",
+					SmtpSenderEmail:        "dfdf",
+					SmtpTLSMode:            "starttls",
+					MailDir:                "/tmp/mail",
+					MagicLinkVerifyURL:     "https://api.foo.bar/auth/verify",
+					MagicLinkRedirectURL:   "https://foo.bar",
+					OIDCProviders: []ciam.OIDCProviderConfig{
+						{
+							Name:         "corp",
+							Issuer:       "https://idp.foo.bar",
+							ClientID:     "foo",
+							ClientSecret: "bar",
+							RedirectURL:  "https://api.foo.bar/auth/oidc/corp/callback",
+						},
+					},
+					TrustedProxies: []string{"10.0.0.0/8", "192.168.0.1"},
+				},
+				Retention: retentionCfg{
+					Policy: retention.Policy{
+						{Target: retention.TargetModelPredictionsRaw, Days: 30},
+						{Target: retention.TargetPrompts, Plan: retention.PlanPremium, Days: 365},
+					},
+					BatchSize:     500,
+					PurgeInterval: 24 * time.Hour,
+				},
+				Diagram: diagramCfg{
+					RedactionDenyList:   []string{"acme", "Project X"},
+					GuardPolicy:         diagram.GuardPolicyBlock,
+					GuardClassifier:     true,
+					TableFlaggedPrompts: "fp",
+					C4PlantUMLInclude:   c4container.C4PlantUMLIncludeInline,
+					C4PlantUMLVersion:   "v2.7.0",
 				},
 			},
 		},
@@ -241,7 +352,7 @@ func Test_loadDefaultConfig(t *testing.T) {
 			got := LoadDefaultConfig(context.TODO(), nil)
 
 			// THEN
-			if got.CIAM.PrivateKey == nil {
+			if got.CIAM.KeySet.SigningKey == nil {
 				t.Error("CIAM signing keys are not defined for dev environment")
 			}
 		},
@@ -255,11 +366,44 @@ func Test_loadDefaultConfig(t *testing.T) {
 			got := LoadDefaultConfig(context.TODO(), nil)
 
 			// THEN
-			if got.CIAM.PrivateKey == nil {
+			if got.CIAM.KeySet.SigningKey == nil {
 				t.Error("CIAM signing keys are not defined for dev environment")
 			}
 		},
 	)
+
+	t.Run(
+		"shall load CIAM key set from secretsmanager", func(t *testing.T) {
+			// GIVEN
+			t.Setenv("ACCESS_CREDENTIALS_URI", "foobar")
+
+			_, newKey, _ := ed25519.GenerateKey(rand.Reader)
+			keySet := ciam.NewKeySet(ciam.GenerateCertificate()).Rotate(newKey, 1)
+			keySetDoc, err := ciam.MarshalKeySet(keySet)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clientSecretsManager := diagram.MockRepositorySecretsVault{
+				V: mustSerialize(
+					secret{
+						ciamConfigStore: ciamConfigStore{
+							PrivateKey: mustMarshalKey(ciam.GenerateCertificate()),
+							KeySet:     keySetDoc,
+						},
+					},
+				),
+			}
+
+			// WHEN
+			got := LoadDefaultConfig(context.TODO(), clientSecretsManager)
+
+			// THEN
+			if !reflect.DeepEqual(got.CIAM.KeySet, keySet) {
+				t.Errorf("unexpected key set. want: %v, got: %v", keySet, got.CIAM.KeySet)
+			}
+		},
+	)
 }
 
 func mustMarshalKey(key ed25519.PrivateKey) string {
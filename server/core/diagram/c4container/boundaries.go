@@ -0,0 +1,128 @@
+package c4container
+
+import "strconv"
+
+// boundaryNode defines the boundary with its members: the containers' DSL, and the nested boundaries.
+type boundaryNode struct {
+	key      string
+	boundary *boundary
+	members  []string
+	children []*boundaryNode
+}
+
+// boundaryTree arranges the boundaries in the order of their first appearance,
+// the containers' groups are resolved to the boundaries by the id, or the label.
+type boundaryTree struct {
+	declared []*boundary
+	nodes    map[string]*boundaryNode
+	roots    []*boundaryNode
+	// members defines the containers outside any boundary.
+	members []string
+}
+
+func newBoundaryTree(declared []*boundary) *boundaryTree {
+	o := &boundaryTree{nodes: map[string]*boundaryNode{}}
+	for _, b := range declared {
+		if b != nil {
+			o.declared = append(o.declared, b)
+		}
+	}
+	return o
+}
+
+// resolve returns the key and the definition of the boundary referenced by the name.
+// The boundary is defined implicitly by its name if it's not declared.
+func (t *boundaryTree) resolve(name string) (string, *boundary) {
+	for i, b := range t.declared {
+		if b.ID == name {
+			return strconv.Itoa(i), b
+		}
+	}
+	for i, b := range t.declared {
+		if b.Label == name {
+			return strconv.Itoa(i), b
+		}
+	}
+	return "=" + name, &boundary{ID: name, Label: name}
+}
+
+// add adds the container's DSL to the boundary referenced by the name.
+func (t *boundaryTree) add(name string, member string) {
+	if name == "" {
+		t.members = append(t.members, member)
+		return
+	}
+	key, b := t.resolve(name)
+	n := t.register(key, b, map[string]struct{}{})
+	n.members = append(n.members, member)
+}
+
+// register adds the boundary with its enclosing boundaries to the tree,
+// the boundary is placed at the top level if its parent forms a cycle.
+func (t *boundaryTree) register(key string, b *boundary, visiting map[string]struct{}) *boundaryNode {
+	if n, ok := t.nodes[key]; ok {
+		return n
+	}
+
+	visiting[key] = struct{}{}
+
+	var parent *boundaryNode
+	if b.Parent != "" {
+		if parentKey, parentBoundary := t.resolve(b.Parent); parentKey != key {
+			if _, ok := visiting[parentKey]; !ok {
+				parent = t.register(parentKey, parentBoundary, visiting)
+			}
+		}
+	}
+
+	n := &boundaryNode{key: key, boundary: b}
+	t.nodes[key] = n
+	if parent != nil {
+		parent.children = append(parent.children, n)
+	} else {
+		t.roots = append(t.roots, n)
+	}
+	return n
+}
+
+// dsl returns the lines of the DSL: the containers outside boundaries followed by the boundaries.
+// The declared boundaries without containers follow the boundaries referenced by the containers.
+func (t *boundaryTree) dsl(ids *dslIdentifiers) []string {
+	for i, b := range t.declared {
+		t.register(strconv.Itoa(i), b, map[string]struct{}{})
+	}
+
+	o := append([]string{}, t.members...)
+	for _, n := range t.roots {
+		o = append(o, n.dsl(ids)...)
+	}
+	return o
+}
+
+func (n *boundaryNode) dsl(ids *dslIdentifiers) []string {
+	label := n.boundary.Label
+	if label == "" {
+		label = n.boundary.ID
+	}
+
+	o := []string{
+		dslBoundaryType(n.boundary.Type) + "(" + ids.boundary(n.key, n.boundary.ID) + `, "` + dslString(label) + `"` +
+			dslOptionalArgs(n.boundary.Tags, "", n.boundary.Link) + ") {",
+	}
+	o = append(o, n.members...)
+	for _, child := range n.children {
+		o = append(o, child.dsl(ids)...)
+	}
+	return append(o, "}")
+}
+
+func dslBoundaryType(s string) string {
+	switch s {
+	case "enterprise":
+		return "Enterprise_Boundary"
+	case "container":
+		return "Container_Boundary"
+	default:
+		return "System_Boundary"
+	}
+}
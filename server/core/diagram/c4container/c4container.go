@@ -14,9 +14,15 @@ import (
 type c4ContainersGraph struct {
 	Containers []*container `json:"nodes"`
 	Rels       []*rel       `json:"links"`
-	Title      string       `json:"title,omitempty"`
-	Footer     string       `json:"footer,omitempty"`
-	WithLegend bool         `json:"legend,omitempty"`
+	Boundaries []*boundary  `json:"boundaries,omitempty"`
+	// ElementTags defines the styles of the nodes and boundaries with the tag.
+	ElementTags []*elementTag `json:"element_tags,omitempty"`
+	// RelTags defines the styles of the links with the tag.
+	RelTags    []*relTag `json:"link_tags,omitempty"`
+	Layout     *layout   `json:"layout,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	Footer     string    `json:"footer,omitempty"`
+	WithLegend bool      `json:"legend,omitempty"`
 }
 
 func (l *c4ContainersGraph) UnmarshalJSON(data []byte) error {
@@ -38,26 +44,123 @@ type container struct {
 	Label       string `json:"label,omitempty"`
 	Technology  string `json:"technology,omitempty"`
 	Description string `json:"description,omitempty"`
-	System      string `json:"group,omitempty"`
-	IsExternal  bool   `json:"external,omitempty"`
-	IsQueue     bool   `json:"queue,omitempty"`
-	IsDatabase  bool   `json:"database,omitempty"`
-	IsUser      bool   `json:"user,omitempty"`
+	// System defines the boundary of the container: the boundary's id, or label.
+	System     string `json:"group,omitempty"`
+	IsExternal bool   `json:"external,omitempty"`
+	IsQueue    bool   `json:"queue,omitempty"`
+	IsDatabase bool   `json:"database,omitempty"`
+	IsUser     bool   `json:"user,omitempty"`
+	// Sprite defines the icon from the PlantUML standard library, e.g. devicons2/go.
+	Sprite string   `json:"sprite,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Link   string   `json:"link,omitempty"`
 }
 
 // rel containers relations.
 type rel struct {
-	From       string `json:"from"`
-	To         string `json:"to"`
-	Label      string `json:"label,omitempty"`
-	Direction  string `json:"direction,omitempty"`
-	Technology string `json:"technology,omitempty"`
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Label      string   `json:"label,omitempty"`
+	Direction  string   `json:"direction,omitempty"`
+	Technology string   `json:"technology,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Link       string   `json:"link,omitempty"`
+}
+
+// boundary defines the boundary grouping the containers and nested boundaries.
+type boundary struct {
+	ID    string `json:"id"`
+	Label string `json:"label,omitempty"`
+	// Type defines the boundary's type: enterprise, system, or container. The system boundary is used by default.
+	Type string `json:"type,omitempty"`
+	// Parent defines the enclosing boundary's id.
+	Parent string   `json:"parent,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Link   string   `json:"link,omitempty"`
+}
+
+// elementTag defines the style of the tagged elements.
+type elementTag struct {
+	Tag         string `json:"tag"`
+	BgColor     string `json:"bg_color,omitempty"`
+	FontColor   string `json:"font_color,omitempty"`
+	BorderColor string `json:"border_color,omitempty"`
+	// Shape defines the elements' shape: rounded, or eight_sided.
+	Shape string `json:"shape,omitempty"`
+}
+
+// relTag defines the style of the tagged relations.
+type relTag struct {
+	Tag       string `json:"tag"`
+	TextColor string `json:"text_color,omitempty"`
+	LineColor string `json:"line_color,omitempty"`
+	// LineStyle defines the line's style: dashed, dotted, or bold.
+	LineStyle string `json:"line_style,omitempty"`
+}
+
+// restoreLabels replaces the redaction placeholders in the graph's labels with the redacted values.
+func (l *c4ContainersGraph) restoreLabels(redaction diagram.Redaction) {
+	if !redaction.IsRedacted() {
+		return
+	}
+	l.Title = redaction.Restore(l.Title)
+	l.Footer = redaction.Restore(l.Footer)
+	for _, c := range l.Containers {
+		c.Label = redaction.Restore(c.Label)
+		c.Technology = redaction.Restore(c.Technology)
+		c.Description = redaction.Restore(c.Description)
+		c.System = redaction.Restore(c.System)
+		c.Link = redaction.Restore(c.Link)
+	}
+	for _, r := range l.Rels {
+		r.Label = redaction.Restore(r.Label)
+		r.Technology = redaction.Restore(r.Technology)
+		r.Link = redaction.Restore(r.Link)
+	}
+	for _, b := range l.Boundaries {
+		b.Label = redaction.Restore(b.Label)
+		b.Link = redaction.Restore(b.Link)
+	}
+}
+
+// HTTPHandlerOps defines the options of the httphandler.
+type HTTPHandlerOps func(o *handlerOptions)
+
+type handlerOptions struct {
+	redactor   diagram.Redactor
+	guard      *diagram.Guard
+	c4PlantUML C4PlantUML
+}
+
+// WithRedactor sets the redactor of the sensitive data in the prompts.
+func WithRedactor(redactor diagram.Redactor) HTTPHandlerOps {
+	return func(o *handlerOptions) {
+		o.redactor = redactor
+	}
+}
+
+// WithGuard sets the guard to inspect the redacted prompts and graphs before they are sent to the model.
+func WithGuard(guard *diagram.Guard) HTTPHandlerOps {
+	return func(o *handlerOptions) {
+		o.guard = guard
+	}
+}
+
+// WithC4PlantUML sets the C4-PlantUML library to render the diagrams.
+func WithC4PlantUML(lib C4PlantUML) HTTPHandlerOps {
+	return func(o *handlerOptions) {
+		o.c4PlantUML = lib
+	}
 }
 
 // NewC4ContainersHTTPHandler initialises the httphandler to generate C4 containers diagram.
+// The sensitive data is redacted from the prompt before it is sent to the model and persisted,
+// the redacted values are restored in the diagram's labels. The predicted graph is validated and repaired,
+// the model is asked to fix the graph once if it cannot be repaired.
+// The layout requested explicitly by the user overrides the layout inferred by the model.
 func NewC4ContainersHTTPHandler(
 	clientModelInference diagram.ModelInference, clientRepositoryPrediction diagram.RepositoryPrediction,
-	httpClient diagram.HTTPClient,
+	httpClient diagram.HTTPClient, fnOps ...HTTPHandlerOps,
 ) (diagram.HTTPHandler, error) {
 	if clientModelInference == nil {
 		return nil, errors.New("model inference client must be provided")
@@ -65,14 +168,43 @@ func NewC4ContainersHTTPHandler(
 	if httpClient == nil {
 		return nil, errors.New("http client must be provided")
 	}
+
+	opts := handlerOptions{redactor: diagram.NewRedactor()}
+	for _, o := range fnOps {
+		if o != nil {
+			o(&opts)
+		}
+	}
+
 	return func(ctx context.Context, input diagram.Input) (diagram.Output, error) {
 		if err := input.Validate(); err != nil {
 			return nil, err
 		}
+		if input.GetPrompt() == "" {
+			return nil, diagram.InputError{Reason: "prompt must be provided"}
+		}
+
+		// the layout and the output language are optional
+		var generateInput diagram.GenerateInput
+		if v, ok := input.(diagram.GenerateInput); ok {
+			generateInput = v
+		}
+		contentSystemGenerate := systemContent(contentSystem, generateInput.OutputLanguage, input.GetPrompt())
+
+		redaction := opts.redactor.Redact(input.GetPrompt())
+
+		var verdict diagram.GuardVerdict
+		if opts.guard != nil {
+			var err error
+			verdict, err = opts.guard.Inspect(ctx, input.GetRequestID(), input.GetUserID(), redaction.Text)
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		if clientRepositoryPrediction != nil {
 			if err := clientRepositoryPrediction.WriteInputPrompt(
-				ctx, input.GetRequestID(), input.GetUserID(), input.GetPrompt(),
+				ctx, input.GetRequestID(), input.GetUserID(), redaction.Text,
 			); err != nil {
 				// FIXME: add proper logging
 				log.Printf("clientRepositoryPrediction.WriteInputPrompt err: %+v", err)
@@ -80,12 +212,29 @@ func NewC4ContainersHTTPHandler(
 		}
 
 		predictionRaw, diagramPrediction, usageTokensPrompt, usageTokensCompletions, err := clientModelInference.Do(
-			ctx, input.GetPrompt(), contentSystem, model,
+			ctx, redaction.Text, contentSystemGenerate, model,
 		)
 		if err != nil {
 			return nil, errors.New(err.Error())
 		}
 
+		diagramGraph, issues, err := parseGraph(diagramPrediction)
+		if issues.hasErrors() {
+			// the model is asked to fix the graph once if it cannot be repaired automatically
+			raw, prediction, tokensPrompt, tokensCompletions, errRetry := clientModelInference.Do(
+				ctx, retryPrompt(redaction.Text, issues.errors()), contentSystemGenerate, model,
+			)
+			if errRetry != nil {
+				// FIXME: add proper logging
+				log.Printf("clientModelInference.Do retry err: %+v", errRetry)
+			} else {
+				predictionRaw, diagramPrediction = raw, prediction
+				usageTokensPrompt += tokensPrompt
+				usageTokensCompletions += tokensCompletions
+				diagramGraph, issues, err = parseGraph(diagramPrediction)
+			}
+		}
+
 		if clientRepositoryPrediction != nil {
 			if err := clientRepositoryPrediction.WriteModelResult(
 				ctx, input.GetRequestID(), input.GetUserID(), predictionRaw, string(diagramPrediction), model,
@@ -96,16 +245,16 @@ func NewC4ContainersHTTPHandler(
 			}
 		}
 
-		if err := errors.NewPredictionError(diagramPrediction); err != nil {
+		if err != nil {
 			return nil, err
 		}
-
-		var diagramGraph c4ContainersGraph
-		if err := json.Unmarshal(diagramPrediction, &diagramGraph); err != nil {
-			return nil, err
+		if issues.hasErrors() {
+			return nil, graphValidationError{Findings: issues.errors()}
 		}
+		diagramGraph.restoreLabels(redaction)
+		diagramGraph.applyLayout(generateInput.Layout)
 
-		diagramPostRendering, err := renderDiagram(ctx, httpClient, &diagramGraph)
+		diagramPostRendering, err := renderDiagram(ctx, httpClient, diagramGraph, opts.c4PlantUML)
 		if err != nil {
 			return nil, err
 		}
@@ -119,11 +268,67 @@ func NewC4ContainersHTTPHandler(
 			}
 		}
 
-		return diagram.NewResultSVG(diagramPostRendering)
+		return diagram.NewResultSVG(diagramPostRendering, verdict.Warning())
 
 	}, nil
 }
 
+// parseGraph deserializes the model's prediction, validates and repairs the graph.
+// The findings contain the error if the prediction is not a valid JSON,
+// the error is returned without findings if the model failed to predict the graph.
+func parseGraph(prediction []byte) (*c4ContainersGraph, findings, error) {
+	if err := errors.NewPredictionError(prediction); err != nil {
+		return nil, nil, err
+	}
+
+	var o c4ContainersGraph
+	if err := json.Unmarshal(prediction, &o); err != nil {
+		return nil, findings{
+			{
+				Code: findingInvalidJSON, Severity: severityError, Element: "graph",
+				Message: "output must be valid JSON: " + err.Error(),
+			},
+		}, err
+	}
+
+	return &o, validateGraph(&o), nil
+}
+
+// retryPrompt defines the prompt to ask the model to fix the issues of the graph.
+func retryPrompt(prompt string, issues findings) string {
+	return prompt + "\nThe output graph must fix the issues: " + issues.String()
+}
+
+// systemContent adds the instruction to write the texts in the output language to the system content.
+// The output language is the language requested by the user, or the language of the prompt.
+// The instruction is omitted for English, or if the language cannot be detected.
+func systemContent(content string, language diagram.Language, prompt string) string {
+	if language.IsEmpty() {
+		language = diagram.DetectLanguage(prompt)
+	}
+	if language.IsEmpty() || language == "en" {
+		return content
+	}
+	return content + "\nWrite all labels, descriptions, titles and texts in " + language.Name() +
+		", keep json keys, ids, technologies and enumerated values in English."
+}
+
+// inspectModelInput inspects the redacted model's input with the guard, unless the guard is not set.
+// It returns the warnings if the input is flagged, and GuardError if the input is rejected.
+func (o handlerOptions) inspectModelInput(ctx context.Context, input diagram.Input, text string) ([]string, error) {
+	if o.guard == nil {
+		return nil, nil
+	}
+	verdict, err := o.guard.Inspect(ctx, input.GetRequestID(), input.GetUserID(), text)
+	if err != nil {
+		return nil, err
+	}
+	if w := verdict.Warning(); w != "" {
+		return []string{w}, nil
+	}
+	return nil, nil
+}
+
 const model = "gpt-3.5-turbo"
 
 const contentSystem =
@@ -132,6 +337,16 @@ const contentSystem =
 	`Every node has id,label,group,technology as strings, and external,queue,database,user as bool.` +
 	`Every link connects nodes using their id:from,to. It also has label,technology and direction as strings.` +
 	`Every json has title and footer as string.` +
+	`Nodes are grouped into boundaries using boundary's id as node's group. ` +
+	`Every boundary has id,label,parent as strings, and type as one of enterprise,system,container. ` +
+	`Nodes, links and boundaries have tags as array of strings, and link as URL. ` +
+	`Every node has sprite as icon path, e.g. devicons2/go, or font-awesome-5/users. ` +
+	`Styles of tagged nodes are defined in element_tags with tag,bg_color,font_color,border_color,` +
+	`and shape as rounded or eight_sided. Styles of tagged links are defined in link_tags with tag,text_color,` +
+	`line_color, and line_style as dashed,dotted or bold.` +
+	`Every json has layout with direction as top_down,left_right or landscape, spacing as compact or wide, ` +
+	`theme as default,dark or corporate, and sketch,hide_stereotypes,legend_in_layout as bool.` +
+	`Prompt can be written in any language, the examples are in English. ` +
 	`Output JSON. If error, return {"error": {{detailed decision explanation}} }` + "\n" +
 
 	// example
@@ -188,6 +403,24 @@ const contentSystem =
 	`"links":[{"from":"0","to":"1","label":"Uses","technology":"HTTP","direction":"LR"},` +
 	`{"from":"1","to":"2","label":"Uses","technology":"HTTP","direction":"LR"}]}` +
 
+	// example
+	`acme enterprise with shop system of go api reading from postgres and calling external payments asynchronously` +
+	"\n" +
+	`{"boundaries":[{"id":"acme","label":"ACME","type":"enterprise"},` +
+	`{"id":"shop","label":"Shop","type":"system","parent":"acme"}],` +
+	`"nodes":[{"id":"0","label":"API","technology":"Go","group":"shop","sprite":"devicons2/go"},` +
+	`{"id":"1","label":"Database","technology":"Postgres","database":true,"group":"shop",` +
+	`"sprite":"devicons2/postgresql"},{"id":"2","label":"Payments","external":true}],` +
+	`"links":[{"from":"0","to":"1","label":"reads from","technology":"TCP","direction":"LR"},` +
+	`{"from":"0","to":"2","label":"calls","technology":"HTTP","tags":["async"]}],` +
+	`"link_tags":[{"tag":"async","line_style":"dashed"}]}` + "\n" +
+
+	// example
+	`dark hand-drawn diagram from left to right: react spa calling node api` + "\n" +
+	`{"nodes":[{"id":"0","label":"SPA","technology":"React"},{"id":"1","label":"API","technology":"Node.js"}],` +
+	`"links":[{"from":"0","to":"1","label":"calls","technology":"HTTP"}],` +
+	`"layout":{"direction":"left_right","theme":"dark","sketch":true}}` + "\n" +
+
 	// example
 	`anna calls bob` + "\n" +
 	`{"nodes":[{"id":"0","label":"Anna","user":true},{"id":"1","label":"Bob","user":true}],` +
@@ -0,0 +1,137 @@
+package c4container
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/kislerdm/diagramastext/server/core/errors"
+)
+
+// Modes to include the C4-PlantUML library into the diagram's code.
+const (
+	// C4PlantUMLIncludeStdlib includes the C4-PlantUML library bundled with the PlantUML renderer.
+	// Note that the library's version is defined by the renderer, i.e. DefaultC4PlantUMLVersion is not enforced.
+	C4PlantUMLIncludeStdlib = "stdlib"
+	// C4PlantUMLIncludeInline inlines the vendored C4-PlantUML library into the diagram's code.
+	C4PlantUMLIncludeInline = "inline"
+)
+
+// DefaultC4PlantUMLVersion defines the version of the vendored C4-PlantUML library.
+// The version is pinned only in the inline mode, see C4PlantUMLIncludeStdlib.
+const DefaultC4PlantUMLVersion = "v2.8.0"
+
+// c4PlantUMLStdlib defines the directive to include the library from the PlantUML standard library.
+const c4PlantUMLStdlib = "!include <C4/C4_Container>"
+
+// c4PlantUMLEntrypoint defines the library's file with the containers' definitions.
+const c4PlantUMLEntrypoint = "C4_Container.puml"
+
+// c4PlantUMLFS contains the vendored versions of the C4-PlantUML library in the directories named after versions.
+// The library's version is vendored by running `make vendor-c4plantuml C4PLANTUML_VERSION=<version>`.
+//
+//go:embed c4plantuml
+var c4PlantUMLFS embed.FS
+
+// C4PlantUML defines how the C4-PlantUML library is included into the diagram's code.
+// The zero value includes the library from the PlantUML standard library.
+type C4PlantUML struct {
+	include string
+}
+
+// NewC4PlantUML initialises the C4-PlantUML library. The version is used to select the vendored library
+// which is inlined into the diagram's code, the stdlib mode ignores the version and uses the one bundled
+// with the PlantUML renderer.
+func NewC4PlantUML(include, version string) (C4PlantUML, error) {
+	fsys, err := fs.Sub(c4PlantUMLFS, "c4plantuml")
+	if err != nil {
+		return C4PlantUML{}, errors.New(err.Error())
+	}
+	return newC4PlantUML(fsys, include, version)
+}
+
+func newC4PlantUML(fsys fs.FS, include, version string) (C4PlantUML, error) {
+	switch include {
+	case "", C4PlantUMLIncludeStdlib:
+		return C4PlantUML{}, nil
+	case C4PlantUMLIncludeInline:
+		if version == "" {
+			version = DefaultC4PlantUMLVersion
+		}
+
+		lib, err := fs.Sub(fsys, version)
+		if err != nil {
+			return C4PlantUML{}, errors.New(err.Error())
+		}
+
+		var o bytes.Buffer
+		if err := inlineC4PlantUML(&o, lib, c4PlantUMLEntrypoint, map[string]struct{}{}); err != nil {
+			return C4PlantUML{}, errors.New("C4-PlantUML " + version + " is not vendored: " + err.Error())
+		}
+		return C4PlantUML{include: strings.TrimSuffix(o.String(), "\n")}, nil
+	default:
+		return C4PlantUML{}, errors.New("unknown C4-PlantUML include mode " + include)
+	}
+}
+
+// dsl returns the diagram's code to include the library.
+func (l C4PlantUML) dsl() string {
+	if l.include == "" {
+		return c4PlantUMLStdlib
+	}
+	return l.include
+}
+
+// inlineC4PlantUML writes the library's file preceded by its vendored dependencies.
+// Every vendored file is written once, the include directives of the vendored files are removed,
+// so the library does not depend on the files resolved at render time.
+func inlineC4PlantUML(o *bytes.Buffer, fsys fs.FS, name string, inlined map[string]struct{}) error {
+	inlined[name] = struct{}{}
+
+	v, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+
+	var content bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(v))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(v)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if dependency, ok := vendoredInclude(fsys, line); ok {
+			if _, ok := inlined[dependency]; !ok {
+				if err := inlineC4PlantUML(o, fsys, dependency, inlined); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		writeStrings(&content, line, "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	_, _ = o.Write(content.Bytes())
+	return nil
+}
+
+// vendoredInclude returns the vendored file's name if the line includes it.
+func vendoredInclude(fsys fs.FS, line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "!include") {
+		return "", false
+	}
+
+	name := path.Base(strings.Trim(fields[len(fields)-1], `<>"`))
+	if path.Ext(name) == "" {
+		name += ".puml"
+	}
+	if _, err := fs.Stat(fsys, name); err != nil {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,134 @@
+package c4container
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func Test_newC4PlantUML(t *testing.T) {
+	fsys := fstest.MapFS{
+		"v1.0.0/C4.puml": {Data: []byte(`' C4
+!$C4Version = "1.0.0"`)},
+		"v1.0.0/C4_Context.puml": {Data: []byte(`' C4_Context
+!if %variable_exists("RELATIVE_INCLUDE")
+  !include %get_variable_value("RELATIVE_INCLUDE")/C4.puml
+!else
+  !include https://raw.githubusercontent.com/plantuml-stdlib/C4-PlantUML/master/C4.puml
+!endif
+!include <tupadr3/common>
+!procedure Person($alias, $label)
+!endprocedure`)},
+		"v1.0.0/C4_Container.puml": {Data: []byte(`' C4_Container
+!include C4_Context.puml
+!include_once C4.puml
+!procedure Container($alias, $label)
+!endprocedure`)},
+	}
+
+	tests := []struct {
+		name    string
+		include string
+		version string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: "!include <C4/C4_Container>",
+		},
+		{
+			name:    "stdlib",
+			include: C4PlantUMLIncludeStdlib,
+			version: "v1.0.0",
+			want:    "!include <C4/C4_Container>",
+		},
+		{
+			name:    "inline",
+			include: C4PlantUMLIncludeInline,
+			version: "v1.0.0",
+			want: `' C4
+!$C4Version = "1.0.0"
+' C4_Context
+!if %variable_exists("RELATIVE_INCLUDE")
+!else
+!endif
+!include <tupadr3/common>
+!procedure Person($alias, $label)
+!endprocedure
+' C4_Container
+!procedure Container($alias, $label)
+!endprocedure`,
+		},
+		{
+			name:    "unhappy path: version is not vendored",
+			include: C4PlantUMLIncludeInline,
+			version: "v0.0.1",
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: unknown include mode",
+			include: "foo",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := newC4PlantUML(fsys, tt.include, tt.version)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("newC4PlantUML() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && got.dsl() != tt.want {
+					t.Errorf("newC4PlantUML() got = %v, want %v", got.dsl(), tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestNewC4PlantUML(t *testing.T) {
+	got, err := NewC4PlantUML(C4PlantUMLIncludeStdlib, DefaultC4PlantUMLVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.dsl() != c4PlantUMLStdlib {
+		t.Errorf("unexpected include: %s", got.dsl())
+	}
+}
+
+func TestNewC4PlantUMLInlineVendored(t *testing.T) {
+	if _, err := fs.Stat(c4PlantUMLFS, "c4plantuml/"+DefaultC4PlantUMLVersion); err != nil {
+		t.Skipf("C4-PlantUML %s is not vendored, run make vendor-c4plantuml", DefaultC4PlantUMLVersion)
+	}
+
+	// GIVEN
+	lib, err := NewC4PlantUML(C4PlantUMLIncludeInline, DefaultC4PlantUMLVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// WHEN
+	dsl, err := marshal(&c4ContainersGraph{Containers: []*container{{ID: "0", Label: "foo"}}}, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, err := plantUMLRequest(dsl)
+
+	// THEN
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(lib.dsl(), "!include C4") {
+		t.Error("the library's files shall be inlined")
+	}
+	if len(route) <= maxPlantUMLRouteLength {
+		t.Errorf("the inlined library is expected to exceed the GET route limit, got: %d", len(route))
+	}
+	if want := `c4Version = "` + strings.TrimPrefix(DefaultC4PlantUMLVersion, "v") + `"`; !strings.Contains(
+		lib.dsl(), want,
+	) {
+		t.Errorf("the vendored library is expected to be of the version %s", DefaultC4PlantUMLVersion)
+	}
+}
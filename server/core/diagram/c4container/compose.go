@@ -0,0 +1,145 @@
+package c4container
+
+import (
+	"errors"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// extractDockerCompose extracts the graph from the docker-compose file: the services define the containers,
+// the dependencies, links, and the hosts referenced in the environment variables define the relations.
+func extractDockerCompose(v []byte) (*c4ContainersGraph, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(v, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("the document must be a mapping")
+	}
+	root := doc.Content[0]
+
+	o := newInfraGraph()
+	if name := yamlMappingValue(root, "name"); name != nil && name.Kind == yaml.ScalarNode {
+		o.graph.Title = name.Value
+	}
+
+	services := yamlMappingValue(root, "services")
+	if services == nil {
+		return o.graph, nil
+	}
+	if services.Kind != yaml.MappingNode {
+		return nil, errors.New("services must be a mapping")
+	}
+
+	// hosts maps the services' names, container names, hostnames and aliases to the services
+	hosts := map[string]string{}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name, spec := services.Content[i].Value, services.Content[i+1]
+
+		o.addNode(
+			&container{
+				ID:         name,
+				Label:      name,
+				Technology: imageTechnology(yamlScalar(yamlMappingValue(spec, "image"))),
+			},
+		)
+
+		hosts[name] = name
+		for _, k := range []string{"container_name", "hostname"} {
+			if host := yamlScalar(yamlMappingValue(spec, k)); host != "" {
+				hosts[host] = name
+			}
+		}
+	}
+
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name, spec := services.Content[i].Value, services.Content[i+1]
+
+		for _, dependency := range yamlKeys(yamlMappingValue(spec, "depends_on")) {
+			o.addRel(name, dependency, "depends on")
+		}
+
+		for _, link := range yamlKeys(yamlMappingValue(spec, "links")) {
+			service, _, _ := strings.Cut(link, ":")
+			o.addRel(name, service, "uses")
+		}
+
+		for _, token := range hostTokens(yamlValues(yamlMappingValue(spec, "environment"))...) {
+			if service, ok := hosts[token]; ok {
+				o.addRel(name, service, "uses")
+			}
+		}
+	}
+
+	return o.graph, nil
+}
+
+// yamlMappingValue returns the value of the mapping node's key, or nil if the key is not found.
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlScalar returns the value of the scalar node, or empty string otherwise.
+func yamlScalar(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+// yamlKeys returns the elements of the sequence node, or the keys of the mapping node.
+func yamlKeys(node *yaml.Node) []string {
+	if node == nil {
+		return nil
+	}
+
+	var o []string
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, el := range node.Content {
+			if el.Kind == yaml.ScalarNode {
+				o = append(o, el.Value)
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			o = append(o, node.Content[i].Value)
+		}
+	}
+	return o
+}
+
+// yamlValues returns the values of the environment variables defined as the sequence of KEY=VALUE,
+// or as the mapping.
+func yamlValues(node *yaml.Node) []string {
+	if node == nil {
+		return nil
+	}
+
+	var o []string
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, el := range node.Content {
+			if el.Kind != yaml.ScalarNode {
+				continue
+			}
+			if _, value, ok := strings.Cut(el.Value, "="); ok {
+				o = append(o, value)
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			o = append(o, yamlScalar(node.Content[i]))
+		}
+	}
+	return o
+}
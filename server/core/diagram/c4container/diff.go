@@ -0,0 +1,538 @@
+package c4container
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+	"github.com/kislerdm/diagramastext/server/core/errors"
+	"github.com/kislerdm/diagramastext/server/core/internal/utils"
+)
+
+// NewC4ContainersDiffHTTPHandler initialises the httphandler to compare two versions of C4 containers diagram.
+// The diagram highlights the added, removed and modified elements, the response also contains the changes.
+func NewC4ContainersDiffHTTPHandler(httpClient diagram.HTTPClient, fnOps ...HTTPHandlerOps) (
+	diagram.HTTPHandler, error,
+) {
+	if httpClient == nil {
+		return nil, errors.New("http client must be provided")
+	}
+
+	var opts handlerOptions
+	for _, o := range fnOps {
+		if o != nil {
+			o(&opts)
+		}
+	}
+
+	return func(ctx context.Context, input diagram.Input) (diagram.Output, error) {
+		if err := input.Validate(); err != nil {
+			return nil, err
+		}
+
+		diffInput, ok := input.(diagram.DiffInput)
+		if !ok || diffInput.Diff.IsEmpty() {
+			return nil, diagram.InputError{Reason: "graphs before and after must be provided"}
+		}
+		d := diffInput.Diff
+
+		before, err := parseInputGraph(d.Before, "before")
+		if err != nil {
+			return nil, err
+		}
+		after, err := parseInputGraph(d.After, "after")
+		if err != nil {
+			return nil, err
+		}
+
+		changes, ids := diffGraphs(before, after)
+
+		diagramGraph := highlightDiff(before, after, changes, ids)
+		diagramGraph.applyLayout(diffInput.Layout)
+
+		diagramPostRendering, err := renderDiagram(ctx, httpClient, diagramGraph, opts.c4PlantUML)
+		if err != nil {
+			return nil, err
+		}
+
+		return newDiffResult(diagramPostRendering, changes)
+	}, nil
+}
+
+// parseInputGraph deserializes, validates and repairs the graph provided by the user.
+// The graph is provided as JSON object, or as JSON string with the C4-PlantUML containers diagram's code.
+func parseInputGraph(v []byte, name string) (*c4ContainersGraph, error) {
+	reason := "invalid graph"
+	if name != "" {
+		reason += " " + name
+	}
+
+	var dsl string
+	if err := json.Unmarshal(v, &dsl); err == nil {
+		o, err := parse([]byte(dsl))
+		if err != nil {
+			// parse fails with the syntax error, or if the diagram contains no containers
+			msg := "no containers found"
+			if errSyntax, ok := err.(dslSyntaxError); ok {
+				msg = errSyntax.Error()
+			}
+			return nil, diagram.InputError{Reason: reason + ": " + msg}
+		}
+		if issues := validateGraph(o); issues.hasErrors() {
+			return nil, diagram.InputError{Reason: reason + ": " + issues.errors().String()}
+		}
+		return o, nil
+	}
+
+	o, issues, err := parseGraph(v)
+	if issues.hasErrors() {
+		return nil, diagram.InputError{Reason: reason + ": " + issues.errors().String()}
+	}
+	if err != nil {
+		return nil, diagram.InputError{Reason: reason}
+	}
+	return o, nil
+}
+
+// diffResult defines the diagram highlighting the changes, and the changes.
+type diffResult struct {
+	SVG  string    `json:"svg"`
+	Diff graphDiff `json:"diff"`
+}
+
+func newDiffResult(svg []byte, diff graphDiff) (diagram.Output, error) {
+	if err := utils.ValidateSVG(svg); err != nil {
+		return nil, err
+	}
+	return diffResult{SVG: string(svg), Diff: diff}, nil
+}
+
+func (r diffResult) Serialize() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// Statuses of the graph's elements changes, the statuses are also used as the tags to highlight the changes.
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeModified = "modified"
+)
+
+// labelSimilarityMin defines the minimal similarity of the labels to match the nodes with different ids.
+const labelSimilarityMin = 0.8
+
+// labelSimilarityPairsMax defines the max number of the unmatched nodes' pairs to compare the labels of,
+// the nodes are matched by their ids only above the limit to bound the cost of the comparison.
+const labelSimilarityPairsMax = 10_000
+
+// labelSimilarityLengthMax defines the max number of the label's characters compared to match the nodes.
+const labelSimilarityLengthMax = 100
+
+// attributeChange defines the change of the element's attribute.
+type attributeChange struct {
+	Attribute string `json:"attribute"`
+	Before    string `json:"before,omitempty"`
+	After     string `json:"after,omitempty"`
+}
+
+// nodeChange defines the change of the node.
+type nodeChange struct {
+	// ID defines the node's id in the graph after the change, or before the change if the node was removed.
+	ID string `json:"id"`
+	// BeforeID defines the node's id in the graph before the change if the node was matched by the label.
+	BeforeID string            `json:"before_id,omitempty"`
+	Status   string            `json:"status"`
+	Changes  []attributeChange `json:"changes,omitempty"`
+}
+
+// linkChange defines the change of the link, the link's ends are identified by the nodes' ids in the graph
+// after the change, the ids of the removed nodes are used for the links of the removed nodes.
+type linkChange struct {
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	Status  string            `json:"status"`
+	Changes []attributeChange `json:"changes,omitempty"`
+}
+
+// graphDiff defines the changes between two versions of the graph.
+type graphDiff struct {
+	Nodes []nodeChange `json:"nodes,omitempty"`
+	Links []linkChange `json:"links,omitempty"`
+}
+
+// diffGraphs compares two versions of the graph. The nodes are matched by their ids,
+// the remaining nodes are matched by their labels' similarity.
+// It returns the changes, and the mapping of the nodes' ids before the change to the ids after the change.
+func diffGraphs(before, after *c4ContainersGraph) (graphDiff, map[string]string) {
+	ids := matchNodes(before.Containers, after.Containers)
+
+	var o graphDiff
+
+	beforeNodes := map[string]*container{}
+	for _, n := range before.Containers {
+		beforeNodes[n.ID] = n
+	}
+	matched := map[string]string{}
+	for beforeID, afterID := range ids {
+		matched[afterID] = beforeID
+	}
+
+	for _, n := range after.Containers {
+		beforeID, ok := matched[n.ID]
+		if !ok {
+			o.Nodes = append(o.Nodes, nodeChange{ID: n.ID, Status: changeAdded})
+			continue
+		}
+
+		changes := diffNodes(beforeNodes[beforeID], n)
+		if beforeID != n.ID {
+			changes = append([]attributeChange{{Attribute: "id", Before: beforeID, After: n.ID}}, changes...)
+		} else {
+			beforeID = ""
+		}
+		if len(changes) > 0 {
+			o.Nodes = append(
+				o.Nodes, nodeChange{ID: n.ID, BeforeID: beforeID, Status: changeModified, Changes: changes},
+			)
+		}
+	}
+
+	for _, n := range before.Containers {
+		if _, ok := ids[n.ID]; !ok {
+			o.Nodes = append(o.Nodes, nodeChange{ID: n.ID, Status: changeRemoved})
+		}
+	}
+
+	links := matchLinks(before.Rels, after.Rels, ids)
+	for _, l := range after.Rels {
+		v, ok := links[l]
+		if !ok {
+			o.Links = append(o.Links, linkChange{From: l.From, To: l.To, Status: changeAdded})
+			continue
+		}
+		if changes := diffLinks(v, l); len(changes) > 0 {
+			o.Links = append(
+				o.Links, linkChange{From: l.From, To: l.To, Status: changeModified, Changes: changes},
+			)
+		}
+	}
+
+	for _, l := range removedLinks(before.Rels, links) {
+		o.Links = append(
+			o.Links, linkChange{From: mappedID(ids, l.From), To: mappedID(ids, l.To), Status: changeRemoved},
+		)
+	}
+
+	return o, ids
+}
+
+// matchLinks returns the mapping of the links after the change to the links before the change.
+// The links are matched by their ends, the links with the same ends are matched with the unchanged links first,
+// and in their order then.
+func matchLinks(before, after []*rel, ids map[string]string) map[*rel]*rel {
+	beforeLinks := map[[2]string][]*rel{}
+	for _, l := range before {
+		k := [2]string{mappedID(ids, l.From), mappedID(ids, l.To)}
+		beforeLinks[k] = append(beforeLinks[k], l)
+	}
+
+	o := map[*rel]*rel{}
+	matched := map[*rel]struct{}{}
+	for _, unchangedOnly := range []bool{true, false} {
+		for _, l := range after {
+			if _, ok := o[l]; ok {
+				continue
+			}
+			for _, v := range beforeLinks[[2]string{l.From, l.To}] {
+				if _, ok := matched[v]; ok {
+					continue
+				}
+				if unchangedOnly && len(diffLinks(v, l)) > 0 {
+					continue
+				}
+				o[l] = v
+				matched[v] = struct{}{}
+				break
+			}
+		}
+	}
+
+	return o
+}
+
+// removedLinks returns the links before the change which are not matched by the links after the change.
+func removedLinks(before []*rel, links map[*rel]*rel) []*rel {
+	matched := map[*rel]struct{}{}
+	for _, v := range links {
+		matched[v] = struct{}{}
+	}
+
+	var o []*rel
+	for _, l := range before {
+		if _, ok := matched[l]; !ok {
+			o = append(o, l)
+		}
+	}
+	return o
+}
+
+func mappedID(ids map[string]string, id string) string {
+	if v, ok := ids[id]; ok {
+		return v
+	}
+	return id
+}
+
+// matchNodes returns the mapping of the nodes' ids before the change to the ids after the change.
+func matchNodes(before, after []*container) map[string]string {
+	o := map[string]string{}
+
+	afterIDs := map[string]struct{}{}
+	for _, n := range after {
+		afterIDs[n.ID] = struct{}{}
+	}
+
+	var unmatchedBefore []*container
+	for _, n := range before {
+		if _, ok := afterIDs[n.ID]; ok {
+			o[n.ID] = n.ID
+			continue
+		}
+		unmatchedBefore = append(unmatchedBefore, n)
+	}
+
+	var unmatchedAfter []*container
+	for _, n := range after {
+		if _, ok := o[n.ID]; !ok {
+			unmatchedAfter = append(unmatchedAfter, n)
+		}
+	}
+
+	if len(unmatchedBefore)*len(unmatchedAfter) > labelSimilarityPairsMax {
+		return o
+	}
+
+	type candidate struct {
+		before, after int
+		score         float64
+	}
+	var candidates []candidate
+	for i, b := range unmatchedBefore {
+		for j, a := range unmatchedAfter {
+			if score := labelSimilarity(nodeName(b), nodeName(a)); score >= labelSimilarityMin {
+				candidates = append(candidates, candidate{before: i, after: j, score: score})
+			}
+		}
+	}
+	sort.SliceStable(
+		candidates, func(i, j int) bool {
+			return candidates[i].score > candidates[j].score
+		},
+	)
+
+	matchedAfter := map[int]struct{}{}
+	for _, c := range candidates {
+		id := unmatchedBefore[c.before].ID
+		if _, ok := o[id]; ok {
+			continue
+		}
+		if _, ok := matchedAfter[c.after]; ok {
+			continue
+		}
+		o[id] = unmatchedAfter[c.after].ID
+		matchedAfter[c.after] = struct{}{}
+	}
+
+	return o
+}
+
+func nodeName(n *container) string {
+	if n.Label != "" {
+		return n.Label
+	}
+	return n.ID
+}
+
+// labelSimilarity returns the similarity of the labels between 0 and 1 based on the edit distance
+// of the labels' letters and digits in lower case.
+func labelSimilarity(a, b string) float64 {
+	normalize := func(s string) []rune {
+		var o []rune
+		for _, r := range strings.ToLower(s) {
+			if len(o) == labelSimilarityLengthMax {
+				break
+			}
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				o = append(o, r)
+			}
+		}
+		return o
+	}
+
+	x, y := normalize(a), normalize(b)
+	if len(x) == 0 || len(y) == 0 {
+		return 0
+	}
+
+	// Levenshtein distance using two rows
+	prev := make([]int, len(y)+1)
+	cur := make([]int, len(y)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		cur[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	maxLen := len(x)
+	if len(y) > maxLen {
+		maxLen = len(y)
+	}
+	return 1 - float64(prev[len(y)])/float64(maxLen)
+}
+
+func minInt(v int, values ...int) int {
+	for _, el := range values {
+		if el < v {
+			v = el
+		}
+	}
+	return v
+}
+
+func diffNodes(before, after *container) []attributeChange {
+	return diffAttributes(
+		[][3]string{
+			{"label", before.Label, after.Label},
+			{"technology", before.Technology, after.Technology},
+			{"description", before.Description, after.Description},
+			{"group", before.System, after.System},
+			{"external", strconv.FormatBool(before.IsExternal), strconv.FormatBool(after.IsExternal)},
+			{"queue", strconv.FormatBool(before.IsQueue), strconv.FormatBool(after.IsQueue)},
+			{"database", strconv.FormatBool(before.IsDatabase), strconv.FormatBool(after.IsDatabase)},
+			{"user", strconv.FormatBool(before.IsUser), strconv.FormatBool(after.IsUser)},
+			{"sprite", before.Sprite, after.Sprite},
+			{"tags", strings.Join(before.Tags, ","), strings.Join(after.Tags, ",")},
+			{"link", before.Link, after.Link},
+		},
+	)
+}
+
+func diffLinks(before, after *rel) []attributeChange {
+	return diffAttributes(
+		[][3]string{
+			{"label", before.Label, after.Label},
+			{"technology", before.Technology, after.Technology},
+			{"direction", before.Direction, after.Direction},
+			{"tags", strings.Join(before.Tags, ","), strings.Join(after.Tags, ",")},
+			{"link", before.Link, after.Link},
+		},
+	)
+}
+
+// diffAttributes returns the changes of the attributes defined as the triplets of name, value before and after.
+func diffAttributes(attributes [][3]string) []attributeChange {
+	var o []attributeChange
+	for _, a := range attributes {
+		if a[1] != a[2] {
+			o = append(o, attributeChange{Attribute: a[0], Before: a[1], After: a[2]})
+		}
+	}
+	return o
+}
+
+// diffTagStyles defines the styles to highlight the changes.
+var (
+	diffElementTags = []*elementTag{
+		{Tag: changeAdded, BgColor: "#2E7D32", FontColor: "#FFFFFF", BorderColor: "#1B5E20"},
+		{Tag: changeRemoved, BgColor: "#C62828", FontColor: "#FFFFFF", BorderColor: "#8E0000"},
+		{Tag: changeModified, BgColor: "#F9A825", FontColor: "#000000", BorderColor: "#C17900"},
+	}
+	diffRelTags = []*relTag{
+		{Tag: changeAdded, TextColor: "#2E7D32", LineColor: "#2E7D32", LineStyle: "bold"},
+		{Tag: changeRemoved, TextColor: "#C62828", LineColor: "#C62828", LineStyle: "dashed"},
+		{Tag: changeModified, TextColor: "#C17900", LineColor: "#C17900"},
+	}
+)
+
+// highlightDiff returns the graph after the change with the removed nodes and links,
+// the added, removed and modified elements are tagged with the change's status.
+// The links are matched the same way as by diffGraphs.
+func highlightDiff(before, after *c4ContainersGraph, diff graphDiff, ids map[string]string) *c4ContainersGraph {
+	o := *after
+	o.WithLegend = true
+	o.ElementTags = append(append([]*elementTag{}, diffElementTags...), after.ElementTags...)
+	o.RelTags = append(append([]*relTag{}, diffRelTags...), after.RelTags...)
+
+	nodeStatus := map[string]string{}
+	for _, n := range diff.Nodes {
+		nodeStatus[n.ID] = n.Status
+	}
+
+	o.Containers = make([]*container, 0, len(after.Containers))
+	for _, n := range after.Containers {
+		o.Containers = append(o.Containers, taggedNode(n, nodeStatus[n.ID]))
+	}
+	for _, n := range before.Containers {
+		if _, ok := ids[n.ID]; !ok {
+			o.Containers = append(o.Containers, taggedNode(n, changeRemoved))
+		}
+	}
+
+	links := matchLinks(before.Rels, after.Rels, ids)
+	o.Rels = make([]*rel, 0, len(after.Rels))
+	for _, l := range after.Rels {
+		v := *l
+		status := changeAdded
+		if beforeLink, ok := links[l]; ok {
+			status = ""
+			if len(diffLinks(beforeLink, l)) > 0 {
+				status = changeModified
+			}
+		}
+		if status != "" {
+			v.Tags = append([]string{status}, l.Tags...)
+		}
+		o.Rels = append(o.Rels, &v)
+	}
+
+	for _, l := range removedLinks(before.Rels, links) {
+		v := *l
+		v.From, v.To = mappedID(ids, l.From), mappedID(ids, l.To)
+		v.Tags = append([]string{changeRemoved}, l.Tags...)
+		o.Rels = append(o.Rels, &v)
+	}
+
+	o.Boundaries = append([]*boundary{}, after.Boundaries...)
+	boundaries := map[string]struct{}{}
+	for _, b := range after.Boundaries {
+		boundaries[b.ID] = struct{}{}
+	}
+	for _, b := range before.Boundaries {
+		if _, ok := boundaries[b.ID]; !ok {
+			o.Boundaries = append(o.Boundaries, b)
+		}
+	}
+
+	return &o
+}
+
+func taggedNode(n *container, status string) *container {
+	v := *n
+	if status != "" {
+		v.Tags = append([]string{status}, n.Tags...)
+	}
+	return &v
+}
@@ -0,0 +1,460 @@
+package c4container
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+)
+
+func Test_diffGraphs(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  *c4ContainersGraph
+		after   *c4ContainersGraph
+		want    graphDiff
+		wantIDs map[string]string
+	}{
+		{
+			name: "shall report no changes",
+			before: &c4ContainersGraph{
+				Containers: []*container{{ID: "0", Label: "Web"}, {ID: "1", Label: "DB", IsDatabase: true}},
+				Rels:       []*rel{{From: "0", To: "1", Label: "reads"}},
+			},
+			after: &c4ContainersGraph{
+				Containers: []*container{{ID: "1", Label: "DB", IsDatabase: true}, {ID: "0", Label: "Web"}},
+				Rels:       []*rel{{From: "0", To: "1", Label: "reads"}},
+			},
+			wantIDs: map[string]string{"0": "0", "1": "1"},
+		},
+		{
+			name: "shall report added, removed and modified nodes and links",
+			before: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Label: "Web", Technology: "Go"},
+					{ID: "1", Label: "DB", IsDatabase: true},
+					{ID: "2", Label: "Cache"},
+				},
+				Rels: []*rel{
+					{From: "0", To: "1", Label: "reads"},
+					{From: "0", To: "2", Label: "reads"},
+				},
+			},
+			after: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Label: "Web", Technology: "Rust"},
+					{ID: "1", Label: "DB", IsDatabase: true},
+					{ID: "3", Label: "Queue", IsQueue: true},
+				},
+				Rels: []*rel{
+					{From: "0", To: "1", Label: "reads and writes", Technology: "TCP"},
+					{From: "0", To: "3", Label: "publishes"},
+				},
+			},
+			want: graphDiff{
+				Nodes: []nodeChange{
+					{
+						ID: "0", Status: changeModified,
+						Changes: []attributeChange{{Attribute: "technology", Before: "Go", After: "Rust"}},
+					},
+					{ID: "3", Status: changeAdded},
+					{ID: "2", Status: changeRemoved},
+				},
+				Links: []linkChange{
+					{
+						From: "0", To: "1", Status: changeModified,
+						Changes: []attributeChange{
+							{Attribute: "label", Before: "reads", After: "reads and writes"},
+							{Attribute: "technology", After: "TCP"},
+						},
+					},
+					{From: "0", To: "3", Status: changeAdded},
+					{From: "0", To: "2", Status: changeRemoved},
+				},
+			},
+			wantIDs: map[string]string{"0": "0", "1": "1"},
+		},
+		{
+			name: "shall match the nodes by the labels' similarity",
+			before: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "web", Label: "Web Server"}, {ID: "db", Label: "Postgres Database"},
+				},
+				Rels: []*rel{{From: "web", To: "db"}},
+			},
+			after: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Label: "web-server"}, {ID: "1", Label: "Postgres DB"}, {ID: "2", Label: "Postgres Databases"},
+				},
+				Rels: []*rel{{From: "0", To: "2"}},
+			},
+			want: graphDiff{
+				Nodes: []nodeChange{
+					{
+						ID: "0", BeforeID: "web", Status: changeModified,
+						Changes: []attributeChange{
+							{Attribute: "id", Before: "web", After: "0"},
+							{Attribute: "label", Before: "Web Server", After: "web-server"},
+						},
+					},
+					{ID: "1", Status: changeAdded},
+					{
+						ID: "2", BeforeID: "db", Status: changeModified,
+						Changes: []attributeChange{
+							{Attribute: "id", Before: "db", After: "2"},
+							{Attribute: "label", Before: "Postgres Database", After: "Postgres Databases"},
+						},
+					},
+				},
+			},
+			wantIDs: map[string]string{"web": "0", "db": "2"},
+		},
+		{
+			name: "shall match the links with the same ends in their order",
+			before: &c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "1"}},
+				Rels:       []*rel{{From: "0", To: "1", Label: "a"}, {From: "0", To: "1", Label: "b"}},
+			},
+			after: &c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "1"}},
+				Rels:       []*rel{{From: "0", To: "1", Label: "a"}},
+			},
+			want: graphDiff{
+				Links: []linkChange{{From: "0", To: "1", Status: changeRemoved}},
+			},
+			wantIDs: map[string]string{"0": "0", "1": "1"},
+		},
+		{
+			name: "shall match the unchanged links with the same ends first",
+			before: &c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "1"}},
+				Rels:       []*rel{{From: "0", To: "1", Label: "a"}, {From: "0", To: "1", Label: "b"}},
+			},
+			after: &c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "1"}},
+				Rels:       []*rel{{From: "0", To: "1", Label: "b"}},
+			},
+			want: graphDiff{
+				Links: []linkChange{{From: "0", To: "1", Status: changeRemoved}},
+			},
+			wantIDs: map[string]string{"0": "0", "1": "1"},
+		},
+		{
+			name: "shall match the nodes by their ids only if there are too many unmatched nodes",
+			before: &c4ContainersGraph{
+				Containers: repeatedNodes("before", labelSimilarityPairsMax/100+1),
+			},
+			after: &c4ContainersGraph{
+				Containers: repeatedNodes("after", 100),
+			},
+			want: graphDiff{Nodes: changedNodes(
+				repeatedNodes("after", 100), changeAdded,
+				repeatedNodes("before", labelSimilarityPairsMax/100+1), changeRemoved,
+			)},
+			wantIDs: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// WHEN
+				got, gotIDs := diffGraphs(tt.before, tt.after)
+
+				// THEN
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("unexpected diff:\ngot = %s\nwant = %s", mustJSON(got), mustJSON(tt.want))
+				}
+				if !reflect.DeepEqual(gotIDs, tt.wantIDs) {
+					t.Errorf("unexpected ids mapping: got = %v, want = %v", gotIDs, tt.wantIDs)
+				}
+			},
+		)
+	}
+}
+
+// repeatedNodes returns the nodes with the same label and the ids prefixed with the given prefix.
+func repeatedNodes(prefix string, n int) []*container {
+	o := make([]*container, n)
+	for i := range o {
+		o[i] = &container{ID: prefix + strconv.Itoa(i), Label: "Service"}
+	}
+	return o
+}
+
+// changedNodes returns the changes of the nodes given as the pairs of the nodes and the status.
+func changedNodes(v ...any) []nodeChange {
+	var o []nodeChange
+	for i := 0; i < len(v); i += 2 {
+		for _, n := range v[i].([]*container) {
+			o = append(o, nodeChange{ID: n.ID, Status: v[i+1].(string)})
+		}
+	}
+	return o
+}
+
+func Test_labelSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{a: "Web Server", b: "web-server", want: 1},
+		{a: "DB", b: "", want: 0},
+		{a: "abcd", b: "abce", want: 0.75},
+		{a: "Datenbank", b: "データベース", want: 0},
+		{a: "データベース", b: "データベース", want: 1},
+		{a: strings.Repeat("a", labelSimilarityLengthMax) + "b", b: strings.Repeat("a", labelSimilarityLengthMax), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.a+"/"+tt.b, func(t *testing.T) {
+				if got := labelSimilarity(tt.a, tt.b); got != tt.want {
+					t.Errorf("labelSimilarity() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_highlightDiff(t *testing.T) {
+	// GIVEN
+	before := &c4ContainersGraph{
+		Containers: []*container{
+			{ID: "0", Label: "Web", Technology: "Go"},
+			{ID: "1", Label: "DB", IsDatabase: true, System: "core"},
+			{ID: "2", Label: "Cache", System: "legacy", Tags: []string{"v1"}},
+		},
+		Rels: []*rel{
+			{From: "0", To: "1", Label: "reads"},
+			{From: "0", To: "2", Label: "reads"},
+		},
+		Boundaries: []*boundary{{ID: "core", Label: "Core"}, {ID: "legacy", Label: "Legacy"}},
+	}
+	after := &c4ContainersGraph{
+		Containers: []*container{
+			{ID: "0", Label: "Web", Technology: "Rust"},
+			{ID: "1", Label: "DB", IsDatabase: true, System: "core"},
+			{ID: "3", Label: "Queue", IsQueue: true},
+		},
+		Rels: []*rel{
+			{From: "0", To: "1", Label: "reads"},
+			{From: "0", To: "3", Label: "publishes"},
+		},
+		Boundaries: []*boundary{{ID: "core", Label: "Core"}},
+	}
+	diff, ids := diffGraphs(before, after)
+
+	// WHEN
+	got := highlightDiff(before, after, diff, ids)
+
+	// THEN
+	dsl, err := marshal(got, C4PlantUML{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValidDSL(t, dsl)
+
+	for _, want := range []string{
+		`AddElementTag("added", $bgColor="#2E7D32", $fontColor="#FFFFFF", $borderColor="#1B5E20")`,
+		`AddRelTag("removed", $textColor="#C62828", $lineColor="#C62828", $lineStyle=DashedLine())`,
+		`Container(0, "Web", "Rust", $tags="modified")`,
+		`ContainerQueue(3, "Queue", $tags="added")`,
+		`System_Boundary(legacy, "Legacy") {`,
+		`Container(2, "Cache", $tags="removed+v1")`,
+		`Rel(0, 1, "reads")`,
+		`Rel(0, 3, "publishes", $tags="added")`,
+		`Rel(0, 2, "reads", $tags="removed")`,
+		`SHOW_LEGEND()`,
+	} {
+		if !strings.Contains(string(dsl), want) {
+			t.Errorf("DSL does not contain %s:\n%s", want, dsl)
+		}
+	}
+
+	if len(after.Containers[0].Tags) > 0 || len(after.Rels[1].Tags) > 0 || len(after.Boundaries) != 1 {
+		t.Errorf("the graph after the change shall not be modified")
+	}
+}
+
+func Test_highlightDiffLinksWithSameEnds(t *testing.T) {
+	// GIVEN
+	before := &c4ContainersGraph{
+		Containers: []*container{{ID: "0", Label: "Web"}, {ID: "1", Label: "DB"}},
+		Rels: []*rel{
+			{From: "0", To: "1", Label: "reads"},
+			{From: "0", To: "1", Label: "writes"},
+		},
+	}
+	after := &c4ContainersGraph{
+		Containers: []*container{{ID: "0", Label: "Web"}, {ID: "1", Label: "DB"}},
+		Rels: []*rel{
+			{From: "0", To: "1", Label: "writes"},
+			{From: "0", To: "1", Label: "deletes"},
+		},
+	}
+	diff, ids := diffGraphs(before, after)
+
+	// WHEN
+	got := highlightDiff(before, after, diff, ids)
+
+	// THEN
+	want := []*rel{
+		{From: "0", To: "1", Label: "writes"},
+		{From: "0", To: "1", Label: "deletes", Tags: []string{changeModified}},
+	}
+	if !reflect.DeepEqual(got.Rels, want) {
+		t.Errorf("unexpected links:\ngot = %s\nwant = %s", mustJSON(got.Rels), mustJSON(want))
+	}
+	wantDiff := []linkChange{
+		{
+			From: "0", To: "1", Status: changeModified,
+			Changes: []attributeChange{{Attribute: "label", Before: "reads", After: "deletes"}},
+		},
+	}
+	if !reflect.DeepEqual(diff.Links, wantDiff) {
+		t.Errorf("unexpected diff:\ngot = %s\nwant = %s", mustJSON(diff.Links), mustJSON(wantDiff))
+	}
+}
+
+func TestNewC4ContainersDiffHTTPHandler(t *testing.T) {
+	const svg = `<?xml version="1.0" encoding="us-ascii" standalone="no"?>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10" width="100%" height="100%">
+<defs></defs><g><g id="elem_n0"><rect fill="#438DD5" width="52.5938" rx="2.5" ry="2.5"></rect></g></g></svg>`
+	httpClient := diagram.MockHTTPClient{
+		V: &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(svg))},
+	}
+
+	handler, err := NewC4ContainersDiffHTTPHandler(httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run(
+		"shall return the diagram and the changes", func(t *testing.T) {
+			// WHEN
+			got, err := handler(
+				context.TODO(), diagram.DiffInput{
+					Input: diagram.MockInput{},
+					Diff: diagram.Diff{
+						Before: []byte(`{"nodes":[{"id":"0","label":"Web"}]}`),
+						After:  []byte(`{"nodes":[{"id":"0","label":"Web"},{"id":"1","label":"DB"}]}`),
+					},
+				},
+			)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			o, err := got.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(o), `"diff":{"nodes":[{"id":"1","status":"added"}]}`) {
+				t.Errorf("unexpected output: %s", o)
+			}
+		},
+	)
+
+	t.Run(
+		"shall compare the C4-PlantUML diagram's code with the graph", func(t *testing.T) {
+			// GIVEN
+			handler, err := NewC4ContainersDiffHTTPHandler(
+				diagram.MockHTTPClient{
+					V: &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(svg))},
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			got, err := handler(
+				context.TODO(), diagram.DiffInput{
+					Input: diagram.MockInput{},
+					Diff: diagram.Diff{
+						Before: []byte(`"@startuml\n!include <C4/C4_Container>\nContainer(0, \"Web\", \"Go\")\n@enduml"`),
+						After:  []byte(`{"nodes":[{"id":"0","label":"Web","technology":"Go"},{"id":"1","label":"DB"}]}`),
+					},
+				},
+			)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			o, err := got.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(o), `"diff":{"nodes":[{"id":"1","status":"added"}]}`) {
+				t.Errorf("unexpected output: %s", o)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail for invalid C4-PlantUML diagram's code", func(t *testing.T) {
+			// WHEN
+			_, err := handler(
+				context.TODO(), diagram.DiffInput{
+					Input: diagram.MockInput{},
+					Diff: diagram.Diff{
+						Before: []byte(`{"nodes":[{"id":"0","label":"Web"}]}`),
+						After:  []byte(`"@startuml\nContainer(0, \"Web)\n@enduml"`),
+					},
+				},
+			)
+
+			// THEN
+			var e diagram.InputError
+			if !errors.As(err, &e) || !strings.HasPrefix(e.Reason, "invalid graph after: line 2: ") {
+				t.Errorf("unexpected error: %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail for invalid graph", func(t *testing.T) {
+			// WHEN
+			_, err := handler(
+				context.TODO(), diagram.DiffInput{
+					Input: diagram.MockInput{},
+					Diff:  diagram.Diff{Before: []byte(`{"nodes":[]}`), After: []byte(`{"nodes":[{"id":"0"}]}`)},
+				},
+			)
+
+			// THEN
+			var e diagram.InputError
+			if !errors.As(err, &e) || !strings.HasPrefix(e.Reason, "invalid graph before: ") {
+				t.Errorf("unexpected error: %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail if the graphs are not provided", func(t *testing.T) {
+			// WHEN
+			_, err := handler(context.TODO(), diagram.MockInput{Prompt: "foo"})
+
+			// THEN
+			var e diagram.InputError
+			if !errors.As(err, &e) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail if http client is not provided", func(t *testing.T) {
+			if _, err := NewC4ContainersDiffHTTPHandler(nil); err == nil {
+				t.Error("error is expected")
+			}
+		},
+	)
+}
@@ -0,0 +1,205 @@
+package c4container
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// dslStringReplacer escapes the characters which can terminate the quoted string in the PlantUML DSL,
+// or can be interpreted by the preprocessor and creole: they are replaced with the HTML entities
+// which are rendered as the original characters. The line breaks are replaced with the PlantUML's "\n".
+var dslStringReplacer = strings.NewReplacer(
+	`\`, "&#92;",
+	`"`, "&#34;",
+	"%", "&#37;",
+	"$", "&#36;",
+	"<", "&#60;",
+	">", "&#62;",
+	"[", "&#91;",
+	"]", "&#93;",
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+)
+
+// dslString escapes the string to be written into the PlantUML DSL as the quoted value.
+// The result does not contain line breaks, or the characters which can break out of the quotes.
+func dslString(s string) string {
+	return dslStringReplacer.Replace(cleanString(s))
+}
+
+// cleanString removes the invalid UTF-8 sequences, the control characters but the line breaks,
+// and the leading and trailing whitespaces.
+func cleanString(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(
+		func(r rune) rune {
+			if r != '\n' && r != '\r' && (unicode.IsControl(r) || r == '\u2028' || r == '\u2029') {
+				return -1
+			}
+			return r
+		}, s,
+	)
+	return strings.TrimSpace(s)
+}
+
+// sanitizeIdentifier converts the string to the valid PlantUML identifier: the whitespaces are removed,
+// and all characters but the latin letters, digits and underscore are replaced with underscore.
+func sanitizeIdentifier(s string) string {
+	var o strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			o.WriteRune(r)
+		case unicode.IsSpace(r):
+		default:
+			o.WriteRune('_')
+		}
+	}
+	if o.Len() == 0 {
+		return "id"
+	}
+	return o.String()
+}
+
+// dslIdentifiers maps the containers' IDs and the boundaries' names to the unique PlantUML identifiers.
+type dslIdentifiers struct {
+	aliases map[string]string
+	used    map[string]struct{}
+}
+
+func newDSLIdentifiers() *dslIdentifiers {
+	return &dslIdentifiers{aliases: map[string]string{}, used: map[string]struct{}{}}
+}
+
+// container returns the identifier of the container.
+func (d *dslIdentifiers) container(id string) string {
+	return d.alias("container:"+id, id)
+}
+
+// boundary returns the identifier of the boundary, the key distinguishes the boundaries with the same id.
+func (d *dslIdentifiers) boundary(key, id string) string {
+	return d.alias("boundary:"+key, id)
+}
+
+func (d *dslIdentifiers) alias(key, s string) string {
+	if v, ok := d.aliases[key]; ok {
+		return v
+	}
+
+	base := sanitizeIdentifier(s)
+	v := base
+	for i := 2; ; i++ {
+		if _, ok := d.used[v]; !ok {
+			break
+		}
+		v = base + "_" + strconv.Itoa(i)
+	}
+
+	d.aliases[key] = v
+	d.used[v] = struct{}{}
+	return v
+}
+
+// dslTag sanitizes the tag's name: all characters but letters, digits, whitespaces, dots, dashes
+// and underscores are removed.
+func dslTag(s string) string {
+	return strings.TrimSpace(
+		strings.Map(
+			func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' || r == '_' || r == '-' || r == '.' {
+					return r
+				}
+				return -1
+			}, s,
+		),
+	)
+}
+
+// dslTags returns the unique sanitized tags joined with "+" as required by C4-PlantUML.
+func dslTags(tags []string) string {
+	o := make([]string, 0, len(tags))
+	seen := map[string]struct{}{}
+	for _, tag := range tags {
+		tag = dslTag(tag)
+		if _, ok := seen[tag]; ok || tag == "" {
+			continue
+		}
+		seen[tag] = struct{}{}
+		o = append(o, tag)
+	}
+	return strings.Join(o, "+")
+}
+
+// dslLink returns the http(s) URL with all characters but the URL's unreserved and delimiters percent-encoded,
+// so the link cannot break out of the quoted string. The parentheses are encoded too, so the link cannot call
+// the preprocessor's functions. It returns empty string if the link is not valid URL, or if it's not valid
+// after the encoding, e.g. the host contains the characters which must not be encoded.
+func dslLink(s string) string {
+	if !isHTTPURL(s) {
+		return ""
+	}
+	u, _ := url.Parse(strings.TrimSpace(s))
+
+	const hex = "0123456789ABCDEF"
+	v := u.String()
+	var o strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '%' && i+2 < len(v) && isHex(v[i+1]) && isHex(v[i+2]):
+			// the percent-encoded characters are written in upper case
+			// to differ from the preprocessor's functions, e.g. %date()
+			o.WriteString(strings.ToUpper(v[i : i+3]))
+			i += 2
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			strings.IndexByte("-._~:/?#@!&'*+,;=", c) >= 0:
+			o.WriteByte(c)
+		default:
+			o.WriteByte('%')
+			o.WriteByte(hex[c>>4])
+			o.WriteByte(hex[c&15])
+		}
+	}
+
+	if !isHTTPURL(o.String()) {
+		return ""
+	}
+	return o.String()
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// spriteRegex defines the icons' sets from the PlantUML standard library supported as sprites.
+var spriteRegex = regexp.MustCompile(`^(devicons|devicons2|font-awesome|font-awesome-5|material)/([a-z0-9_-]+)$`)
+
+// dslSprite returns the directive to include the sprite from the PlantUML standard library,
+// and the sprite's name. It returns empty strings if the sprite is not supported.
+func dslSprite(s string) (include, name string) {
+	m := spriteRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return "", ""
+	}
+	return "!include <tupadr3/" + m[1] + "/" + m[2] + ">", m[2]
+}
+
+var colorRegex = regexp.MustCompile(`^(?:#[0-9A-Fa-f]{3,8}|[A-Za-z]{1,32})$`)
+
+// dslColor returns the color defined as the hex code, or the name. It returns empty string if the color is not valid.
+func dslColor(s string) string {
+	s = strings.TrimSpace(s)
+	if !colorRegex.MatchString(s) {
+		return ""
+	}
+	return s
+}
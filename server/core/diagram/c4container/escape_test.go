@@ -0,0 +1,375 @@
+package c4container
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_dslString(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "plain text",
+			in:   "  Web Server, Go 1.19  ",
+			want: "Web Server, Go 1.19",
+		},
+		{
+			name: "line breaks",
+			in:   "foo\nbar\r\nbaz\rqux",
+			want: `foo\nbar\nbaz\nqux`,
+		},
+		{
+			name: "quotes and brackets",
+			in:   `foo"), Container(bar, "baz`,
+			want: `foo&#34;), Container(bar, &#34;baz`,
+		},
+		{
+			name: "preprocessor directives",
+			in:   "\n!include https://foo.bar/baz.puml\n@enduml",
+			want: `!include https://foo.bar/baz.puml\n@enduml`,
+		},
+		{
+			name: "preprocessor functions and variables",
+			in:   `%getenv("HOME") $label`,
+			want: `&#37;getenv(&#34;HOME&#34;) &#36;label`,
+		},
+		{
+			name: "creole tags and links",
+			in:   `<img:https://foo.bar/baz.png> [[https://foo.bar]]`,
+			want: `&#60;img:https://foo.bar/baz.png&#62; &#91;&#91;https://foo.bar&#93;&#93;`,
+		},
+		{
+			name: "trailing backslash",
+			in:   `foo\`,
+			want: `foo&#92;`,
+		},
+		{
+			name: "control characters and invalid utf-8",
+			in:   "foo\x00\tbar\u2028\xff",
+			want: "foobar",
+		},
+		{
+			name: "unicode",
+			in:   "Сервер 服务器",
+			want: "Сервер 服务器",
+		},
+		{
+			name: "german umlauts and eszett",
+			in:   " Größenänderung über Straße ",
+			want: "Größenänderung über Straße",
+		},
+		{
+			name: "spanish accents and punctuation",
+			in:   "¿Lee de la base de datos? ¡Sí, señor!",
+			want: "¿Lee de la base de datos? ¡Sí, señor!",
+		},
+		{
+			name: "japanese with full-width space and punctuation",
+			in:   "\u3000データベース「注文」から読み取る。\u3000",
+			want: "データベース「注文」から読み取る。",
+		},
+		{
+			name: "japanese truncated multibyte sequence and control characters",
+			in:   "データ\x00ベース\xe3\x83",
+			want: "データベース",
+		},
+		{
+			name: "combining characters and emoji",
+			in:   "Cafe\u0301 🚀 \"ca\u0301fe\"",
+			want: "Cafe\u0301 🚀 &#34;ca\u0301fe&#34;",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := dslString(tt.in); got != tt.want {
+					t.Errorf("dslString() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_sanitizeIdentifier(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "0", want: "0"},
+		{in: "web_server", want: "web_server"},
+		{in: "Web Client", want: "WebClient"},
+		{in: "foo-bar.baz", want: "foo_bar_baz"},
+		{in: `0, "foo")`, want: "0__foo__"},
+		{in: "сервер", want: "______"},
+		{in: " \n", want: "id"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.in, func(t *testing.T) {
+				if got := sanitizeIdentifier(tt.in); got != tt.want {
+					t.Errorf("sanitizeIdentifier() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_dslTags(t *testing.T) {
+	if got := dslTags([]string{"v1", " v1 ", "", "a+b", `"$%`, "back-end.v2"}); got != "v1+ab+back-end.v2" {
+		t.Errorf("unexpected tags: %s", got)
+	}
+}
+
+func Test_dslLink(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "https://foo.bar/baz?q=1#qux", want: "https://foo.bar/baz?q=1#qux"},
+		{in: " http://foo.bar/a b ", want: "http://foo.bar/a%20b"},
+		{in: `https://foo.bar/?q="$x"&r=<a>[0]`, want: "https://foo.bar/?q=%22%24x%22&r=%3Ca%3E%5B0%5D"},
+		{in: "https://foo.bar/%2f?q=%date()", want: "https://foo.bar/%2F?q=%DAte%28%29"},
+		{in: "https://foo.bar/\\", want: "https://foo.bar/%5C"},
+		{in: "javascript:alert(1)"},
+		{in: "/relative/path"},
+		{in: "https://"},
+		{in: "foo"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.in, func(t *testing.T) {
+				if got := dslLink(tt.in); got != tt.want {
+					t.Errorf("dslLink() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_dslSprite(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantInclude string
+		wantName    string
+	}{
+		{in: "devicons2/go", wantInclude: "!include <tupadr3/devicons2/go>", wantName: "go"},
+		{in: " Font-Awesome-5/Users ", wantInclude: "!include <tupadr3/font-awesome-5/users>", wantName: "users"},
+		{in: "devicons2/go>\n!include foo"},
+		{in: "foo/bar"},
+		{in: "go"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.in, func(t *testing.T) {
+				include, name := dslSprite(tt.in)
+				if include != tt.wantInclude || name != tt.wantName {
+					t.Errorf("dslSprite() = %v, %v, want %v, %v", include, name, tt.wantInclude, tt.wantName)
+				}
+			},
+		)
+	}
+}
+
+func Test_dslColor(t *testing.T) {
+	for in, want := range map[string]string{
+		"#fff": "#fff", "#FF0000AA": "#FF0000AA", " red ": "red", "#ff": "", `red")`: "", "#gggggg": "",
+	} {
+		if got := dslColor(in); got != want {
+			t.Errorf("dslColor(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func Test_dslIdentifiers(t *testing.T) {
+	// GIVEN
+	ids := newDSLIdentifiers()
+
+	// WHEN
+	got := []string{
+		ids.container("a-b"),
+		ids.container("a_b"),
+		ids.container("a-b"),
+		ids.boundary("0", "a b"),
+		ids.boundary("0", "a b"),
+		ids.container("ab"),
+	}
+
+	// THEN
+	want := []string{"a_b", "a_b_2", "a_b", "ab", "ab", "ab_2"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("unexpected identifiers: got %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+var (
+	dslQuotedString = `"(?:[^"\\%$<>\[\]\x00-\x1f\x7f]|\\n)*"`
+	dslIdentifier   = `[A-Za-z0-9_]+`
+	dslLinkArg      = `\$link="(?:[^"\\%$<>\[\]()\x00-\x20\x7f]|%[0-9A-F]{2})*"`
+	dslArg          = `(?:` + dslQuotedString + `|` + dslLinkArg +
+		`|\$[A-Za-z]+=(?:` + dslQuotedString + `|[A-Za-z]+\(\)))`
+	dslValidLineRegex = []*regexp.Regexp{
+		regexp.MustCompile(`^$`),
+		regexp.MustCompile(`^!include <tupadr3/[a-z0-9-]+/[a-z0-9_-]+>$`),
+		regexp.MustCompile(`^footer "generated by diagramastext.dev - %date\('yyyy-MM-dd'\)"$`),
+		regexp.MustCompile(`^(?:footer|title) ` + dslQuotedString + `$`),
+		regexp.MustCompile(`^Add(?:Element|Rel)Tag\(` + dslQuotedString + `(?:, ` + dslArg + `)*\)$`),
+		regexp.MustCompile(
+			`^(?:Person|Container(?:Db|Queue)?)(?:_Ext)?\(` + dslIdentifier + `(?:, ` + dslArg + `)+\)$`,
+		),
+		regexp.MustCompile(
+			`^(?:Enterprise|System|Container)_Boundary\(` + dslIdentifier + `(?:, ` + dslArg + `)+\) \{$`,
+		),
+		regexp.MustCompile(`^}$`),
+		regexp.MustCompile(`^Rel(?:_[RLDU])?\(` + dslIdentifier + `, ` + dslIdentifier + `(?:, ` + dslArg + `)+\)$`),
+		regexp.MustCompile(`^SHOW_LEGEND\(\)$`),
+		regexp.MustCompile(`^(?:LAYOUT_[A-Z_]+|HIDE_STEREOTYPE)\(\)$`),
+		regexp.MustCompile(`^skinparam [A-Za-z]+ [#A-Za-z0-9]+$`),
+		regexp.MustCompile(`^Update(?:Element|Rel|Boundary)Style\((?:` + dslArg + `)(?:, ` + dslArg + `)*\)$`),
+	}
+)
+
+// assertValidDSL checks that the DSL consists of the lines generated by the marshaller only.
+func assertValidDSL(t *testing.T, dsl []byte) {
+	t.Helper()
+
+	lines := strings.Split(string(dsl), "\n")
+	if len(lines) < 3 || lines[0] != "@startuml" || lines[len(lines)-1] != "@enduml" ||
+		!strings.HasPrefix(lines[1], "!include ") {
+		t.Fatalf("unexpected DSL envelope:\n%s", dsl)
+	}
+
+	var depth int
+	for _, line := range lines[2 : len(lines)-1] {
+		var valid bool
+		for _, re := range dslValidLineRegex {
+			if re.MatchString(line) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			t.Fatalf("invalid DSL line %q:\n%s", line, dsl)
+		}
+
+		switch {
+		case strings.HasSuffix(line, "{"):
+			depth++
+		case line == "}":
+			depth--
+		}
+		if depth < 0 {
+			t.Fatalf("unbalanced boundaries:\n%s", dsl)
+		}
+	}
+	if depth != 0 {
+		t.Fatalf("unbalanced boundaries:\n%s", dsl)
+	}
+}
+
+func FuzzMarshal(f *testing.F) {
+	f.Add("0", "Web Server", "Go", "Reads from postgres", "", "1", "Uses", "LR", "TCP", "", "")
+	f.Add("a b", "foo\"), Container(x, \"y", "%getenv(\"HOME\")", "$label", "Core", "a-b", "\n@enduml", "TB", "\\",
+		"<img:https://foo.bar/baz.png>", "[[https://foo.bar]]")
+	f.Add("0, \"1\")", "\n!include https://foo.bar/baz.puml\n", "\r\n", "\x00", "\"}\n}", "\"", "\\n", "", "",
+		"Сервер", "\xff")
+
+	f.Fuzz(
+		func(
+			t *testing.T, id, label, technology, description, group, to, relLabel, direction, relTechnology, title,
+			footer string,
+		) {
+			// GIVEN
+			graph := &c4ContainersGraph{
+				Containers: []*container{
+					{ID: id, Label: label, Technology: technology, Description: description, System: group},
+					{
+						ID: to, Label: relLabel, System: title, IsDatabase: true, IsExternal: true,
+						Sprite: technology, Tags: []string{label, description}, Link: footer,
+					},
+					{ID: group, Label: footer, System: id, IsUser: true, Sprite: "devicons2/" + label},
+				},
+				Rels: []*rel{
+					{From: id, To: to, Label: relLabel, Direction: direction, Technology: relTechnology},
+					{From: to, To: group, Label: label, Technology: technology, Tags: []string{title}, Link: relLabel},
+				},
+				Boundaries: []*boundary{
+					{ID: group, Label: description, Type: direction, Parent: title, Link: relTechnology},
+					{ID: title, Parent: group, Tags: []string{footer}},
+				},
+				ElementTags: []*elementTag{{Tag: label, BgColor: description, Shape: direction}},
+				RelTags:     []*relTag{{Tag: title, LineColor: footer, LineStyle: direction}},
+				Title:       title,
+				Footer:      footer,
+				WithLegend:  len(title)%2 == 0,
+			}
+
+			// WHEN
+			got, err := marshal(graph, C4PlantUML{})
+
+			// THEN
+			if err != nil {
+				return
+			}
+			assertValidDSL(t, got)
+		},
+	)
+}
+
+func FuzzMarshalJSON(f *testing.F) {
+	f.Add([]byte(`{"nodes":[{"id":"0","label":"Web Server","technology":"Go","group":"Core"},` +
+		`{"id":"1","label":"Database","technology":"Postgres","database":true}],` +
+		`"links":[{"from":"0","to":"1","label":"reads","direction":"LR"}],"title":"foo","legend":false}`))
+	f.Add([]byte(`{"nodes":[{"id":"0\")\n@enduml","label":"\"\\\\%$<>[]","group":"\n!include foo"}],` +
+		`"links":[{"from":"0\")\n@enduml","to":"x y","label":"}"}],"footer":"\r\n"}`))
+	f.Add([]byte(`{"boundaries":[{"id":"a","type":"enterprise","parent":"b","tags":["x\")"]},{"id":"b","parent":"a"},` +
+		`{"label":"c","parent":"a","link":"https://foo.bar/\"$%date()"}],` +
+		`"nodes":[{"id":"0","group":"c","sprite":"devicons2/go","tags":["a+b","\""],"link":"http://x/%zz%2f"}],` +
+		`"links":[{"from":"0","to":"0","tags":["$x"],"link":"https://foo.bar/?q=\"<>"}],` +
+		`"element_tags":[{"tag":"a","bg_color":"#fff\")","shape":"rounded"}],` +
+		`"link_tags":[{"tag":"$x","line_style":"dotted"},null]}`))
+	f.Add([]byte(`{"nodes":[{"id":"0"}],"layout":{"direction":"left_right","spacing":"wide","theme":"corporate",` +
+		`"sketch":true,"hide_stereotypes":true,"legend_in_layout":true}}`))
+	f.Add([]byte(`{"nodes":[{"id":"0"}],"layout":{"direction":"\")\n@enduml","theme":"dark","spacing":"%date"}}`))
+
+	f.Fuzz(
+		func(t *testing.T, data []byte) {
+			// GIVEN
+			var graph c4ContainersGraph
+			if err := json.Unmarshal(data, &graph); err != nil {
+				return
+			}
+			for _, n := range graph.Containers {
+				if n == nil {
+					return
+				}
+			}
+			for _, l := range graph.Rels {
+				if l == nil {
+					return
+				}
+			}
+			for _, b := range graph.Boundaries {
+				if b == nil {
+					return
+				}
+			}
+
+			// WHEN
+			got, err := marshal(&graph, C4PlantUML{})
+
+			// THEN
+			if err != nil {
+				return
+			}
+			assertValidDSL(t, got)
+		},
+	)
+}
@@ -0,0 +1,351 @@
+package c4container
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+	"github.com/kislerdm/diagramastext/server/core/errors"
+)
+
+// Severities of the review hints.
+const (
+	// severityWarning defines the design issue which is likely to be a problem.
+	severityWarning = "warning"
+	// severityInfo defines the missing detail which makes the diagram less informative.
+	severityInfo = "info"
+)
+
+// Codes of the review hints.
+const (
+	hintSharedDatabase          = "shared_database"
+	hintExternalWithoutProtocol = "external_without_protocol"
+	hintUserAccessesDatabase    = "user_accesses_database"
+	hintIsolatedNode            = "isolated_node"
+	hintMissingTechnology       = "missing_technology"
+	hintMissingLinkLabel        = "missing_link_label"
+)
+
+// sharedDatabaseAccessorsMin defines the number of the containers accessing the database
+// which indicates the database shared instead of being accessed through an API.
+const sharedDatabaseAccessorsMin = 3
+
+// NewC4ContainersExplainHTTPHandler initialises the httphandler to explain and review C4 containers diagram.
+// The review is deterministic, the model is optionally asked to write the prose description of the diagram.
+// The model inference client is optional.
+func NewC4ContainersExplainHTTPHandler(
+	clientModelInference diagram.ModelInference, clientRepositoryPrediction diagram.RepositoryPrediction,
+	fnOps ...HTTPHandlerOps,
+) (diagram.HTTPHandler, error) {
+	opts := handlerOptions{redactor: diagram.NewRedactor()}
+	for _, o := range fnOps {
+		if o != nil {
+			o(&opts)
+		}
+	}
+
+	return func(ctx context.Context, input diagram.Input) (diagram.Output, error) {
+		if err := input.Validate(); err != nil {
+			return nil, err
+		}
+
+		explanationInput, ok := input.(diagram.ExplanationInput)
+		if !ok || explanationInput.Explanation.IsEmpty() {
+			return nil, diagram.InputError{Reason: "graph must be provided"}
+		}
+		explanation := explanationInput.Explanation
+
+		g, err := parseInputGraph(explanation.Graph, "")
+		if err != nil {
+			return nil, err
+		}
+
+		o := explainResult{Summary: summarizeGraph(g), Findings: reviewGraph(g)}
+
+		if explanation.Narrative {
+			o.Narrative, o.Warnings, err = narrateGraph(
+				ctx, clientModelInference, clientRepositoryPrediction, opts, explanationInput, g,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return o, nil
+	}, nil
+}
+
+// explainResult defines the explanation and review of the diagram.
+type explainResult struct {
+	// Summary defines the deterministic summary of the diagram's elements.
+	Summary string `json:"summary"`
+	// Findings defines the review hints.
+	Findings findings `json:"findings"`
+	// Narrative defines the prose description of the diagram written by the model.
+	Narrative string   `json:"narrative,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+func (r explainResult) Serialize() ([]byte, error) {
+	if r.Findings == nil {
+		r.Findings = findings{}
+	}
+	return json.Marshal(r)
+}
+
+// reviewGraph returns the review hints of the graph. The elements are referred by their position in the graph.
+func reviewGraph(g *c4ContainersGraph) findings {
+	var o findings
+
+	nodes := map[string]*container{}
+	for _, n := range g.Containers {
+		nodes[n.ID] = n
+	}
+
+	connected := map[string]struct{}{}
+	accessors := map[string]map[string]struct{}{}
+	for i, l := range g.Rels {
+		connected[l.From] = struct{}{}
+		connected[l.To] = struct{}{}
+
+		from, to := nodes[l.From], nodes[l.To]
+		if from == nil || to == nil {
+			continue
+		}
+
+		if (from.IsExternal || to.IsExternal) && l.Technology == "" {
+			external := to
+			if !to.IsExternal {
+				external = from
+			}
+			o = append(
+				o, finding{
+					Code: hintExternalWithoutProtocol, Severity: severityWarning, Element: linkElement(i),
+					Message: "link between " + nodeName(from) + " and " + nodeName(to) +
+						" has no protocol to communicate with the external system " + nodeName(external),
+				},
+			)
+		}
+
+		// the direction of the database access is not consistent in the diagrams, so both ends are checked
+		for _, v := range [][2]*container{{from, to}, {to, from}} {
+			client, db := v[0], v[1]
+			if !db.IsDatabase || client.IsDatabase {
+				continue
+			}
+			if client.IsUser {
+				o = append(
+					o, finding{
+						Code: hintUserAccessesDatabase, Severity: severityWarning, Element: linkElement(i),
+						Message: "user " + nodeName(client) + " accesses the database " + nodeName(db) +
+							" directly without an application",
+					},
+				)
+				continue
+			}
+			if accessors[db.ID] == nil {
+				accessors[db.ID] = map[string]struct{}{}
+			}
+			accessors[db.ID][client.ID] = struct{}{}
+		}
+
+		if l.Label == "" {
+			o = append(
+				o, finding{
+					Code: hintMissingLinkLabel, Severity: severityInfo, Element: linkElement(i),
+					Message: "link from " + nodeName(from) + " to " + nodeName(to) + " has no description",
+				},
+			)
+		}
+	}
+
+	for i, n := range g.Containers {
+		if v := len(accessors[n.ID]); v >= sharedDatabaseAccessorsMin {
+			o = append(
+				o, finding{
+					Code: hintSharedDatabase, Severity: severityWarning, Element: nodeElement(i),
+					Message: "database " + nodeName(n) + " is accessed by " + strconv.Itoa(v) +
+						" containers without an API",
+				},
+			)
+		}
+
+		if _, ok := connected[n.ID]; !ok && len(g.Containers) > 1 {
+			o = append(
+				o, finding{
+					Code: hintIsolatedNode, Severity: severityWarning, Element: nodeElement(i),
+					Message: nodeName(n) + " is not connected to other elements",
+				},
+			)
+		}
+
+		if n.Technology == "" && !n.IsUser && !n.IsExternal {
+			o = append(
+				o, finding{
+					Code: hintMissingTechnology, Severity: severityInfo, Element: nodeElement(i),
+					Message: nodeName(n) + " has no technology",
+				},
+			)
+		}
+	}
+
+	return o
+}
+
+// summarizeGraph returns the summary of the graph's elements,
+// e.g. "The diagram contains 2 containers and 1 database connected by 2 links.".
+func summarizeGraph(g *c4ContainersGraph) string {
+	var containers, databases, queues, externals, users int
+	for _, n := range g.Containers {
+		switch {
+		case n.IsUser:
+			users++
+		case n.IsExternal:
+			externals++
+		case n.IsDatabase:
+			databases++
+		case n.IsQueue:
+			queues++
+		default:
+			containers++
+		}
+	}
+
+	var elements []string
+	for _, el := range []struct {
+		count            int
+		singular, plural string
+	}{
+		{containers, "container", "containers"},
+		{databases, "database", "databases"},
+		{queues, "queue", "queues"},
+		{externals, "external system", "external systems"},
+		{users, "user", "users"},
+	} {
+		if el.count > 0 {
+			elements = append(elements, plural(el.count, el.singular, el.plural))
+		}
+	}
+
+	o := "The diagram contains " + joinEnumeration(elements)
+	if len(g.Rels) > 0 {
+		o += " connected by " + plural(len(g.Rels), "link", "links")
+	}
+	if len(g.Boundaries) > 0 {
+		o += ", grouped into " + plural(len(g.Boundaries), "boundary", "boundaries")
+	}
+	return o + "."
+}
+
+func plural(count int, singular, plural string) string {
+	if count == 1 {
+		return "1 " + singular
+	}
+	return strconv.Itoa(count) + " " + plural
+}
+
+// joinEnumeration joins the elements as the enumeration, e.g. "a, b and c".
+func joinEnumeration(elements []string) string {
+	if len(elements) < 2 {
+		return strings.Join(elements, "")
+	}
+	return strings.Join(elements[:len(elements)-1], ", ") + " and " + elements[len(elements)-1]
+}
+
+// narrateGraph asks the model to write the prose description of the graph.
+// It returns the description, and the warnings if the graph is flagged by the guard,
+// or the description cannot be generated. The error is returned if the graph is rejected by the guard.
+func narrateGraph(
+	ctx context.Context, clientModelInference diagram.ModelInference,
+	clientRepositoryPrediction diagram.RepositoryPrediction, opts handlerOptions, input diagram.ExplanationInput,
+	g *c4ContainersGraph,
+) (string, []string, error) {
+	const warning = "narrative is not generated"
+
+	if clientModelInference == nil {
+		return "", []string{warning}, nil
+	}
+
+	graphJSON, err := json.Marshal(
+		c4ContainersGraph{Containers: g.Containers, Rels: g.Rels, Boundaries: g.Boundaries, Title: g.Title},
+	)
+	if err != nil {
+		// FIXME: add proper logging
+		log.Printf("narrateGraph json.Marshal err: %+v", err)
+		return "", []string{warning}, nil
+	}
+
+	redaction := opts.redactor.Redact(string(graphJSON))
+
+	// the graph's labels are user's input, hence they are inspected as the prompt
+	warnings, err := opts.inspectModelInput(ctx, input, redaction.Text)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if clientRepositoryPrediction != nil {
+		if err := clientRepositoryPrediction.WriteInputPrompt(
+			ctx, input.GetRequestID(), input.GetUserID(), "explain c4 diagram",
+		); err != nil {
+			// FIXME: add proper logging
+			log.Printf("clientRepositoryPrediction.WriteInputPrompt err: %+v", err)
+		}
+	}
+
+	predictionRaw, prediction, usageTokensPrompt, usageTokensCompletions, err := clientModelInference.Do(
+		ctx, redaction.Text, systemContent(contentSystemExplain, input.OutputLanguage, ""), model,
+	)
+	if err != nil {
+		// FIXME: add proper logging
+		log.Printf("clientModelInference.Do explain err: %+v", err)
+		return "", append(warnings, warning), nil
+	}
+
+	if clientRepositoryPrediction != nil {
+		if err := clientRepositoryPrediction.WriteModelResult(
+			ctx, input.GetRequestID(), input.GetUserID(), predictionRaw, string(prediction), model,
+			usageTokensPrompt, usageTokensCompletions,
+		); err != nil {
+			// FIXME: add proper logging
+			log.Printf("clientRepositoryPrediction.WriteModelResult err: %+v", err)
+		}
+	}
+
+	if err := errors.NewPredictionError(prediction); err != nil {
+		return "", append(warnings, warning), nil
+	}
+
+	var o struct {
+		Narrative string `json:"narrative"`
+	}
+	if err := json.Unmarshal(prediction, &o); err != nil || strings.TrimSpace(o.Narrative) == "" {
+		return "", append(warnings, warning), nil
+	}
+
+	if clientRepositoryPrediction != nil {
+		if err := clientRepositoryPrediction.WriteSuccessFlag(
+			ctx, input.GetRequestID(), input.GetUserID(), input.GetUserAPIToken(),
+		); err != nil {
+			// FIXME: add proper logging
+			log.Printf("clientRepositoryPrediction.WriteSuccessFlag err: %+v", err)
+		}
+	}
+
+	return redaction.Restore(strings.TrimSpace(o.Narrative)), warnings, nil
+}
+
+const contentSystemExplain = `Given graph of software system as json, describe its architecture in prose ` +
+	`for a design document. Explain the responsibility of every node, how nodes communicate using the links' ` +
+	`labels and technologies, and how nodes are grouped into boundaries. Do not invent nodes, or links. ` +
+	`Use at most two paragraphs. Output JSON with narrative as string. ` +
+	`If error, return {"error": {{detailed decision explanation}} }` + "\n" +
+
+	// example
+	`{"nodes":[{"id":"0","label":"Web Server","technology":"Go"},{"id":"1","label":"Database",` +
+	`"technology":"Postgres","database":true}],"links":[{"from":"0","to":"1","label":"reads from",` +
+	`"technology":"TCP"}]}` + "\n" +
+	`{"narrative":"The system consists of a web server written in Go which reads the data from ` +
+	`the Postgres database over TCP."}`
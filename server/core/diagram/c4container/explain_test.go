@@ -0,0 +1,416 @@
+package c4container
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+)
+
+func Test_reviewGraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph *c4ContainersGraph
+		want  findings
+	}{
+		{
+			name: "shall report no findings",
+			graph: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Label: "Web", Technology: "Go"},
+					{ID: "1", Label: "DB", Technology: "Postgres", IsDatabase: true},
+				},
+				Rels: []*rel{{From: "0", To: "1", Label: "reads", Technology: "TCP"}},
+			},
+		},
+		{
+			name: "shall report the database shared by three containers",
+			graph: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Label: "Orders", Technology: "Go"},
+					{ID: "1", Label: "Billing", Technology: "Go"},
+					{ID: "2", Label: "Reports", Technology: "Python"},
+					{ID: "3", Label: "DB", Technology: "Postgres", IsDatabase: true},
+				},
+				Rels: []*rel{
+					{From: "0", To: "3", Label: "reads", Technology: "TCP"},
+					{From: "1", To: "3", Label: "writes", Technology: "TCP"},
+					{From: "3", To: "2", Label: "streams", Technology: "CDC"},
+					{From: "0", To: "3", Label: "writes", Technology: "TCP"},
+				},
+			},
+			want: findings{
+				{
+					Code: hintSharedDatabase, Severity: severityWarning, Element: nodeElement(3),
+					Message: "database DB is accessed by 3 containers without an API",
+				},
+			},
+		},
+		{
+			name: "shall report the external system without protocol, and the user accessing the database",
+			graph: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Label: "Analyst", IsUser: true},
+					{ID: "1", Label: "DB", Technology: "Postgres", IsDatabase: true},
+					{ID: "2", Label: "Payments", IsExternal: true},
+					{ID: "3", Label: "Web", Technology: "Go"},
+				},
+				Rels: []*rel{
+					{From: "0", To: "1", Label: "queries", Technology: "SQL"},
+					{From: "3", To: "2", Label: "charges"},
+					{From: "3", To: "1", Technology: "TCP"},
+				},
+			},
+			want: findings{
+				{
+					Code: hintUserAccessesDatabase, Severity: severityWarning, Element: linkElement(0),
+					Message: "user Analyst accesses the database DB directly without an application",
+				},
+				{
+					Code: hintExternalWithoutProtocol, Severity: severityWarning, Element: linkElement(1),
+					Message: "link between Web and Payments has no protocol to communicate with the external system " +
+						"Payments",
+				},
+				{
+					Code: hintMissingLinkLabel, Severity: severityInfo, Element: linkElement(2),
+					Message: "link from Web to DB has no description",
+				},
+			},
+		},
+		{
+			name: "shall report the isolated node without technology",
+			graph: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Label: "Web", Technology: "Go"},
+					{ID: "1", Label: "Cache"},
+					{ID: "2", Label: "DB", Technology: "Postgres", IsDatabase: true},
+				},
+				Rels: []*rel{{From: "0", To: "2", Label: "reads", Technology: "TCP"}},
+			},
+			want: findings{
+				{
+					Code: hintIsolatedNode, Severity: severityWarning, Element: nodeElement(1),
+					Message: "Cache is not connected to other elements",
+				},
+				{
+					Code: hintMissingTechnology, Severity: severityInfo, Element: nodeElement(1),
+					Message: "Cache has no technology",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := reviewGraph(tt.graph); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("unexpected findings:\ngot = %s\nwant = %s", mustJSON(got), mustJSON(tt.want))
+				}
+			},
+		)
+	}
+}
+
+func Test_summarizeGraph(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph *c4ContainersGraph
+		want  string
+	}{
+		{
+			name:  "shall summarize single node",
+			graph: &c4ContainersGraph{Containers: []*container{{ID: "0"}}},
+			want:  "The diagram contains 1 container.",
+		},
+		{
+			name: "shall summarize all kinds of elements",
+			graph: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0"}, {ID: "1"}, {ID: "2", IsDatabase: true}, {ID: "3", IsQueue: true},
+					{ID: "4", IsExternal: true, IsDatabase: true}, {ID: "5", IsUser: true},
+				},
+				Rels:       []*rel{{From: "5", To: "0"}, {From: "0", To: "1"}},
+				Boundaries: []*boundary{{ID: "core"}},
+			},
+			want: "The diagram contains 2 containers, 1 database, 1 queue, 1 external system and 1 user " +
+				"connected by 2 links, grouped into 1 boundary.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := summarizeGraph(tt.graph); got != tt.want {
+					t.Errorf("summarizeGraph() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestNewC4ContainersExplainHTTPHandler(t *testing.T) {
+	const graph = `{"nodes":[{"id":"0","label":"Web","technology":"Go"},` +
+		`{"id":"1","label":"Mail","technology":"SMTP","external":true}],` +
+		`"links":[{"from":"0","to":"1","label":"sends email to admin@example.com"}]}`
+
+	t.Run(
+		"shall return the review without narrative", func(t *testing.T) {
+			// GIVEN
+			modelInferenceClient := &mockModelInference{}
+			handler, err := NewC4ContainersExplainHTTPHandler(modelInferenceClient, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			got, err := handler(
+				context.TODO(), diagram.ExplanationInput{
+					Input: diagram.MockInput{}, Explanation: diagram.Explanation{Graph: []byte(graph)},
+				},
+			)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			o, err := got.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := `{"summary":"The diagram contains 1 container and 1 external system connected by 1 link.",` +
+				`"findings":[{"code":"external_without_protocol","severity":"warning","element":"links[0]",` +
+				`"message":"link between Web and Mail has no protocol to communicate with the external system Mail"}]}`
+			if string(o) != want {
+				t.Errorf("unexpected output:\ngot = %s\nwant = %s", o, want)
+			}
+			if modelInferenceClient.Prompt != "" {
+				t.Errorf("model shall not be called")
+			}
+		},
+	)
+
+	t.Run(
+		"shall review the C4-PlantUML diagram's code", func(t *testing.T) {
+			// GIVEN
+			handler, err := NewC4ContainersExplainHTTPHandler(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const dsl = `"@startuml\n!include <C4/C4_Container>\nContainer(0, \"Web\", \"Go\")\n` +
+				`System_Ext(1, \"Mail\")\nRel(0, 1, \"sends email\")\n@enduml"`
+
+			// WHEN
+			got, err := handler(
+				context.TODO(), diagram.ExplanationInput{
+					Input: diagram.MockInput{}, Explanation: diagram.Explanation{Graph: []byte(dsl)},
+				},
+			)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			o, err := got.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(o), `"code":"external_without_protocol"`) {
+				t.Errorf("unexpected output: %s", o)
+			}
+		},
+	)
+
+	t.Run(
+		"shall return the narrative with restored redacted values", func(t *testing.T) {
+			// GIVEN
+			repositoryPredictionClient := &mockRepositoryPrediction{}
+			modelInferenceClient := &mockModelInference{
+				MockModelInference: diagram.MockModelInference{
+					V: []byte(`{"narrative":"Web written in Go sends emails to [EMAIL_1] via Mail."}`),
+				},
+			}
+			handler, err := NewC4ContainersExplainHTTPHandler(modelInferenceClient, repositoryPredictionClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			got, err := handler(
+				context.TODO(), diagram.ExplanationInput{
+					Input:       diagram.MockInput{},
+					Explanation: diagram.Explanation{Graph: []byte(graph), Narrative: true},
+				},
+			)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			o, err := got.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(o), `"narrative":"Web written in Go sends emails to admin@example.com via Mail."`) {
+				t.Errorf("unexpected output: %s", o)
+			}
+			if strings.Contains(modelInferenceClient.Prompt, "admin@example.com") {
+				t.Errorf("sensitive data shall be redacted in the prompt: %s", modelInferenceClient.Prompt)
+			}
+			if repositoryPredictionClient.InputPromptWritten != 1 || repositoryPredictionClient.ModelPredictionWritten != 1 ||
+				repositoryPredictionClient.SuccessFlagWritten != 1 {
+				t.Errorf("unexpected repository calls: %+v", repositoryPredictionClient)
+			}
+		},
+	)
+
+	t.Run(
+		"shall return the review with warning if the narrative fails", func(t *testing.T) {
+			for name, client := range map[string]diagram.ModelInference{
+				"model error":      diagram.MockModelInference{Err: errors.New("foobar")},
+				"prediction error": diagram.MockModelInference{V: []byte(`{"error":"not a graph"}`)},
+				"no model":         nil,
+			} {
+				t.Run(
+					name, func(t *testing.T) {
+						// GIVEN
+						handler, err := NewC4ContainersExplainHTTPHandler(client, nil)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						// WHEN
+						got, err := handler(
+							context.TODO(), diagram.ExplanationInput{
+								Input:       diagram.MockInput{},
+								Explanation: diagram.Explanation{Graph: []byte(graph), Narrative: true},
+							},
+						)
+
+						// THEN
+						if err != nil {
+							t.Fatal(err)
+						}
+						o, err := got.Serialize()
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !strings.Contains(string(o), `"warnings":["narrative is not generated"]`) {
+							t.Errorf("unexpected output: %s", o)
+						}
+					},
+				)
+			}
+		},
+	)
+
+	t.Run(
+		"shall inspect the graph with the guard before it is sent to the model", func(t *testing.T) {
+			classifier := diagram.MockPromptClassifier{Reason: diagram.GuardReasonOffTopic}
+			input := diagram.ExplanationInput{
+				Input: diagram.MockInput{}, Explanation: diagram.Explanation{Graph: []byte(graph), Narrative: true},
+			}
+
+			t.Run(
+				"block", func(t *testing.T) {
+					// GIVEN
+					modelInferenceClient := &mockModelInference{}
+					guard, err := diagram.NewGuard(diagram.GuardPolicyBlock, classifier, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					handler, err := NewC4ContainersExplainHTTPHandler(modelInferenceClient, nil, WithGuard(guard))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					// WHEN
+					_, err = handler(context.TODO(), input)
+
+					// THEN
+					if !reflect.DeepEqual(err, diagram.GuardError{Reason: diagram.GuardReasonOffTopic}) {
+						t.Errorf("unexpected error: %v", err)
+					}
+					if modelInferenceClient.Prompt != "" {
+						t.Error("the rejected graph is not expected to be sent to the model")
+					}
+				},
+			)
+
+			t.Run(
+				"warn", func(t *testing.T) {
+					// GIVEN
+					modelInferenceClient := &mockModelInference{
+						MockModelInference: diagram.MockModelInference{V: []byte(`{"narrative":"Web sends emails."}`)},
+					}
+					guard, err := diagram.NewGuard(diagram.GuardPolicyWarn, classifier, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					handler, err := NewC4ContainersExplainHTTPHandler(modelInferenceClient, nil, WithGuard(guard))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					// WHEN
+					got, err := handler(context.TODO(), input)
+
+					// THEN
+					if err != nil {
+						t.Fatal(err)
+					}
+					o, err := got.Serialize()
+					if err != nil {
+						t.Fatal(err)
+					}
+					const want = `"narrative":"Web sends emails.","warnings":["prompt flagged: off_topic"]`
+					if !strings.Contains(string(o), want) {
+						t.Errorf("unexpected output: %s", o)
+					}
+				},
+			)
+		},
+	)
+
+	t.Run(
+		"shall fail for invalid graph", func(t *testing.T) {
+			// GIVEN
+			handler, err := NewC4ContainersExplainHTTPHandler(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			_, err = handler(
+				context.TODO(), diagram.ExplanationInput{
+					Input: diagram.MockInput{}, Explanation: diagram.Explanation{Graph: []byte(`{"nodes":[]}`)},
+				},
+			)
+
+			// THEN
+			var e diagram.InputError
+			if !errors.As(err, &e) || !strings.HasPrefix(e.Reason, "invalid graph: ") {
+				t.Errorf("unexpected error: %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail if the graph is not provided", func(t *testing.T) {
+			// GIVEN
+			handler, err := NewC4ContainersExplainHTTPHandler(nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			_, err = handler(context.TODO(), diagram.MockInput{Prompt: "foo"})
+
+			// THEN
+			var e diagram.InputError
+			if !errors.As(err, &e) || e.Reason != "graph must be provided" {
+				t.Errorf("unexpected error: %v", err)
+			}
+		},
+	)
+}
@@ -0,0 +1,313 @@
+package c4container
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+	"github.com/kislerdm/diagramastext/server/core/errors"
+)
+
+// NewC4ContainersFromInfraHTTPHandler initialises the httphandler to generate C4 containers diagram
+// from the infrastructure code: docker-compose, Kubernetes manifests, or Terraform plan.
+// The graph is extracted deterministically, the model is optionally asked to add the labels and descriptions,
+// the extracted graph is rendered as is if the model fails. The model inference client is optional.
+func NewC4ContainersFromInfraHTTPHandler(
+	clientModelInference diagram.ModelInference, clientRepositoryPrediction diagram.RepositoryPrediction,
+	httpClient diagram.HTTPClient, fnOps ...HTTPHandlerOps,
+) (diagram.HTTPHandler, error) {
+	if httpClient == nil {
+		return nil, errors.New("http client must be provided")
+	}
+
+	opts := handlerOptions{redactor: diagram.NewRedactor()}
+	for _, o := range fnOps {
+		if o != nil {
+			o(&opts)
+		}
+	}
+
+	return func(ctx context.Context, input diagram.Input) (diagram.Output, error) {
+		if err := input.Validate(); err != nil {
+			return nil, err
+		}
+
+		infraInput, ok := input.(diagram.InfraInput)
+		if !ok || infraInput.Infra.IsEmpty() {
+			return nil, diagram.InputError{Reason: "infrastructure code must be provided"}
+		}
+		infra := infraInput.Infra
+
+		diagramGraph, err := extractInfra(infra)
+		if err != nil {
+			return nil, err
+		}
+
+		if clientRepositoryPrediction != nil {
+			if err := clientRepositoryPrediction.WriteInputPrompt(
+				ctx, input.GetRequestID(), input.GetUserID(), "c4 diagram from "+infra.Format,
+			); err != nil {
+				// FIXME: add proper logging
+				log.Printf("clientRepositoryPrediction.WriteInputPrompt err: %+v", err)
+			}
+		}
+
+		var warnings []string
+		if infra.Describe {
+			warnings, err = describeGraph(
+				ctx, clientModelInference, clientRepositoryPrediction, opts, infraInput, diagramGraph,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		diagramGraph.applyLayout(infraInput.Layout)
+
+		diagramPostRendering, err := renderDiagram(ctx, httpClient, diagramGraph, opts.c4PlantUML)
+		if err != nil {
+			return nil, err
+		}
+
+		if clientRepositoryPrediction != nil {
+			if err := clientRepositoryPrediction.WriteSuccessFlag(
+				ctx, input.GetRequestID(), input.GetUserID(), input.GetUserAPIToken(),
+			); err != nil {
+				// FIXME: add proper logging
+				log.Printf("clientRepositoryPrediction.WriteSuccessFlag err: %+v", err)
+			}
+		}
+
+		return diagram.NewResultSVG(diagramPostRendering, warnings...)
+	}, nil
+}
+
+// extractInfra extracts the graph from the infrastructure code. The graph is validated,
+// so the databases and queues are inferred from the containers' technologies.
+func extractInfra(infra diagram.Infra) (*c4ContainersGraph, error) {
+	var (
+		o   *c4ContainersGraph
+		err error
+	)
+
+	switch infra.Format {
+	case diagram.InfraFormatDockerCompose:
+		o, err = extractDockerCompose([]byte(infra.Source))
+	case diagram.InfraFormatKubernetes:
+		o, err = extractKubernetes([]byte(infra.Source))
+	case diagram.InfraFormatTerraformPlan:
+		o, err = extractTerraformPlan([]byte(infra.Source))
+	default:
+		return nil, diagram.InputError{Reason: "unknown infrastructure code format " + infra.Format}
+	}
+
+	if err != nil {
+		return nil, diagram.InputError{Reason: "cannot parse " + infra.Format + ": " + err.Error()}
+	}
+	if len(o.Containers) == 0 {
+		return nil, diagram.InputError{Reason: "no services found in " + infra.Format}
+	}
+
+	_ = validateGraph(o)
+
+	return o, nil
+}
+
+// infraGraph builds the graph extracted from the infrastructure code.
+type infraGraph struct {
+	graph *c4ContainersGraph
+	nodes map[string]*container
+	rels  map[[2]string]struct{}
+}
+
+func newInfraGraph() *infraGraph {
+	return &infraGraph{
+		graph: &c4ContainersGraph{WithLegend: true},
+		nodes: map[string]*container{},
+		rels:  map[[2]string]struct{}{},
+	}
+}
+
+// addNode adds the node, the node with the existing id is ignored.
+func (g *infraGraph) addNode(n *container) {
+	if _, ok := g.nodes[n.ID]; ok {
+		return
+	}
+	g.nodes[n.ID] = n
+	g.graph.Containers = append(g.graph.Containers, n)
+}
+
+// addRel adds the relation between the existing nodes, the duplicates and self-loops are ignored.
+func (g *infraGraph) addRel(from, to, label string) {
+	if from == to {
+		return
+	}
+	if _, ok := g.nodes[from]; !ok {
+		return
+	}
+	if _, ok := g.nodes[to]; !ok {
+		return
+	}
+
+	k := [2]string{from, to}
+	if _, ok := g.rels[k]; ok {
+		return
+	}
+	g.rels[k] = struct{}{}
+	g.graph.Rels = append(g.graph.Rels, &rel{From: from, To: to, Label: label})
+}
+
+var hostTokenSeparatorRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// hostTokens returns the sorted unique tokens of the values which may reference the hosts,
+// e.g. "db" is the token of "postgres://user@db:5432/app".
+func hostTokens(values ...string) []string {
+	seen := map[string]struct{}{}
+	var o []string
+	for _, v := range values {
+		for _, token := range hostTokenSeparatorRegex.Split(v, -1) {
+			token = strings.Trim(token, ".")
+			if _, ok := seen[token]; ok || token == "" {
+				continue
+			}
+			seen[token] = struct{}{}
+			o = append(o, token)
+		}
+	}
+	sort.Strings(o)
+	return o
+}
+
+// imageTechnology returns the container image's name and tag without the registry and the digest,
+// e.g. postgres:15 for docker.io/library/postgres:15@sha256:00.
+func imageTechnology(image string) string {
+	image, _, _ = strings.Cut(strings.TrimSpace(image), "@")
+	if i := strings.LastIndexByte(image, '/'); i >= 0 {
+		image = image[i+1:]
+	}
+	return image
+}
+
+// describeGraph asks the model to add the labels and descriptions to the graph's elements. The structure
+// of the graph is preserved: only the attributes of the existing nodes and links are updated.
+// It returns the warning if the graph cannot be described.
+func describeGraph(
+	ctx context.Context, clientModelInference diagram.ModelInference,
+	clientRepositoryPrediction diagram.RepositoryPrediction, opts handlerOptions, input diagram.InfraInput,
+	g *c4ContainersGraph,
+) ([]string, error) {
+	const warning = "labels and descriptions are not generated"
+
+	if clientModelInference == nil {
+		return []string{warning}, nil
+	}
+
+	graphJSON, err := json.Marshal(c4ContainersGraph{Containers: g.Containers, Rels: g.Rels})
+	if err != nil {
+		// FIXME: add proper logging
+		log.Printf("describeGraph json.Marshal err: %+v", err)
+		return []string{warning}, nil
+	}
+
+	redaction := opts.redactor.Redact(string(graphJSON))
+
+	// the labels extracted from the infrastructure code are user's input, hence they are inspected as the prompt
+	warnings, err := opts.inspectModelInput(ctx, input, redaction.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	predictionRaw, prediction, usageTokensPrompt, usageTokensCompletions, err := clientModelInference.Do(
+		ctx, redaction.Text, systemContent(contentSystemDescribe, input.OutputLanguage, ""), model,
+	)
+	if err != nil {
+		// FIXME: add proper logging
+		log.Printf("clientModelInference.Do describe err: %+v", err)
+		return append(warnings, warning), nil
+	}
+
+	if clientRepositoryPrediction != nil {
+		if err := clientRepositoryPrediction.WriteModelResult(
+			ctx, input.GetRequestID(), input.GetUserID(), predictionRaw, string(prediction), model,
+			usageTokensPrompt, usageTokensCompletions,
+		); err != nil {
+			// FIXME: add proper logging
+			log.Printf("clientRepositoryPrediction.WriteModelResult err: %+v", err)
+		}
+	}
+
+	if err := errors.NewPredictionError(prediction); err != nil {
+		return append(warnings, warning), nil
+	}
+
+	var described c4ContainersGraph
+	if err := json.Unmarshal(prediction, &described); err != nil {
+		return append(warnings, warning), nil
+	}
+	described.restoreLabels(redaction)
+
+	mergeDescriptions(g, &described)
+	return warnings, nil
+}
+
+// mergeDescriptions copies the labels and descriptions of the described graph's nodes and links
+// to the nodes and links of the graph with the same ids. The technologies are only set if missing.
+func mergeDescriptions(g, described *c4ContainersGraph) {
+	nodes := map[string]*container{}
+	for _, n := range described.Containers {
+		if n != nil {
+			nodes[n.ID] = n
+		}
+	}
+	for _, n := range g.Containers {
+		v, ok := nodes[n.ID]
+		if !ok {
+			continue
+		}
+		if v.Label != "" {
+			n.Label = v.Label
+		}
+		if v.Description != "" {
+			n.Description = v.Description
+		}
+		if n.Technology == "" {
+			n.Technology = v.Technology
+		}
+	}
+
+	rels := map[[2]string]*rel{}
+	for _, l := range described.Rels {
+		if l != nil {
+			rels[[2]string{l.From, l.To}] = l
+		}
+	}
+	for _, l := range g.Rels {
+		v, ok := rels[[2]string{l.From, l.To}]
+		if !ok {
+			continue
+		}
+		if v.Label != "" {
+			l.Label = v.Label
+		}
+		if l.Technology == "" {
+			l.Technology = v.Technology
+		}
+	}
+}
+
+const contentSystemDescribe = `Given graph of software system as json, add label,description,technology to every node ` +
+	`and label,technology to every link. Labels are short names, descriptions explain the node's responsibility. ` +
+	`Keep nodes' id, and links' from,to unchanged. Do not add, or remove nodes and links. ` +
+	`Output JSON with nodes and links. If error, return {"error": {{detailed decision explanation}} }` + "\n" +
+
+	// example
+	`{"nodes":[{"id":"api","label":"api","technology":"node:18"},{"id":"db","label":"db",` +
+	`"technology":"postgres:15","database":true}],"links":[{"from":"api","to":"db","label":"depends on"}]}` + "\n" +
+	`{"nodes":[{"id":"api","label":"API","technology":"Node.js","description":"Serves the REST API"},` +
+	`{"id":"db","label":"Database","technology":"Postgres","description":"Stores the application's data",` +
+	`"database":true}],"links":[{"from":"api","to":"db","label":"Reads from and writes to",` +
+	`"technology":"SQL/TCP"}]}`
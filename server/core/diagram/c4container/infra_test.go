@@ -0,0 +1,563 @@
+package c4container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+)
+
+func Test_extractInfra(t *testing.T) {
+	tests := []struct {
+		name  string
+		infra diagram.Infra
+		want  *c4ContainersGraph
+	}{
+		{
+			name: "shall extract the graph from the docker-compose",
+			infra: diagram.Infra{
+				Format: diagram.InfraFormatDockerCompose,
+				Source: `version: '3.8'
+services:
+  db:
+    image: postgres:15.1-alpine3.16
+    container_name: diagranastext-db
+    environment:
+      POSTGRES_USER: postgres
+  pgweb:
+    image: sosedoff/pgweb
+    environment:
+      - DATABASE_URL=postgres://postgres:postgres@db:5432/postgres?sslmode=disable
+    depends_on:
+      db:
+        condition: service_healthy
+  server:
+    depends_on:
+      - db
+    image: diagranastext-core
+    build:
+      context: ./server/core
+    environment:
+      DB_HOST: 'db:5432'
+      QUEUE_URL: amqp://broker
+  broker:
+    image: docker.io/library/rabbitmq:3@sha256:0000
+  webclient:
+    image: node:current-alpine3.17
+    links:
+      - "server:api"
+    environment:
+      VITE_URL_API: "http://localhost:9000"
+`,
+			},
+			want: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "db", Label: "db", Technology: "postgres:15.1-alpine3.16", IsDatabase: true},
+					{ID: "pgweb", Label: "pgweb", Technology: "pgweb"},
+					{ID: "server", Label: "server", Technology: "diagranastext-core"},
+					{ID: "broker", Label: "broker", Technology: "rabbitmq:3", IsQueue: true},
+					{ID: "webclient", Label: "webclient", Technology: "node:current-alpine3.17"},
+				},
+				Rels: []*rel{
+					{From: "pgweb", To: "db", Label: "depends on"},
+					{From: "server", To: "db", Label: "depends on"},
+					{From: "server", To: "broker", Label: "uses"},
+					{From: "webclient", To: "server", Label: "uses"},
+				},
+				WithLegend: true,
+			},
+		},
+		{
+			name: "shall extract the graph from the Kubernetes manifests",
+			infra: diagram.Infra{
+				Format: diagram.InfraFormatKubernetes,
+				Source: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  selector:
+    matchLabels:
+      app: api
+  template:
+    metadata:
+      labels:
+        app: api
+    spec:
+      containers:
+        - name: api
+          image: ghcr.io/acme/api:1.0.0
+          env:
+            - name: DB_HOST
+              value: postgres.default.svc.cluster.local
+            - name: PAYMENTS_URL
+              value: https://payments
+          args: ["--cache", "redis:6379"]
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: postgres
+spec:
+  template:
+    metadata:
+      labels:
+        app: postgres
+    spec:
+      containers:
+        - image: postgres:15
+---
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: postgres
+    spec:
+      selector:
+        app: postgres
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: api
+    spec:
+      selector:
+        app: api
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: payments
+    spec:
+      type: ExternalName
+      externalName: payments.example.com
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - image: acme/report
+              command: ["report", "--api=http://api"]
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  ingressClassName: nginx
+  rules:
+    - http:
+        paths:
+          - path: /
+            backend:
+              service:
+                name: api
+`,
+			},
+			want: &c4ContainersGraph{
+				Containers: []*container{
+					{
+						ID: "default/deployment/api", Label: "api", Technology: "api:1.0.0",
+						Description: "Deployment",
+					},
+					{
+						ID: "default/statefulset/postgres", Label: "postgres", Technology: "postgres:15",
+						Description: "StatefulSet", IsDatabase: true,
+					},
+					{
+						ID: "default/cronjob/report", Label: "report", Technology: "report",
+						Description: "CronJob",
+					},
+					{
+						ID: "default/service/payments", Label: "payments", Technology: "payments.example.com",
+						IsExternal: true,
+					},
+					{ID: "default/ingress/web", Label: "web", Technology: "nginx", Description: "Ingress"},
+				},
+				Rels: []*rel{
+					{From: "default/deployment/api", To: "default/service/payments", Label: "uses"},
+					{From: "default/deployment/api", To: "default/statefulset/postgres", Label: "uses"},
+					{From: "default/cronjob/report", To: "default/deployment/api", Label: "uses"},
+					{From: "default/ingress/web", To: "default/deployment/api", Label: "routes to"},
+				},
+				WithLegend: true,
+			},
+		},
+		{
+			name: "shall extract the graph from the Terraform plan",
+			infra: diagram.Infra{
+				Format: diagram.InfraFormatTerraformPlan,
+				Source: `{
+  "planned_values": {
+    "root_module": {
+      "resources": [
+        {"address": "aws_iam_role.this", "mode": "managed", "type": "aws_iam_role", "name": "this"},
+        {"address": "data.aws_region.this", "mode": "data", "type": "aws_region", "name": "this"},
+        {
+          "address": "aws_lambda_function.api[0]", "mode": "managed", "type": "aws_lambda_function",
+          "name": "api", "values": {"function_name": "core"}
+        },
+        {
+          "address": "aws_lambda_function.api[1]", "mode": "managed", "type": "aws_lambda_function",
+          "name": "api", "values": {"function_name": "core"}
+        },
+        {"address": "aws_sqs_queue.events", "mode": "managed", "type": "aws_sqs_queue", "name": "events"}
+      ],
+      "child_modules": [
+        {
+          "address": "module.db",
+          "resources": [
+            {
+              "address": "module.db.aws_db_instance.main", "mode": "managed", "type": "aws_db_instance",
+              "name": "main", "values": {"identifier": "core-db"}
+            }
+          ]
+        }
+      ]
+    }
+  },
+  "configuration": {
+    "root_module": {
+      "resources": [
+        {
+          "address": "aws_lambda_function.api",
+          "mode": "managed",
+          "expressions": {
+            "role": {"references": ["aws_iam_role.this.arn", "aws_iam_role.this"]},
+            "environment": [
+              {"variables": {"references": ["aws_sqs_queue.events.url", "aws_sqs_queue.events", "var.env"]}}
+            ]
+          },
+          "depends_on": ["module.db"]
+        }
+      ],
+      "module_calls": {
+        "db": {
+          "module": {
+            "resources": [
+              {
+                "address": "aws_db_instance.main",
+                "mode": "managed",
+                "depends_on": ["aws_sqs_queue.events"]
+              }
+            ]
+          }
+        }
+      }
+    }
+  }
+}`,
+			},
+			want: &c4ContainersGraph{
+				Containers: []*container{
+					{
+						ID: "aws_lambda_function.api", Label: "core", Technology: "AWS Lambda",
+						Description: "aws_lambda_function",
+					},
+					{
+						ID: "aws_sqs_queue.events", Label: "events", Technology: "Amazon SQS",
+						Description: "aws_sqs_queue", IsQueue: true,
+					},
+					{
+						ID: "module.db.aws_db_instance.main", Label: "core-db", Technology: "Amazon RDS",
+						Description: "aws_db_instance", System: "module.db", IsDatabase: true,
+					},
+				},
+				Rels: []*rel{
+					{From: "aws_lambda_function.api", To: "aws_sqs_queue.events", Label: "uses"},
+				},
+				WithLegend: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// WHEN
+				got, err := extractInfra(tt.infra)
+
+				// THEN
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("unexpected graph:\ngot = %s\nwant = %s", mustJSON(got), mustJSON(tt.want))
+				}
+			},
+		)
+	}
+}
+
+func mustJSON(v interface{}) string {
+	o, _ := json.Marshal(v)
+	return string(o)
+}
+
+func Test_extractInfraUnhappyPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		infra      diagram.Infra
+		wantReason string
+	}{
+		{
+			name:       "shall fail for unknown format",
+			infra:      diagram.Infra{Format: "helm", Source: "foo"},
+			wantReason: "unknown infrastructure code format helm",
+		},
+		{
+			name:       "shall fail for invalid docker-compose",
+			infra:      diagram.Infra{Format: diagram.InfraFormatDockerCompose, Source: "services: [foo"},
+			wantReason: "cannot parse docker-compose: ",
+		},
+		{
+			name:       "shall fail for docker-compose with services defined as a list",
+			infra:      diagram.Infra{Format: diagram.InfraFormatDockerCompose, Source: "services: [foo]"},
+			wantReason: "cannot parse docker-compose: services must be a mapping",
+		},
+		{
+			name:       "shall fail for docker-compose without services",
+			infra:      diagram.Infra{Format: diagram.InfraFormatDockerCompose, Source: "version: '3.8'"},
+			wantReason: "no services found in docker-compose",
+		},
+		{
+			name: "shall fail for Kubernetes manifests without workloads",
+			infra: diagram.Infra{
+				Format: diagram.InfraFormatKubernetes, Source: "kind: ConfigMap\nmetadata:\n  name: foo",
+			},
+			wantReason: "no services found in kubernetes",
+		},
+		{
+			name:       "shall fail for Terraform plan in HCL",
+			infra:      diagram.Infra{Format: diagram.InfraFormatTerraformPlan, Source: `resource "foo" "bar" {}`},
+			wantReason: "cannot parse terraform-plan: ",
+		},
+		{
+			name:       "shall fail for Terraform plan without planned values",
+			infra:      diagram.Infra{Format: diagram.InfraFormatTerraformPlan, Source: `{"format_version":"1.2"}`},
+			wantReason: "cannot parse terraform-plan: planned_values must be set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// WHEN
+				_, err := extractInfra(tt.infra)
+
+				// THEN
+				var e diagram.InputError
+				if !errors.As(err, &e) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !strings.HasPrefix(e.Reason, tt.wantReason) {
+					t.Errorf("unexpected reason: got = %s, want = %s", e.Reason, tt.wantReason)
+				}
+			},
+		)
+	}
+}
+
+func Test_mergeDescriptions(t *testing.T) {
+	// GIVEN
+	g := &c4ContainersGraph{
+		Containers: []*container{
+			{ID: "api", Label: "api", Technology: "node:18"},
+			{ID: "db", Label: "db", Technology: "postgres:15", IsDatabase: true},
+		},
+		Rels: []*rel{{From: "api", To: "db", Label: "depends on"}},
+	}
+	described := &c4ContainersGraph{
+		Containers: []*container{
+			{ID: "api", Label: "API", Technology: "Node.js", Description: "Serves the REST API"},
+			{ID: "db", Label: "Database", Description: "Stores the data", IsExternal: true},
+			{ID: "cache", Label: "Cache"},
+			nil,
+		},
+		Rels: []*rel{
+			{From: "api", To: "db", Label: "Reads from", Technology: "SQL"},
+			{From: "api", To: "cache", Label: "Reads from"},
+		},
+	}
+
+	// WHEN
+	mergeDescriptions(g, described)
+
+	// THEN
+	want := &c4ContainersGraph{
+		Containers: []*container{
+			{ID: "api", Label: "API", Technology: "node:18", Description: "Serves the REST API"},
+			{ID: "db", Label: "Database", Technology: "postgres:15", Description: "Stores the data", IsDatabase: true},
+		},
+		Rels: []*rel{{From: "api", To: "db", Label: "Reads from", Technology: "SQL"}},
+	}
+	if !reflect.DeepEqual(g, want) {
+		t.Errorf("unexpected graph:\ngot = %s\nwant = %s", mustJSON(g), mustJSON(want))
+	}
+}
+
+func TestNewC4ContainersFromInfraHTTPHandler(t *testing.T) {
+	const svg = `<?xml version="1.0" encoding="us-ascii" standalone="no"?>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10" width="100%" height="100%">
+<defs></defs><g><g id="elem_n0"><rect fill="#438DD5" width="52.5938" rx="2.5" ry="2.5"></rect></g></g></svg>`
+	newHTTPClient := func() diagram.HTTPClient {
+		return diagram.MockHTTPClient{
+			V: &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(svg))},
+		}
+	}
+	infra := diagram.Infra{
+		Format:   diagram.InfraFormatDockerCompose,
+		Source:   "services:\n  api:\n    image: acme/api\n    environment:\n      DB: acme-db\n  acme-db: {}",
+		Describe: true,
+	}
+
+	t.Run(
+		"shall describe the graph using the model", func(t *testing.T) {
+			// GIVEN
+			repositoryPredictionClient := &mockRepositoryPrediction{}
+			modelInferenceClient := &mockModelInference{
+				MockModelInference: diagram.MockModelInference{
+					V: []byte(`{"nodes":[{"id":"api","label":"[REDACTED_1] API"}]}`),
+				},
+			}
+			handler, err := NewC4ContainersFromInfraHTTPHandler(
+				modelInferenceClient, repositoryPredictionClient, newHTTPClient(),
+				WithRedactor(diagram.NewRedactor("acme")),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			got, err := handler(
+				context.TODO(), diagram.InfraInput{
+					Input: diagram.MockInput{RequestID: "1410904f-f646-488f-ae08-cc341dfb321c", UserID: placeholderUserID},
+					Infra: infra,
+				},
+			)
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(modelInferenceClient.Prompt, "acme") {
+				t.Errorf("sensitive data is sent to the model: %s", modelInferenceClient.Prompt)
+			}
+			if repositoryPredictionClient.Prompt != "c4 diagram from docker-compose" {
+				t.Errorf("unexpected prompt persisted: %s", repositoryPredictionClient.Prompt)
+			}
+			if repositoryPredictionClient.ModelPredictionWritten != 1 ||
+				repositoryPredictionClient.SuccessFlagWritten != 1 {
+				t.Errorf("unexpected persistence: %+v", repositoryPredictionClient)
+			}
+			o, err := got.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(o), `"warnings"`) {
+				t.Errorf("unexpected warnings: %s", o)
+			}
+		},
+	)
+
+	t.Run(
+		"shall render the extracted graph with warning if the model fails", func(t *testing.T) {
+			// GIVEN
+			handler, err := NewC4ContainersFromInfraHTTPHandler(
+				diagram.MockModelInference{Err: errors.New("foobar")}, nil, newHTTPClient(),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			got, err := handler(context.TODO(), diagram.InfraInput{Input: diagram.MockInput{}, Infra: infra})
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			o, err := got.Serialize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(o), `"warnings":["labels and descriptions are not generated"]`) {
+				t.Errorf("unexpected output: %s", o)
+			}
+		},
+	)
+
+	t.Run(
+		"shall reject the graph flagged by the guard before it is sent to the model", func(t *testing.T) {
+			// GIVEN
+			modelInferenceClient := &mockModelInference{}
+			repositoryGuard := &diagram.MockRepositoryGuard{}
+			guard, err := diagram.NewGuard(
+				diagram.GuardPolicyBlock, diagram.MockPromptClassifier{Reason: diagram.GuardReasonInjection},
+				repositoryGuard,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler, err := NewC4ContainersFromInfraHTTPHandler(
+				modelInferenceClient, nil, newHTTPClient(), WithRedactor(diagram.NewRedactor("acme")),
+				WithGuard(guard),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			_, err = handler(context.TODO(), diagram.InfraInput{Input: diagram.MockInput{}, Infra: infra})
+
+			// THEN
+			if !reflect.DeepEqual(err, diagram.GuardError{Reason: diagram.GuardReasonInjection}) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if modelInferenceClient.Prompt != "" {
+				t.Error("the rejected graph is not expected to be sent to the model")
+			}
+			if len(repositoryGuard.Prompts) != 1 || strings.Contains(repositoryGuard.Prompts[0].Prompt, "acme") {
+				t.Errorf("the redacted graph is expected to be recorded: %+v", repositoryGuard.Prompts)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail if the infrastructure code is not provided", func(t *testing.T) {
+			// GIVEN
+			handler, err := NewC4ContainersFromInfraHTTPHandler(nil, nil, newHTTPClient())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// WHEN
+			_, err = handler(context.TODO(), diagram.MockInput{Prompt: "foo"})
+
+			// THEN
+			var e diagram.InputError
+			if !errors.As(err, &e) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail if http client is not provided", func(t *testing.T) {
+			_, err := NewC4ContainersFromInfraHTTPHandler(nil, nil, nil)
+			if err == nil {
+				t.Error("error is expected")
+			}
+		},
+	)
+}
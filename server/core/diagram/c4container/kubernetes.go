@@ -0,0 +1,293 @@
+package c4container
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// k8sObject defines the attributes of the Kubernetes objects required to extract the graph.
+type k8sObject struct {
+	Kind     string      `yaml:"kind"`
+	Metadata k8sMetadata `yaml:"metadata"`
+	Spec     k8sSpec     `yaml:"spec"`
+	// Items defines the objects of the List.
+	Items []k8sObject `yaml:"items"`
+}
+
+type k8sMetadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+type k8sSpec struct {
+	// Template defines the pods of the Deployment, StatefulSet, DaemonSet, ReplicaSet and Job.
+	Template    k8sPodTemplate `yaml:"template"`
+	JobTemplate struct {
+		Spec struct {
+			Template k8sPodTemplate `yaml:"template"`
+		} `yaml:"spec"`
+	} `yaml:"jobTemplate"`
+
+	// Containers defines the containers of the Pod.
+	Containers []k8sContainer `yaml:"containers"`
+
+	// Selector defines the Service's selector.
+	Selector     map[string]interface{} `yaml:"selector"`
+	Type         string                 `yaml:"type"`
+	ExternalName string                 `yaml:"externalName"`
+
+	// Ingress
+	IngressClassName string       `yaml:"ingressClassName"`
+	DefaultBackend   k8sBackend   `yaml:"defaultBackend"`
+	Backend          k8sBackend   `yaml:"backend"`
+	Rules            []k8sIngress `yaml:"rules"`
+}
+
+type k8sPodTemplate struct {
+	Metadata k8sMetadata `yaml:"metadata"`
+	Spec     struct {
+		Containers []k8sContainer `yaml:"containers"`
+	} `yaml:"spec"`
+}
+
+type k8sContainer struct {
+	Image   string   `yaml:"image"`
+	Command []string `yaml:"command"`
+	Args    []string `yaml:"args"`
+	Env     []struct {
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+type k8sIngress struct {
+	HTTP struct {
+		Paths []struct {
+			Backend k8sBackend `yaml:"backend"`
+		} `yaml:"paths"`
+	} `yaml:"http"`
+}
+
+type k8sBackend struct {
+	// ServiceName defines the backend of the extensions/v1beta1 Ingress.
+	ServiceName string `yaml:"serviceName"`
+	Service     struct {
+		Name string `yaml:"name"`
+	} `yaml:"service"`
+}
+
+func (b k8sBackend) name() string {
+	if b.Service.Name != "" {
+		return b.Service.Name
+	}
+	return b.ServiceName
+}
+
+func (o k8sObject) namespace() string {
+	if o.Metadata.Namespace == "" {
+		return "default"
+	}
+	return o.Metadata.Namespace
+}
+
+func (o k8sObject) id() string {
+	return o.namespace() + "/" + strings.ToLower(o.Kind) + "/" + o.Metadata.Name
+}
+
+// pod returns the pods' labels and containers of the workload, and false if the object is not a workload.
+func (o k8sObject) pod() (map[string]string, []k8sContainer, bool) {
+	switch o.Kind {
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+		return o.Spec.Template.Metadata.Labels, o.Spec.Template.Spec.Containers, true
+	case "CronJob":
+		t := o.Spec.JobTemplate.Spec.Template
+		return t.Metadata.Labels, t.Spec.Containers, true
+	case "Pod":
+		return o.Metadata.Labels, o.Spec.Containers, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// extractKubernetes extracts the graph from the Kubernetes manifests: the workloads define the containers,
+// the Services referenced in the containers' environment variables, commands and arguments define the relations.
+// The Ingresses are linked to the workloads behind the backend Services.
+func extractKubernetes(v []byte) (*c4ContainersGraph, error) {
+	objects, err := decodeKubernetesManifests(v)
+	if err != nil {
+		return nil, err
+	}
+
+	o := newInfraGraph()
+
+	namespaces := map[string]struct{}{}
+	for _, obj := range objects {
+		if _, _, ok := obj.pod(); ok {
+			namespaces[obj.namespace()] = struct{}{}
+		}
+	}
+
+	group := func(obj k8sObject) string {
+		if len(namespaces) > 1 {
+			return obj.namespace()
+		}
+		return ""
+	}
+
+	var workloads []k8sObject
+	for _, obj := range objects {
+		_, containers, ok := obj.pod()
+		if !ok || obj.Metadata.Name == "" {
+			continue
+		}
+
+		n := &container{ID: obj.id(), Label: obj.Metadata.Name, Description: obj.Kind, System: group(obj)}
+		if len(containers) > 0 {
+			n.Technology = imageTechnology(containers[0].Image)
+		}
+		o.addNode(n)
+		workloads = append(workloads, obj)
+	}
+
+	// services maps the Services' hosts to the ids of the nodes behind them
+	services := map[string][]string{}
+	for _, obj := range objects {
+		if obj.Kind != "Service" || obj.Metadata.Name == "" {
+			continue
+		}
+
+		var ids []string
+		if obj.Spec.Type == "ExternalName" {
+			n := &container{
+				ID: obj.id(), Label: obj.Metadata.Name, Technology: obj.Spec.ExternalName, IsExternal: true,
+			}
+			o.addNode(n)
+			ids = append(ids, n.ID)
+		} else {
+			ids = selectWorkloads(obj, workloads)
+		}
+
+		name, ns := obj.Metadata.Name, obj.namespace()
+		for _, host := range []string{
+			name + "." + ns, name + "." + ns + ".svc", name + "." + ns + ".svc.cluster.local",
+		} {
+			services[host] = append(services[host], ids...)
+		}
+		// the short name is resolved within the namespace
+		services[ns+"/"+name] = append(services[ns+"/"+name], ids...)
+	}
+
+	resolve := func(ns, host string) []string {
+		if ids, ok := services[host]; ok {
+			return ids
+		}
+		return services[ns+"/"+host]
+	}
+
+	for _, obj := range workloads {
+		_, containers, _ := obj.pod()
+
+		var values []string
+		for _, c := range containers {
+			values = append(values, c.Command...)
+			values = append(values, c.Args...)
+			for _, env := range c.Env {
+				values = append(values, env.Value)
+			}
+		}
+
+		for _, token := range hostTokens(values...) {
+			for _, id := range resolve(obj.namespace(), token) {
+				o.addRel(obj.id(), id, "uses")
+			}
+		}
+	}
+
+	for _, obj := range objects {
+		if obj.Kind != "Ingress" || obj.Metadata.Name == "" {
+			continue
+		}
+
+		n := &container{
+			ID: obj.id(), Label: obj.Metadata.Name, Technology: obj.Spec.IngressClassName, Description: obj.Kind,
+			System: group(obj),
+		}
+
+		backends := []string{obj.Spec.DefaultBackend.name(), obj.Spec.Backend.name()}
+		for _, rule := range obj.Spec.Rules {
+			for _, path := range rule.HTTP.Paths {
+				backends = append(backends, path.Backend.name())
+			}
+		}
+
+		o.addNode(n)
+		for _, backend := range backends {
+			if backend == "" {
+				continue
+			}
+			for _, id := range resolve(obj.namespace(), backend) {
+				o.addRel(n.ID, id, "routes to")
+			}
+		}
+	}
+
+	return o.graph, nil
+}
+
+// decodeKubernetesManifests decodes the multi-document manifests, the Lists are flattened.
+func decodeKubernetesManifests(v []byte) ([]k8sObject, error) {
+	var o []k8sObject
+
+	decoder := yaml.NewDecoder(bytes.NewReader(v))
+	for {
+		var obj k8sObject
+		err := decoder.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.Kind == "List" || strings.HasSuffix(obj.Kind, "List") {
+			o = append(o, obj.Items...)
+			continue
+		}
+		if obj.Kind != "" {
+			o = append(o, obj)
+		}
+	}
+
+	return o, nil
+}
+
+// selectWorkloads returns the ids of the workloads in the Service's namespace with the pods matching its selector.
+func selectWorkloads(service k8sObject, workloads []k8sObject) []string {
+	if len(service.Spec.Selector) == 0 {
+		return nil
+	}
+
+	var o []string
+	for _, w := range workloads {
+		if w.namespace() != service.namespace() {
+			continue
+		}
+
+		labels, _, _ := w.pod()
+		matches := true
+		for k, v := range service.Spec.Selector {
+			if s, ok := v.(string); !ok || labels[k] != s {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			o = append(o, w.id())
+		}
+	}
+	return o
+}
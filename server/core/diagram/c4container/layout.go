@@ -0,0 +1,204 @@
+package c4container
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+)
+
+// layout defines the diagram's direction, spacing and theme.
+type layout struct {
+	// Direction defines the diagram's direction: top_down, left_right, or landscape.
+	Direction string `json:"direction,omitempty"`
+	// Spacing defines the spacing between elements: compact, or wide.
+	Spacing string `json:"spacing,omitempty"`
+	// Theme defines the named theme: default, dark, or corporate.
+	Theme           string `json:"theme,omitempty"`
+	Sketch          bool   `json:"sketch,omitempty"`
+	HideStereotypes bool   `json:"hide_stereotypes,omitempty"`
+	// LegendInLayout defines whether the legend is placed in the diagram's layout instead of the side panel.
+	LegendInLayout bool `json:"legend_in_layout,omitempty"`
+}
+
+// applyLayout overrides the layout inferred by the model with the layout requested explicitly by the user.
+func (l *c4ContainersGraph) applyLayout(v diagram.Layout) {
+	if v == (diagram.Layout{}) {
+		return
+	}
+
+	if l.Layout == nil {
+		l.Layout = &layout{}
+	}
+	if v.Direction != "" {
+		l.Layout.Direction = v.Direction
+	}
+	if v.Spacing != "" {
+		l.Layout.Spacing = v.Spacing
+	}
+	if v.Theme != "" {
+		l.Layout.Theme = v.Theme
+	}
+	if v.Sketch != nil {
+		l.Layout.Sketch = *v.Sketch
+	}
+	if v.HideStereotypes != nil {
+		l.Layout.HideStereotypes = *v.HideStereotypes
+	}
+	if v.LegendInLayout != nil {
+		l.Layout.LegendInLayout = *v.LegendInLayout
+	}
+}
+
+// layoutAliases maps the alternative notations to the supported layout attributes.
+var layoutAliases = map[string]string{
+	"lr":            diagram.LayoutDirectionLeftRight,
+	"left-right":    diagram.LayoutDirectionLeftRight,
+	"left to right": diagram.LayoutDirectionLeftRight,
+	"horizontal":    diagram.LayoutDirectionLeftRight,
+	"td":            diagram.LayoutDirectionTopDown,
+	"tb":            diagram.LayoutDirectionTopDown,
+	"top-down":      diagram.LayoutDirectionTopDown,
+	"top to bottom": diagram.LayoutDirectionTopDown,
+	"vertical":      diagram.LayoutDirectionTopDown,
+	"tight":         diagram.LayoutSpacingCompact,
+	"dense":         diagram.LayoutSpacingCompact,
+	"spacious":      diagram.LayoutSpacingWide,
+	"loose":         diagram.LayoutSpacingWide,
+	"night":         diagram.LayoutThemeDark,
+	"dark mode":     diagram.LayoutThemeDark,
+	"company":       diagram.LayoutThemeCorporate,
+	"brand":         diagram.LayoutThemeCorporate,
+}
+
+// normalizeLayoutAttribute returns the attribute's value from the list of supported values, or its alias.
+// The empty string is returned for the unknown value.
+func normalizeLayoutAttribute(v string, supported ...string) string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if alias, ok := layoutAliases[v]; ok {
+		v = alias
+	}
+	for _, s := range supported {
+		if v == s {
+			return v
+		}
+	}
+	return ""
+}
+
+// validateLayout normalizes the layout inferred by the model, the unknown attributes are dropped.
+func validateLayout(g *c4ContainersGraph) findings {
+	if g.Layout == nil {
+		return nil
+	}
+
+	var o findings
+	for _, attr := range []struct {
+		name      string
+		v         *string
+		supported []string
+	}{
+		{
+			name: "direction", v: &g.Layout.Direction,
+			supported: []string{
+				diagram.LayoutDirectionTopDown, diagram.LayoutDirectionLeftRight, diagram.LayoutDirectionLandscape,
+			},
+		},
+		{
+			name: "spacing", v: &g.Layout.Spacing,
+			supported: []string{diagram.LayoutSpacingCompact, diagram.LayoutSpacingWide},
+		},
+		{
+			name: "theme", v: &g.Layout.Theme,
+			supported: []string{diagram.LayoutThemeDefault, diagram.LayoutThemeDark, diagram.LayoutThemeCorporate},
+		},
+	} {
+		if *attr.v == "" {
+			continue
+		}
+
+		v := normalizeLayoutAttribute(*attr.v, attr.supported...)
+		switch {
+		case v == "":
+			o = append(
+				o, finding{
+					Code: findingUnknownLayout, Severity: severityRepaired, Element: "layout." + attr.name,
+					Message: "unknown " + attr.name + " " + *attr.v + " is dropped",
+				},
+			)
+		case v != *attr.v:
+			o = append(
+				o, finding{
+					Code: findingLayoutAlias, Severity: severityRepaired, Element: "layout." + attr.name,
+					Message: attr.name + " " + *attr.v + " is replaced with " + v,
+				},
+			)
+		}
+		*attr.v = v
+	}
+
+	return o
+}
+
+// themes defines the styles of the named themes.
+var themes = map[string]string{
+	diagram.LayoutThemeDark: `skinparam backgroundColor #1E1E1E
+skinparam titleFontColor #E0E0E0
+skinparam footerFontColor #9E9E9E
+skinparam legendBackgroundColor #2B2B2B
+skinparam legendFontColor #E0E0E0
+UpdateElementStyle("person", $bgColor="#1F4E79", $fontColor="#E0E0E0", $borderColor="#5B9BD5")
+UpdateElementStyle("external_person", $bgColor="#3A3A3A", $fontColor="#E0E0E0", $borderColor="#6E6E6E")
+UpdateElementStyle("container", $bgColor="#2E5C8A", $fontColor="#E0E0E0", $borderColor="#6FA8DC")
+UpdateElementStyle("external_container", $bgColor="#4A4A4A", $fontColor="#E0E0E0", $borderColor="#7A7A7A")
+UpdateRelStyle($textColor="#C0C0C0", $lineColor="#9E9E9E")
+UpdateBoundaryStyle($fontColor="#E0E0E0", $borderColor="#9E9E9E")
+`,
+	diagram.LayoutThemeCorporate: `skinparam defaultFontName Helvetica
+UpdateElementStyle("person", $bgColor="#263950", $fontColor="#FFFFFF", $borderColor="#1B2A3B")
+UpdateElementStyle("external_person", $bgColor="#86919B", $fontColor="#FFFFFF", $borderColor="#6B757D")
+UpdateElementStyle("container", $bgColor="#3498DB", $fontColor="#FFFFFF", $borderColor="#2A7AB0")
+UpdateElementStyle("external_container", $bgColor="#919EA8", $fontColor="#FFFFFF", $borderColor="#747F87")
+UpdateRelStyle($textColor="#263950", $lineColor="#263950")
+UpdateBoundaryStyle($fontColor="#263950", $borderColor="#263950")
+AddElementTag("highlight", $bgColor="#5BBAD5", $fontColor="#263950", $borderColor="#263950")
+`,
+}
+
+// dslLayout returns the directives of the diagram's layout and theme.
+func dslLayout(l *layout) string {
+	if l == nil {
+		return ""
+	}
+
+	var o bytes.Buffer
+	switch l.Direction {
+	case diagram.LayoutDirectionTopDown:
+		writeStrings(&o, "LAYOUT_TOP_DOWN()\n")
+	case diagram.LayoutDirectionLeftRight:
+		writeStrings(&o, "LAYOUT_LEFT_RIGHT()\n")
+	case diagram.LayoutDirectionLandscape:
+		writeStrings(&o, "LAYOUT_LANDSCAPE()\n")
+	}
+
+	if l.LegendInLayout {
+		writeStrings(&o, "LAYOUT_WITH_LEGEND()\n")
+	}
+	if l.Sketch {
+		writeStrings(&o, "LAYOUT_AS_SKETCH()\n")
+	}
+	if l.HideStereotypes {
+		writeStrings(&o, "HIDE_STEREOTYPE()\n")
+	}
+
+	switch l.Spacing {
+	case diagram.LayoutSpacingCompact:
+		writeStrings(&o, "skinparam nodesep 20\nskinparam ranksep 30\n")
+	case diagram.LayoutSpacingWide:
+		writeStrings(&o, "skinparam nodesep 80\nskinparam ranksep 100\n")
+	}
+
+	writeStrings(&o, themes[l.Theme])
+
+	return o.String()
+}
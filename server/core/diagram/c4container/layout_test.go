@@ -0,0 +1,223 @@
+package c4container
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+)
+
+func Test_c4ContainersGraph_applyLayout(t *testing.T) {
+	enabled := true
+	disabled := false
+
+	tests := []struct {
+		name      string
+		inferred  *layout
+		requested diagram.Layout
+		want      *layout
+	}{
+		{
+			name:     "shall keep the inferred layout if no layout is requested",
+			inferred: &layout{Direction: diagram.LayoutDirectionLeftRight, Sketch: true},
+			want:     &layout{Direction: diagram.LayoutDirectionLeftRight, Sketch: true},
+		},
+		{
+			name: "shall keep the graph without layout if no layout is requested",
+		},
+		{
+			name:      "shall set the requested layout",
+			requested: diagram.Layout{Theme: diagram.LayoutThemeDark, HideStereotypes: &enabled},
+			want:      &layout{Theme: diagram.LayoutThemeDark, HideStereotypes: true},
+		},
+		{
+			name: "shall override the inferred layout with the requested attributes",
+			inferred: &layout{
+				Direction: diagram.LayoutDirectionLeftRight, Spacing: diagram.LayoutSpacingCompact, Sketch: true,
+			},
+			requested: diagram.Layout{
+				Direction: diagram.LayoutDirectionTopDown, Sketch: &disabled, LegendInLayout: &enabled,
+			},
+			want: &layout{
+				Direction: diagram.LayoutDirectionTopDown, Spacing: diagram.LayoutSpacingCompact, LegendInLayout: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// GIVEN
+				g := &c4ContainersGraph{Layout: tt.inferred}
+
+				// WHEN
+				g.applyLayout(tt.requested)
+
+				// THEN
+				if !reflect.DeepEqual(g.Layout, tt.want) {
+					t.Errorf("unexpected layout: got = %+v, want = %+v", g.Layout, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_validateLayout(t *testing.T) {
+	tests := []struct {
+		name      string
+		layout    *layout
+		want      *layout
+		wantCodes []string
+	}{
+		{
+			name: "shall pass the graph without layout",
+		},
+		{
+			name: "shall pass the valid layout",
+			layout: &layout{
+				Direction: diagram.LayoutDirectionLandscape, Spacing: diagram.LayoutSpacingWide,
+				Theme: diagram.LayoutThemeCorporate,
+			},
+			want: &layout{
+				Direction: diagram.LayoutDirectionLandscape, Spacing: diagram.LayoutSpacingWide,
+				Theme: diagram.LayoutThemeCorporate,
+			},
+		},
+		{
+			name:   "shall replace the aliases",
+			layout: &layout{Direction: "Left to Right", Spacing: "tight", Theme: "DARK"},
+			want: &layout{
+				Direction: diagram.LayoutDirectionLeftRight, Spacing: diagram.LayoutSpacingCompact,
+				Theme: diagram.LayoutThemeDark,
+			},
+			wantCodes: []string{findingLayoutAlias, findingLayoutAlias, findingLayoutAlias},
+		},
+		{
+			name:      "shall drop the unknown attributes",
+			layout:    &layout{Direction: "diagonal", Theme: "neon", Sketch: true},
+			want:      &layout{Sketch: true},
+			wantCodes: []string{findingUnknownLayout, findingUnknownLayout},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// GIVEN
+				g := &c4ContainersGraph{Layout: tt.layout}
+
+				// WHEN
+				got := validateLayout(g)
+
+				// THEN
+				var gotCodes []string
+				for _, f := range got {
+					if f.Severity != severityRepaired {
+						t.Errorf("unexpected severity: %s", f.Severity)
+					}
+					gotCodes = append(gotCodes, f.Code)
+				}
+				if !reflect.DeepEqual(gotCodes, tt.wantCodes) {
+					t.Errorf("unexpected findings: got = %v, want = %v", gotCodes, tt.wantCodes)
+				}
+				if !reflect.DeepEqual(g.Layout, tt.want) {
+					t.Errorf("unexpected layout: got = %+v, want = %+v", g.Layout, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_marshalLayout(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout *layout
+		want   string
+	}{
+		{
+			name: "shall write the layout's directives",
+			layout: &layout{
+				Direction: diagram.LayoutDirectionLeftRight, Spacing: diagram.LayoutSpacingCompact, Sketch: true,
+				HideStereotypes: true,
+			},
+			want: `@startuml
+!include <C4/C4_Container>
+LAYOUT_LEFT_RIGHT()
+LAYOUT_AS_SKETCH()
+HIDE_STEREOTYPE()
+skinparam nodesep 20
+skinparam ranksep 30
+footer "generated by diagramastext.dev - %date('yyyy-MM-dd')"
+Container(0, "0")
+SHOW_LEGEND()
+@enduml`,
+		},
+		{
+			name:   "shall replace the legend with the legend placed in the layout",
+			layout: &layout{Direction: diagram.LayoutDirectionTopDown, LegendInLayout: true},
+			want: `@startuml
+!include <C4/C4_Container>
+LAYOUT_TOP_DOWN()
+LAYOUT_WITH_LEGEND()
+footer "generated by diagramastext.dev - %date('yyyy-MM-dd')"
+Container(0, "0")
+@enduml`,
+		},
+		{
+			name:   "shall write the corporate theme",
+			layout: &layout{Theme: diagram.LayoutThemeCorporate},
+			want: `@startuml
+!include <C4/C4_Container>
+` + themes[diagram.LayoutThemeCorporate] + `footer "generated by diagramastext.dev - %date('yyyy-MM-dd')"
+Container(0, "0")
+SHOW_LEGEND()
+@enduml`,
+		},
+		{
+			name:   "shall write no directives for the default theme",
+			layout: &layout{Theme: diagram.LayoutThemeDefault},
+			want: `@startuml
+!include <C4/C4_Container>
+footer "generated by diagramastext.dev - %date('yyyy-MM-dd')"
+Container(0, "0")
+SHOW_LEGEND()
+@enduml`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// GIVEN
+				g := &c4ContainersGraph{Containers: []*container{{ID: "0"}}, Layout: tt.layout, WithLegend: true}
+
+				// WHEN
+				got, err := marshal(g, C4PlantUML{})
+
+				// THEN
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("unexpected DSL:\n%s\nwant:\n%s", got, tt.want)
+				}
+				assertValidDSL(t, got)
+			},
+		)
+	}
+}
+
+func Test_themes(t *testing.T) {
+	for _, theme := range []string{diagram.LayoutThemeDark, diagram.LayoutThemeCorporate} {
+		t.Run(
+			theme, func(t *testing.T) {
+				if themes[theme] == "" {
+					t.Fatalf("theme %s is not defined", theme)
+				}
+				g := &c4ContainersGraph{Containers: []*container{{ID: "0"}}, Layout: &layout{Theme: theme}}
+				got, err := marshal(g, C4PlantUML{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertValidDSL(t, got)
+			},
+		)
+	}
+}
@@ -0,0 +1,634 @@
+package c4container
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+	"github.com/kislerdm/diagramastext/server/core/errors"
+)
+
+// dslSyntaxError defines the statement of the DSL which cannot be parsed.
+type dslSyntaxError struct {
+	Line    int
+	Message string
+}
+
+func (e dslSyntaxError) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": " + e.Message
+}
+
+// parse reads the C4-PlantUML containers diagram's DSL into the graph.
+// It supports the statements written by marshal, and their common variants, e.g. System, Component,
+// Rel_Down, Rel_Back, or the positional arguments instead of the keyword arguments.
+// The unknown statements, the comments and the preprocessor's blocks, e.g. the inlined C4-PlantUML library,
+// are skipped. The boundaries without attributes besides the name are read as the containers' groups.
+func parse(dsl []byte) (*c4ContainersGraph, error) {
+	lines := strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(dsl)), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+
+	p := &dslParser{graph: &c4ContainersGraph{}, sprites: map[string]string{}}
+	lines = p.readTheme(lines)
+
+	for i, line := range lines {
+		if err := p.read(line); err != nil {
+			return nil, dslSyntaxError{Line: i + 1, Message: err.Error()}
+		}
+	}
+
+	if len(p.boundaries) > 0 {
+		return nil, dslSyntaxError{Line: len(lines), Message: "boundary is not closed"}
+	}
+	if len(p.graph.Containers) == 0 {
+		return nil, errors.New("no containers found")
+	}
+
+	p.resolveSprites()
+
+	return p.graph, nil
+}
+
+// parsedBoundary defines the boundary being read with its members.
+type parsedBoundary struct {
+	alias       string
+	boundary    *boundary
+	members     []*container
+	hasChildren bool
+	parent      *parsedBoundary
+}
+
+type dslParser struct {
+	graph *c4ContainersGraph
+	// sprites maps the sprites' names to their paths in the PlantUML standard library.
+	sprites map[string]string
+	// boundaries defines the stack of the boundaries being read.
+	boundaries []*parsedBoundary
+	// pending defines the boundary which block is opened on the next line.
+	pending *parsedBoundary
+	// preprocessorDepth defines the depth of the preprocessor's blocks being skipped.
+	preprocessorDepth int
+	inComment         bool
+}
+
+// readTheme detects the named theme written by marshal, and returns the lines without the theme's directives.
+func (p *dslParser) readTheme(lines []string) []string {
+	for _, name := range []string{diagram.LayoutThemeDark, diagram.LayoutThemeCorporate} {
+		themeLines := strings.Split(strings.TrimSpace(themes[name]), "\n")
+
+		positions := map[string][]int{}
+		for i, line := range lines {
+			positions[line] = append(positions[line], i)
+		}
+
+		skip := map[int]struct{}{}
+		for _, line := range themeLines {
+			found := false
+			for _, i := range positions[line] {
+				if _, ok := skip[i]; !ok {
+					skip[i] = struct{}{}
+					found = true
+					break
+				}
+			}
+			if !found {
+				skip = nil
+				break
+			}
+		}
+		if skip == nil {
+			continue
+		}
+
+		p.layout().Theme = name
+		o := make([]string, len(lines))
+		for i, line := range lines {
+			// the lines are kept to preserve the lines' numbers in the syntax errors
+			if _, ok := skip[i]; !ok {
+				o[i] = line
+			}
+		}
+		return o
+	}
+	return lines
+}
+
+func (p *dslParser) layout() *layout {
+	if p.graph.Layout == nil {
+		p.graph.Layout = &layout{}
+	}
+	return p.graph.Layout
+}
+
+var (
+	preprocessorBlockStartRegex = regexp.MustCompile(
+		`^!(?:unquoted\s+)?(?:final\s+)?(?:procedure|function|if|ifdef|ifndef|while|foreach|definelong)\b`,
+	)
+	preprocessorBlockEndRegex = regexp.MustCompile(
+		`^!(?:endprocedure|endfunction|endif|endwhile|endfor|enddefinelong)\b`,
+	)
+	spriteIncludeRegex = regexp.MustCompile(`^!include\s+<tupadr3/([a-z0-9-]+)/([a-z0-9_-]+)>$`)
+)
+
+func (p *dslParser) read(line string) error {
+	switch {
+	case p.inComment:
+		p.inComment = !strings.HasSuffix(line, "'/")
+		return nil
+	case strings.HasPrefix(line, "/'"):
+		p.inComment = !strings.HasSuffix(line[2:], "'/")
+		return nil
+	case preprocessorBlockStartRegex.MatchString(line):
+		p.preprocessorDepth++
+		return nil
+	case preprocessorBlockEndRegex.MatchString(line):
+		if p.preprocessorDepth > 0 {
+			p.preprocessorDepth--
+		}
+		return nil
+	case p.preprocessorDepth > 0:
+		return nil
+	case line == "" || strings.HasPrefix(line, "'") || strings.HasPrefix(line, "@"):
+		return nil
+	case strings.HasPrefix(line, "!"):
+		if m := spriteIncludeRegex.FindStringSubmatch(line); m != nil {
+			if _, ok := p.sprites[m[2]]; !ok {
+				p.sprites[m[2]] = m[1] + "/" + m[2]
+			}
+		}
+		return nil
+	case line == "{":
+		if p.pending == nil {
+			return errors.New("unexpected block opening")
+		}
+		p.boundaries = append(p.boundaries, p.pending)
+		p.pending = nil
+		return nil
+	case line == "}":
+		return p.closeBoundary()
+	}
+
+	p.pending = nil
+
+	if keyword, value, ok := strings.Cut(line, " "); ok {
+		switch keyword {
+		case "title":
+			p.graph.Title = dslQuotedValue(value)
+			return nil
+		case "footer":
+			if line != strings.TrimSpace(dslFooter("")) {
+				p.graph.Footer = dslQuotedValue(value)
+			}
+			return nil
+		case "skinparam":
+			p.readSkinparam(value)
+			return nil
+		}
+	}
+
+	name, args, block, err := parseMacro(line)
+	if err != nil {
+		return err
+	}
+
+	return p.readMacro(name, args, block)
+}
+
+func (p *dslParser) readSkinparam(v string) {
+	fields := strings.Fields(v)
+	if len(fields) != 2 || fields[0] != "nodesep" {
+		return
+	}
+	switch fields[1] {
+	case "20":
+		p.layout().Spacing = diagram.LayoutSpacingCompact
+	case "80":
+		p.layout().Spacing = diagram.LayoutSpacingWide
+	}
+}
+
+// containerMacroRegex defines the elements read as the containers.
+var containerMacroRegex = regexp.MustCompile(`^(Person|Container|System|Component)(Db|Queue)?(_Ext)?$`)
+
+// relMacroRegex defines the relations' variants.
+var relMacroRegex = regexp.MustCompile(`^(?:Bi)?Rel(?:_(Back|Neighbor|Back_Neighbor|[RLDU]|Right|Left|Down|Up))?$`)
+
+func (p *dslParser) readMacro(name string, args []macroArg, block bool) error {
+	if m := containerMacroRegex.FindStringSubmatch(name); m != nil {
+		if m[1] == "Person" && m[2] != "" {
+			return nil
+		}
+		return p.readContainer(m[1], m[2], m[3] != "", args)
+	}
+
+	if m := relMacroRegex.FindStringSubmatch(name); m != nil {
+		return p.readRel(m[1], args)
+	}
+
+	switch name {
+	case "Enterprise_Boundary", "System_Boundary", "Container_Boundary", "Boundary":
+		return p.readBoundary(name, args, block)
+	case "AddElementTag":
+		p.readElementTag(args)
+	case "AddRelTag":
+		p.readRelTag(args)
+	case "SHOW_LEGEND", "SHOW_FLOATING_LEGEND":
+		p.graph.WithLegend = true
+	case "LAYOUT_WITH_LEGEND":
+		p.graph.WithLegend = true
+		p.layout().LegendInLayout = true
+	case "LAYOUT_TOP_DOWN":
+		p.layout().Direction = diagram.LayoutDirectionTopDown
+	case "LAYOUT_LEFT_RIGHT":
+		p.layout().Direction = diagram.LayoutDirectionLeftRight
+	case "LAYOUT_LANDSCAPE":
+		p.layout().Direction = diagram.LayoutDirectionLandscape
+	case "LAYOUT_AS_SKETCH":
+		p.layout().Sketch = true
+	case "HIDE_STEREOTYPE":
+		p.layout().HideStereotypes = true
+	}
+
+	return nil
+}
+
+func (p *dslParser) readContainer(kind, shape string, isExternal bool, args []macroArg) error {
+	params := []string{"alias", "label", "techn", "descr", "sprite", "tags", "link"}
+	if kind == "Person" || kind == "System" {
+		params = []string{"alias", "label", "descr", "sprite", "tags", "link"}
+	}
+
+	v := macroArgs(args, params...)
+	if v["alias"] == "" {
+		return errors.New(kind + " must be identified")
+	}
+
+	n := &container{
+		ID:          v["alias"],
+		Label:       v["label"],
+		Technology:  v["techn"],
+		Description: v["descr"],
+		IsExternal:  isExternal,
+		IsQueue:     shape == "Queue",
+		IsDatabase:  shape == "Db",
+		IsUser:      kind == "Person",
+		Sprite:      v["sprite"],
+		Tags:        parseTags(v["tags"]),
+		Link:        v["link"],
+	}
+	if n.Label == n.ID {
+		// the container's id is written as the label if the latter is not set
+		n.Label = ""
+	}
+
+	p.graph.Containers = append(p.graph.Containers, n)
+	if len(p.boundaries) > 0 {
+		b := p.boundaries[len(p.boundaries)-1]
+		b.members = append(b.members, n)
+	}
+
+	return nil
+}
+
+func (p *dslParser) readRel(variant string, args []macroArg) error {
+	v := macroArgs(args, "from", "to", "label", "techn", "descr", "sprite", "tags", "link")
+	if v["from"] == "" || v["to"] == "" {
+		return errors.New("relation must specify the end nodes")
+	}
+
+	l := &rel{
+		From:       v["from"],
+		To:         v["to"],
+		Label:      v["label"],
+		Technology: v["techn"],
+		Tags:       parseTags(v["tags"]),
+		Link:       v["link"],
+	}
+	if l.Label == "Uses" {
+		// the default label is written by marshal if the label is not set
+		l.Label = ""
+	}
+
+	switch variant {
+	case "R", "Right":
+		l.Direction = "LR"
+	case "L", "Left":
+		l.Direction = "RL"
+	case "D", "Down":
+		l.Direction = "TD"
+	case "U", "Up":
+		l.Direction = "DT"
+	case "Back", "Back_Neighbor":
+		// the arrow points to the first argument
+		l.From, l.To = l.To, l.From
+	}
+
+	p.graph.Rels = append(p.graph.Rels, l)
+	return nil
+}
+
+func (p *dslParser) readBoundary(name string, args []macroArg, block bool) error {
+	params := []string{"alias", "label", "tags", "link"}
+	if name == "Boundary" {
+		params = []string{"alias", "label", "type", "tags", "link"}
+	}
+
+	v := macroArgs(args, params...)
+	if v["alias"] == "" {
+		return errors.New(name + " must be identified")
+	}
+
+	b := &parsedBoundary{
+		alias: v["alias"],
+		boundary: &boundary{
+			ID:    v["alias"],
+			Label: v["label"],
+			Tags:  parseTags(v["tags"]),
+			Link:  v["link"],
+		},
+	}
+
+	switch name {
+	case "Enterprise_Boundary":
+		b.boundary.Type = "enterprise"
+	case "Container_Boundary":
+		b.boundary.Type = "container"
+	case "Boundary":
+		switch t := strings.ToLower(v["type"]); t {
+		case "enterprise", "container":
+			b.boundary.Type = t
+		}
+	}
+
+	if len(p.boundaries) > 0 {
+		b.parent = p.boundaries[len(p.boundaries)-1]
+		b.parent.hasChildren = true
+		b.boundary.Parent = b.parent.boundary.ID
+	}
+
+	p.graph.Boundaries = append(p.graph.Boundaries, b.boundary)
+	if block {
+		p.boundaries = append(p.boundaries, b)
+	} else {
+		p.pending = b
+	}
+
+	return nil
+}
+
+// implicitBoundaryAliasRegex defines the suffix added to the boundary's alias to make it unique.
+var implicitBoundaryAliasRegex = regexp.MustCompile(`^(.+?)(?:_[0-9]+)?$`)
+
+// closeBoundary assigns the boundary to its members. The boundary defined only by its name,
+// i.e. written by marshal for the containers' group which is not declared, is removed from the boundaries.
+func (p *dslParser) closeBoundary() error {
+	if len(p.boundaries) == 0 {
+		return errors.New("unexpected block closing")
+	}
+
+	b := p.boundaries[len(p.boundaries)-1]
+	p.boundaries = p.boundaries[:len(p.boundaries)-1]
+
+	if b.boundary.Label == "" {
+		b.boundary.Label = b.boundary.ID
+	}
+
+	name := sanitizeIdentifier(b.boundary.Label)
+	isImplicit := b.boundary.Type == "" && b.boundary.Tags == nil && b.boundary.Link == "" &&
+		b.parent == nil && !b.hasChildren &&
+		(b.alias == name || implicitBoundaryAliasRegex.FindStringSubmatch(b.alias)[1] == name)
+
+	group := b.boundary.ID
+	if isImplicit {
+		group = b.boundary.Label
+		p.removeBoundary(b.boundary)
+	} else if b.boundary.Label == b.boundary.ID {
+		// the boundary's id is written as the label if the latter is not set
+		b.boundary.Label = ""
+	}
+
+	for _, n := range b.members {
+		if n.System == "" {
+			n.System = group
+		}
+	}
+
+	return nil
+}
+
+func (p *dslParser) removeBoundary(b *boundary) {
+	o := p.graph.Boundaries[:0]
+	for _, el := range p.graph.Boundaries {
+		if el != b {
+			o = append(o, el)
+		}
+	}
+	p.graph.Boundaries = o
+	if len(o) == 0 {
+		p.graph.Boundaries = nil
+	}
+}
+
+func (p *dslParser) readElementTag(args []macroArg) {
+	v := macroArgs(args, "tagStereo", "bgColor", "fontColor", "borderColor", "shadowing", "shape")
+	if v["tagStereo"] == "" {
+		return
+	}
+
+	t := &elementTag{
+		Tag:         v["tagStereo"],
+		BgColor:     v["bgColor"],
+		FontColor:   v["fontColor"],
+		BorderColor: v["borderColor"],
+	}
+	switch v["shape"] {
+	case "RoundedBoxShape()":
+		t.Shape = "rounded"
+	case "EightSidedShape()":
+		t.Shape = "eight_sided"
+	}
+
+	p.graph.ElementTags = append(p.graph.ElementTags, t)
+}
+
+func (p *dslParser) readRelTag(args []macroArg) {
+	v := macroArgs(args, "tagStereo", "textColor", "lineColor", "lineStyle")
+	if v["tagStereo"] == "" {
+		return
+	}
+
+	t := &relTag{
+		Tag:       v["tagStereo"],
+		TextColor: v["textColor"],
+		LineColor: v["lineColor"],
+	}
+	switch v["lineStyle"] {
+	case "DashedLine()":
+		t.LineStyle = "dashed"
+	case "DottedLine()":
+		t.LineStyle = "dotted"
+	case "BoldLine()":
+		t.LineStyle = "bold"
+	}
+
+	p.graph.RelTags = append(p.graph.RelTags, t)
+}
+
+// resolveSprites replaces the sprites' names with their paths in the PlantUML standard library,
+// the sprites which are not included from the standard library are dropped.
+func (p *dslParser) resolveSprites() {
+	for _, n := range p.graph.Containers {
+		if n.Sprite == "" || spriteRegex.MatchString(n.Sprite) {
+			continue
+		}
+		n.Sprite = p.sprites[n.Sprite]
+	}
+}
+
+func parseTags(s string) []string {
+	var o []string
+	for _, tag := range strings.Split(s, "+") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			o = append(o, tag)
+		}
+	}
+	return o
+}
+
+// macroArg defines the macro's argument: the positional argument if the key is empty, or the keyword argument.
+type macroArg struct {
+	key   string
+	value string
+}
+
+// macroArgs maps the macro's arguments to the parameters' names. The positional arguments are mapped
+// in the order of the parameters, the keyword arguments are mapped by the name, e.g. $techn.
+func macroArgs(args []macroArg, params ...string) map[string]string {
+	o := map[string]string{}
+	var i int
+	for _, a := range args {
+		if a.key != "" {
+			o[a.key] = a.value
+			continue
+		}
+		if i < len(params) {
+			o[params[i]] = a.value
+		}
+		i++
+	}
+	return o
+}
+
+var macroNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseMacro reads the macro's call, e.g. Container(api, "API", $techn="Go"), optionally followed by
+// the opening of the block.
+func parseMacro(line string) (name string, args []macroArg, block bool, err error) {
+	start := strings.IndexByte(line, '(')
+	if start < 0 {
+		return "", nil, false, nil
+	}
+
+	name = strings.TrimSpace(line[:start])
+	if !macroNameRegex.MatchString(name) {
+		// the statements of the PlantUML which are not macros are skipped
+		return "", nil, false, nil
+	}
+
+	var (
+		depth   = 1
+		inQuote bool
+		argFrom = start + 1
+		end     = -1
+		raw     []string
+	)
+	for i := start + 1; i < len(line) && end < 0; i++ {
+		switch c := line[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				end = i
+				raw = append(raw, line[argFrom:i])
+			}
+		case c == ',' && depth == 1:
+			raw = append(raw, line[argFrom:i])
+			argFrom = i + 1
+		}
+	}
+
+	if inQuote {
+		return "", nil, false, errors.New("string is not terminated: " + line)
+	}
+	if end < 0 {
+		return "", nil, false, errors.New("macro's call is not closed: " + line)
+	}
+
+	switch rest := strings.TrimSpace(line[end+1:]); rest {
+	case "":
+	case "{":
+		block = true
+	default:
+		return "", nil, false, errors.New("unexpected statement after macro's call: " + line)
+	}
+
+	if len(raw) == 1 && strings.TrimSpace(raw[0]) == "" {
+		return name, nil, block, nil
+	}
+
+	for _, s := range raw {
+		args = append(args, parseArg(strings.TrimSpace(s)))
+	}
+
+	return name, args, block, nil
+}
+
+var keywordArgRegex = regexp.MustCompile(`^\$([A-Za-z]+)\s*=\s*(.*)$`)
+
+func parseArg(s string) macroArg {
+	if m := keywordArgRegex.FindStringSubmatch(s); m != nil {
+		return macroArg{key: m[1], value: dslQuotedValue(m[2])}
+	}
+	return macroArg{value: dslQuotedValue(s)}
+}
+
+// dslQuotedValue returns the unescaped content of the quoted string, or the value as is if it's not quoted.
+func dslQuotedValue(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return dslUnescape(s[1 : len(s)-1])
+	}
+	return s
+}
+
+var htmlEntityRegex = regexp.MustCompile(`&#(?:([0-9]{1,7})|[xX]([0-9A-Fa-f]{1,6}));`)
+
+// dslUnescape reverts dslString: the PlantUML's line breaks, and the HTML numeric entities are decoded.
+// The decoded string is cleaned the same way as by dslString.
+func dslUnescape(s string) string {
+	s = strings.ReplaceAll(s, `\n`, "\n")
+	return cleanString(htmlEntityRegex.ReplaceAllStringFunc(
+		s, func(entity string) string {
+			m := htmlEntityRegex.FindStringSubmatch(entity)
+			var (
+				v   uint64
+				err error
+			)
+			if m[1] != "" {
+				v, err = strconv.ParseUint(m[1], 10, 32)
+			} else {
+				v, err = strconv.ParseUint(m[2], 16, 32)
+			}
+			if err != nil || v == 0 || v > 0x10FFFF {
+				return entity
+			}
+			return string(rune(v))
+		},
+	))
+}
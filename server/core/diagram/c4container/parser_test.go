@@ -0,0 +1,403 @@
+package c4container
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kislerdm/diagramastext/server/core/diagram"
+)
+
+func Test_parseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph *c4ContainersGraph
+	}{
+		{
+			name:  "shall read the single container",
+			graph: &c4ContainersGraph{Containers: []*container{{ID: "0"}}},
+		},
+		{
+			name: "shall read the containers, relations and groups",
+			graph: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "user", Label: "User", Description: "Buys goods", IsUser: true, IsExternal: true},
+					{ID: "spa", Label: "Web App", Technology: "React", Sprite: "devicons2/react", Tags: []string{"ui"}},
+					{
+						ID: "api", Label: "API", Technology: "Go", Description: "Handles \"orders\"\nand 100% of $ [x] <y>",
+						System: "Core Team", Sprite: "devicons2/go", Link: "https://example.com/api",
+					},
+					{ID: "db", Label: "Database", Technology: "Postgres", System: "Core Team", IsDatabase: true},
+					{ID: "bus", Label: "Bus", Technology: "Kafka", IsQueue: true, IsExternal: true, System: "Ops"},
+				},
+				Rels: []*rel{
+					{From: "user", To: "spa", Direction: "LR"},
+					{From: "spa", To: "api", Label: "calls", Technology: "HTTP", Tags: []string{"sync", "critical"}},
+					{From: "api", To: "db", Label: "reads", Direction: "TD", Link: "https://example.com/db"},
+					{From: "api", To: "bus", Label: "publishes", Direction: "RL"},
+					{From: "bus", To: "api", Label: "consumes", Direction: "DT"},
+				},
+				Title:      "Shop",
+				Footer:     "v1",
+				WithLegend: true,
+			},
+		},
+		{
+			name: "shall read the declared boundaries, tags' styles and layout",
+			graph: &c4ContainersGraph{
+				Containers: []*container{
+					{ID: "payments", Label: "Payments", IsExternal: true, Tags: []string{"vendor"}},
+					{ID: "api", Label: "API", Technology: "Go", System: "shop"},
+					{ID: "worker", Label: "Worker", Technology: "Go", System: "jobs"},
+				},
+				Rels: []*rel{
+					{From: "api", To: "payments", Label: "charges", Technology: "HTTP", Tags: []string{"async"}},
+				},
+				Boundaries: []*boundary{
+					{ID: "acme", Label: "ACME", Type: "enterprise", Link: "https://acme.com"},
+					{ID: "shop", Label: "Shop", Parent: "acme", Tags: []string{"v2"}},
+					{ID: "jobs", Label: "Jobs", Type: "container", Parent: "shop"},
+					{ID: "empty", Label: "Empty", Type: "container"},
+				},
+				ElementTags: []*elementTag{
+					{Tag: "vendor", BgColor: "#FF0000", FontColor: "white", BorderColor: "#000", Shape: "eight_sided"},
+					{Tag: "v2", Shape: "rounded"},
+				},
+				RelTags: []*relTag{
+					{Tag: "async", TextColor: "grey", LineColor: "#AAA", LineStyle: "dashed"},
+					{Tag: "bold", LineStyle: "bold"},
+				},
+				Layout: &layout{
+					Direction: diagram.LayoutDirectionLeftRight, Spacing: diagram.LayoutSpacingWide,
+					Theme: diagram.LayoutThemeDark, Sketch: true, HideStereotypes: true,
+				},
+			},
+		},
+		{
+			name: "shall read the legend placed in the layout and the corporate theme",
+			graph: &c4ContainersGraph{
+				Containers: []*container{{ID: "0", Label: "Backend", Tags: []string{"highlight"}}},
+				Layout: &layout{
+					Direction: diagram.LayoutDirectionLandscape, Spacing: diagram.LayoutSpacingCompact,
+					Theme: diagram.LayoutThemeCorporate, LegendInLayout: true,
+				},
+				WithLegend: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// GIVEN
+				dsl, err := marshal(tt.graph, C4PlantUML{})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// WHEN
+				got, err := parse(dsl)
+
+				// THEN
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.graph) {
+					gotJSON, _ := json.Marshal(got)
+					wantJSON, _ := json.Marshal(tt.graph)
+					t.Errorf("unexpected graph:\n%s\nwant:\n%s\ndsl:\n%s", gotJSON, wantJSON, dsl)
+				}
+			},
+		)
+	}
+}
+
+func Test_parse(t *testing.T) {
+	tests := []struct {
+		name string
+		dsl  string
+		want *c4ContainersGraph
+	}{
+		{
+			name: "shall read the hand-written diagram",
+			dsl: `@startuml
+!include https://raw.githubusercontent.com/plantuml-stdlib/C4-PlantUML/master/C4_Container.puml
+!include <tupadr3/font-awesome-5/users>
+
+' the comment is skipped
+/' the block
+comment is skipped '/
+title Internet Banking
+
+Person(customer, Customer, "A customer of the bank", $sprite="users")
+System_Boundary(c1, "Internet Banking")
+{
+    Container(web_app, "Web Application", "Java, Spring MVC", "Delivers the static content")
+    ContainerDb(database, "Database", "SQL Database", "Stores &#34;user&#34; data", "", "pii")
+}
+System_Ext(email_system, "E-Mail System", "The internal Microsoft Exchange system")
+Boundary(b, "Cloud", "enterprise") {
+    Component_Ext(cdn, "CDN", $techn="Cloudflare")
+}
+
+Rel_Down(customer, web_app, "Uses", "HTTPS")
+Rel_Back(database, web_app, "Reads from and writes to", "JDBC")
+BiRel_Neighbor(web_app, email_system, "Sends e-mails", $link="https://example.com")
+Lay_R(web_app, email_system)
+UpdateElementStyle("person", $bgColor="#000000")
+SHOW_FLOATING_LEGEND()
+@enduml`,
+			want: &c4ContainersGraph{
+				Containers: []*container{
+					{
+						ID: "customer", Label: "Customer", Description: "A customer of the bank", IsUser: true,
+						Sprite: "font-awesome-5/users",
+					},
+					{
+						ID: "web_app", Label: "Web Application", Technology: "Java, Spring MVC",
+						Description: "Delivers the static content", System: "c1",
+					},
+					{
+						ID: "database", Label: "Database", Technology: "SQL Database", Description: `Stores "user" data`,
+						IsDatabase: true, System: "c1", Tags: []string{"pii"},
+					},
+					{
+						ID: "email_system", Label: "E-Mail System", Description: "The internal Microsoft Exchange system",
+						IsExternal: true,
+					},
+					{ID: "cdn", Label: "CDN", Technology: "Cloudflare", IsExternal: true, System: "b"},
+				},
+				Rels: []*rel{
+					{From: "customer", To: "web_app", Technology: "HTTPS", Direction: "TD"},
+					{From: "web_app", To: "database", Label: "Reads from and writes to", Technology: "JDBC"},
+					{From: "web_app", To: "email_system", Label: "Sends e-mails", Link: "https://example.com"},
+				},
+				Boundaries: []*boundary{
+					{ID: "c1", Label: "Internet Banking"},
+					{ID: "b", Label: "Cloud", Type: "enterprise"},
+				},
+				Title:      "Internet Banking",
+				WithLegend: true,
+			},
+		},
+		{
+			name: "shall skip the inlined library",
+			dsl: `@startuml
+' C4
+!$C4Version = "2.8.0"
+!unquoted procedure Container($alias, $label, $techn="", $descr="")
+Container($alias, $label)
+!endprocedure
+!if %variable_exists("RELATIVE_INCLUDE")
+Rel(a, b)
+!endif
+Container(api, "API")
+@enduml`,
+			want: &c4ContainersGraph{Containers: []*container{{ID: "api", Label: "API"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// WHEN
+				got, err := parse([]byte(tt.dsl))
+
+				// THEN
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					gotJSON, _ := json.Marshal(got)
+					wantJSON, _ := json.Marshal(tt.want)
+					t.Errorf("unexpected graph:\n%s\nwant:\n%s", gotJSON, wantJSON)
+				}
+			},
+		)
+	}
+}
+
+func Test_parseUnhappyPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		dsl      string
+		wantLine int
+	}{
+		{
+			name: "shall fail: no containers",
+			dsl:  "@startuml\nRel(a, b)\n@enduml",
+		},
+		{
+			name:     "shall fail: string is not terminated",
+			dsl:      "@startuml\nContainer(a, \"A)\n@enduml",
+			wantLine: 2,
+		},
+		{
+			name:     "shall fail: macro's call is not closed",
+			dsl:      "@startuml\nContainer(a, \"A\"\n@enduml",
+			wantLine: 2,
+		},
+		{
+			name:     "shall fail: unexpected statement after macro's call",
+			dsl:      "@startuml\nContainer(a, \"A\") foo\n@enduml",
+			wantLine: 2,
+		},
+		{
+			name:     "shall fail: container without alias",
+			dsl:      "@startuml\nContainer($label=\"A\")\n@enduml",
+			wantLine: 2,
+		},
+		{
+			name:     "shall fail: relation without end",
+			dsl:      "@startuml\nContainer(a, \"A\")\nRel(a)\n@enduml",
+			wantLine: 3,
+		},
+		{
+			name:     "shall fail: unexpected block closing",
+			dsl:      "@startuml\nContainer(a, \"A\")\n}\n@enduml",
+			wantLine: 3,
+		},
+		{
+			name:     "shall fail: unexpected block opening",
+			dsl:      "@startuml\nContainer(a, \"A\")\n{\n@enduml",
+			wantLine: 3,
+		},
+		{
+			name:     "shall fail: boundary is not closed",
+			dsl:      "@startuml\nSystem_Boundary(b, \"B\") {\nContainer(a, \"A\")\n@enduml",
+			wantLine: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// WHEN
+				_, err := parse([]byte(tt.dsl))
+
+				// THEN
+				if err == nil {
+					t.Fatal("error expected")
+				}
+				if tt.wantLine > 0 {
+					var errSyntax dslSyntaxError
+					if !errors.As(err, &errSyntax) || errSyntax.Line != tt.wantLine {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}
+			},
+		)
+	}
+}
+
+func Test_dslUnescape(t *testing.T) {
+	for _, s := range []string{
+		`foo "bar" \ 100% $x <y> [z]`,
+		"line\nbreak",
+		"Ünïcödé ✓",
+	} {
+		if got := dslUnescape(dslString(s)); got != s {
+			t.Errorf("unexpected unescaped string: got %q, want %q", got, s)
+		}
+	}
+}
+
+// assertParseIdempotent asserts that the graph read from the DSL is stable after it's written and read again.
+// The graphs which cannot be written are skipped.
+func assertParseIdempotent(t *testing.T, graph *c4ContainersGraph) {
+	t.Helper()
+
+	dsl, err := marshal(graph, C4PlantUML{})
+	if err != nil {
+		return
+	}
+	want, err := parse(dsl)
+	if err != nil {
+		t.Fatalf("marshal output cannot be parsed: %v\n%s", err, dsl)
+	}
+
+	dsl, err = marshal(want, C4PlantUML{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parse(dsl)
+	if err != nil {
+		t.Fatalf("marshal output cannot be parsed: %v\n%s", err, dsl)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		t.Errorf("unstable graph:\n%s\nwant:\n%s\ndsl:\n%s", gotJSON, wantJSON, dsl)
+	}
+}
+
+func FuzzParseRoundTrip(f *testing.F) {
+	f.Add([]byte(`{"nodes":[{"id":"0","label":"Web Server","technology":"Go","group":"Core"},` +
+		`{"id":"1","label":"Database","technology":"Postgres","database":true}],` +
+		`"links":[{"from":"0","to":"1","label":"reads","direction":"LR"}],"title":"foo","legend":false}`))
+	f.Add([]byte(`{"nodes":[{"id":"0\")\n@enduml","label":"\"\\\\%$<>[]","group":"\n!include foo"}],` +
+		`"links":[{"from":"0\")\n@enduml","to":"x y","label":"}"}],"footer":"\r\n"}`))
+	f.Add([]byte(`{"boundaries":[{"id":"a","type":"enterprise","parent":"b","tags":["x\")"]},{"id":"b","parent":"a"},` +
+		`{"label":"c","parent":"a","link":"https://foo.bar/\"$%date()"}],` +
+		`"nodes":[{"id":"0","group":"c","sprite":"devicons2/go","tags":["a+b","\""],"link":"http://x/%zz%2f"}],` +
+		`"links":[{"from":"0","to":"0","tags":["$x"],"link":"https://foo.bar/?q=\"<>"}],` +
+		`"element_tags":[{"tag":"a","bg_color":"#fff\")","shape":"rounded"}],` +
+		`"link_tags":[{"tag":"$x","line_style":"dotted"},null]}`))
+	f.Add([]byte(`{"nodes":[{"id":"X","group":"X"},{"id":"a","group":"a b"},{"id":"ab"}],` +
+		`"layout":{"direction":"left_right","theme":"corporate","legend_in_layout":true}}`))
+
+	f.Fuzz(
+		func(t *testing.T, data []byte) {
+			// GIVEN
+			var graph c4ContainersGraph
+			if err := json.Unmarshal(data, &graph); err != nil || len(graph.Containers) == 0 {
+				return
+			}
+			for _, n := range graph.Containers {
+				if n == nil {
+					return
+				}
+			}
+			for _, l := range graph.Rels {
+				if l == nil {
+					return
+				}
+			}
+			for _, b := range graph.Boundaries {
+				if b == nil {
+					return
+				}
+			}
+
+			// WHEN & THEN
+			assertParseIdempotent(t, &graph)
+		},
+	)
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte(`@startuml
+System_Boundary(c1, "Internet Banking") {
+Container(web_app, "Web Application", "Java", "Delivers &#34;content&#34;", $tags="a+b")
+}
+Rel_Back(web_app, c1, "Uses", $link="https://x")
+@enduml`))
+	f.Add([]byte("Container(a, \"(\", $sprite=\"go\")\n!include <tupadr3/devicons/go>\nBoundary(b, \"B\")\n{\n}"))
+
+	f.Fuzz(
+		func(t *testing.T, data []byte) {
+			// WHEN
+			graph, err := parse(data)
+
+			// THEN
+			if err != nil {
+				return
+			}
+			for _, n := range graph.Containers {
+				if n.ID == "" {
+					t.Fatalf("container without id: %s", data)
+				}
+			}
+			assertParseIdempotent(t, graph)
+		},
+	)
+}
@@ -14,8 +14,10 @@ import (
 	"github.com/kislerdm/diagramastext/server/core/diagram/c4container/compression"
 )
 
-func renderDiagram(ctx context.Context, httpClient diagram.HTTPClient, v *c4ContainersGraph) ([]byte, error) {
-	c4ContainersDSL, err := marshal(v)
+func renderDiagram(
+	ctx context.Context, httpClient diagram.HTTPClient, v *c4ContainersGraph, lib C4PlantUML,
+) ([]byte, error) {
+	c4ContainersDSL, err := marshal(v, lib)
 	if err != nil {
 		return nil, err
 	}
@@ -25,13 +27,20 @@ func renderDiagram(ctx context.Context, httpClient diagram.HTTPClient, v *c4Cont
 		return nil, err
 	}
 
-	return callPlantUML(ctx, httpClient, requestRoute)
+	return callPlantUML(ctx, httpClient, requestRoute, c4ContainersDSL)
 }
 
-func callPlantUML(ctx context.Context, httpClient diagram.HTTPClient, route string) ([]byte, error) {
+// callPlantUML renders the diagram encoded into the route of the GET request. The diagram's code is sent
+// in the body of the POST request instead if the route exceeds maxPlantUMLRouteLength, e.g. the library is inlined.
+func callPlantUML(ctx context.Context, httpClient diagram.HTTPClient, route string, dsl []byte) ([]byte, error) {
 	const baseURL = "https://www.plantuml.com/plantuml/"
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"svg/"+route, nil)
+	method, url, body := http.MethodGet, baseURL+"svg/"+route, io.Reader(nil)
+	if len(route) > maxPlantUMLRouteLength {
+		method, url, body = http.MethodPost, baseURL+"svg", bytes.NewReader(dsl)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
@@ -59,45 +68,40 @@ func writeStrings(w *bytes.Buffer, s ...string) {
 	}
 }
 
-func marshal(c *c4ContainersGraph) ([]byte, error) {
+func marshal(c *c4ContainersGraph, lib C4PlantUML) ([]byte, error) {
 	if len(c.Containers) == 0 {
 		return nil, errors.New("no containers found")
 	}
 
 	var o bytes.Buffer
 	writeStrings(
-		&o,
-		`@startuml
-!include https://raw.githubusercontent.com/plantuml-stdlib/C4-PlantUML/master/C4_Container.puml`, "\n",
+		&o, "@startuml\n", lib.dsl(), "\n", dslSprites(c.Containers), dslLayout(c.Layout),
 		dslFooter(c.Footer), dslTitle(c.Title),
+		dslTagStyles(c.ElementTags, c.RelTags),
 	)
 
-	groups := map[string][]string{}
+	ids := newDSLIdentifiers()
+	boundaries := newBoundaryTree(c.Boundaries)
 	for _, n := range c.Containers {
 		if n.ID == "" {
 			return nil, errors.New("container must be identified: 'id' attribute")
 		}
-
-		if _, ok := groups[n.System]; !ok {
-			groups[n.System] = []string{}
-		}
-		groups[n.System] = append(groups[n.System], dslContainer(n))
+		boundaries.add(n.System, dslContainer(n, ids.container(n.ID)))
 	}
 
-	dslSystems(&o, groups)
-
-	writeStrings(&o, "\n")
+	writeStrings(&o, strings.Join(boundaries.dsl(ids), "\n"), "\n")
 
 	for _, l := range c.Rels {
 		if l.From == "" || l.To == "" {
 			return nil, errors.New("relation must specify the end nodes: 'from' and 'to' attributes")
 		}
 
-		dslRelation(&o, l)
+		dslRelation(&o, l, ids.container(l.From), ids.container(l.To))
 		writeStrings(&o, "\n")
 	}
 
-	writeStrings(&o, dslLegend(c.WithLegend), "@enduml")
+	// the legend is shown by LAYOUT_WITH_LEGEND if it's placed in the layout
+	writeStrings(&o, dslLegend(c.WithLegend && (c.Layout == nil || !c.Layout.LegendInLayout)), "@enduml")
 
 	return o.Bytes(), nil
 }
@@ -109,26 +113,103 @@ func dslLegend(withLegend bool) string {
 	return ""
 }
 
-func dslRelation(o *bytes.Buffer, l *rel) {
+// dslSprites returns the directives to include the containers' sprites in the order of first appearance.
+func dslSprites(containers []*container) string {
+	var o bytes.Buffer
+	seen := map[string]struct{}{}
+	for _, n := range containers {
+		include, _ := dslSprite(n.Sprite)
+		if _, ok := seen[include]; ok || include == "" {
+			continue
+		}
+		seen[include] = struct{}{}
+		writeStrings(&o, include, "\n")
+	}
+	return o.String()
+}
+
+// dslTagStyles returns the definitions of the tags' styles.
+func dslTagStyles(elementTags []*elementTag, relTags []*relTag) string {
+	var o bytes.Buffer
+
+	for _, t := range elementTags {
+		if t == nil || dslTag(t.Tag) == "" {
+			continue
+		}
+		writeStrings(
+			&o, `AddElementTag("`, dslTag(t.Tag), `"`,
+			dslKeywordArg("bgColor", dslColor(t.BgColor)),
+			dslKeywordArg("fontColor", dslColor(t.FontColor)),
+			dslKeywordArg("borderColor", dslColor(t.BorderColor)),
+		)
+		switch t.Shape {
+		case "rounded":
+			writeStrings(&o, ", $shape=RoundedBoxShape()")
+		case "eight_sided":
+			writeStrings(&o, ", $shape=EightSidedShape()")
+		}
+		writeStrings(&o, ")\n")
+	}
+
+	for _, t := range relTags {
+		if t == nil || dslTag(t.Tag) == "" {
+			continue
+		}
+		writeStrings(
+			&o, `AddRelTag("`, dslTag(t.Tag), `"`,
+			dslKeywordArg("textColor", dslColor(t.TextColor)),
+			dslKeywordArg("lineColor", dslColor(t.LineColor)),
+		)
+		switch t.LineStyle {
+		case "dashed":
+			writeStrings(&o, ", $lineStyle=DashedLine()")
+		case "dotted":
+			writeStrings(&o, ", $lineStyle=DottedLine()")
+		case "bold":
+			writeStrings(&o, ", $lineStyle=BoldLine()")
+		}
+		writeStrings(&o, ")\n")
+	}
+
+	return o.String()
+}
+
+// dslKeywordArg returns the macro's keyword argument, or empty string if the value is empty.
+// The value must be escaped.
+func dslKeywordArg(name, value string) string {
+	if value == "" {
+		return ""
+	}
+	return ", $" + name + `="` + value + `"`
+}
+
+// dslOptionalArgs returns the keyword arguments of the sprite, tags and link shared by the elements and relations.
+func dslOptionalArgs(tags []string, sprite, link string) string {
+	_, spriteName := dslSprite(sprite)
+	return dslKeywordArg("sprite", spriteName) + dslKeywordArg("tags", dslTags(tags)) +
+		dslKeywordArg("link", dslLink(link))
+}
+
+func dslRelation(o *bytes.Buffer, l *rel, from, to string) {
 	writeStrings(o, "Rel")
 
 	if d := relationDirection(l.Direction); d != "" {
 		writeStrings(o, "_", d)
 	}
 
-	writeStrings(o, "(", l.From, ", ", l.To)
+	writeStrings(o, "(", from, ", ", to)
 
 	label := l.Label
 	if label == "" {
 		label = "Uses"
 	}
-	writeStrings(o, `, "`, stringCleaner(label), `"`)
+	writeStrings(o, `, "`, dslString(label), `"`)
 
 	if l.Technology != "" {
-		writeStrings(o, `, "`, stringCleaner(l.Technology), `"`)
+		writeStrings(o, `, "`, dslString(l.Technology), `"`)
 	}
 
-	writeStrings(o, ")")
+	writeStrings(o, dslOptionalArgs(l.Tags, "", l.Link), ")")
 }
 
 func relationDirection(s string) string {
@@ -146,23 +227,6 @@ func relationDirection(s string) string {
 	}
 }
 
-func dslSystems(o *bytes.Buffer, groups map[string][]string) {
-	tmp := groups
-
-	if members, ok := tmp[""]; ok {
-		writeStrings(o, strings.Join(members, "\n"))
-		delete(tmp, "")
-	}
-
-	for groupName, members := range tmp {
-		description := stringCleaner(groupName)
-		id := strings.NewReplacer("\n", "", " ", "").Replace(description)
-		writeStrings(
-			o, "\nSystem_Boundary(", id, `, "`, description, "\") {\n", strings.Join(members, "\n"), "\n}",
-		)
-	}
-}
-
 func dslContainerType(o *bytes.Buffer, n *container) {
 	if n.IsUser {
 		writeStrings(o, "Person")
@@ -180,47 +244,59 @@ func dslContainerType(o *bytes.Buffer, n *container) {
 	}
 }
 
-func dslContainer(n *container) string {
+func dslContainer(n *container, id string) string {
 	var o bytes.Buffer
 
 	dslContainerType(&o, n)
 
-	writeStrings(&o, "(", n.ID)
+	writeStrings(&o, "(", id)
 
 	label := n.Label
 	if label == "" {
 		label = n.ID
 	}
 
-	writeStrings(&o, `, "`, stringCleaner(label), `"`)
-
-	if n.Technology != "" {
-		writeStrings(&o, `, "`, stringCleaner(n.Technology), `"`)
-	}
+	writeStrings(&o, `, "`, dslString(label), `"`)
 
-	if n.Description != "" {
-		writeStrings(&o, `, "`, stringCleaner(n.Description), `"`)
+	switch {
+	case n.IsUser:
+		// the person does not have technology, it's shown as the description if the latter is not set
+		description := n.Description
+		if description == "" {
+			description = n.Technology
+		}
+		if description != "" {
+			writeStrings(&o, `, "`, dslString(description), `"`)
+		}
+	case n.Description != "":
+		writeStrings(&o, `, "`, dslString(n.Technology), `", "`, dslString(n.Description), `"`)
+	case n.Technology != "":
+		writeStrings(&o, `, "`, dslString(n.Technology), `"`)
 	}
 
-	writeStrings(&o, ")")
+	writeStrings(&o, dslOptionalArgs(n.Tags, n.Sprite, n.Link), ")")
 
 	return o.String()
 }
 
 func dslFooter(footer string) string {
 	if footer == "" {
-		footer = "generated by diagramastext.dev - %date('yyyy-MM-dd')"
+		return `footer "generated by diagramastext.dev - %date('yyyy-MM-dd')"` + "\n"
 	}
-	return `footer "` + stringCleaner(footer) + "\"\n"
+	return `footer "` + dslString(footer) + "\"\n"
 }
 
 func dslTitle(title string) string {
 	if title == "" {
 		return ""
 	}
-	return `title "` + stringCleaner(title) + "\"\n"
+	return `title "` + dslString(title) + "\"\n"
 }
 
+// maxPlantUMLRouteLength defines the max length of the encoded diagram in the URL of the GET request,
+// the URL's length is limited to 8 KiB by the web servers and proxies by default.
+const maxPlantUMLRouteLength = 7 * 1024
+
 // plantUMLRequest converts the diagram as code to the 64Bytes encoded string to query plantuml
 //
 // Example: the diagram's code
@@ -310,9 +386,3 @@ func encode6bit(e byte) byte {
 		return '?'
 	}
 }
-
-func stringCleaner(s string) string {
-	s = strings.TrimSpace(s)
-	s = strings.ReplaceAll(s, "\n", "\\n")
-	return s
-}
@@ -2,12 +2,15 @@ package c4container
 
 import (
 	"bytes"
+	"compress/flate"
 	"context"
+	"encoding/base64"
 	errs "errors"
 	"io"
 	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/kislerdm/diagramastext/server/core/diagram"
@@ -242,7 +245,7 @@ func Test_marshal(t *testing.T) {
 				},
 			},
 			want: []byte(`@startuml
-!include https://raw.githubusercontent.com/plantuml-stdlib/C4-PlantUML/master/C4_Container.puml
+!include <C4/C4_Container>
 footer "generated by diagramastext.dev - %date('yyyy-MM-dd')"
 Container(0, "0")
 @enduml`),
@@ -336,7 +339,7 @@ Container(0, "0")
 		//				},
 		//			},
 		//			want: []byte(`@startuml
-		//!include https://raw.githubusercontent.com/plantuml-stdlib/C4-PlantUML/master/C4_Container.puml
+		//!include <C4/C4_Container>
 		//footer "foobar\n"bazqux\nquxx""
 		//title "Container diagram for diagramastext.dev"
 		//Person(0, "User")
@@ -358,6 +361,76 @@ Container(0, "0")
 		//@enduml`),
 		//			wantErr: nil,
 		//		},
+		{
+			name: "escaped labels and sanitized identifiers",
+			args: args{
+				c: &c4ContainersGraph{
+					Containers: []*container{
+						{ID: "web-server", Label: "Web \"Server\")\n@enduml", System: "Core (v2)"},
+						{ID: "web_server", Label: "%getenv(\"HOME\")", System: "Core (v2)"},
+					},
+					Rels:   []*rel{{From: "web-server", To: "web_server", Label: "!include foo.puml"}},
+					Footer: "<img:https://foo.bar/baz.png>",
+				},
+			},
+			want: []byte(`@startuml
+!include <C4/C4_Container>
+footer "&#60;img:https://foo.bar/baz.png&#62;"
+System_Boundary(Core_v2_, "Core (v2)") {
+Container(web_server, "Web &#34;Server&#34;)\n@enduml")
+Container(web_server_2, "&#37;getenv(&#34;HOME&#34;)")
+}
+Rel(web_server, web_server_2, "!include foo.puml")
+@enduml`),
+			wantErr: nil,
+		},
+		{
+			name: "nested boundaries, tags, sprites and links",
+			args: args{
+				c: &c4ContainersGraph{
+					Boundaries: []*boundary{
+						{ID: "shop", Label: "Shop", Parent: "acme", Tags: []string{"v2"}},
+						{ID: "acme", Label: "ACME", Type: "enterprise", Link: "https://acme.com/docs?q=a b"},
+						{ID: "cycle", Parent: "cycle"},
+					},
+					Containers: []*container{
+						{ID: "2", Label: "Payments", IsExternal: true, System: "Partners"},
+						{
+							ID: "0", Label: "API", Technology: "Go", System: "shop", Sprite: "devicons2/go",
+							Tags: []string{"v2", "v2", "a+b"}, Link: "https://github.com/acme/api",
+						},
+						{ID: "1", Label: "DB", Description: "Stores data", IsDatabase: true, System: "Shop"},
+						{ID: "3", Label: "User", Technology: "Browser", IsUser: true, Sprite: "foo/bar"},
+					},
+					Rels: []*rel{
+						{From: "0", To: "2", Label: "calls", Tags: []string{"async"}, Link: "javascript:alert(1)"},
+					},
+					ElementTags: []*elementTag{{Tag: "v2", BgColor: "#ff0000", BorderColor: "red;", Shape: "rounded"}},
+					RelTags:     []*relTag{{Tag: "async", LineColor: "Gray", LineStyle: "dashed"}, {Tag: "%$"}},
+				},
+			},
+			want: []byte(`@startuml
+!include <C4/C4_Container>
+!include <tupadr3/devicons2/go>
+footer "generated by diagramastext.dev - %date('yyyy-MM-dd')"
+AddElementTag("v2", $bgColor="#ff0000", $shape=RoundedBoxShape())
+AddRelTag("async", $lineColor="Gray", $lineStyle=DashedLine())
+Person(3, "User", "Browser")
+System_Boundary(Partners, "Partners") {
+Container_Ext(2, "Payments")
+}
+Enterprise_Boundary(acme, "ACME", $link="https://acme.com/docs?q=a%20b") {
+System_Boundary(shop, "Shop", $tags="v2") {
+Container(0, "API", "Go", $sprite="go", $tags="v2+ab", $link="https://github.com/acme/api")
+ContainerDb(1, "DB", "", "Stores data")
+}
+}
+System_Boundary(cycle, "cycle") {
+}
+Rel(0, 2, "calls", $tags="async")
+@enduml`),
+			wantErr: nil,
+		},
 		{
 			name:    "unhappy path: no containers present in the graph",
 			args:    args{c: &c4ContainersGraph{}},
@@ -415,7 +488,7 @@ Container(0, "0")
 				},
 			},
 			want: []byte(`@startuml
-!include https://raw.githubusercontent.com/plantuml-stdlib/C4-PlantUML/master/C4_Container.puml
+!include <C4/C4_Container>
 footer "generated by diagramastext.dev - %date('yyyy-MM-dd')"
 Container(0, "Web Server", "Python", "Reads from external MongoDB")
 ContainerDb_Ext(1, "Database", "MongoDB")
@@ -431,7 +504,7 @@ SHOW_LEGEND()
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				got, err := marshal(tt.args.c)
+				got, err := marshal(tt.args.c, C4PlantUML{})
 				if !reflect.DeepEqual(err, tt.wantErr) {
 					t.Errorf("marshal() error = %v, want %v", err, tt.wantErr)
 					return
@@ -483,6 +556,71 @@ func Test_plantUMLRequest(t *testing.T) {
 	}
 }
 
+// alphabetPlantUMLRoute defines the characters used to encode the diagram's DSL in the request to PlantUML server.
+const alphabetPlantUMLRoute = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_"
+
+// decodePlantUMLRequest decodes the route of the request to PlantUML server back to the diagram's DSL.
+func decodePlantUMLRequest(t *testing.T, route string) string {
+	t.Helper()
+
+	compressed, err := base64.NewEncoding(alphabetPlantUMLRoute).WithPadding(base64.NoPadding).DecodeString(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(o)
+}
+
+func Test_plantUMLRequestUTF8(t *testing.T) {
+	tests := []struct {
+		name string
+		dsl  string
+	}{
+		{
+			name: "german",
+			dsl:  `Container(0, "Größenänderung", "Go", "Liest aus der Datenbank über TCP")`,
+		},
+		{
+			name: "spanish",
+			dsl:  `Container(0, "Servidor", "Java", "¿Lee de la base de datos? ¡Sí!")`,
+		},
+		{
+			name: "japanese",
+			dsl:  `ContainerDb(1, "データベース", "Postgres")` + "\n" + `Rel(0, 1, "注文を読み取る", "TCP")`,
+		},
+		{
+			name: "mixed scripts and emoji",
+			dsl:  `Container(0, "Сервер 服务器 서버 🚀", "Café\u0301")`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// GIVEN
+				dsl := "@startuml\n" + tt.dsl + "\n@enduml"
+
+				// WHEN
+				route, err := plantUMLRequest([]byte(dsl))
+
+				// THEN
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.Trim(route, alphabetPlantUMLRoute) != "" {
+					t.Errorf("route contains characters which are not URL safe: %s", route)
+				}
+				if got := decodePlantUMLRequest(t, route); got != dsl {
+					t.Errorf("unexpected DSL after decompression:\ngot = %s\nwant = %s", got, dsl)
+				}
+			},
+		)
+	}
+}
+
 func Test_renderDiagramHappyPath(t *testing.T) {
 	t.Parallel()
 
@@ -522,7 +660,7 @@ func Test_renderDiagramHappyPath(t *testing.T) {
 			}
 
 			// WHEN
-			got, err := renderDiagram(context.TODO(), httpClient, graph)
+			got, err := renderDiagram(context.TODO(), httpClient, graph, C4PlantUML{})
 
 			// THEN
 			if err != nil {
@@ -553,7 +691,7 @@ func Test_renderDiagramUnhappyPath(t *testing.T) {
 				ctx: context.TODO(),
 				v:   &c4ContainersGraph{},
 			},
-			wantErrText: "diagram/c4container/plantuml.go:64: no containers found",
+			wantErrText: "diagram/c4container/plantuml.go:73: no containers found",
 		},
 		{
 			name: "http call error",
@@ -564,7 +702,7 @@ func Test_renderDiagramUnhappyPath(t *testing.T) {
 				},
 				v: &c4ContainersGraph{Containers: []*container{{ID: "0"}}},
 			},
-			wantErrText: "diagram/c4container/plantuml.go:41: foobar",
+			wantErrText: "diagram/c4container/plantuml.go:50: foobar",
 		},
 		{
 			name: "http response not OK",
@@ -577,13 +715,13 @@ func Test_renderDiagramUnhappyPath(t *testing.T) {
 				},
 				v: &c4ContainersGraph{Containers: []*container{{ID: "0"}}},
 			},
-			wantErrText: "diagram/c4container/plantuml.go:46: the response is not ok, status code: " + strconv.Itoa(http.StatusTooManyRequests),
+			wantErrText: "diagram/c4container/plantuml.go:55: the response is not ok, status code: " + strconv.Itoa(http.StatusTooManyRequests),
 		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				if _, err := renderDiagram(tt.args.ctx, tt.args.httpClient, tt.args.v); !errors.IsError(
+				if _, err := renderDiagram(tt.args.ctx, tt.args.httpClient, tt.args.v, C4PlantUML{}); !errors.IsError(
 					err, tt.wantErrText,
 				) {
 					t.Errorf("renderDiagram() error = %v, want = %s", err, tt.wantErrText)
@@ -737,3 +875,102 @@ func Test_relationDirection(t *testing.T) {
 		)
 	}
 }
+
+func Test_marshalDeterministicBoundaries(t *testing.T) {
+	// GIVEN
+	graph := &c4ContainersGraph{
+		Containers: []*container{
+			{ID: "0", System: "c"}, {ID: "1", System: "a"}, {ID: "2", System: "b"}, {ID: "3", System: "a"},
+			{ID: "4", System: "d"}, {ID: "5", System: "e"},
+		},
+	}
+	want, err := marshal(graph, C4PlantUML{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// WHEN
+	for i := 0; i < 100; i++ {
+		got, err := marshal(graph, C4PlantUML{})
+
+		// THEN
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("non-deterministic output:\n%s\n%s", got, want)
+		}
+	}
+
+	if !strings.Contains(
+		string(want), "System_Boundary(c, \"c\") {\nContainer(0, \"0\")\n}\nSystem_Boundary(a, \"a\") {",
+	) {
+		t.Errorf("boundaries are expected in the order of first appearance:\n%s", want)
+	}
+}
+
+type mockHTTPClientRequest struct {
+	Method, Path string
+	Body         []byte
+}
+
+func (m *mockHTTPClientRequest) Do(req *http.Request) (*http.Response, error) {
+	m.Method, m.Path = req.Method, req.URL.Path
+	if req.Body != nil {
+		m.Body, _ = io.ReadAll(req.Body)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("<svg></svg>"))}, nil
+}
+
+func Test_callPlantUML(t *testing.T) {
+	longRoute := strings.Repeat("a", maxPlantUMLRouteLength+1)
+	tests := []struct {
+		name       string
+		route      string
+		wantMethod string
+		wantPath   string
+		wantBody   []byte
+	}{
+		{
+			name:       "shall encode the diagram into the route of the GET request",
+			route:      "foo",
+			wantMethod: http.MethodGet,
+			wantPath:   "/plantuml/svg/foo",
+		},
+		{
+			name:       "shall send the diagram in the body of the POST request if the route is too long",
+			route:      longRoute,
+			wantMethod: http.MethodPost,
+			wantPath:   "/plantuml/svg",
+			wantBody:   []byte("@startuml\n@enduml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// GIVEN
+				httpClient := &mockHTTPClientRequest{}
+
+				// WHEN
+				got, err := callPlantUML(context.TODO(), httpClient, tt.route, []byte("@startuml\n@enduml"))
+
+				// THEN
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != "<svg></svg>" {
+					t.Errorf("unexpected response: %s", got)
+				}
+				if httpClient.Method != tt.wantMethod || httpClient.Path != tt.wantPath {
+					t.Errorf(
+						"unexpected request: %s %s, want: %s %s", httpClient.Method, httpClient.Path,
+						tt.wantMethod, tt.wantPath,
+					)
+				}
+				if !reflect.DeepEqual(httpClient.Body, tt.wantBody) {
+					t.Errorf("unexpected request body: %s, want: %s", httpClient.Body, tt.wantBody)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,276 @@
+package c4container
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// terraformResourceType defines how the Terraform resource of the given type is shown on the diagram.
+type terraformResourceType struct {
+	Technology string
+	IsDatabase bool
+	IsQueue    bool
+}
+
+// terraformResourceTypes defines the resources types shown on the diagram, other resources,
+// e.g. IAM roles, or networks are omitted.
+var terraformResourceTypes = map[string]terraformResourceType{
+	// AWS
+	"aws_api_gateway_rest_api":          {Technology: "Amazon API Gateway"},
+	"aws_apigatewayv2_api":              {Technology: "Amazon API Gateway"},
+	"aws_cloudfront_distribution":       {Technology: "Amazon CloudFront"},
+	"aws_cloudwatch_event_rule":         {Technology: "Amazon EventBridge", IsQueue: true},
+	"aws_db_instance":                   {Technology: "Amazon RDS", IsDatabase: true},
+	"aws_docdb_cluster":                 {Technology: "Amazon DocumentDB", IsDatabase: true},
+	"aws_dynamodb_table":                {Technology: "Amazon DynamoDB", IsDatabase: true},
+	"aws_ecs_service":                   {Technology: "Amazon ECS"},
+	"aws_eks_cluster":                   {Technology: "Amazon EKS"},
+	"aws_elasticache_cluster":           {Technology: "Amazon ElastiCache", IsDatabase: true},
+	"aws_elasticache_replication_group": {Technology: "Amazon ElastiCache", IsDatabase: true},
+	"aws_elasticsearch_domain":          {Technology: "Amazon OpenSearch Service", IsDatabase: true},
+	"aws_instance":                      {Technology: "Amazon EC2"},
+	"aws_kinesis_stream":                {Technology: "Amazon Kinesis", IsQueue: true},
+	"aws_lambda_function":               {Technology: "AWS Lambda"},
+	"aws_lb":                            {Technology: "Elastic Load Balancing"},
+	"aws_mq_broker":                     {Technology: "Amazon MQ", IsQueue: true},
+	"aws_msk_cluster":                   {Technology: "Amazon MSK", IsQueue: true},
+	"aws_neptune_cluster":               {Technology: "Amazon Neptune", IsDatabase: true},
+	"aws_opensearch_domain":             {Technology: "Amazon OpenSearch Service", IsDatabase: true},
+	"aws_rds_cluster":                   {Technology: "Amazon Aurora", IsDatabase: true},
+	"aws_s3_bucket":                     {Technology: "Amazon S3", IsDatabase: true},
+	"aws_sfn_state_machine":             {Technology: "AWS Step Functions"},
+	"aws_sns_topic":                     {Technology: "Amazon SNS", IsQueue: true},
+	"aws_sqs_queue":                     {Technology: "Amazon SQS", IsQueue: true},
+	// Google Cloud
+	"google_alloydb_cluster":                {Technology: "AlloyDB", IsDatabase: true},
+	"google_api_gateway_gateway":            {Technology: "API Gateway"},
+	"google_bigquery_dataset":               {Technology: "BigQuery", IsDatabase: true},
+	"google_bigtable_instance":              {Technology: "Bigtable", IsDatabase: true},
+	"google_cloud_run_service":              {Technology: "Cloud Run"},
+	"google_cloud_run_v2_service":           {Technology: "Cloud Run"},
+	"google_cloud_scheduler_job":            {Technology: "Cloud Scheduler"},
+	"google_cloud_tasks_queue":              {Technology: "Cloud Tasks", IsQueue: true},
+	"google_cloudfunctions2_function":       {Technology: "Cloud Functions"},
+	"google_cloudfunctions_function":        {Technology: "Cloud Functions"},
+	"google_compute_global_forwarding_rule": {Technology: "Cloud Load Balancing"},
+	"google_compute_instance":               {Technology: "Compute Engine"},
+	"google_container_cluster":              {Technology: "Google Kubernetes Engine"},
+	"google_firestore_database":             {Technology: "Firestore", IsDatabase: true},
+	"google_pubsub_topic":                   {Technology: "Pub/Sub", IsQueue: true},
+	"google_redis_instance":                 {Technology: "Memorystore", IsDatabase: true},
+	"google_spanner_instance":               {Technology: "Cloud Spanner", IsDatabase: true},
+	"google_sql_database_instance":          {Technology: "Cloud SQL", IsDatabase: true},
+	"google_storage_bucket":                 {Technology: "Cloud Storage", IsDatabase: true},
+	"google_workflows_workflow":             {Technology: "Workflows"},
+	// Azure
+	"azurerm_api_management":             {Technology: "Azure API Management"},
+	"azurerm_app_service":                {Technology: "Azure App Service"},
+	"azurerm_container_app":              {Technology: "Azure Container Apps"},
+	"azurerm_cosmosdb_account":           {Technology: "Azure Cosmos DB", IsDatabase: true},
+	"azurerm_eventgrid_topic":            {Technology: "Azure Event Grid", IsQueue: true},
+	"azurerm_eventhub":                   {Technology: "Azure Event Hubs", IsQueue: true},
+	"azurerm_function_app":               {Technology: "Azure Functions"},
+	"azurerm_kubernetes_cluster":         {Technology: "Azure Kubernetes Service"},
+	"azurerm_linux_function_app":         {Technology: "Azure Functions"},
+	"azurerm_linux_virtual_machine":      {Technology: "Azure Virtual Machines"},
+	"azurerm_linux_web_app":              {Technology: "Azure App Service"},
+	"azurerm_logic_app_workflow":         {Technology: "Azure Logic Apps"},
+	"azurerm_mssql_server":               {Technology: "Azure SQL Database", IsDatabase: true},
+	"azurerm_mysql_flexible_server":      {Technology: "Azure Database for MySQL", IsDatabase: true},
+	"azurerm_postgresql_flexible_server": {Technology: "Azure Database for PostgreSQL", IsDatabase: true},
+	"azurerm_redis_cache":                {Technology: "Azure Cache for Redis", IsDatabase: true},
+	"azurerm_servicebus_queue":           {Technology: "Azure Service Bus", IsQueue: true},
+	"azurerm_servicebus_topic":           {Technology: "Azure Service Bus", IsQueue: true},
+	"azurerm_storage_account":            {Technology: "Azure Storage", IsDatabase: true},
+	"azurerm_windows_virtual_machine":    {Technology: "Azure Virtual Machines"},
+	// Kubernetes
+	"kubernetes_deployment":   {Technology: "Kubernetes Deployment"},
+	"kubernetes_stateful_set": {Technology: "Kubernetes StatefulSet"},
+	// SaaS
+	"cloudflare_worker_script":      {Technology: "Cloudflare Workers"},
+	"confluent_kafka_topic":         {Technology: "Confluent Kafka", IsQueue: true},
+	"digitalocean_app":              {Technology: "DigitalOcean App Platform"},
+	"digitalocean_database_cluster": {Technology: "DigitalOcean Managed Databases", IsDatabase: true},
+	"heroku_app":                    {Technology: "Heroku"},
+	"mongodbatlas_cluster":          {Technology: "MongoDB Atlas", IsDatabase: true},
+	"neon_project":                  {Technology: "Neon Postgres", IsDatabase: true},
+}
+
+// terraformPlan defines the attributes of the plan in the JSON format required to extract the graph.
+type terraformPlan struct {
+	PlannedValues struct {
+		RootModule terraformModule `json:"root_module"`
+	} `json:"planned_values"`
+	Configuration struct {
+		RootModule terraformModuleConfiguration `json:"root_module"`
+	} `json:"configuration"`
+}
+
+type terraformModule struct {
+	Address   string `json:"address"`
+	Resources []struct {
+		Address string                 `json:"address"`
+		Mode    string                 `json:"mode"`
+		Type    string                 `json:"type"`
+		Name    string                 `json:"name"`
+		Values  map[string]interface{} `json:"values"`
+	} `json:"resources"`
+	ChildModules []terraformModule `json:"child_modules"`
+}
+
+type terraformModuleConfiguration struct {
+	Resources []struct {
+		Address     string                 `json:"address"`
+		Mode        string                 `json:"mode"`
+		Expressions map[string]interface{} `json:"expressions"`
+		DependsOn   []string               `json:"depends_on"`
+	} `json:"resources"`
+	ModuleCalls map[string]struct {
+		Module terraformModuleConfiguration `json:"module"`
+	} `json:"module_calls"`
+}
+
+// terraformLabelAttributes defines the resources' attributes used as the labels in the order of precedence.
+var terraformLabelAttributes = []string{
+	"name", "function_name", "bucket", "identifier", "cluster_identifier", "cluster_name", "table_name",
+}
+
+var terraformIndexRegex = regexp.MustCompile(`\[[^]]*]`)
+
+// extractTerraformPlan extracts the graph from the Terraform plan in the JSON format, i.e. the output of
+// `terraform show -json`: the managed resources of the known types define the containers, the references
+// in the resources' configuration and the explicit dependencies define the relations.
+// The resources of the child modules are grouped by the module.
+func extractTerraformPlan(v []byte) (*c4ContainersGraph, error) {
+	var plan terraformPlan
+	if err := json.Unmarshal(v, &plan); err != nil {
+		return nil, err
+	}
+	if plan.PlannedValues.RootModule.Resources == nil && plan.PlannedValues.RootModule.ChildModules == nil {
+		return nil, errors.New("planned_values must be set")
+	}
+
+	o := newInfraGraph()
+	addTerraformModule(o, plan.PlannedValues.RootModule)
+	addTerraformReferences(o, "", plan.Configuration.RootModule)
+
+	return o.graph, nil
+}
+
+func addTerraformModule(o *infraGraph, module terraformModule) {
+	for _, r := range module.Resources {
+		if r.Mode == "data" {
+			continue
+		}
+		t, ok := terraformResourceTypes[r.Type]
+		if !ok {
+			continue
+		}
+
+		label := r.Name
+		for _, k := range terraformLabelAttributes {
+			if v, ok := r.Values[k].(string); ok && v != "" {
+				label = v
+				break
+			}
+		}
+
+		o.addNode(
+			&container{
+				ID:          terraformIndexRegex.ReplaceAllString(r.Address, ""),
+				Label:       label,
+				Technology:  t.Technology,
+				Description: r.Type,
+				System:      terraformIndexRegex.ReplaceAllString(module.Address, ""),
+				IsDatabase:  t.IsDatabase,
+				IsQueue:     t.IsQueue,
+			},
+		)
+	}
+
+	for _, m := range module.ChildModules {
+		addTerraformModule(o, m)
+	}
+}
+
+func addTerraformReferences(o *infraGraph, prefix string, module terraformModuleConfiguration) {
+	for _, r := range module.Resources {
+		if r.Mode == "data" {
+			continue
+		}
+		from := prefix + r.Address
+
+		for _, reference := range terraformReferences(r.Expressions) {
+			if to, ok := terraformResourceAddress(prefix, reference); ok {
+				o.addRel(from, to, "uses")
+			}
+		}
+		for _, dependency := range r.DependsOn {
+			if to, ok := terraformResourceAddress(prefix, dependency); ok {
+				o.addRel(from, to, "depends on")
+			}
+		}
+	}
+
+	names := make([]string, 0, len(module.ModuleCalls))
+	for name := range module.ModuleCalls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		addTerraformReferences(o, prefix+"module."+name+".", module.ModuleCalls[name].Module)
+	}
+}
+
+// terraformReferences returns the sorted unique references found in the resource's expressions.
+func terraformReferences(expressions interface{}) []string {
+	seen := map[string]struct{}{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, el := range v {
+				if k != "references" {
+					walk(el)
+					continue
+				}
+				refs, _ := el.([]interface{})
+				for _, ref := range refs {
+					if s, ok := ref.(string); ok {
+						seen[s] = struct{}{}
+					}
+				}
+			}
+		case []interface{}:
+			for _, el := range v {
+				walk(el)
+			}
+		}
+	}
+	walk(expressions)
+
+	o := make([]string, 0, len(seen))
+	for k := range seen {
+		o = append(o, k)
+	}
+	sort.Strings(o)
+	return o
+}
+
+// terraformResourceAddress returns the address of the resource referenced within the module,
+// e.g. module.db.aws_db_instance.main for aws_db_instance.main[0].address referenced in the module db.
+// The references to the variables, locals, data sources and modules' outputs are ignored.
+func terraformResourceAddress(prefix, reference string) (string, bool) {
+	parts := strings.SplitN(terraformIndexRegex.ReplaceAllString(reference, ""), ".", 3)
+	if len(parts) < 2 {
+		return "", false
+	}
+	switch parts[0] {
+	case "var", "local", "data", "module", "each", "count", "path", "terraform", "self":
+		return "", false
+	}
+	return prefix + parts[0] + "." + parts[1], true
+}
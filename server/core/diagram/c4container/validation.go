@@ -0,0 +1,441 @@
+package c4container
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Severities of the graph's findings.
+const (
+	// severityRepaired defines the issue which was repaired automatically.
+	severityRepaired = "repaired"
+	// severityError defines the issue which cannot be repaired.
+	severityError = "error"
+)
+
+// Codes of the graph's findings.
+const (
+	findingInvalidJSON       = "invalid_json"
+	findingEmptyGraph        = "empty_graph"
+	findingMissingID         = "missing_id"
+	findingDuplicateNode     = "duplicate_node"
+	findingDuplicateID       = "duplicate_id"
+	findingMissingLinkEnd    = "missing_link_end"
+	findingLinkEndByLabel    = "link_end_by_label"
+	findingDanglingLink      = "dangling_link"
+	findingSelfLoop          = "self_loop"
+	findingUnknownDirection  = "unknown_direction"
+	findingDirectionAlias    = "direction_alias"
+	findingInferredContainer = "inferred_container_type"
+	findingInvalidBoundary   = "invalid_boundary"
+	findingUnknownParent     = "unknown_boundary_parent"
+	findingUnknownLayout     = "unknown_layout"
+	findingLayoutAlias       = "layout_alias"
+)
+
+// finding defines the issue found in the graph.
+type finding struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	// Element defines the graph's element with the issue, e.g. nodes[0], or links[1].
+	Element string `json:"element"`
+	Message string `json:"message"`
+}
+
+func (f finding) String() string {
+	return f.Element + ": " + f.Message
+}
+
+type findings []finding
+
+// hasErrors reports whether any of the findings cannot be repaired.
+func (f findings) hasErrors() bool {
+	for _, el := range f {
+		if el.Severity == severityError {
+			return true
+		}
+	}
+	return false
+}
+
+// errors returns the findings which cannot be repaired.
+func (f findings) errors() findings {
+	var o findings
+	for _, el := range f {
+		if el.Severity == severityError {
+			o = append(o, el)
+		}
+	}
+	return o
+}
+
+func (f findings) String() string {
+	o := make([]string, len(f))
+	for i, el := range f {
+		o[i] = el.String()
+	}
+	return strings.Join(o, "; ")
+}
+
+// graphValidationError defines the graph which cannot be repaired.
+type graphValidationError struct {
+	Findings findings
+}
+
+func (e graphValidationError) Error() string {
+	return "invalid graph: " + e.Findings.String()
+}
+
+func nodeElement(i int) string {
+	return "nodes[" + strconv.Itoa(i) + "]"
+}
+
+func linkElement(i int) string {
+	return "links[" + strconv.Itoa(i) + "]"
+}
+
+func boundaryElement(i int) string {
+	return "boundaries[" + strconv.Itoa(i) + "]"
+}
+
+// validateGraph validates the graph and repairs it in place where it's safe to do so:
+// the duplicate IDs are deduplicated, the dangling links and self-loops are dropped,
+// the directions and the layout are normalized, and the databases and queues are inferred from the technology.
+func validateGraph(g *c4ContainersGraph) findings {
+	var o findings
+
+	if len(g.Containers) == 0 {
+		return append(
+			o, finding{
+				Code: findingEmptyGraph, Severity: severityError, Element: "nodes",
+				Message: "graph must contain at least one node",
+			},
+		)
+	}
+
+	o = append(o, validateNodes(g)...)
+	o = append(o, validateLinks(g)...)
+	o = append(o, validateBoundaries(g)...)
+	o = append(o, validateLayout(g)...)
+
+	return o
+}
+
+func validateNodes(g *c4ContainersGraph) findings {
+	var o findings
+
+	ids := map[string]*container{}
+	for _, n := range g.Containers {
+		if n != nil && n.ID != "" {
+			if _, ok := ids[n.ID]; !ok {
+				ids[n.ID] = n
+			}
+		}
+	}
+
+	uniqueID := func(base string) string {
+		for i := 2; ; i++ {
+			id := base + "_" + strconv.Itoa(i)
+			if _, ok := ids[id]; !ok {
+				return id
+			}
+		}
+	}
+
+	nodes := g.Containers[:0]
+	for i, n := range g.Containers {
+		if n == nil {
+			continue
+		}
+
+		switch first, ok := ids[n.ID]; {
+		case n.ID == "":
+			n.ID = uniqueID("node")
+			o = append(
+				o, finding{
+					Code: findingMissingID, Severity: severityRepaired, Element: nodeElement(i),
+					Message: "node has no id, assigned " + n.ID,
+				},
+			)
+			ids[n.ID] = n
+		case ok && first != n && reflect.DeepEqual(*first, *n):
+			o = append(
+				o, finding{
+					Code: findingDuplicateNode, Severity: severityRepaired, Element: nodeElement(i),
+					Message: "duplicate of the node " + n.ID + " is dropped",
+				},
+			)
+			continue
+		case ok && first != n:
+			id := uniqueID(n.ID)
+			o = append(
+				o, finding{
+					Code: findingDuplicateID, Severity: severityRepaired, Element: nodeElement(i),
+					Message: "id " + n.ID + " is used by several nodes, renamed to " + id,
+				},
+			)
+			n.ID = id
+			ids[n.ID] = n
+		}
+
+		if f, ok := inferContainerType(n); ok {
+			f.Element = nodeElement(i)
+			o = append(o, f)
+		}
+
+		nodes = append(nodes, n)
+	}
+	g.Containers = nodes
+
+	return o
+}
+
+func validateLinks(g *c4ContainersGraph) findings {
+	var o findings
+
+	ids := map[string]struct{}{}
+	labels := map[string][]string{}
+	for _, n := range g.Containers {
+		ids[n.ID] = struct{}{}
+		if n.Label != "" {
+			k := strings.ToLower(strings.TrimSpace(n.Label))
+			labels[k] = append(labels[k], n.ID)
+		}
+	}
+
+	// resolve returns the node's id referenced by the link's end, the end can be the node's unique label
+	resolve := func(end string) (string, bool, bool) {
+		if _, ok := ids[end]; ok {
+			return end, true, false
+		}
+		if v := labels[strings.ToLower(strings.TrimSpace(end))]; len(v) == 1 {
+			return v[0], true, true
+		}
+		return "", false, false
+	}
+
+	links := g.Rels[:0]
+	for i, l := range g.Rels {
+		if l == nil {
+			continue
+		}
+
+		if l.From == "" || l.To == "" {
+			o = append(
+				o, finding{
+					Code: findingMissingLinkEnd, Severity: severityRepaired, Element: linkElement(i),
+					Message: "link must specify 'from' and 'to' nodes, it is dropped",
+				},
+			)
+			continue
+		}
+
+		var dangling bool
+		for _, end := range []*string{&l.From, &l.To} {
+			id, ok, byLabel := resolve(*end)
+			switch {
+			case !ok:
+				dangling = true
+			case byLabel:
+				o = append(
+					o, finding{
+						Code: findingLinkEndByLabel, Severity: severityRepaired, Element: linkElement(i),
+						Message: "link refers to the node by its label " + *end + ", replaced with id " + id,
+					},
+				)
+				*end = id
+			}
+		}
+		if dangling {
+			o = append(
+				o, finding{
+					Code: findingDanglingLink, Severity: severityRepaired, Element: linkElement(i),
+					Message: "link " + l.From + " -> " + l.To + " refers to unknown node, it is dropped",
+				},
+			)
+			continue
+		}
+
+		if l.From == l.To {
+			o = append(
+				o, finding{
+					Code: findingSelfLoop, Severity: severityRepaired, Element: linkElement(i),
+					Message: "link connects the node " + l.From + " to itself, it is dropped",
+				},
+			)
+			continue
+		}
+
+		if f, ok := normalizeDirection(l); ok {
+			f.Element = linkElement(i)
+			o = append(o, f)
+		}
+
+		links = append(links, l)
+	}
+	g.Rels = links
+
+	return o
+}
+
+func validateBoundaries(g *c4ContainersGraph) findings {
+	var o findings
+
+	boundaries := g.Boundaries[:0]
+	for i, b := range g.Boundaries {
+		if b == nil {
+			continue
+		}
+		if b.ID == "" {
+			if b.Label == "" {
+				o = append(
+					o, finding{
+						Code: findingInvalidBoundary, Severity: severityRepaired, Element: boundaryElement(i),
+						Message: "boundary has neither id, nor label, it is dropped",
+					},
+				)
+				continue
+			}
+			b.ID = b.Label
+			o = append(
+				o, finding{
+					Code: findingMissingID, Severity: severityRepaired, Element: boundaryElement(i),
+					Message: "boundary has no id, assigned its label " + b.ID,
+				},
+			)
+		}
+		boundaries = append(boundaries, b)
+	}
+	g.Boundaries = boundaries
+
+	ids := map[string]struct{}{}
+	for _, b := range g.Boundaries {
+		ids[b.ID] = struct{}{}
+		ids[b.Label] = struct{}{}
+	}
+
+	for i, b := range g.Boundaries {
+		if b.Parent == "" {
+			continue
+		}
+		if _, ok := ids[b.Parent]; !ok || b.Parent == b.ID {
+			o = append(
+				o, finding{
+					Code: findingUnknownParent, Severity: severityRepaired, Element: boundaryElement(i),
+					Message: "boundary's parent " + b.Parent + " is not defined, the boundary is placed at the top level",
+				},
+			)
+			b.Parent = ""
+		}
+	}
+
+	return o
+}
+
+// directionAliases maps the alternative notations to the supported directions.
+var directionAliases = map[string]string{
+	"TB":    "TD",
+	"BT":    "DT",
+	"R":     "LR",
+	"RIGHT": "LR",
+	"L":     "RL",
+	"LEFT":  "RL",
+	"D":     "TD",
+	"DOWN":  "TD",
+	"U":     "DT",
+	"UP":    "DT",
+}
+
+func normalizeDirection(l *rel) (finding, bool) {
+	if l.Direction == "" {
+		return finding{}, false
+	}
+
+	v := strings.ToUpper(strings.TrimSpace(l.Direction))
+	if relationDirection(v) != "" {
+		l.Direction = v
+		return finding{}, false
+	}
+
+	if alias, ok := directionAliases[v]; ok {
+		f := finding{
+			Code: findingDirectionAlias, Severity: severityRepaired,
+			Message: "direction " + l.Direction + " is replaced with " + alias,
+		}
+		l.Direction = alias
+		return f, true
+	}
+
+	f := finding{
+		Code: findingUnknownDirection, Severity: severityRepaired,
+		Message: "unknown direction " + l.Direction + " is dropped",
+	}
+	l.Direction = ""
+	return f, true
+}
+
+var (
+	databaseTechnologies = []string{
+		"postgres", "postgresql", "mysql", "mariadb", "mongodb", "mongo", "dynamodb", "cassandra", "sqlite",
+		"mssql", "sqlserver", "oracle", "cockroachdb", "clickhouse", "bigquery", "snowflake", "redshift", "neo4j",
+		"couchdb", "couchbase", "firestore", "spanner", "timescaledb", "influxdb", "elasticsearch", "redis",
+		"memcached", "hbase", "scylladb", "database",
+	}
+	queueTechnologies = []string{
+		"kafka", "rabbitmq", "sqs", "sns", "pubsub", "kinesis", "nats", "activemq", "pulsar", "eventbridge",
+		"eventhubs", "servicebus", "zeromq", "mqtt", "queue",
+	}
+)
+
+// technologyTokens splits the technology name into lowercase alphanumeric tokens,
+// the adjacent tokens are also joined to match the names like "Pub/Sub", or "SQL Server".
+func technologyTokens(s string) map[string]struct{} {
+	words := strings.FieldsFunc(
+		strings.ToLower(s), func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+		},
+	)
+	o := make(map[string]struct{}, 2*len(words))
+	for i, w := range words {
+		o[w] = struct{}{}
+		if i > 0 {
+			o[words[i-1]+w] = struct{}{}
+		}
+	}
+	return o
+}
+
+func matchTechnology(tokens map[string]struct{}, names []string) bool {
+	for _, name := range names {
+		if _, ok := tokens[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// inferContainerType sets the database, or queue flag of the node based on its technology.
+func inferContainerType(n *container) (finding, bool) {
+	if n.IsUser || n.IsDatabase || n.IsQueue || n.Technology == "" {
+		return finding{}, false
+	}
+
+	tokens := technologyTokens(n.Technology)
+	switch {
+	case matchTechnology(tokens, queueTechnologies):
+		n.IsQueue = true
+		return finding{
+			Code: findingInferredContainer, Severity: severityRepaired,
+			Message: "node " + n.ID + " is marked as queue based on its technology " + n.Technology,
+		}, true
+	case matchTechnology(tokens, databaseTechnologies):
+		n.IsDatabase = true
+		return finding{
+			Code: findingInferredContainer, Severity: severityRepaired,
+			Message: "node " + n.ID + " is marked as database based on its technology " + n.Technology,
+		}, true
+	default:
+		return finding{}, false
+	}
+}
@@ -0,0 +1,193 @@
+package c4container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_validateGraph(t *testing.T) {
+	tests := []struct {
+		name      string
+		graph     c4ContainersGraph
+		want      c4ContainersGraph
+		wantCodes []string
+		wantErr   bool
+	}{
+		{
+			name:  "shall pass valid graph",
+			graph: c4ContainersGraph{Containers: []*container{{ID: "0"}, {ID: "1"}}, Rels: []*rel{{From: "0", To: "1"}}},
+			want:  c4ContainersGraph{Containers: []*container{{ID: "0"}, {ID: "1"}}, Rels: []*rel{{From: "0", To: "1"}}},
+		},
+		{
+			name:      "shall fail empty graph",
+			graph:     c4ContainersGraph{},
+			want:      c4ContainersGraph{},
+			wantCodes: []string{findingEmptyGraph},
+			wantErr:   true,
+		},
+		{
+			name: "shall dedupe nodes",
+			graph: c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "0"}, {ID: "0", Label: "foo"}, {Label: "bar"}},
+			},
+			want: c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "0_2", Label: "foo"}, {ID: "node_2", Label: "bar"}},
+			},
+			wantCodes: []string{findingDuplicateNode, findingDuplicateID, findingMissingID},
+		},
+		{
+			name: "shall drop invalid links",
+			graph: c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "1"}},
+				Rels:       []*rel{{From: "0"}, {From: "0", To: "2"}, {From: "1", To: "1"}, {From: "0", To: "1"}},
+			},
+			want: c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "1"}},
+				Rels:       []*rel{{From: "0", To: "1"}},
+			},
+			wantCodes: []string{findingMissingLinkEnd, findingDanglingLink, findingSelfLoop},
+		},
+		{
+			name: "shall resolve link's end by the node's label",
+			graph: c4ContainersGraph{
+				Containers: []*container{{ID: "0", Label: "Backend"}, {ID: "1", Label: "Database"}},
+				Rels:       []*rel{{From: "backend", To: "1"}},
+			},
+			want: c4ContainersGraph{
+				Containers: []*container{{ID: "0", Label: "Backend"}, {ID: "1", Label: "Database"}},
+				Rels:       []*rel{{From: "0", To: "1"}},
+			},
+			wantCodes: []string{findingLinkEndByLabel},
+		},
+		{
+			name: "shall normalize directions",
+			graph: c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "1"}},
+				Rels: []*rel{
+					{From: "0", To: "1", Direction: "lr"},
+					{From: "0", To: "1", Direction: "TB"},
+					{From: "0", To: "1", Direction: "left"},
+					{From: "0", To: "1", Direction: "diagonal"},
+				},
+			},
+			want: c4ContainersGraph{
+				Containers: []*container{{ID: "0"}, {ID: "1"}},
+				Rels: []*rel{
+					{From: "0", To: "1", Direction: "LR"},
+					{From: "0", To: "1", Direction: "TD"},
+					{From: "0", To: "1", Direction: "RL"},
+					{From: "0", To: "1"},
+				},
+			},
+			wantCodes: []string{findingDirectionAlias, findingDirectionAlias, findingUnknownDirection},
+		},
+		{
+			name: "shall infer databases and queues",
+			graph: c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Technology: "PostgreSQL 15"},
+					{ID: "1", Technology: "Apache Kafka"},
+					{ID: "2", Technology: "GCP Pub/Sub"},
+					{ID: "3", Technology: "Go"},
+					{ID: "4", Technology: "Postgres", IsUser: true},
+					{ID: "5", Technology: "MySQL", IsDatabase: true},
+				},
+			},
+			want: c4ContainersGraph{
+				Containers: []*container{
+					{ID: "0", Technology: "PostgreSQL 15", IsDatabase: true},
+					{ID: "1", Technology: "Apache Kafka", IsQueue: true},
+					{ID: "2", Technology: "GCP Pub/Sub", IsQueue: true},
+					{ID: "3", Technology: "Go"},
+					{ID: "4", Technology: "Postgres", IsUser: true},
+					{ID: "5", Technology: "MySQL", IsDatabase: true},
+				},
+			},
+			wantCodes: []string{findingInferredContainer, findingInferredContainer, findingInferredContainer},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				// GIVEN
+				graph := tt.graph
+
+				// WHEN
+				got := validateGraph(&graph)
+
+				// THEN
+				var codes []string
+				for _, f := range got {
+					codes = append(codes, f.Code)
+				}
+				if !reflect.DeepEqual(codes, tt.wantCodes) {
+					t.Errorf("unexpected findings: %+v", got)
+				}
+				if got.hasErrors() != tt.wantErr {
+					t.Errorf("hasErrors() = %v, want %v", got.hasErrors(), tt.wantErr)
+				}
+				if !reflect.DeepEqual(graph, tt.want) {
+					t.Errorf("unexpected graph: %+v", graph)
+				}
+			},
+		)
+	}
+}
+
+func Test_parseGraph(t *testing.T) {
+	t.Run(
+		"shall report invalid json", func(t *testing.T) {
+			_, got, err := parseGraph([]byte(`{"nodes":[`))
+			if err == nil {
+				t.Error("error expected")
+			}
+			if len(got) != 1 || got[0].Code != findingInvalidJSON || !got.hasErrors() {
+				t.Errorf("unexpected findings: %+v", got)
+			}
+		},
+	)
+
+	t.Run(
+		"shall not report findings for the prediction error", func(t *testing.T) {
+			_, got, err := parseGraph([]byte(`{"error":"foo"}`))
+			if err == nil {
+				t.Error("error expected")
+			}
+			if got != nil {
+				t.Errorf("unexpected findings: %+v", got)
+			}
+		},
+	)
+}
+
+func Test_validateGraphBoundaries(t *testing.T) {
+	// GIVEN
+	graph := c4ContainersGraph{
+		Containers: []*container{{ID: "0", System: "core"}},
+		Boundaries: []*boundary{
+			nil,
+			{Label: "Core", Parent: "acme"},
+			{},
+			{ID: "acme", Parent: "foo"},
+			{ID: "bar", Parent: "bar"},
+		},
+	}
+
+	// WHEN
+	got := validateGraph(&graph)
+
+	// THEN
+	var codes []string
+	for _, f := range got {
+		codes = append(codes, f.Code)
+	}
+	wantCodes := []string{findingMissingID, findingInvalidBoundary, findingUnknownParent, findingUnknownParent}
+	if !reflect.DeepEqual(codes, wantCodes) {
+		t.Errorf("unexpected findings: %+v", got)
+	}
+
+	want := []*boundary{{ID: "Core", Label: "Core", Parent: "acme"}, {ID: "acme"}, {ID: "bar"}}
+	if !reflect.DeepEqual(graph.Boundaries, want) {
+		t.Errorf("unexpected boundaries: %+v", graph.Boundaries)
+	}
+}
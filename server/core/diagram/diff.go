@@ -0,0 +1,54 @@
+package diagram
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// GraphLengthMax defines the maximum size of the diagram's graph in bytes.
+const GraphLengthMax = 256 << 10
+
+// Diff defines two versions of the diagram's graph to compare.
+type Diff struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// IsEmpty reports whether the graphs to compare are not set.
+func (d Diff) IsEmpty() bool {
+	return len(d.Before) == 0 && len(d.After) == 0
+}
+
+// Validate validates that both graphs are set and fit the size limit.
+func (d Diff) Validate() error {
+	for _, v := range []json.RawMessage{d.Before, d.After} {
+		if len(v) == 0 || len(v) > GraphLengthMax {
+			return errors.New(
+				"graphs before and after must be set, and not exceed " + strconv.Itoa(GraphLengthMax) + " bytes",
+			)
+		}
+	}
+	return nil
+}
+
+// DiffInput defines the input to compare two versions of the diagram's graph.
+type DiffInput struct {
+	Input
+	Diff Diff
+	// Layout defines the layout of the diagram highlighting the changes.
+	Layout Layout
+}
+
+// Validate validates the input, the graphs and the layout.
+func (v DiffInput) Validate() error {
+	if err := v.Input.Validate(); err != nil {
+		return err
+	}
+	if !v.Diff.IsEmpty() {
+		if err := v.Diff.Validate(); err != nil {
+			return err
+		}
+	}
+	return v.Layout.Validate()
+}
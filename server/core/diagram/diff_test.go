@@ -0,0 +1,81 @@
+package diagram
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiff_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		diff    Diff
+		wantErr bool
+	}{
+		{
+			name: "shall pass",
+			diff: Diff{Before: json.RawMessage(`{"nodes":[]}`), After: json.RawMessage(`{"nodes":[]}`)},
+		},
+		{
+			name:    "shall fail: graph after the change is not set",
+			diff:    Diff{Before: json.RawMessage(`{"nodes":[]}`)},
+			wantErr: true,
+		},
+		{
+			name: "shall fail: too large graph",
+			diff: Diff{
+				Before: json.RawMessage(`{"nodes":[]}`),
+				After:  json.RawMessage(`"` + strings.Repeat("a", GraphLengthMax) + `"`),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.diff.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func TestDiffInput_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   DiffInput
+		wantErr bool
+	}{
+		{
+			name: "shall pass: diff without prompt",
+			input: DiffInput{
+				Input: NewInputWithoutPrompt("foo", ""),
+				Diff:  Diff{Before: json.RawMessage(`{}`), After: json.RawMessage(`{}`)},
+			},
+		},
+		{
+			name:    "shall fail: invalid diff",
+			input:   DiffInput{Input: NewInputWithoutPrompt("foo", ""), Diff: Diff{After: json.RawMessage(`{}`)}},
+			wantErr: true,
+		},
+		{
+			name: "shall fail: invalid layout",
+			input: DiffInput{
+				Input:  NewInputWithoutPrompt("foo", ""),
+				Diff:   Diff{Before: json.RawMessage(`{}`), After: json.RawMessage(`{}`)},
+				Layout: Layout{Theme: "foo"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.input.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,46 @@
+package diagram
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Explanation defines the diagram's graph to explain and review.
+type Explanation struct {
+	Graph json.RawMessage `json:"graph"`
+	// Narrative defines whether the model shall write the prose description of the diagram.
+	Narrative bool `json:"narrative,omitempty"`
+}
+
+// IsEmpty reports whether the graph to explain is not set.
+func (e Explanation) IsEmpty() bool {
+	return len(e.Graph) == 0
+}
+
+// Validate validates that the graph fits the size limit.
+func (e Explanation) Validate() error {
+	if len(e.Graph) > GraphLengthMax {
+		return errors.New("graph must not exceed " + strconv.Itoa(GraphLengthMax) + " bytes")
+	}
+	return nil
+}
+
+// ExplanationInput defines the input to explain and review the diagram's graph.
+type ExplanationInput struct {
+	Input
+	Explanation Explanation
+	// OutputLanguage defines the language of the prose description written by the model.
+	OutputLanguage Language
+}
+
+// Validate validates the input, the graph and the output language.
+func (v ExplanationInput) Validate() error {
+	if err := v.Input.Validate(); err != nil {
+		return err
+	}
+	if err := v.Explanation.Validate(); err != nil {
+		return err
+	}
+	return v.OutputLanguage.Validate()
+}
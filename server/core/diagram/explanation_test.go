@@ -0,0 +1,66 @@
+package diagram
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExplanation_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		explanation Explanation
+		wantErr     bool
+	}{
+		{
+			name:        "shall pass",
+			explanation: Explanation{Graph: json.RawMessage(`{"nodes":[]}`), Narrative: true},
+		},
+		{
+			name:        "shall fail: too large graph",
+			explanation: Explanation{Graph: json.RawMessage(`"` + strings.Repeat("a", GraphLengthMax) + `"`)},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.explanation.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func TestExplanationInput_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   ExplanationInput
+		wantErr bool
+	}{
+		{
+			name: "shall pass: explanation without prompt",
+			input: ExplanationInput{
+				Input: NewInputWithoutPrompt("foo", ""), Explanation: Explanation{Graph: json.RawMessage(`{}`)},
+			},
+		},
+		{
+			name: "shall fail: unsupported output language",
+			input: ExplanationInput{
+				Input: NewInputWithoutPrompt("foo", ""), Explanation: Explanation{Graph: json.RawMessage(`{}`)},
+				OutputLanguage: "klingon",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.input.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
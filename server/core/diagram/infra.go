@@ -0,0 +1,83 @@
+package diagram
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Formats of the infrastructure code.
+const (
+	InfraFormatDockerCompose = "docker-compose"
+	InfraFormatKubernetes    = "kubernetes"
+	// InfraFormatTerraformPlan defines the plan in the JSON format, i.e. the output of `terraform show -json`.
+	InfraFormatTerraformPlan = "terraform-plan"
+)
+
+// InfraSourceLengthMax defines the maximum size of the infrastructure code in bytes.
+const InfraSourceLengthMax = 512 << 10
+
+// Infra defines the infrastructure code to generate the diagram from.
+type Infra struct {
+	// Format defines the format of the infrastructure code: docker-compose, kubernetes, or terraform-plan.
+	Format string `json:"format"`
+	Source string `json:"source"`
+	// Describe defines whether the model shall add the labels and descriptions to the extracted diagram.
+	Describe bool `json:"describe,omitempty"`
+}
+
+// IsEmpty reports whether the infrastructure code is not set.
+func (i Infra) IsEmpty() bool {
+	return i == (Infra{})
+}
+
+// Validate validates the infrastructure code's attributes.
+func (i Infra) Validate() error {
+	switch i.Format {
+	case InfraFormatDockerCompose, InfraFormatKubernetes, InfraFormatTerraformPlan:
+	default:
+		return errors.New("unknown infrastructure code format " + i.Format)
+	}
+
+	if len(i.Source) == 0 || len(i.Source) > InfraSourceLengthMax {
+		return errors.New(
+			"infrastructure code size must be between 1 and " + strconv.Itoa(InfraSourceLengthMax) + " bytes",
+		)
+	}
+
+	return nil
+}
+
+// InputError defines the error of the input which cannot be processed, e.g. the invalid infrastructure code.
+type InputError struct {
+	Reason string
+}
+
+func (e InputError) Error() string {
+	return "invalid input: " + e.Reason
+}
+
+// InfraInput defines the input to generate the diagram from the infrastructure code.
+type InfraInput struct {
+	Input
+	Infra Infra
+	// Layout defines the layout requested explicitly by the user.
+	Layout Layout
+	// OutputLanguage defines the language of the labels and descriptions written by the model.
+	OutputLanguage Language
+}
+
+// Validate validates the input, the infrastructure code, the layout and the output language.
+func (v InfraInput) Validate() error {
+	if err := v.Input.Validate(); err != nil {
+		return err
+	}
+	if !v.Infra.IsEmpty() {
+		if err := v.Infra.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := v.OutputLanguage.Validate(); err != nil {
+		return err
+	}
+	return v.Layout.Validate()
+}
@@ -0,0 +1,94 @@
+package diagram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInfra_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		infra   Infra
+		wantErr bool
+	}{
+		{
+			name:  "shall pass: docker-compose",
+			infra: Infra{Format: InfraFormatDockerCompose, Source: "services: {}"},
+		},
+		{
+			name:  "shall pass: terraform plan",
+			infra: Infra{Format: InfraFormatTerraformPlan, Source: "{}", Describe: true},
+		},
+		{
+			name:    "shall fail: unknown format",
+			infra:   Infra{Format: "helm", Source: "foo"},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: empty source",
+			infra:   Infra{Format: InfraFormatKubernetes},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: too large source",
+			infra:   Infra{Format: InfraFormatKubernetes, Source: strings.Repeat("a", InfraSourceLengthMax+1)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.infra.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func TestInfraInput_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   InfraInput
+		wantErr bool
+	}{
+		{
+			name: "shall pass: infra without prompt",
+			input: InfraInput{
+				Input: NewInputWithoutPrompt("foo", ""),
+				Infra: Infra{Format: InfraFormatDockerCompose, Source: "services: {}"},
+			},
+		},
+		{
+			name: "shall fail: infra with prompt",
+			input: InfraInput{
+				Input: &inquiry{Prompt: "foo bar", WithoutPrompt: true},
+				Infra: Infra{Format: InfraFormatDockerCompose, Source: "services: {}"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: invalid infra",
+			input:   InfraInput{Input: NewInputWithoutPrompt("foo", ""), Infra: Infra{Format: "foo", Source: "bar"}},
+			wantErr: true,
+		},
+		{
+			name: "shall fail: invalid layout",
+			input: InfraInput{
+				Input:  NewInputWithoutPrompt("foo", ""),
+				Infra:  Infra{Format: InfraFormatDockerCompose, Source: "services: {}"},
+				Layout: Layout{Direction: "foo"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.input.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/kislerdm/diagramastext/server/core/internal/utils"
 )
@@ -51,6 +52,8 @@ type inquiry struct {
 	UserID          string
 	APIToken        string
 	PromptLengthMax uint16
+	// WithoutPrompt defines the input of the request which does not take the prompt, e.g. to compare the graphs.
+	WithoutPrompt bool
 }
 
 const promptLengthMin = 3
@@ -72,11 +75,21 @@ func (v inquiry) GetUserAPIToken() string {
 }
 
 func (v inquiry) Validate() error {
+	if v.WithoutPrompt {
+		if v.Prompt != "" {
+			return errors.New("prompt is not expected")
+		}
+		return nil
+	}
+
 	max := int(v.PromptLengthMax)
 
 	prompt := strings.ReplaceAll(v.Prompt, "\n", "")
 
-	if len(prompt) < promptLengthMin || len(prompt) > max {
+	// the length is defined in characters to have the same limit for the prompts written in non-latin scripts
+	promptLength := utf8.RuneCountInString(prompt)
+
+	if promptLength < promptLengthMin || promptLength > max {
 		return errors.New(
 			"prompt length must be between " + strconv.Itoa(promptLengthMin) + " and " +
 				strconv.Itoa(max) + " characters",
@@ -102,3 +115,34 @@ func NewInput(prompt string, userID string, apiToken string, promptLengthMax uin
 
 	return o, nil
 }
+
+// NewInputWithoutPrompt initialises the `Input` object of the request which does not take the prompt,
+// e.g. to generate the diagram from the infrastructure code.
+func NewInputWithoutPrompt(userID string, apiToken string) Input {
+	return &inquiry{
+		UserID:        userID,
+		APIToken:      apiToken,
+		RequestID:     utils.NewUUID(),
+		WithoutPrompt: true,
+	}
+}
+
+// GenerateInput defines the input to generate the diagram from the prompt.
+type GenerateInput struct {
+	Input
+	// Layout defines the layout requested explicitly by the user.
+	Layout Layout
+	// OutputLanguage defines the language of the diagram's labels, the prompt's language is used by default.
+	OutputLanguage Language
+}
+
+// Validate validates the input, the layout and the output language.
+func (v GenerateInput) Validate() error {
+	if err := v.Input.Validate(); err != nil {
+		return err
+	}
+	if err := v.OutputLanguage.Validate(); err != nil {
+		return err
+	}
+	return v.Layout.Validate()
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -55,6 +56,40 @@ func Test_inquiry_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "happy path - length is counted in characters, not bytes",
+			fields: fields{
+				// 34 characters, 100 bytes
+				Prompt:          strings.Repeat("データ", 11) + "図",
+				PromptLengthMax: promptLengthMax,
+			},
+			wantErr: false,
+		},
+		{
+			name: "happy path - short prompt in japanese",
+			fields: fields{
+				Prompt:          "三つの箱",
+				PromptLengthMax: promptLengthMax,
+			},
+			wantErr: false,
+		},
+		{
+			name: "unhappy path - too long in characters",
+			fields: fields{
+				Prompt:          strings.Repeat("ü", promptLengthMax+1),
+				PromptLengthMax: promptLengthMax,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path - too short in characters",
+			fields: fields{
+				// 2 characters, 6 bytes
+				Prompt:          "図表",
+				PromptLengthMax: promptLengthMax,
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
@@ -118,7 +153,9 @@ func TestNewInput(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				got, err := NewInput(tt.args.prompt, tt.args.userID, tt.args.apiToken, tt.args.promptLengthMax)
+				got, err := NewInput(
+					tt.args.prompt, tt.args.userID, tt.args.apiToken, tt.args.promptLengthMax,
+				)
 				if (err != nil) != tt.wantErr {
 					t.Errorf("NewInputDriverHTTP() error = %v, wantErr %v", err, tt.wantErr)
 					return
@@ -142,6 +179,63 @@ func TestNewInput(t *testing.T) {
 	}
 }
 
+func TestNewInputWithoutPrompt(t *testing.T) {
+	// WHEN
+	got := NewInputWithoutPrompt("foo", "bar")
+
+	// THEN
+	if err := got.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetUserID() != "foo" || got.GetUserAPIToken() != "bar" || got.GetPrompt() != "" || got.GetRequestID() == "" {
+		t.Errorf("unexpected input: %+v", got)
+	}
+}
+
+func TestGenerateInput_Validate(t *testing.T) {
+	input, err := NewInput("foo bar", "foo", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		input   GenerateInput
+		wantErr bool
+	}{
+		{
+			name: "shall pass: with layout and output language",
+			input: GenerateInput{
+				Input: input, Layout: Layout{Direction: LayoutDirectionLeftRight}, OutputLanguage: "de",
+			},
+		},
+		{
+			name:    "shall fail: invalid layout",
+			input:   GenerateInput{Input: input, Layout: Layout{Theme: "foo"}},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: unsupported output language",
+			input:   GenerateInput{Input: input, OutputLanguage: "klingon"},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: invalid prompt",
+			input:   GenerateInput{Input: &inquiry{Prompt: "a", PromptLengthMax: 100}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.input.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
 func TestMockInput(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,224 @@
+package diagram
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Language defines the natural language as ISO 639-1 code, e.g. "de".
+type Language string
+
+// languageNames defines the supported languages, the names are used in the instructions to the model.
+var languageNames = map[Language]string{
+	"ar": "Arabic",
+	"de": "German",
+	"el": "Greek",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"he": "Hebrew",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"uk": "Ukrainian",
+	"zh": "Chinese",
+}
+
+// IsEmpty reports whether the language is not set.
+func (l Language) IsEmpty() bool {
+	return l == ""
+}
+
+// Validate validates that the language is supported, the empty language is valid.
+func (l Language) Validate() error {
+	if _, ok := languageNames[l]; !ok && !l.IsEmpty() {
+		codes := make([]string, 0, len(languageNames))
+		for k := range languageNames {
+			codes = append(codes, string(k))
+		}
+		sort.Strings(codes)
+		return errors.New("output language must be one of " + strings.Join(codes, ","))
+	}
+	return nil
+}
+
+// Name returns the English name of the language, e.g. "German", it returns empty string if the language is unknown.
+func (l Language) Name() string {
+	return languageNames[l]
+}
+
+// latinLanguages defines the frequent words and the specific letters of the languages using the latin script.
+// The order defines the priority if the text matches several languages equally.
+var latinLanguages = []struct {
+	language Language
+	words    map[string]struct{}
+	letters  string
+}{
+	{
+		language: "en",
+		words: wordSet(
+			"the", "and", "with", "from", "to", "of", "which", "that", "is", "are", "into", "for", "by", "on",
+			"draw", "reads", "writes", "calls", "uses",
+		),
+	},
+	{
+		language: "de",
+		words: wordSet(
+			"der", "die", "das", "und", "mit", "von", "zu", "ein", "eine", "einen", "einem", "dem", "den", "ist",
+			"auf", "für", "aus", "nach", "über", "zeichne", "liest", "schreibt", "ruft", "nutzt",
+		),
+		letters: "äöüß",
+	},
+	{
+		language: "es",
+		words: wordSet(
+			"el", "la", "los", "las", "y", "con", "de", "del", "que", "un", "una", "por", "para", "dibuja", "lee",
+			"escribe", "llama", "usa",
+		),
+		letters: "ñ¿¡",
+	},
+	{
+		language: "fr",
+		words: wordSet(
+			"le", "les", "et", "avec", "du", "des", "une", "qui", "pour", "dans", "dessine", "lit", "écrit",
+			"appelle", "utilise",
+		),
+		letters: "èêëîœ",
+	},
+	{
+		language: "it",
+		words: wordSet(
+			"il", "lo", "gli", "e", "con", "di", "che", "una", "per", "dal", "disegna", "legge", "scrive", "chiama",
+			"usa",
+		),
+		letters: "ì",
+	},
+	{
+		language: "pt",
+		words: wordSet(
+			"o", "os", "as", "e", "com", "do", "da", "dos", "que", "um", "uma", "para", "desenha", "lê", "escreve",
+			"chama", "usa",
+		),
+		letters: "ãõ",
+	},
+	{
+		language: "nl",
+		words: wordSet(
+			"de", "het", "en", "met", "van", "een", "die", "naar", "teken", "leest", "schrijft", "roept",
+			"gebruikt",
+		),
+		letters: "ĳ",
+	},
+	{
+		language: "pl",
+		words: wordSet(
+			"i", "z", "w", "do", "na", "się", "oraz", "który", "która", "narysuj", "czyta", "zapisuje",
+			"wywołuje", "używa",
+		),
+		letters: "ąęłśźżćń",
+	},
+}
+
+func wordSet(words ...string) map[string]struct{} {
+	o := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		o[w] = struct{}{}
+	}
+	return o
+}
+
+// DetectLanguage detects the language of the text by its dominant script,
+// the languages using the latin script are detected by the frequent words and the specific letters.
+// It returns the empty language if the language cannot be detected.
+func DetectLanguage(text string) Language {
+	var latin, kana, han, hangul, cyrillic, greek, arabic, hebrew int
+	var ukrainian bool
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+			ukrainian = ukrainian || strings.ContainsRune("іїєґІЇЄҐ", r)
+		case unicode.Is(unicode.Greek, r):
+			greek++
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+		case unicode.Is(unicode.Hebrew, r):
+			hebrew++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+
+	// japanese text mixes kana and kanji, kanji alone is interpreted as chinese
+	cjk := Language("zh")
+	if kana > 0 {
+		cjk = "ja"
+	}
+	cyrillicLanguage := Language("ru")
+	if ukrainian {
+		cyrillicLanguage = "uk"
+	}
+
+	var o Language
+	var count int
+	for _, script := range []struct {
+		language Language
+		count    int
+	}{
+		{cjk, kana + han},
+		{"ko", hangul},
+		{cyrillicLanguage, cyrillic},
+		{"el", greek},
+		{"ar", arabic},
+		{"he", hebrew},
+	} {
+		if script.count > count {
+			o, count = script.language, script.count
+		}
+	}
+
+	// the technologies' names are often written in latin script, hence it's dominant only if prevails
+	if latin > count {
+		return detectLatinLanguage(text)
+	}
+	return o
+}
+
+func detectLatinLanguage(text string) Language {
+	text = strings.ToLower(text)
+	words := strings.FieldsFunc(
+		text, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		},
+	)
+
+	var o Language
+	var scoreMax int
+	for _, l := range latinLanguages {
+		var score int
+		for _, w := range words {
+			if _, ok := l.words[w]; ok {
+				score++
+			}
+		}
+		for _, r := range l.letters {
+			score += strings.Count(text, string(r))
+		}
+		if score > scoreMax {
+			o, scoreMax = l.language, score
+		}
+	}
+	return o
+}
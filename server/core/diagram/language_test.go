@@ -0,0 +1,104 @@
+package diagram
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Language
+	}{
+		{
+			name: "english",
+			text: "c4 diagram with python backend reading from postgres over tcp",
+			want: "en",
+		},
+		{
+			name: "german",
+			text: "Zeichne ein C4-Diagramm mit einem Go-Backend, das aus der Postgres-Datenbank über TCP liest",
+			want: "de",
+		},
+		{
+			name: "spanish",
+			text: "Dibuja un diagrama C4 con un backend en Java que lee de DynamoDB y publica eventos en Kafka",
+			want: "es",
+		},
+		{
+			name: "japanese with technologies in latin script",
+			text: "Goのバックエンドが TCP経由でPostgresデータベースから読み取るC4図を描いて",
+			want: "ja",
+		},
+		{
+			name: "chinese",
+			text: "绘制一个C4图：Go后端通过TCP从Postgres数据库读取数据",
+			want: "zh",
+		},
+		{
+			name: "korean",
+			text: "Go 백엔드가 Postgres 데이터베이스에서 읽는 C4 다이어그램을 그려줘",
+			want: "ko",
+		},
+		{
+			name: "russian",
+			text: "Нарисуй диаграмму C4: бэкенд на Go читает из базы данных Postgres",
+			want: "ru",
+		},
+		{
+			name: "ukrainian",
+			text: "Намалюй діаграму C4: бекенд на Go читає з бази даних Postgres",
+			want: "uk",
+		},
+		{
+			name: "technologies only",
+			text: "golang postgres kafka",
+		},
+		{
+			name: "no letters",
+			text: "1 2 3 -> 4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := DetectLanguage(tt.text); got != tt.want {
+					t.Errorf("DetectLanguage() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestLanguage_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		language Language
+		wantErr  bool
+	}{
+		{
+			name: "shall pass: not set",
+		},
+		{
+			name:     "shall pass: supported language",
+			language: "ja",
+		},
+		{
+			name:     "shall fail: unsupported language",
+			language: "xx",
+			wantErr:  true,
+		},
+		{
+			name:     "shall fail: language name instead of code",
+			language: "German",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.language.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,64 @@
+package diagram
+
+import "errors"
+
+// Directions of the diagram's layout.
+const (
+	LayoutDirectionTopDown   = "top_down"
+	LayoutDirectionLeftRight = "left_right"
+	LayoutDirectionLandscape = "landscape"
+)
+
+// Spacings between the diagram's elements.
+const (
+	LayoutSpacingCompact = "compact"
+	LayoutSpacingWide    = "wide"
+)
+
+// Themes of the diagram.
+const (
+	LayoutThemeDefault = "default"
+	LayoutThemeDark    = "dark"
+	// LayoutThemeCorporate defines the diagramastext.dev palette.
+	LayoutThemeCorporate = "corporate"
+)
+
+// Layout defines the diagram's layout requested explicitly by the user.
+// The set fields override the layout inferred from the prompt.
+type Layout struct {
+	// Direction defines the diagram's direction: top_down, left_right, or landscape.
+	Direction string `json:"direction,omitempty"`
+	// Spacing defines the spacing between elements: compact, or wide.
+	Spacing string `json:"spacing,omitempty"`
+	// Theme defines the named theme: default, dark, or corporate.
+	Theme string `json:"theme,omitempty"`
+	// Sketch defines whether to render the diagram as a hand-drawn sketch.
+	Sketch *bool `json:"sketch,omitempty"`
+	// HideStereotypes defines whether to hide the elements' stereotypes, e.g. <<container>>.
+	HideStereotypes *bool `json:"hide_stereotypes,omitempty"`
+	// LegendInLayout defines whether to place the legend in the diagram's layout.
+	LegendInLayout *bool `json:"legend_in_layout,omitempty"`
+}
+
+// Validate validates the layout's attributes.
+func (l Layout) Validate() error {
+	switch l.Direction {
+	case "", LayoutDirectionTopDown, LayoutDirectionLeftRight, LayoutDirectionLandscape:
+	default:
+		return errors.New("unknown layout direction " + l.Direction)
+	}
+
+	switch l.Spacing {
+	case "", LayoutSpacingCompact, LayoutSpacingWide:
+	default:
+		return errors.New("unknown layout spacing " + l.Spacing)
+	}
+
+	switch l.Theme {
+	case "", LayoutThemeDefault, LayoutThemeDark, LayoutThemeCorporate:
+	default:
+		return errors.New("unknown theme " + l.Theme)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package diagram
+
+import "testing"
+
+func TestLayout_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  Layout
+		wantErr bool
+	}{
+		{
+			name: "shall pass: empty layout",
+		},
+		{
+			name: "shall pass: all attributes set",
+			layout: Layout{
+				Direction: LayoutDirectionLandscape,
+				Spacing:   LayoutSpacingWide,
+				Theme:     LayoutThemeCorporate,
+			},
+		},
+		{
+			name:    "shall fail: unknown direction",
+			layout:  Layout{Direction: "right_left"},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: unknown spacing",
+			layout:  Layout{Spacing: "huge"},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: unknown theme",
+			layout:  Layout{Theme: "neon"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.layout.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
@@ -26,16 +26,24 @@ func (m MockOutput) Serialize() ([]byte, error) {
 type responseSVG struct {
 	// SVG XML-encoded SVG diagram.
 	SVG string `json:"svg"`
+	// Warnings defines the warnings about the request, e.g. the flagged prompt.
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 func (r responseSVG) Serialize() ([]byte, error) {
 	return json.Marshal(r)
 }
 
-// NewResultSVG create a response object with the SVG diagram.
-func NewResultSVG(v []byte) (Output, error) {
+// NewResultSVG create a response object with the SVG diagram, and optional warnings.
+func NewResultSVG(v []byte, warnings ...string) (Output, error) {
 	if err := utils.ValidateSVG(v); err != nil {
 		return nil, err
 	}
-	return &responseSVG{SVG: string(v)}, nil
+	o := &responseSVG{SVG: string(v)}
+	for _, w := range warnings {
+		if w != "" {
+			o.Warnings = append(o.Warnings, w)
+		}
+	}
+	return o, nil
 }
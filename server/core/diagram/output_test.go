@@ -69,7 +69,8 @@ func TestNewResultSVG(t *testing.T) {
 
 func Test_responseSVG_Serialize(t *testing.T) {
 	type fields struct {
-		SVG string
+		SVG      string
+		Warnings []string
 	}
 
 	tests := []struct {
@@ -86,12 +87,21 @@ func Test_responseSVG_Serialize(t *testing.T) {
 			want:    []byte(`{"svg":"foo"}`),
 			wantErr: false,
 		},
+		{
+			name: "happy path: with warnings",
+			fields: fields{
+				SVG:      "foo",
+				Warnings: []string{"prompt flagged: off_topic"},
+			},
+			want: []byte(`{"svg":"foo","warnings":["prompt flagged: off_topic"]}`),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
 				r := responseSVG{
-					SVG: tt.fields.SVG,
+					SVG:      tt.fields.SVG,
+					Warnings: tt.fields.Warnings,
 				}
 				got, err := r.Serialize()
 				if (err != nil) != tt.wantErr {
@@ -0,0 +1,234 @@
+package diagram
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of the redacted values.
+const (
+	RedactionKindSecret   = "SECRET"
+	RedactionKindURL      = "URL"
+	RedactionKindEmail    = "EMAIL"
+	RedactionKindIP       = "IP"
+	RedactionKindHost     = "HOST"
+	RedactionKindDenyList = "REDACTED"
+)
+
+// redactionDetector detects the values of the kind in the text.
+type redactionDetector struct {
+	kind    string
+	pattern *regexp.Regexp
+	// group defines the index of the pattern's submatch to redact, the whole match is redacted if zero.
+	group int
+	// valid validates the detected value.
+	valid func(string) bool
+}
+
+// redactionDetectors defines the detectors ordered by priority: the match of the detector listed earlier
+// wins if the matches of several detectors overlap.
+var redactionDetectors = []redactionDetector{
+	{
+		kind:    RedactionKindSecret,
+		pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+	},
+	{
+		kind: RedactionKindSecret,
+		pattern: regexp.MustCompile(
+			`\b(?:sk-[A-Za-z0-9_\-]{20,}|AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|` +
+				`github_pat_[A-Za-z0-9_]{22,}|xox[abprs]-[A-Za-z0-9\-]{10,}|AIza[0-9A-Za-z_\-]{35}|` +
+				`eyJ[A-Za-z0-9_\-]{5,}\.[A-Za-z0-9_\-]{5,}\.[A-Za-z0-9_\-]+)`,
+		),
+	},
+	{
+		kind: RedactionKindSecret,
+		pattern: regexp.MustCompile(
+			`(?i)\b(?:password|passwd|pwd|secret|token|api[_\-]?key|access[_\-]?key)\s*[:=]\s*["']?([^\s"',;]+)`,
+		),
+		group: 1,
+	},
+	{
+		kind:    RedactionKindURL,
+		pattern: regexp.MustCompile(`(?i)\b(?:https?|ftp|wss?|s3|gs)://[^\s"'<>()\[\]{}]*[^\s"'<>()\[\]{}.,;:!?]`),
+	},
+	{
+		kind:    RedactionKindEmail,
+		pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}\b`),
+	},
+	{
+		kind:    RedactionKindIP,
+		pattern: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`),
+		valid:   func(s string) bool { return net.ParseIP(s) != nil },
+	},
+	{
+		kind:    RedactionKindIP,
+		pattern: regexp.MustCompile(`(?i)(?:[0-9a-f]{0,4}:){2,7}[0-9a-f]{0,4}`),
+		valid:   isIPv6,
+	},
+	{
+		kind: RedactionKindHost,
+		pattern: regexp.MustCompile(
+			`(?i)\b(?:[a-z0-9](?:[a-z0-9\-]*[a-z0-9])?\.)+` +
+				`(?:internal|local|localdomain|lan|corp|intranet|intra|private|home\.arpa)\b`,
+		),
+	},
+}
+
+// isIPv6 validates the IPv6 address. It requires at least two groups, one of which contains a digit
+// to avoid false positives like "std::vector".
+func isIPv6(s string) bool {
+	if net.ParseIP(s) == nil {
+		return false
+	}
+	var groups int
+	for _, el := range strings.Split(s, ":") {
+		if el != "" {
+			groups++
+		}
+	}
+	return groups > 1 && strings.ContainsAny(s, "0123456789")
+}
+
+// Redactor detects emails, IPs, URLs, secrets, internal hostnames and the deny-listed terms in the text,
+// and replaces them with the placeholders.
+type Redactor struct {
+	denyList *regexp.Regexp
+}
+
+// NewRedactor initialises the Redactor. The denyList defines the terms to redact regardless of the case.
+func NewRedactor(denyList ...string) Redactor {
+	var terms []string
+	for _, el := range denyList {
+		if el = strings.TrimSpace(el); el != "" {
+			terms = append(terms, regexp.QuoteMeta(el))
+		}
+	}
+
+	var o Redactor
+	if len(terms) > 0 {
+		// longer terms first to redact the longest match
+		sort.SliceStable(terms, func(i, j int) bool { return len(terms[i]) > len(terms[j]) })
+		o.denyList = regexp.MustCompile(`(?i)` + strings.Join(terms, "|"))
+	}
+	return o
+}
+
+type redactionMatch struct {
+	start, end int
+	kind       string
+}
+
+func (r Redactor) matches(text string) []redactionMatch {
+	var o []redactionMatch
+	for _, d := range redactionDetectors {
+		for _, m := range d.pattern.FindAllStringSubmatchIndex(text, -1) {
+			start, end := m[2*d.group], m[2*d.group+1]
+			if start < 0 || start == end {
+				continue
+			}
+			if d.valid != nil && !d.valid(text[start:end]) {
+				continue
+			}
+			o = append(o, redactionMatch{start: start, end: end, kind: d.kind})
+		}
+	}
+
+	if r.denyList != nil {
+		for _, m := range r.denyList.FindAllStringIndex(text, -1) {
+			o = append(o, redactionMatch{start: m[0], end: m[1], kind: RedactionKindDenyList})
+		}
+	}
+
+	// the stable sort keeps the detectors' priority for the matches starting at the same position
+	sort.SliceStable(
+		o, func(i, j int) bool {
+			if o[i].start != o[j].start {
+				return o[i].start < o[j].start
+			}
+			return o[i].end > o[j].end
+		},
+	)
+
+	// overlapping matches are dropped
+	var (
+		end int
+		n   int
+	)
+	for _, m := range o {
+		if m.start < end {
+			continue
+		}
+		o[n] = m
+		n++
+		end = m.end
+	}
+	return o[:n]
+}
+
+// Redact replaces the sensitive data in the text with the placeholders.
+// Equal values are replaced with the same placeholder.
+func (r Redactor) Redact(text string) Redaction {
+	o := Redaction{values: map[string]string{}}
+
+	matches := r.matches(text)
+	if len(matches) == 0 {
+		o.Text = text
+		return o
+	}
+
+	var (
+		buf          strings.Builder
+		placeholders = map[string]string{}
+		cnt          = map[string]int{}
+		pos          int
+	)
+	for _, m := range matches {
+		v := text[m.start:m.end]
+		k := m.kind + "\x00" + v
+		placeholder, ok := placeholders[k]
+		if !ok {
+			cnt[m.kind]++
+			placeholder = "[" + m.kind + "_" + strconv.Itoa(cnt[m.kind]) + "]"
+			placeholders[k] = placeholder
+			o.values[placeholder] = v
+		}
+		_, _ = buf.WriteString(text[pos:m.start])
+		_, _ = buf.WriteString(placeholder)
+		pos = m.end
+	}
+	_, _ = buf.WriteString(text[pos:])
+
+	o.Text = buf.String()
+	return o
+}
+
+// Redaction defines the redacted text and the values replaced with the placeholders.
+type Redaction struct {
+	Text string
+	// values maps the placeholders to the redacted values.
+	values map[string]string
+}
+
+// IsRedacted reports whether the text contains the placeholders.
+func (r Redaction) IsRedacted() bool {
+	return len(r.values) > 0
+}
+
+// Restore replaces the placeholders in the text with the redacted values.
+// Secrets are not restored to keep them out of the generated diagram.
+func (r Redaction) Restore(text string) string {
+	if len(r.values) == 0 || !strings.Contains(text, "[") {
+		return text
+	}
+	var pairs []string
+	for placeholder, v := range r.values {
+		if strings.HasPrefix(placeholder, "["+RedactionKindSecret+"_") {
+			continue
+		}
+		pairs = append(pairs, placeholder, v)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
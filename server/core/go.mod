@@ -5,4 +5,5 @@ go 1.19
 require (
 	github.com/google/uuid v1.3.0
 	golang.org/x/text v0.8.0
+	gopkg.in/yaml.v3 v3.0.1
 )
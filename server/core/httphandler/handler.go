@@ -2,15 +2,26 @@ package httphandler
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
-	"strings"
 
 	"github.com/kislerdm/diagramastext/server/core/ciam"
 	"github.com/kislerdm/diagramastext/server/core/diagram"
 )
 
+// Routes of the diagrams' handlers.
+const (
+	RouteGenerateC4          = "/generate/c4"
+	RouteGenerateC4FromInfra = "/generate/c4/from-infra"
+	RouteDiffC4              = "/diff/c4"
+	RouteExplainC4           = "/explain/c4"
+)
+
+// NewHandler initialises the http handler. The diagrams' handlers are mapped to the routes matched exactly.
+// All diagrams' routes consume the requests quota: the diff renders the diagram,
+// and the explanation is written by the model upon request.
 func NewHandler(
 	ciamHandler ciam.HTTPHandlerFn, corsHeaders map[string]string, diagramHandlers map[string]diagram.HTTPHandler,
 ) http.Handler {
@@ -44,23 +55,17 @@ func (h handlerDiagrams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	const prefix = "/generate"
-
-	t := strings.TrimPrefix(r.URL.Path, prefix)
-
-	handler, ok := h.diagramHandlers[t]
+	handler, ok := h.diagramHandlers[r.URL.Path]
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		_, _ = w.Write([]byte(`{"error":"` + r.URL.Path + ` not found"}`))
 		return
 	}
 
-	var requestContract struct {
-		Prompt string `json:"prompt"`
-	}
+	requestContract := newDiagramRequest(r.URL.Path)
 
 	defer func() { _ = r.Body.Close() }()
-	if err := json.NewDecoder(r.Body).Decode(&requestContract); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(requestContract); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte(`{"error":"wrong request format"}`))
 		h.log.Println(err)
@@ -74,7 +79,7 @@ func (h handlerDiagrams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	input, err := diagram.NewInput(requestContract.Prompt, user.ID, user.APIToken, user.Role.Quotas().PromptLengthMax)
+	input, err := requestContract.input(user)
 	if err != nil {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		_, _ = w.Write([]byte(`{"error":"wrong request format"}`))
@@ -83,6 +88,19 @@ func (h handlerDiagrams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	o, err := handler(r.Context(), input)
+	var errGuard diagram.GuardError
+	if errors.As(err, &errGuard) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"error":"` + errGuard.Error() + `"}`))
+		return
+	}
+	var errInput diagram.InputError
+	if errors.As(err, &errInput) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		errBytes, _ := json.Marshal(map[string]string{"error": errInput.Error()})
+		_, _ = w.Write(errBytes)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(`{"error":"internal error"}`))
@@ -103,6 +121,103 @@ func (h handlerDiagrams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// diagramRequest defines the contract of the diagram's route request.
+type diagramRequest interface {
+	// input initialises the diagram handler's input of the user's request.
+	input(user *ciam.User) (diagram.Input, error)
+}
+
+// newDiagramRequest initialises the request's contract of the route, the diagram is generated from the prompt
+// unless the route defines its contract.
+func newDiagramRequest(route string) diagramRequest {
+	switch route {
+	case RouteGenerateC4FromInfra:
+		return &infraRequest{}
+	case RouteDiffC4:
+		return &diffRequest{}
+	case RouteExplainC4:
+		return &explanationRequest{}
+	default:
+		return &generateRequest{}
+	}
+}
+
+// generateRequest defines the request to generate the diagram from the prompt.
+type generateRequest struct {
+	Prompt string         `json:"prompt"`
+	Layout diagram.Layout `json:"layout"`
+	// OutputLanguage defines the language of the diagram's labels, the prompt's language is used by default.
+	OutputLanguage diagram.Language `json:"output_language"`
+}
+
+func (v generateRequest) input(user *ciam.User) (diagram.Input, error) {
+	input, err := diagram.NewInput(v.Prompt, user.ID, user.APIToken, user.Role.Quotas().PromptLengthMax)
+	if err != nil {
+		return nil, err
+	}
+	return validInput(diagram.GenerateInput{Input: input, Layout: v.Layout, OutputLanguage: v.OutputLanguage})
+}
+
+// infraRequest defines the request to generate the diagram from the infrastructure code.
+type infraRequest struct {
+	Infra  diagram.Infra  `json:"infra"`
+	Layout diagram.Layout `json:"layout"`
+	// OutputLanguage defines the language of the labels and descriptions written by the model.
+	OutputLanguage diagram.Language `json:"output_language"`
+}
+
+func (v infraRequest) input(user *ciam.User) (diagram.Input, error) {
+	return validInput(
+		diagram.InfraInput{
+			Input: diagram.NewInputWithoutPrompt(user.ID, user.APIToken), Infra: v.Infra, Layout: v.Layout,
+			OutputLanguage: v.OutputLanguage,
+		},
+	)
+}
+
+// diffRequest defines the request to compare two versions of the diagram's graph.
+type diffRequest struct {
+	// Before and After define the versions of the graph to compare.
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+	Layout diagram.Layout  `json:"layout"`
+}
+
+func (v diffRequest) input(user *ciam.User) (diagram.Input, error) {
+	return validInput(
+		diagram.DiffInput{
+			Input: diagram.NewInputWithoutPrompt(user.ID, user.APIToken),
+			Diff:  diagram.Diff{Before: v.Before, After: v.After}, Layout: v.Layout,
+		},
+	)
+}
+
+// explanationRequest defines the request to explain and review the diagram's graph.
+type explanationRequest struct {
+	Graph json.RawMessage `json:"graph"`
+	// Narrative defines whether the prose description of the diagram is requested.
+	Narrative bool `json:"narrative"`
+	// OutputLanguage defines the language of the prose description.
+	OutputLanguage diagram.Language `json:"output_language"`
+}
+
+func (v explanationRequest) input(user *ciam.User) (diagram.Input, error) {
+	return validInput(
+		diagram.ExplanationInput{
+			Input:          diagram.NewInputWithoutPrompt(user.ID, user.APIToken),
+			Explanation:    diagram.Explanation{Graph: v.Graph, Narrative: v.Narrative},
+			OutputLanguage: v.OutputLanguage,
+		},
+	)
+}
+
+func validInput(input diagram.Input) (diagram.Input, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
 type handlerCORS struct {
 	headersMap map[string]string
 	next       http.Handler
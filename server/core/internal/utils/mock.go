@@ -9,6 +9,9 @@ type MockWriter struct {
 }
 
 func (m *MockWriter) Header() http.Header {
+	if m.Headers == nil {
+		m.Headers = http.Header{}
+	}
 	return m.Headers
 }
 
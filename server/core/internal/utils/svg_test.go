@@ -41,6 +41,52 @@ func TestValidateSVG(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "happy path: utf-8 labels",
+			args: args{
+				v: []byte(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<svg xmlns="http://www.w3.org/2000/svg" height="179px" viewBox="0 0 375 179" width="375px">
+<defs></defs>
+<g>
+	<g id="elem_0">
+		<rect fill="#438DD5" height="52.5938" rx="2.5" ry="2.5" width="125" x="7" y="11.8301"></rect>
+		<text fill="#FFFFFF" font-size="16" x="17" y="36.6816">Größenänderung</text>
+		<text fill="#FFFFFF" font-size="12" x="17" y="51.5938">¿Lee de la base de datos?</text>
+	</g>
+	<g id="elem_1">
+		<text fill="#FFFFFF" font-size="16" x="261" y="46.1816">データベース</text>
+	</g>
+</g>
+</svg>`),
+			},
+			wantErr: false,
+		},
+		{
+			name: "happy path: utf-8 labels as character references in us-ascii document",
+			args: args{
+				v: []byte(`<?xml version="1.0" encoding="us-ascii" standalone="no"?>
+<svg xmlns="http://www.w3.org/2000/svg" height="179px" viewBox="0 0 375 179" width="375px">
+<defs></defs>
+<g>
+	<g id="elem_0">
+		<text fill="#FFFFFF" font-size="16" x="17" y="36.6816">&#12487;&#12540;&#12479;&#12505;&#12540;&#12473;</text>
+		<text fill="#FFFFFF" font-size="12" x="17" y="51.5938">Gr&#246;&#223;e</text>
+	</g>
+</g>
+</svg>`),
+			},
+			wantErr: false,
+		},
+		{
+			name: "unhappy path: invalid utf-8 sequence in the label",
+			args: args{
+				v: []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>" +
+					`<svg xmlns="http://www.w3.org/2000/svg" height="179px" viewBox="0 0 375 179" width="375px">` +
+					`<defs></defs><g><g id="elem_0"><text font-size="16" x="17" y="36.6816">` +
+					"\xe3\x83\x87\xe3" + `</text></g></g></svg>`),
+			},
+			wantErr: true,
+		},
 		{
 			name: "unhappy path: corrupt encoding",
 			args: args{
@@ -17,10 +17,13 @@ type mockDbClient struct {
 	query string
 	tx    pgx.Tx
 	v     pgx.Rows
+	// queries records all executed queries.
+	queries []string
 }
 
 func (m *mockDbClient) Query(_ context.Context, query string, _ ...any) (pgx.Rows, error) {
 	m.query = query
+	m.queries = append(m.queries, query)
 	if m.err != nil {
 		return nil, m.err
 	}
@@ -33,6 +36,7 @@ func (m *mockDbClient) Close(_ context.Context) error {
 
 func (m *mockDbClient) Exec(_ context.Context, query string, _ ...any) (pgconn.CommandTag, error) {
 	m.query = query
+	m.queries = append(m.queries, query)
 	if m.err != nil {
 		return pgconn.CommandTag{}, m.err
 	}
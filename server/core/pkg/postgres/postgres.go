@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,17 +13,22 @@ import (
 
 // Config configuration of the postgres Client.
 type Config struct {
-	DBHost             string `json:"db_host"`
-	DBName             string `json:"db_name"`
-	DBUser             string `json:"db_user"`
-	DBPassword         string `json:"db_password"`
-	TablePrompt        string `json:"table_prompt,omitempty"`
-	TablePrediction    string `json:"table_prediction,omitempty"`
-	TableSuccessStatus string `json:"table_success_status,omitempty"`
-	TableUsers         string `json:"table_users,omitempty"`
-	TableTokens        string `json:"table_tokens,omitempty"`
-	TableOneTimeSecret string `json:"table_one_time_secret,omitempty"`
-	SSLMode            string `json:"ssl_mode"`
+	DBHost                 string `json:"db_host"`
+	DBName                 string `json:"db_name"`
+	DBUser                 string `json:"db_user"`
+	DBPassword             string `json:"db_password"`
+	TablePrompt            string `json:"table_prompt,omitempty"`
+	TablePrediction        string `json:"table_prediction,omitempty"`
+	TableSuccessStatus     string `json:"table_success_status,omitempty"`
+	TableUsers             string `json:"table_users,omitempty"`
+	TableTokens            string `json:"table_tokens,omitempty"`
+	TableOneTimeSecret     string `json:"table_one_time_secret,omitempty"`
+	TableRateLimitCounters string `json:"table_rate_limit_counters,omitempty"`
+	TableRefreshTokens     string `json:"table_refresh_tokens,omitempty"`
+	TableOIDCStates        string `json:"table_oidc_states,omitempty"`
+	TableAuditLog          string `json:"table_audit_log,omitempty"`
+	TableFlaggedPrompts    string `json:"table_flagged_prompts,omitempty"`
+	SSLMode                string `json:"ssl_mode"`
 }
 
 func (cfg Config) Validate() error {
@@ -53,6 +59,21 @@ func (cfg Config) Validate() error {
 	if cfg.TableOneTimeSecret == "" {
 		return errors.New("table_one_time_secret must be provided")
 	}
+	if cfg.TableRateLimitCounters == "" {
+		return errors.New("table_rate_limit_counters must be provided")
+	}
+	if cfg.TableRefreshTokens == "" {
+		return errors.New("table_refresh_tokens must be provided")
+	}
+	if cfg.TableOIDCStates == "" {
+		return errors.New("table_oidc_states must be provided")
+	}
+	if cfg.TableAuditLog == "" {
+		return errors.New("table_audit_log must be provided")
+	}
+	if cfg.TableFlaggedPrompts == "" {
+		return errors.New("table_flagged_prompts must be provided")
+	}
 	return validateSSLMode(cfg.SSLMode)
 }
 
@@ -108,6 +129,11 @@ func NewPostgresClient(ctx context.Context, cfg Config) (
 		tableUsers:                cfg.TableUsers,
 		tableTokens:               cfg.TableTokens,
 		tableOneTimeSecret:        cfg.TableOneTimeSecret,
+		tableRateLimitCounters:    cfg.TableRateLimitCounters,
+		tableRefreshTokens:        cfg.TableRefreshTokens,
+		tableOIDCStates:           cfg.TableOIDCStates,
+		tableAuditLog:             cfg.TableAuditLog,
+		tableFlaggedPrompts:       cfg.TableFlaggedPrompts,
 	}, nil
 }
 
@@ -119,26 +145,87 @@ type Client struct {
 	tableUsers                string
 	tableTokens               string
 	tableOneTimeSecret        string
+	tableRateLimitCounters    string
+	tableRefreshTokens        string
+	tableOIDCStates           string
+	tableAuditLog             string
+	tableFlaggedPrompts       string
+}
+
+// IncrementRateLimitCounter increments the counter, and deletes the key's expired counters.
+// The counters of the keys which are no longer incremented are deleted by the retention purge.
+func (c Client) IncrementRateLimitCounter(ctx context.Context, key string, windowStart, expiresAt time.Time) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	_, err := c.c.Exec(
+		ctx, "WITH expired AS (DELETE FROM "+c.tableRateLimitCounters+" WHERE key = $1 AND expires_at < NOW())"+
+			" INSERT INTO "+c.tableRateLimitCounters+" (key, window_start, counter, expires_at) VALUES ($1, $2, 1, $3)"+
+			" ON CONFLICT (key, window_start) DO UPDATE SET counter = "+c.tableRateLimitCounters+".counter + 1",
+		key, windowStart, expiresAt,
+	)
+	return err
 }
 
-func (c Client) GetDailySuccessfulResultsTimestampsByUserID(ctx context.Context, userID string) ([]time.Time, error) {
+// IncrementRateLimitCounterIfBelow increments the counter given its value is below max.
+// The conflicting row is locked by the upsert, hence the check and the increment are atomic.
+func (c Client) IncrementRateLimitCounterIfBelow(
+	ctx context.Context, key string, windowStart, expiresAt time.Time, max uint32,
+) (bool, error) {
+	if key == "" {
+		return false, errors.New("key is required")
+	}
+	if max == 0 {
+		return false, nil
+	}
 	rows, err := c.c.Query(
-		ctx, `SELECT timestamp FROM `+c.tableWriteSuccessFlag+
-			` WHERE timestamp::date = current_date AND user_id = $1`, userID,
+		ctx, "WITH expired AS (DELETE FROM "+c.tableRateLimitCounters+" WHERE key = $1 AND expires_at < NOW())"+
+			" INSERT INTO "+c.tableRateLimitCounters+" (key, window_start, counter, expires_at) VALUES ($1, $2, 1, $3)"+
+			" ON CONFLICT (key, window_start) DO UPDATE SET counter = "+c.tableRateLimitCounters+".counter + 1"+
+			" WHERE "+c.tableRateLimitCounters+".counter < $4 RETURNING counter",
+		key, windowStart, expiresAt, int64(max),
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	// the row is returned only if it was inserted, or updated
+	incremented := rows.Next()
+	return incremented, rows.Err()
+}
+
+func (c Client) ReadRateLimitCounters(ctx context.Context, key string, windowStarts ...time.Time) (
+	[]uint32, error,
+) {
+	if key == "" {
+		return nil, errors.New("key is required")
+	}
+	rows, err := c.c.Query(
+		ctx, "SELECT window_start, counter FROM "+c.tableRateLimitCounters+
+			" WHERE key = $1 AND window_start = ANY($2)", key, windowStarts,
 	)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var o []time.Time
-	var ts time.Time
+	counters := map[time.Time]uint32{}
+	var (
+		ts  time.Time
+		cnt int
+	)
 	for rows.Next() {
-		if err := rows.Scan(&ts); err != nil {
+		if err := rows.Scan(&ts, &cnt); err != nil {
 			return nil, err
 		}
-		o = append(o, ts)
+		counters[ts.UTC()] = uint32(cnt)
+	}
+
+	o := make([]uint32, len(windowStarts))
+	for i, el := range windowStarts {
+		o[i] = counters[el.UTC()]
 	}
-	rows.Close()
 	return o, nil
 }
 
@@ -147,7 +234,7 @@ func (c Client) GetActiveUserIDByActiveTokenID(ctx context.Context, id string) (
 		ctx, `SELECT u.user_id 
 FROM `+c.tableUsers+` AS u 
 INNER JOIN `+c.tableTokens+` AS t USING (user_id) 
-WHERE t.token = $1 AND t.is_active AND u.is_active`, id,
+WHERE t.token = $1 AND t.is_active AND u.is_active AND NOT u.is_blocked`, id,
 	)
 	if err != nil {
 		return "", err
@@ -183,6 +270,29 @@ func (c Client) WriteInputPrompt(ctx context.Context, requestID, userID, prompt
 	return err
 }
 
+func (c Client) WriteFlaggedPrompt(
+	ctx context.Context, requestID, userID, prompt, reason, detector, policy string,
+) error {
+	if requestID == "" {
+		return errors.New("request_id is required")
+	}
+	if reason == "" {
+		return errors.New("reason is required")
+	}
+	_, err := c.c.Exec(
+		ctx, `INSERT INTO `+c.tableFlaggedPrompts+
+			` (request_id, user_id, prompt, reason, detector, policy, timestamp) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		requestID,
+		userID,
+		prompt,
+		reason,
+		detector,
+		policy,
+		time.Now().UTC(),
+	)
+	return err
+}
+
 func (c Client) WriteModelResult(
 	ctx context.Context, requestID, userID, predictionRaw, prediction, model string,
 	usageTokensPrompt, usageTokensCompletions uint16,
@@ -300,7 +410,7 @@ func (c Client) CreateUser(ctx context.Context, id, email, fingerprint string, i
 }
 
 func (c Client) ReadUser(ctx context.Context, id string) (
-	found, isActive bool, role uint8, email, fingerprint string, err error,
+	found, isActive, isBlocked bool, role uint8, email, fingerprint string, err error,
 ) {
 	if id == "" {
 		err = errors.New("id is required")
@@ -309,6 +419,7 @@ func (c Client) ReadUser(ctx context.Context, id string) (
 	rows, err := c.c.Query(
 		ctx, `SELECT 
 	is_active
+	,is_blocked
 	,email
     ,role
 	,web_fingerprint
@@ -319,8 +430,8 @@ FROM `+c.tableUsers+` WHERE user_id = $1`, id,
 	}
 	if rows.Next() {
 		var r int
-		if err := rows.Scan(&isActive, &email, &r, &fingerprint); err != nil {
-			return false, false, 0, "", "", err
+		if err := rows.Scan(&isActive, &isBlocked, &email, &r, &fingerprint); err != nil {
+			return false, false, false, 0, "", "", err
 		}
 		rows.Close()
 
@@ -328,7 +439,7 @@ FROM `+c.tableUsers+` WHERE user_id = $1`, id,
 		found = true
 		return
 	}
-	return false, false, 0, "", "", nil
+	return false, false, false, 0, "", "", nil
 }
 
 func (c Client) LookupUserByEmail(ctx context.Context, email string) (id string, isActive bool, err error) {
@@ -365,7 +476,9 @@ func (c Client) LookupUserByFingerprint(ctx context.Context, fingerprint string)
 			// The last registered user with the given fingerprint will be selected
 			// FIXME: shall this behaviour be sustained?
 			// FIXME: consider alternatives to ORDER BY for the sake of performance
-			` WHERE web_fingerprint = $1 ORDER BY created_at LIMIT 1`, fingerprint,
+			// Only the anonymous user is matched: the fingerprint is not a secret,
+			// hence it must not grant access to the registered user's account.
+			` WHERE web_fingerprint = $1 AND role = 0 ORDER BY created_at LIMIT 1`, fingerprint,
 	)
 	if err != nil {
 		return
@@ -387,6 +500,67 @@ func (c Client) UpdateUserSetActive(ctx context.Context, id string) error {
 	return err
 }
 
+func (c Client) UpgradeAnonymUser(ctx context.Context, anonymUserID, userID string) (err error) {
+	if anonymUserID == "" {
+		return errors.New("anonymUserID is required")
+	}
+	if userID == "" {
+		return errors.New("userID is required")
+	}
+	if anonymUserID == userID {
+		return errors.New("user cannot be upgraded to itself")
+	}
+
+	tx, err := c.c.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return
+		}
+		err = tx.Commit(ctx)
+	}()
+
+	for _, stmt := range []struct {
+		query string
+		args  []any
+	}{
+		{"UPDATE " + c.tableWritePrompt + " SET user_id = $1 WHERE user_id = $2", []any{userID, anonymUserID}},
+		{"UPDATE " + c.tableWriteModelPrediction + " SET user_id = $1 WHERE user_id = $2", []any{userID, anonymUserID}},
+		{"UPDATE " + c.tableWriteSuccessFlag + " SET user_id = $1 WHERE user_id = $2", []any{userID, anonymUserID}},
+		{"UPDATE " + c.tableFlaggedPrompts + " SET user_id = $1 WHERE user_id = $2", []any{userID, anonymUserID}},
+		{
+			// the quotas consumed by the anonymous user are accounted for the registered user
+			"WITH moved AS (DELETE FROM " + c.tableRateLimitCounters + " WHERE " + rateLimitCountersOfUser("$2") +
+				" RETURNING key, window_start, counter, expires_at)" +
+				" INSERT INTO " + c.tableRateLimitCounters + " (key, window_start, counter, expires_at)" +
+				" SELECT REPLACE(key, $2, $1), window_start, counter, expires_at FROM moved" +
+				" ON CONFLICT (key, window_start) DO UPDATE SET counter = " + c.tableRateLimitCounters +
+				".counter + EXCLUDED.counter",
+			[]any{userID, anonymUserID},
+		},
+		{
+			"UPDATE " + c.tableUsers + " SET web_fingerprint = NULL, is_active = FALSE, update_at = now()" +
+				" WHERE user_id = $1",
+			[]any{anonymUserID},
+		},
+		{"UPDATE " + c.tableRefreshTokens + " SET is_active = FALSE WHERE user_id = $1", []any{anonymUserID}},
+	} {
+		if _, err = tx.Exec(ctx, stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rateLimitCountersOfUser defines the condition to select the rate limit counters of the user,
+// the counters' keys end with the user ID followed by the window, e.g. "requests:success:{{user_id}}/60".
+func rateLimitCountersOfUser(userID string) string {
+	return "key LIKE '%:' || " + userID + " || '/%'"
+}
+
 func (c Client) WriteOneTimeSecret(ctx context.Context, userID, secret string, createdAt time.Time) error {
 	if userID == "" {
 		return errors.New("userID is required")
@@ -418,13 +592,14 @@ func (c Client) ReadOneTimeSecret(ctx context.Context, userID string) (
 		return
 	}
 
+	defer rows.Close()
+
 	if rows.Next() {
 		if err := rows.Scan(&secret, &issuedAt); err != nil {
 			return false, "", time.Time{}, err
 		}
-		rows.Close()
+		found = true
 	}
-	found = true
 	return
 }
 
@@ -435,3 +610,487 @@ func (c Client) DeleteOneTimeSecret(ctx context.Context, userID string) error {
 	_, err := c.c.Exec(ctx, "DELETE FROM "+c.tableOneTimeSecret+" WHERE user_id = $1", userID)
 	return err
 }
+
+func (c Client) WriteRefreshToken(
+	ctx context.Context, userID, tokenID, familyID string, expiresAt time.Time,
+) error {
+	if userID == "" {
+		return errors.New("userID is required")
+	}
+	if tokenID == "" {
+		return errors.New("tokenID is required")
+	}
+	if familyID == "" {
+		return errors.New("familyID is required")
+	}
+	_, err := c.c.Exec(
+		ctx, "INSERT INTO "+c.tableRefreshTokens+" (token_id, family_id, user_id, expires_at) VALUES ($1, $2, $3, $4)",
+		tokenID, familyID, userID, expiresAt,
+	)
+	return err
+}
+
+func (c Client) DeactivateRefreshToken(ctx context.Context, userID, tokenID string) (
+	familyID string, found bool, err error,
+) {
+	if userID == "" {
+		return "", false, errors.New("userID is required")
+	}
+	if tokenID == "" {
+		return "", false, errors.New("tokenID is required")
+	}
+
+	rows, err := c.c.Query(
+		ctx, "UPDATE "+c.tableRefreshTokens+" SET is_active = FALSE"+
+			" WHERE token_id = $1 AND user_id = $2 AND is_active AND expires_at > now() RETURNING family_id",
+		tokenID, userID,
+	)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&familyID); err != nil {
+			return "", false, err
+		}
+		found = true
+	}
+	return familyID, found, nil
+}
+
+func (c Client) RevokeRefreshTokenFamily(ctx context.Context, userID, tokenID string) error {
+	if userID == "" {
+		return errors.New("userID is required")
+	}
+	if tokenID == "" {
+		return errors.New("tokenID is required")
+	}
+	_, err := c.c.Exec(
+		ctx, "UPDATE "+c.tableRefreshTokens+" SET is_active = FALSE WHERE is_active AND family_id = "+
+			"(SELECT family_id FROM "+c.tableRefreshTokens+" WHERE token_id = $1 AND user_id = $2)",
+		tokenID, userID,
+	)
+	return err
+}
+
+func (c Client) RevokeUserRefreshTokens(ctx context.Context, userID string) error {
+	if userID == "" {
+		return errors.New("userID is required")
+	}
+	_, err := c.c.Exec(
+		ctx, "UPDATE "+c.tableRefreshTokens+" SET is_active = FALSE WHERE user_id = $1 AND is_active", userID,
+	)
+	return err
+}
+
+func (c Client) WriteOIDCState(
+	ctx context.Context, state, provider, nonce, codeVerifier string, expiresAt time.Time,
+) error {
+	if state == "" {
+		return errors.New("state is required")
+	}
+	if provider == "" {
+		return errors.New("provider is required")
+	}
+	_, err := c.c.Exec(
+		ctx, "INSERT INTO "+c.tableOIDCStates+" (state, provider, nonce, code_verifier, expires_at)"+
+			" VALUES ($1, $2, $3, $4, $5)",
+		state, provider, nonce, codeVerifier, expiresAt,
+	)
+	return err
+}
+
+func (c Client) ConsumeOIDCState(ctx context.Context, state string) (
+	found bool, provider, nonce, codeVerifier string, expiresAt time.Time, err error,
+) {
+	if state == "" {
+		return false, "", "", "", time.Time{}, errors.New("state is required")
+	}
+
+	rows, err := c.c.Query(
+		ctx, "DELETE FROM "+c.tableOIDCStates+" WHERE state = $1 RETURNING provider, nonce, code_verifier, expires_at",
+		state,
+	)
+	if err != nil {
+		return false, "", "", "", time.Time{}, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&provider, &nonce, &codeVerifier, &expiresAt); err != nil {
+			return false, "", "", "", time.Time{}, err
+		}
+		found = true
+	}
+	return found, provider, nonce, codeVerifier, expiresAt, nil
+}
+
+// UserRecord defines the user's data read by admins.
+// It's the unnamed struct's alias to satisfy the CIAM admin repository interface.
+type UserRecord = struct {
+	ID          string
+	Email       string
+	Fingerprint string
+	Role        uint8
+	IsActive    bool
+	IsBlocked   bool
+	IsPremium   bool
+	CreatedAt   time.Time
+}
+
+// RequestRecord defines the user's request to generate a diagram.
+// It's the unnamed struct's alias to satisfy the CIAM admin repository interface.
+type RequestRecord = struct {
+	RequestID string
+	Prompt    string
+	IsSuccess bool
+	Timestamp time.Time
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (c Client) SearchUsers(ctx context.Context, email, fingerprint string, limit uint16) ([]UserRecord, error) {
+	if email == "" && fingerprint == "" {
+		return nil, errors.New("email or fingerprint is required")
+	}
+
+	var (
+		conditions []string
+		args       []any
+	)
+	if email != "" {
+		args = append(args, "%"+escapeLike(email)+"%")
+		conditions = append(conditions, "email ILIKE $"+strconv.Itoa(len(args)))
+	}
+	if fingerprint != "" {
+		args = append(args, fingerprint)
+		conditions = append(conditions, "web_fingerprint = $"+strconv.Itoa(len(args)))
+	}
+	args = append(args, int(limit))
+
+	rows, err := c.c.Query(
+		ctx, "SELECT user_id, COALESCE(email, ''), COALESCE(web_fingerprint, ''), role, is_active, is_blocked"+
+			", is_premium, created_at FROM "+c.tableUsers+" WHERE "+strings.Join(conditions, " OR ")+
+			" ORDER BY created_at DESC LIMIT $"+strconv.Itoa(len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var o []UserRecord
+	for rows.Next() {
+		var (
+			u    UserRecord
+			role int
+		)
+		if err := rows.Scan(
+			&u.ID, &u.Email, &u.Fingerprint, &role, &u.IsActive, &u.IsBlocked, &u.IsPremium, &u.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		u.Role = uint8(role)
+		o = append(o, u)
+	}
+	return o, rows.Err()
+}
+
+// updateUser executes the UPDATE statement returning the updated user_id to tell if the user was found.
+func (c Client) updateUser(ctx context.Context, userID, set string, v any) (found bool, err error) {
+	if userID == "" {
+		return false, errors.New("userID is required")
+	}
+	rows, err := c.c.Query(
+		ctx, "UPDATE "+c.tableUsers+" SET "+set+" = $1, update_at = now() WHERE user_id = $2 RETURNING user_id",
+		v, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+func (c Client) UpdateUserBlocked(ctx context.Context, userID string, isBlocked bool) (found bool, err error) {
+	return c.updateUser(ctx, userID, "is_blocked", isBlocked)
+}
+
+func (c Client) UpdateUserRole(ctx context.Context, userID string, role uint8) (found bool, err error) {
+	return c.updateUser(ctx, userID, "role", int(role))
+}
+
+func (c Client) UpdateUserPlan(ctx context.Context, userID string, isPremium bool) (found bool, err error) {
+	return c.updateUser(ctx, userID, "is_premium", isPremium)
+}
+
+func (c Client) RevokeUserAPITokens(ctx context.Context, userID string) (revoked uint32, err error) {
+	if userID == "" {
+		return 0, errors.New("userID is required")
+	}
+	rows, err := c.c.Query(
+		ctx, "UPDATE "+c.tableTokens+" SET is_active = FALSE, updated_at = now() WHERE user_id = $1 AND is_active"+
+			" RETURNING token",
+		userID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, rows.Err()
+}
+
+func (c Client) ReadUserRequests(ctx context.Context, userID string, limit uint16) ([]RequestRecord, error) {
+	if userID == "" {
+		return nil, errors.New("userID is required")
+	}
+	rows, err := c.c.Query(
+		ctx, "SELECT p.request_id, p.prompt, s.request_id IS NOT NULL, p.timestamp FROM "+c.tableWritePrompt+
+			" AS p LEFT JOIN "+c.tableWriteSuccessFlag+" AS s ON p.request_id = s.request_id"+
+			" WHERE p.user_id = $1 ORDER BY p.timestamp DESC LIMIT $2",
+		userID, int(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var o []RequestRecord
+	for rows.Next() {
+		var v RequestRecord
+		if err := rows.Scan(&v.RequestID, &v.Prompt, &v.IsSuccess, &v.Timestamp); err != nil {
+			return nil, err
+		}
+		o = append(o, v)
+	}
+	return o, rows.Err()
+}
+
+func (c Client) WriteAuditLog(ctx context.Context, adminID, action, userID, details string) error {
+	if adminID == "" {
+		return errors.New("adminID is required")
+	}
+	if action == "" {
+		return errors.New("action is required")
+	}
+	_, err := c.c.Exec(
+		ctx, "INSERT INTO "+c.tableAuditLog+" (admin_id, action, user_id, details) VALUES ($1, $2, $3, $4)",
+		adminID, action, nullIfEmpty(userID), nullIfEmpty(details),
+	)
+	return err
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// jsonAggregate defines the sub-query which aggregates the user's rows of the table to JSON array.
+func jsonAggregate(table, orderBy string, columns ...string) string {
+	fields := make([]string, len(columns))
+	for i, col := range columns {
+		fields[i] = "'" + col + "', " + col
+	}
+	return "COALESCE((SELECT json_agg(json_build_object(" + strings.Join(fields, ", ") + ") ORDER BY " + orderBy +
+		") FROM " + table + " WHERE user_id = u.user_id), '[]')"
+}
+
+// ExportUserData reads the user's data in a single statement, hence from the consistent snapshot.
+// Note that the secrets, i.e. one-time secrets and refresh tokens, are not exported.
+// The admins' actions on the user are exported without the admins' identities.
+func (c Client) ExportUserData(ctx context.Context, userID string) (found bool, data []byte, err error) {
+	if userID == "" {
+		return false, nil, errors.New("userID is required")
+	}
+
+	sections := []string{
+		"'user', json_build_object('user_id', u.user_id, 'email', u.email, 'web_fingerprint', u.web_fingerprint" +
+			", 'role', u.role, 'is_active', u.is_active, 'is_blocked', u.is_blocked, 'is_premium', u.is_premium" +
+			", 'created_at', u.created_at" +
+			", 'updated_at', u.update_at)",
+		"'prompts', " + jsonAggregate(c.tableWritePrompt, "timestamp", "request_id", "prompt", "timestamp"),
+		"'model_predictions', " + jsonAggregate(
+			c.tableWriteModelPrediction, "timestamp",
+			"request_id", "response_raw", "response", "prompt_tokens", "completion_tokens", "model_id", "timestamp",
+		),
+		"'successful_requests', " + jsonAggregate(
+			c.tableWriteSuccessFlag, "timestamp", "request_id", "token", "timestamp",
+		),
+		"'api_tokens', " + jsonAggregate(c.tableTokens, "created_at", "token", "is_active", "created_at", "updated_at"),
+		"'sessions', " + jsonAggregate(c.tableRefreshTokens, "created_at", "is_active", "created_at", "expires_at"),
+		"'flagged_prompts', " + jsonAggregate(
+			c.tableFlaggedPrompts, "timestamp", "request_id", "prompt", "reason", "timestamp",
+		),
+		"'admin_actions', " + jsonAggregate(c.tableAuditLog, "created_at", "action", "details", "created_at"),
+	}
+
+	rows, err := c.c.Query(
+		ctx, "SELECT json_build_object("+strings.Join(sections, ", ")+")::TEXT FROM "+c.tableUsers+
+			" AS u WHERE u.user_id = $1",
+		userID,
+	)
+	if err != nil {
+		return false, nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return false, nil, err
+		}
+		return true, []byte(v), nil
+	}
+	return false, nil, rows.Err()
+}
+
+// DeleteUser deletes the user's data and anonymizes the user's record.
+// The admin audit log is preserved. The OIDC states are not deleted because they are not linked to users,
+// they expire within minutes and are purged by the retention job.
+func (c Client) DeleteUser(ctx context.Context, userID string) (err error) {
+	if userID == "" {
+		return errors.New("userID is required")
+	}
+
+	tx, err := c.c.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return
+		}
+		err = tx.Commit(ctx)
+	}()
+
+	// the order of statements follows the foreign keys' dependencies
+	for _, table := range []string{
+		c.tableWriteSuccessFlag,
+		c.tableWriteModelPrediction,
+		c.tableWritePrompt,
+		c.tableTokens,
+		c.tableOneTimeSecret,
+		c.tableRefreshTokens,
+		c.tableFlaggedPrompts,
+	} {
+		if _, err = tx.Exec(ctx, "DELETE FROM "+table+" WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(
+		ctx, "DELETE FROM "+c.tableRateLimitCounters+" WHERE "+rateLimitCountersOfUser("$1"), userID,
+	); err != nil {
+		return err
+	}
+
+	// the user's record is anonymized, instead of deleted to preserve referential integrity of the audit log
+	_, err = tx.Exec(
+		ctx, "UPDATE "+c.tableUsers+" SET email = NULL, web_fingerprint = NULL, is_active = FALSE"+
+			", is_premium = FALSE, update_at = now() WHERE user_id = $1",
+		userID,
+	)
+	return err
+}
+
+// retentionPlanCondition returns the condition to filter the users on the plan defined by the retention policy.
+func retentionPlanCondition(plan string) (string, error) {
+	switch plan {
+	case "":
+		return "", nil
+	case "anonym":
+		return " AND u.role = 0", nil
+	case "free":
+		return " AND u.role <> 0 AND NOT u.is_premium", nil
+	case "premium":
+		return " AND u.is_premium", nil
+	default:
+		return "", errors.New("unknown plan " + plan)
+	}
+}
+
+// PurgeBatch purges up to batchSize rows of the target's data created, or expired before the given time
+// by the users on the plan. It returns the number of purged rows.
+// The rate limit counters and OIDC states are not linked to the users, hence they cannot be purged per plan.
+func (c Client) PurgeBatch(ctx context.Context, target, plan string, before time.Time, batchSize uint32) (
+	int64, error,
+) {
+	if batchSize == 0 {
+		return 0, errors.New("batch size must be positive")
+	}
+
+	cond, err := retentionPlanCondition(plan)
+	if err != nil {
+		return 0, err
+	}
+
+	selectRows := func(table, keys, column, filter string) string {
+		o := "SELECT " + keys + " FROM " + table + " AS t"
+		if cond != "" {
+			o += " INNER JOIN " + c.tableUsers + " AS u ON t.user_id = u.user_id"
+		}
+		return o + " WHERE t." + column + " < $1" + filter + cond + " LIMIT $2"
+	}
+	selectBatch := func(table, filter string) string {
+		return selectRows(table, "t.request_id", "timestamp", filter)
+	}
+
+	var query string
+	switch target {
+	case "prompts":
+		// the rows referencing the prompts are deleted by the same statement
+		// because the foreign key constraints are checked by the end of the statement
+		query = "WITH batch AS (" + selectBatch(c.tableWritePrompt, "") + ")" +
+			", predictions AS (DELETE FROM " + c.tableWriteModelPrediction +
+			" WHERE request_id IN (SELECT request_id FROM batch))" +
+			", statuses AS (DELETE FROM " + c.tableWriteSuccessFlag +
+			" WHERE request_id IN (SELECT request_id FROM batch))" +
+			" DELETE FROM " + c.tableWritePrompt + " WHERE request_id IN (SELECT request_id FROM batch)"
+	case "model_predictions":
+		query = "DELETE FROM " + c.tableWriteModelPrediction +
+			" WHERE request_id IN (" + selectBatch(c.tableWriteModelPrediction, "") + ")"
+	case "model_predictions_raw":
+		query = "UPDATE " + c.tableWriteModelPrediction + " SET response_raw = ''" +
+			" WHERE request_id IN (" + selectBatch(c.tableWriteModelPrediction, " AND t.response_raw <> ''") + ")"
+	case "successful_requests":
+		query = "DELETE FROM " + c.tableWriteSuccessFlag +
+			" WHERE request_id IN (" + selectBatch(c.tableWriteSuccessFlag, "") + ")"
+	case "flagged_prompts":
+		query = "DELETE FROM " + c.tableFlaggedPrompts +
+			" WHERE request_id IN (" + selectBatch(c.tableFlaggedPrompts, "") + ")"
+	case "refresh_tokens":
+		query = "DELETE FROM " + c.tableRefreshTokens +
+			" WHERE token_id IN (" + selectRows(c.tableRefreshTokens, "t.token_id", "expires_at", "") + ")"
+	case "rate_limit_counters", "oidc_states":
+		if cond != "" {
+			return 0, errors.New("retention of " + target + " cannot be limited to a plan")
+		}
+		table, keys := c.tableRateLimitCounters, "key, window_start"
+		if target == "oidc_states" {
+			table, keys = c.tableOIDCStates, "state"
+		}
+		query = "DELETE FROM " + table + " WHERE (" + keys + ") IN (" +
+			selectRows(table, keys, "expires_at", "") + ")"
+	default:
+		return 0, errors.New("unknown retention target " + target)
+	}
+
+	tag, err := c.c.Exec(ctx, query, before, int64(batchSize))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
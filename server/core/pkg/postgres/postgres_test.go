@@ -13,17 +13,22 @@ import (
 
 func TestConfig_Validate(t *testing.T) {
 	type fields struct {
-		DBHost             string
-		DBName             string
-		DBUser             string
-		DBPassword         string
-		TablePrompt        string
-		TablePrediction    string
-		TableSuccessStatus string
-		TableUsers         string
-		TableTokens        string
-		TableOneTimeSecret string
-		SSLMode            string
+		DBHost                 string
+		DBName                 string
+		DBUser                 string
+		DBPassword             string
+		TablePrompt            string
+		TablePrediction        string
+		TableSuccessStatus     string
+		TableUsers             string
+		TableTokens            string
+		TableOneTimeSecret     string
+		TableRateLimitCounters string
+		TableRefreshTokens     string
+		TableOIDCStates        string
+		TableAuditLog          string
+		TableFlaggedPrompts    string
+		SSLMode                string
 	}
 	tests := []struct {
 		name    string
@@ -33,134 +38,195 @@ func TestConfig_Validate(t *testing.T) {
 		{
 			name: "valid",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "postgres",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "bar",
-				TableSuccessStatus: "qux",
-				TableUsers:         "quxx",
-				TableTokens:        "baz",
-				TableOneTimeSecret: "foobar",
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "quxx",
+				TableTokens:            "baz",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: nil,
 		},
 		{
 			name: "valid: ssl - full verification",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "postgres",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "bar",
-				TableSuccessStatus: "qux",
-				TableUsers:         "quxx",
-				TableTokens:        "baz",
-				TableOneTimeSecret: "foobar",
-				SSLMode:            "verify-full",
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "quxx",
+				TableTokens:            "baz",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
+				SSLMode:                "verify-full",
 			},
 			wantErr: nil,
 		},
 		{
 			name: "invalid: host is missing",
 			fields: fields{
-				DBHost:             "",
-				DBName:             "postgres",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "bar",
-				TableSuccessStatus: "qux",
-				TableUsers:         "quxx",
-				TableTokens:        "baz",
-				TableOneTimeSecret: "foobar",
+				DBHost:                 "",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "quxx",
+				TableTokens:            "baz",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: errors.New("host must be provided"),
 		},
 		{
 			name: "invalid: dbname is missing",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "bar",
-				TableSuccessStatus: "qux",
-				TableUsers:         "quxx",
-				TableTokens:        "baz",
-				TableOneTimeSecret: "foobar",
+				DBHost:                 "localhost",
+				DBName:                 "",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "quxx",
+				TableTokens:            "baz",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: errors.New("dbname must be provided"),
 		},
 		{
 			name: "invalid: user is missing",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "postgres",
-				DBUser:             "",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "bar",
-				TableSuccessStatus: "qux",
-				TableUsers:         "",
-				TableTokens:        "baz",
-				TableOneTimeSecret: "foobar",
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "",
+				TableTokens:            "baz",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: errors.New("user must be provided"),
 		},
 		{
 			name: "invalid: table_prompt is missing",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "postgres",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "",
-				TablePrediction:    "bar",
-				TableSuccessStatus: "qux",
-				TableUsers:         "users",
-				TableTokens:        "tokens",
-				TableOneTimeSecret: "foobar",
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "users",
+				TableTokens:            "tokens",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: errors.New("table_prompt must be provided"),
 		},
 		{
 			name: "invalid: table_prediction is missing",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "postgres",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "",
-				TableSuccessStatus: "qux",
-				TableUsers:         "users",
-				TableTokens:        "tokens",
-				TableOneTimeSecret: "foobar",
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "users",
+				TableTokens:            "tokens",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: errors.New("table_prediction must be provided"),
 		},
 		{
 			name: "invalid: table_success_status is missing",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "postgres",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "bar",
-				TableUsers:         "users",
-				TableTokens:        "tokens",
-				TableSuccessStatus: "",
-				TableOneTimeSecret: "foobar",
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableUsers:             "users",
+				TableTokens:            "tokens",
+				TableSuccessStatus:     "",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: errors.New("table_success_status must be provided"),
 		},
 		{
 			name: "invalid: table_one_time_secret is missing",
+			fields: fields{
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "users",
+				TableTokens:            "tokens",
+				TableOneTimeSecret:     "",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
+			},
+			wantErr: errors.New("table_one_time_secret must be provided"),
+		},
+		{
+			name: "invalid: table_rate_limit_counters is missing",
 			fields: fields{
 				DBHost:             "localhost",
 				DBName:             "postgres",
@@ -171,40 +237,124 @@ func TestConfig_Validate(t *testing.T) {
 				TableSuccessStatus: "qux",
 				TableUsers:         "users",
 				TableTokens:        "tokens",
-				TableOneTimeSecret: "",
+				TableOneTimeSecret: "foobar",
 			},
-			wantErr: errors.New("table_one_time_secret must be provided"),
+			wantErr: errors.New("table_rate_limit_counters must be provided"),
+		},
+		{
+			name: "invalid: table_refresh_tokens is missing",
+			fields: fields{
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "users",
+				TableTokens:            "tokens",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+			},
+			wantErr: errors.New("table_refresh_tokens must be provided"),
+		},
+		{
+			name: "invalid: table_oidc_states is missing",
+			fields: fields{
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "users",
+				TableTokens:            "tokens",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+			},
+			wantErr: errors.New("table_oidc_states must be provided"),
+		},
+		{
+			name: "invalid: table_audit_log is missing",
+			fields: fields{
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "users",
+				TableTokens:            "tokens",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+			},
+			wantErr: errors.New("table_audit_log must be provided"),
+		},
+		{
+			name: "invalid: table_flagged_prompts is missing",
+			fields: fields{
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "users",
+				TableTokens:            "tokens",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+			},
+			wantErr: errors.New("table_flagged_prompts must be provided"),
 		},
 		{
 			name: "invalid: table_tokens is missing",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "postgres",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "bar",
-				TableSuccessStatus: "qux",
-				TableUsers:         "users",
-				TableTokens:        "",
-				TableOneTimeSecret: "quxx",
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				TableSuccessStatus:     "qux",
+				TableUsers:             "users",
+				TableTokens:            "",
+				TableOneTimeSecret:     "quxx",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: errors.New("table_tokens must be provided"),
 		},
 		{
 			name: "invalid: ssl mode is wrong",
 			fields: fields{
-				DBHost:             "localhost",
-				DBName:             "postgres",
-				DBUser:             "postgres",
-				DBPassword:         "postgres",
-				TablePrompt:        "foo",
-				TablePrediction:    "bar",
-				SSLMode:            "qux",
-				TableSuccessStatus: "quxx",
-				TableUsers:         "quxx",
-				TableTokens:        "baz",
-				TableOneTimeSecret: "foobar",
+				DBHost:                 "localhost",
+				DBName:                 "postgres",
+				DBUser:                 "postgres",
+				DBPassword:             "postgres",
+				TablePrompt:            "foo",
+				TablePrediction:        "bar",
+				SSLMode:                "qux",
+				TableSuccessStatus:     "quxx",
+				TableUsers:             "quxx",
+				TableTokens:            "baz",
+				TableOneTimeSecret:     "foobar",
+				TableRateLimitCounters: "rate_limit_counters",
+				TableRefreshTokens:     "refresh_tokens",
+				TableOIDCStates:        "oidc_states",
+				TableAuditLog:          "audit_log",
+				TableFlaggedPrompts:    "flagged_prompts",
 			},
 			wantErr: errors.New("ssl mode qux is not supported"),
 		},
@@ -216,17 +366,22 @@ func TestConfig_Validate(t *testing.T) {
 		t.Run(
 			tt.name, func(t *testing.T) {
 				cfg := Config{
-					DBHost:             tt.fields.DBHost,
-					DBName:             tt.fields.DBName,
-					DBUser:             tt.fields.DBUser,
-					DBPassword:         tt.fields.DBPassword,
-					TablePrompt:        tt.fields.TablePrompt,
-					TablePrediction:    tt.fields.TablePrediction,
-					TableSuccessStatus: tt.fields.TableSuccessStatus,
-					TableUsers:         tt.fields.TableUsers,
-					TableTokens:        tt.fields.TableTokens,
-					TableOneTimeSecret: tt.fields.TableOneTimeSecret,
-					SSLMode:            tt.fields.SSLMode,
+					DBHost:                 tt.fields.DBHost,
+					DBName:                 tt.fields.DBName,
+					DBUser:                 tt.fields.DBUser,
+					DBPassword:             tt.fields.DBPassword,
+					TablePrompt:            tt.fields.TablePrompt,
+					TablePrediction:        tt.fields.TablePrediction,
+					TableSuccessStatus:     tt.fields.TableSuccessStatus,
+					TableUsers:             tt.fields.TableUsers,
+					TableTokens:            tt.fields.TableTokens,
+					TableOneTimeSecret:     tt.fields.TableOneTimeSecret,
+					TableRateLimitCounters: tt.fields.TableRateLimitCounters,
+					TableRefreshTokens:     tt.fields.TableRefreshTokens,
+					TableOIDCStates:        tt.fields.TableOIDCStates,
+					TableAuditLog:          tt.fields.TableAuditLog,
+					TableFlaggedPrompts:    tt.fields.TableFlaggedPrompts,
+					SSLMode:                tt.fields.SSLMode,
 				}
 				err := cfg.Validate()
 				if !reflect.DeepEqual(err, tt.wantErr) {
@@ -253,16 +408,21 @@ func TestNewRepositoryPostgres(t *testing.T) {
 			args: args{
 				ctx: context.TODO(),
 				cfg: Config{
-					DBHost:             "mock",
-					DBName:             "postgres",
-					DBUser:             "postgres",
-					DBPassword:         "foo",
-					TablePrompt:        "bar",
-					TablePrediction:    "baz",
-					TableSuccessStatus: "qux",
-					TableUsers:         "quxx",
-					TableTokens:        "baz",
-					TableOneTimeSecret: "quxxx",
+					DBHost:                 "mock",
+					DBName:                 "postgres",
+					DBUser:                 "postgres",
+					DBPassword:             "foo",
+					TablePrompt:            "bar",
+					TablePrediction:        "baz",
+					TableSuccessStatus:     "qux",
+					TableUsers:             "quxx",
+					TableTokens:            "baz",
+					TableOneTimeSecret:     "quxxx",
+					TableRateLimitCounters: "rate_limit_counters",
+					TableRefreshTokens:     "refresh_tokens",
+					TableOIDCStates:        "oidc_states",
+					TableAuditLog:          "audit_log",
+					TableFlaggedPrompts:    "flagged_prompts",
 				},
 			},
 			want: &Client{
@@ -273,6 +433,11 @@ func TestNewRepositoryPostgres(t *testing.T) {
 				tableUsers:                "quxx",
 				tableTokens:               "baz",
 				tableOneTimeSecret:        "quxxx",
+				tableRateLimitCounters:    "rate_limit_counters",
+				tableRefreshTokens:        "refresh_tokens",
+				tableOIDCStates:           "oidc_states",
+				tableAuditLog:             "audit_log",
+				tableFlaggedPrompts:       "flagged_prompts",
 			},
 			wantErr: false,
 		},
@@ -736,7 +901,7 @@ func TestClient_GetActiveUserIDByActiveTokenID(t *testing.T) {
 			wantExecutedQueryTemplate: `SELECT u.user_id 
 FROM ` + tableUsers + ` AS u 
 INNER JOIN ` + tableTokens + ` AS t USING (user_id) 
-WHERE t.token = $1 AND t.is_active AND u.is_active`,
+WHERE t.token = $1 AND t.is_active AND u.is_active AND NOT u.is_blocked`,
 			wantErr: nil,
 		},
 		{
@@ -753,7 +918,7 @@ WHERE t.token = $1 AND t.is_active AND u.is_active`,
 			wantExecutedQueryTemplate: `SELECT u.user_id 
 FROM ` + tableUsers + ` AS u 
 INNER JOIN ` + tableTokens + ` AS t USING (user_id) 
-WHERE t.token = $1 AND t.is_active AND u.is_active`,
+WHERE t.token = $1 AND t.is_active AND u.is_active AND NOT u.is_blocked`,
 			wantErr: errors.New("foobar"),
 		},
 	}
@@ -852,141 +1017,6 @@ func TestConfig_ConnectionString(t *testing.T) {
 	}
 }
 
-func TestClient_GetDailySuccessfulResultsTimestampsByUserID(t *testing.T) {
-	type fields struct {
-		c                         dbClient
-		tableWritePrompt          string
-		tableWriteModelPrediction string
-		tableWriteSuccessFlag     string
-		tableUsers                string
-		tableTokens               string
-	}
-	type args struct {
-		ctx    context.Context
-		userID string
-	}
-	tests := []struct {
-		name                      string
-		fields                    fields
-		args                      args
-		want                      []time.Time
-		wantErr                   bool
-		wantExecutedQueryTemplate string
-	}{
-		{
-			name: "empty array",
-			fields: fields{
-				c: &mockDbClient{
-					query: "",
-					v: &mockRows{
-						tag: pgconn.NewCommandTag("SELECT"),
-						s:   &sync.RWMutex{},
-						v:   nil,
-					},
-				},
-				tableWriteSuccessFlag: "foo",
-			},
-			args: args{
-				ctx:    context.TODO(),
-				userID: "",
-			},
-			want:                      nil,
-			wantErr:                   false,
-			wantExecutedQueryTemplate: `SELECT timestamp FROM foo WHERE timestamp::date = current_date AND user_id = $1`,
-		},
-		{
-			name: "array with two elements",
-			fields: fields{
-				c: &mockDbClient{
-					query: "",
-					v: &mockRows{
-						tag: pgconn.NewCommandTag("SELECT"),
-						s:   &sync.RWMutex{},
-						v: [][]any{
-							{time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
-							{time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)},
-						},
-					},
-				},
-				tableWriteSuccessFlag: "foo",
-			},
-			args: args{
-				ctx:    context.TODO(),
-				userID: "",
-			},
-			want: []time.Time{
-				time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
-				time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC),
-			},
-			wantErr:                   false,
-			wantExecutedQueryTemplate: `SELECT timestamp FROM foo WHERE timestamp::date = current_date AND user_id = $1`,
-		},
-		{
-			name: "unhappy path",
-			fields: fields{
-				c: &mockDbClient{
-					err: errors.New("foobat"),
-				},
-				tableWriteSuccessFlag: "foo",
-			},
-			args: args{
-				ctx:    context.TODO(),
-				userID: "",
-			},
-			wantErr:                   true,
-			wantExecutedQueryTemplate: `SELECT timestamp FROM foo WHERE timestamp::date = current_date AND user_id = $1`,
-		},
-		{
-			name: "unhappy path: while reading a raw",
-			fields: fields{
-				c: &mockDbClient{
-					query: "",
-					v: &mockRows{
-						tag: pgconn.NewCommandTag("SELECT"),
-						s:   &sync.RWMutex{},
-						err: errors.New("foobar"),
-						v: [][]any{
-							{time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
-						},
-					},
-				},
-				tableWriteSuccessFlag: "foo",
-			},
-			args: args{
-				ctx:    context.TODO(),
-				userID: "",
-			},
-			wantErr:                   true,
-			wantExecutedQueryTemplate: `SELECT timestamp FROM foo WHERE timestamp::date = current_date AND user_id = $1`,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(
-			tt.name, func(t *testing.T) {
-				c := Client{
-					c:                     tt.fields.c,
-					tableWriteSuccessFlag: tt.fields.tableWriteSuccessFlag,
-				}
-				got, err := c.GetDailySuccessfulResultsTimestampsByUserID(tt.args.ctx, tt.args.userID)
-				if (err != nil) != tt.wantErr {
-					t.Errorf("GetDailySuccessfulResultsTimestampsByUserID() error = %v, wantErr %v", err, tt.wantErr)
-					return
-				}
-				if !reflect.DeepEqual(got, tt.want) {
-					t.Errorf("GetDailySuccessfulResultsTimestampsByUserID() got = %v, want %v", got, tt.want)
-				}
-				gotQueryExecuted := c.c.(*mockDbClient).query
-				if gotQueryExecuted != tt.wantExecutedQueryTemplate {
-					t.Errorf(
-						"GetDailySuccessfulResultsTimestampsByUserID() executes wrong query = %s, want = %s",
-						gotQueryExecuted, tt.wantExecutedQueryTemplate,
-					)
-				}
-			},
-		)
-	}
-}
-
 func TestClient_CreateUser(t *testing.T) {
 	type fields struct {
 		c                         dbClient
@@ -1105,6 +1135,7 @@ func TestClient_ReadUser(t *testing.T) {
 		args            args
 		wantFound       bool
 		wantIsActive    bool
+		wantIsBlocked   bool
 		wantRole        uint8
 		wantEmail       string
 		wantFingerprint string
@@ -1119,7 +1150,7 @@ func TestClient_ReadUser(t *testing.T) {
 						tag: pgconn.NewCommandTag("SELECT"),
 						s:   &sync.RWMutex{},
 						v: [][]any{
-							{true, "foo@bar.baz", 1, "qux"},
+							{true, true, "foo@bar.baz", 1, "qux"},
 						},
 					},
 				},
@@ -1131,6 +1162,7 @@ func TestClient_ReadUser(t *testing.T) {
 			},
 			wantFound:       true,
 			wantIsActive:    true,
+			wantIsBlocked:   true,
 			wantRole:        1,
 			wantEmail:       "foo@bar.baz",
 			wantFingerprint: "qux",
@@ -1175,7 +1207,7 @@ func TestClient_ReadUser(t *testing.T) {
 					tableUsers:                tt.fields.tableUsers,
 					tableTokens:               tt.fields.tableTokens,
 				}
-				gotFound, gotIsActive, gotRole, gotEmail, gotFingerprint, err := c.ReadUser(
+				gotFound, gotIsActive, gotIsBlocked, gotRole, gotEmail, gotFingerprint, err := c.ReadUser(
 					tt.args.ctx, tt.args.id,
 				)
 				if (err != nil) != tt.wantErr {
@@ -1188,6 +1220,9 @@ func TestClient_ReadUser(t *testing.T) {
 				if gotIsActive != tt.wantIsActive {
 					t.Errorf("ReadUser() gotIsActive = %v, want %v", gotIsActive, tt.wantIsActive)
 				}
+				if gotIsBlocked != tt.wantIsBlocked {
+					t.Errorf("ReadUser() gotIsBlocked = %v, want %v", gotIsBlocked, tt.wantIsBlocked)
+				}
 				if gotRole != tt.wantRole {
 					t.Errorf("ReadUser() gotRole = %v, want %v", gotRole, tt.wantRole)
 				}
@@ -1368,7 +1403,8 @@ func TestClient_LookupUserByFingerprint(t *testing.T) {
 				ctx:         context.TODO(),
 				fingerprint: "foo",
 			},
-			wantQuery:    "SELECT user_id, is_active FROM users WHERE web_fingerprint = $1 ORDER BY created_at LIMIT 1",
+			wantQuery: "SELECT user_id, is_active FROM users WHERE web_fingerprint = $1 AND role = 0" +
+				" ORDER BY created_at LIMIT 1",
 			wantId:       "ccb42cbf-92c5-4069-bd01-ae25d49d9727",
 			wantIsActive: true,
 			wantErr:      false,
@@ -1388,7 +1424,8 @@ func TestClient_LookupUserByFingerprint(t *testing.T) {
 				ctx:         context.TODO(),
 				fingerprint: "foo",
 			},
-			wantQuery:    "SELECT user_id, is_active FROM users WHERE web_fingerprint = $1 ORDER BY created_at LIMIT 1",
+			wantQuery: "SELECT user_id, is_active FROM users WHERE web_fingerprint = $1 AND role = 0" +
+				" ORDER BY created_at LIMIT 1",
 			wantId:       "",
 			wantIsActive: false,
 			wantErr:      false,
@@ -1662,6 +1699,24 @@ func TestClient_ReadOneTimeSecret(t *testing.T) {
 			wantErr:      false,
 			wantQuery:    "SELECT secret, created_at FROM secret WHERE user_id = $1",
 		},
+		{
+			name: "happy path: secret not found",
+			fields: fields{
+				c: &mockDbClient{
+					v: &mockRows{
+						tag: pgconn.NewCommandTag("SELECT"),
+						s:   &sync.RWMutex{},
+					},
+				},
+				tableOneTimeSecret: "secret",
+			},
+			args: args{
+				ctx:    context.TODO(),
+				userID: "ccb42cbf-92c5-4069-bd01-ae25d49d9727",
+			},
+			wantFound: false,
+			wantQuery: "SELECT secret, created_at FROM secret WHERE user_id = $1",
+		},
 		{
 			name:    "unhappy path: no user ID provided",
 			wantErr: true,
@@ -1771,3 +1826,1242 @@ func TestClient_DeleteOneTimeSecret(t *testing.T) {
 		)
 	}
 }
+
+func TestClient_IncrementRateLimitCounter(t *testing.T) {
+	const wantQuery = "WITH expired AS (DELETE FROM foo WHERE key = $1 AND expires_at < NOW())" +
+		" INSERT INTO foo (key, window_start, counter, expires_at) VALUES ($1, $2, 1, $3)" +
+		" ON CONFLICT (key, window_start) DO UPDATE SET counter = foo.counter + 1"
+
+	tests := []struct {
+		name      string
+		c         dbClient
+		key       string
+		wantErr   bool
+		wantQuery string
+	}{
+		{
+			name:      "happy path",
+			c:         &mockDbClient{},
+			key:       "bar",
+			wantQuery: wantQuery,
+		},
+		{
+			name:    "unhappy path: no key",
+			c:       &mockDbClient{},
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			key:       "bar",
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{
+					c:                      tt.c,
+					tableRateLimitCounters: "foo",
+				}
+				err := c.IncrementRateLimitCounter(context.TODO(), tt.key, time.Now(), time.Now().Add(time.Minute))
+				if (err != nil) != tt.wantErr {
+					t.Errorf("IncrementRateLimitCounter() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got := c.c.(*mockDbClient).query; got != tt.wantQuery {
+					t.Errorf("IncrementRateLimitCounter() executes wrong query = %s, want = %s", got, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_IncrementRateLimitCounterIfBelow(t *testing.T) {
+	const wantQuery = "WITH expired AS (DELETE FROM foo WHERE key = $1 AND expires_at < NOW())" +
+		" INSERT INTO foo (key, window_start, counter, expires_at) VALUES ($1, $2, 1, $3)" +
+		" ON CONFLICT (key, window_start) DO UPDATE SET counter = foo.counter + 1" +
+		" WHERE foo.counter < $4 RETURNING counter"
+
+	tests := []struct {
+		name      string
+		c         dbClient
+		key       string
+		max       uint32
+		want      bool
+		wantErr   bool
+		wantQuery string
+	}{
+		{
+			name: "shall increment the counter given it's below max",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("INSERT"),
+					s:   &sync.RWMutex{},
+					v:   [][]any{{2}},
+				},
+			},
+			key:       "bar",
+			max:       3,
+			want:      true,
+			wantQuery: wantQuery,
+		},
+		{
+			name: "shall not increment the counter given it reached max",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("INSERT"),
+					s:   &sync.RWMutex{},
+				},
+			},
+			key:       "bar",
+			max:       3,
+			want:      false,
+			wantQuery: wantQuery,
+		},
+		{
+			name: "shall not increment the counter given zero max",
+			c:    &mockDbClient{},
+			key:  "bar",
+			want: false,
+		},
+		{
+			name:    "unhappy path: no key",
+			c:       &mockDbClient{},
+			max:     3,
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			key:       "bar",
+			max:       3,
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{
+					c:                      tt.c,
+					tableRateLimitCounters: "foo",
+				}
+				got, err := c.IncrementRateLimitCounterIfBelow(
+					context.TODO(), tt.key, time.Now(), time.Now().Add(time.Minute), tt.max,
+				)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("IncrementRateLimitCounterIfBelow() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if got != tt.want {
+					t.Errorf("IncrementRateLimitCounterIfBelow() got = %v, want %v", got, tt.want)
+				}
+				if gotQuery := c.c.(*mockDbClient).query; gotQuery != tt.wantQuery {
+					t.Errorf(
+						"IncrementRateLimitCounterIfBelow() executes wrong query = %s, want = %s", gotQuery,
+						tt.wantQuery,
+					)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_ReadRateLimitCounters(t *testing.T) {
+	const wantQuery = "SELECT window_start, counter FROM foo WHERE key = $1 AND window_start = ANY($2)"
+
+	windowCurrent := time.Date(2023, 1, 1, 0, 1, 0, 0, time.UTC)
+	windowPrevious := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		c         dbClient
+		key       string
+		want      []uint32
+		wantErr   bool
+		wantQuery string
+	}{
+		{
+			name: "shall return counters in the order of requested windows",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("SELECT"),
+					s:   &sync.RWMutex{},
+					v: [][]any{
+						{windowPrevious, 2},
+					},
+				},
+			},
+			key:       "bar",
+			want:      []uint32{0, 2},
+			wantQuery: wantQuery,
+		},
+		{
+			name:    "unhappy path: no key",
+			c:       &mockDbClient{},
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			key:       "bar",
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+		{
+			name: "unhappy path: while reading a raw",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("SELECT"),
+					s:   &sync.RWMutex{},
+					err: errors.New("foo"),
+					v: [][]any{
+						{windowPrevious, 2},
+					},
+				},
+			},
+			key:       "bar",
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{
+					c:                      tt.c,
+					tableRateLimitCounters: "foo",
+				}
+				got, err := c.ReadRateLimitCounters(context.TODO(), tt.key, windowCurrent, windowPrevious)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("ReadRateLimitCounters() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ReadRateLimitCounters() got = %v, want %v", got, tt.want)
+				}
+				if gotQuery := c.c.(*mockDbClient).query; gotQuery != tt.wantQuery {
+					t.Errorf("ReadRateLimitCounters() executes wrong query = %s, want = %s", gotQuery, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_WriteRefreshToken(t *testing.T) {
+	const wantQuery = "INSERT INTO foo (token_id, family_id, user_id, expires_at) VALUES ($1, $2, $3, $4)"
+
+	type args struct {
+		userID, tokenID, familyID string
+	}
+	tests := []struct {
+		name      string
+		c         dbClient
+		args      args
+		wantErr   bool
+		wantQuery string
+	}{
+		{
+			name:      "happy path",
+			c:         &mockDbClient{},
+			args:      args{userID: "bar", tokenID: "qux", familyID: "quxx"},
+			wantQuery: wantQuery,
+		},
+		{
+			name:    "unhappy path: no userID",
+			c:       &mockDbClient{},
+			args:    args{tokenID: "qux", familyID: "quxx"},
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: no tokenID",
+			c:       &mockDbClient{},
+			args:    args{userID: "bar", familyID: "quxx"},
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: no familyID",
+			c:       &mockDbClient{},
+			args:    args{userID: "bar", tokenID: "qux"},
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			args:      args{userID: "bar", tokenID: "qux", familyID: "quxx"},
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{c: tt.c, tableRefreshTokens: "foo"}
+				err := c.WriteRefreshToken(context.TODO(), tt.args.userID, tt.args.tokenID, tt.args.familyID, time.Now())
+				if (err != nil) != tt.wantErr {
+					t.Errorf("WriteRefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got := c.c.(*mockDbClient).query; got != tt.wantQuery {
+					t.Errorf("WriteRefreshToken() executes wrong query = %s, want = %s", got, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_DeactivateRefreshToken(t *testing.T) {
+	const wantQuery = "UPDATE foo SET is_active = FALSE" +
+		" WHERE token_id = $1 AND user_id = $2 AND is_active AND expires_at > now() RETURNING family_id"
+
+	tests := []struct {
+		name         string
+		c            dbClient
+		userID       string
+		wantFamilyID string
+		wantFound    bool
+		wantErr      bool
+		wantQuery    string
+	}{
+		{
+			name: "shall deactivate the active token",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("UPDATE"),
+					s:   &sync.RWMutex{},
+					v:   [][]any{{"qux"}},
+				},
+			},
+			userID:       "bar",
+			wantFamilyID: "qux",
+			wantFound:    true,
+			wantQuery:    wantQuery,
+		},
+		{
+			name: "shall not find the inactive token",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("UPDATE"),
+					s:   &sync.RWMutex{},
+				},
+			},
+			userID:    "bar",
+			wantQuery: wantQuery,
+		},
+		{
+			name:    "unhappy path: no userID",
+			c:       &mockDbClient{},
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			userID:    "bar",
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{c: tt.c, tableRefreshTokens: "foo"}
+				familyID, found, err := c.DeactivateRefreshToken(context.TODO(), tt.userID, "quxx")
+				if (err != nil) != tt.wantErr {
+					t.Errorf("DeactivateRefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if familyID != tt.wantFamilyID || found != tt.wantFound {
+					t.Errorf(
+						"DeactivateRefreshToken() got = (%s, %v), want (%s, %v)",
+						familyID, found, tt.wantFamilyID, tt.wantFound,
+					)
+				}
+				if got := c.c.(*mockDbClient).query; got != tt.wantQuery {
+					t.Errorf("DeactivateRefreshToken() executes wrong query = %s, want = %s", got, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_RevokeRefreshTokens(t *testing.T) {
+	t.Run(
+		"shall revoke the token's family", func(t *testing.T) {
+			c := Client{c: &mockDbClient{}, tableRefreshTokens: "foo"}
+			if err := c.RevokeRefreshTokenFamily(context.TODO(), "bar", "qux"); err != nil {
+				t.Fatal(err)
+			}
+			const want = "UPDATE foo SET is_active = FALSE WHERE is_active AND family_id = " +
+				"(SELECT family_id FROM foo WHERE token_id = $1 AND user_id = $2)"
+			if got := c.c.(*mockDbClient).query; got != want {
+				t.Errorf("RevokeRefreshTokenFamily() executes wrong query = %s, want = %s", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail to revoke the token's family given no tokenID", func(t *testing.T) {
+			c := Client{c: &mockDbClient{}, tableRefreshTokens: "foo"}
+			if err := c.RevokeRefreshTokenFamily(context.TODO(), "bar", ""); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+
+	t.Run(
+		"shall revoke all user's tokens", func(t *testing.T) {
+			c := Client{c: &mockDbClient{}, tableRefreshTokens: "foo"}
+			if err := c.RevokeUserRefreshTokens(context.TODO(), "bar"); err != nil {
+				t.Fatal(err)
+			}
+			const want = "UPDATE foo SET is_active = FALSE WHERE user_id = $1 AND is_active"
+			if got := c.c.(*mockDbClient).query; got != want {
+				t.Errorf("RevokeUserRefreshTokens() executes wrong query = %s, want = %s", got, want)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail to revoke user's tokens given db error", func(t *testing.T) {
+			c := Client{c: &mockDbClient{err: errors.New("foo")}, tableRefreshTokens: "foo"}
+			if err := c.RevokeUserRefreshTokens(context.TODO(), "bar"); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+}
+
+func TestClient_WriteOIDCState(t *testing.T) {
+	t.Run(
+		"shall write the state", func(t *testing.T) {
+			c := Client{c: &mockDbClient{}, tableOIDCStates: "foo"}
+			if err := c.WriteOIDCState(context.TODO(), "bar", "qux", "n", "v", time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			const want = "INSERT INTO foo (state, provider, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4, $5)"
+			if got := c.c.(*mockDbClient).query; got != want {
+				t.Errorf("WriteOIDCState() executes wrong query = %s, want = %s", got, want)
+			}
+		},
+	)
+	t.Run(
+		"shall fail given no state", func(t *testing.T) {
+			c := Client{c: &mockDbClient{}, tableOIDCStates: "foo"}
+			if err := c.WriteOIDCState(context.TODO(), "", "qux", "n", "v", time.Now()); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+}
+
+func TestClient_ConsumeOIDCState(t *testing.T) {
+	const wantQuery = "DELETE FROM foo WHERE state = $1 RETURNING provider, nonce, code_verifier, expires_at"
+	expiresAt := time.Now().UTC()
+
+	tests := []struct {
+		name             string
+		c                dbClient
+		state            string
+		wantFound        bool
+		wantProvider     string
+		wantNonce        string
+		wantCodeVerifier string
+		wantExpiresAt    time.Time
+		wantErr          bool
+		wantQuery        string
+	}{
+		{
+			name: "shall consume the state",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("DELETE"),
+					s:   &sync.RWMutex{},
+					v:   [][]any{{"github", "n", "v", expiresAt}},
+				},
+			},
+			state:            "bar",
+			wantFound:        true,
+			wantProvider:     "github",
+			wantNonce:        "n",
+			wantCodeVerifier: "v",
+			wantExpiresAt:    expiresAt,
+			wantQuery:        wantQuery,
+		},
+		{
+			name: "shall not find the state",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("DELETE"),
+					s:   &sync.RWMutex{},
+				},
+			},
+			state:     "bar",
+			wantQuery: wantQuery,
+		},
+		{
+			name:    "unhappy path: no state",
+			c:       &mockDbClient{},
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			state:     "bar",
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{c: tt.c, tableOIDCStates: "foo"}
+				found, provider, nonce, codeVerifier, expiresAt, err := c.ConsumeOIDCState(context.TODO(), tt.state)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("ConsumeOIDCState() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if found != tt.wantFound || provider != tt.wantProvider || nonce != tt.wantNonce ||
+					codeVerifier != tt.wantCodeVerifier || !expiresAt.Equal(tt.wantExpiresAt) {
+					t.Errorf(
+						"ConsumeOIDCState() got = (%v, %s, %s, %s, %v)",
+						found, provider, nonce, codeVerifier, expiresAt,
+					)
+				}
+				if got := c.c.(*mockDbClient).query; got != tt.wantQuery {
+					t.Errorf("ConsumeOIDCState() executes wrong query = %s, want = %s", got, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_UpgradeAnonymUser(t *testing.T) {
+	newClient := func(db *mockDbClient) Client {
+		return Client{
+			c:                         &mockDbClient{tx: mockTx{client: db}},
+			tableWritePrompt:          "prompts",
+			tableWriteModelPrediction: "predictions",
+			tableWriteSuccessFlag:     "success",
+			tableUsers:                "users",
+			tableRefreshTokens:        "refresh_tokens",
+			tableFlaggedPrompts:       "flagged",
+			tableRateLimitCounters:    "rl",
+		}
+	}
+
+	t.Run(
+		"shall re-parent the anonymous user's history in one transaction", func(t *testing.T) {
+			// GIVEN
+			db := &mockDbClient{}
+			c := newClient(db)
+
+			// WHEN
+			err := c.UpgradeAnonymUser(context.TODO(), "anonym", "user")
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{
+				"UPDATE prompts SET user_id = $1 WHERE user_id = $2",
+				"UPDATE predictions SET user_id = $1 WHERE user_id = $2",
+				"UPDATE success SET user_id = $1 WHERE user_id = $2",
+				"UPDATE flagged SET user_id = $1 WHERE user_id = $2",
+				"WITH moved AS (DELETE FROM rl WHERE key LIKE '%:' || $2 || '/%'" +
+					" RETURNING key, window_start, counter, expires_at)" +
+					" INSERT INTO rl (key, window_start, counter, expires_at)" +
+					" SELECT REPLACE(key, $2, $1), window_start, counter, expires_at FROM moved" +
+					" ON CONFLICT (key, window_start) DO UPDATE SET counter = rl.counter + EXCLUDED.counter",
+				"UPDATE users SET web_fingerprint = NULL, is_active = FALSE, update_at = now() WHERE user_id = $1",
+				"UPDATE refresh_tokens SET is_active = FALSE WHERE user_id = $1",
+			}
+			if !reflect.DeepEqual(db.queries, want) {
+				t.Errorf("UpgradeAnonymUser() executes wrong queries = %v, want = %v", db.queries, want)
+			}
+		},
+	)
+
+	t.Run(
+		"shall stop at the first failed statement", func(t *testing.T) {
+			// GIVEN
+			db := &mockDbClient{err: errors.New("foo")}
+			c := newClient(db)
+
+			// WHEN
+			err := c.UpgradeAnonymUser(context.TODO(), "anonym", "user")
+
+			// THEN
+			if err == nil {
+				t.Error("error expected")
+			}
+			if len(db.queries) != 1 {
+				t.Errorf("no statements are expected to be executed after the failure, got: %v", db.queries)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail to upgrade the user to itself", func(t *testing.T) {
+			if err := newClient(&mockDbClient{}).UpgradeAnonymUser(context.TODO(), "user", "user"); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+}
+
+func TestClient_SearchUsers(t *testing.T) {
+	createdAt := time.Now().UTC()
+
+	tests := []struct {
+		name        string
+		c           dbClient
+		email       string
+		fingerprint string
+		want        []UserRecord
+		wantErr     bool
+		wantQuery   string
+	}{
+		{
+			name: "shall search users by email and fingerprint",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("SELECT"),
+					s:   &sync.RWMutex{},
+					v:   [][]any{{"u0", "foo@bar.baz", "qux", 1, true, true, false, createdAt}},
+				},
+			},
+			email:       "foo%",
+			fingerprint: "qux",
+			want: []UserRecord{
+				{
+					ID: "u0", Email: "foo@bar.baz", Fingerprint: "qux", Role: 1, IsActive: true, IsBlocked: true,
+					CreatedAt: createdAt,
+				},
+			},
+			wantQuery: "SELECT user_id, COALESCE(email, ''), COALESCE(web_fingerprint, ''), role, is_active, " +
+				"is_blocked, is_premium, created_at FROM users WHERE email ILIKE $1 OR web_fingerprint = $2 " +
+				"ORDER BY created_at DESC LIMIT $3",
+		},
+		{
+			name: "shall search users by fingerprint",
+			c: &mockDbClient{
+				v: &mockRows{tag: pgconn.NewCommandTag("SELECT"), s: &sync.RWMutex{}},
+			},
+			fingerprint: "qux",
+			wantQuery: "SELECT user_id, COALESCE(email, ''), COALESCE(web_fingerprint, ''), role, is_active, " +
+				"is_blocked, is_premium, created_at FROM users WHERE web_fingerprint = $1 ORDER BY created_at DESC LIMIT $2",
+		},
+		{
+			name:    "unhappy path: neither email nor fingerprint",
+			c:       &mockDbClient{},
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: db error",
+			c:       &mockDbClient{err: errors.New("foo")},
+			email:   "foo",
+			wantErr: true,
+			wantQuery: "SELECT user_id, COALESCE(email, ''), COALESCE(web_fingerprint, ''), role, is_active, " +
+				"is_blocked, is_premium, created_at FROM users WHERE email ILIKE $1 ORDER BY created_at DESC LIMIT $2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{c: tt.c, tableUsers: "users"}
+				got, err := c.SearchUsers(context.TODO(), tt.email, tt.fingerprint, 10)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("SearchUsers() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("SearchUsers() got = %+v, want %+v", got, tt.want)
+				}
+				if got := c.c.(*mockDbClient).query; got != tt.wantQuery {
+					t.Errorf("SearchUsers() executes wrong query = %s, want = %s", got, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func Test_escapeLike(t *testing.T) {
+	if got := escapeLike(`foo_%\`); got != `foo\_\%\\` {
+		t.Errorf("unexpected escaped string: %s", got)
+	}
+}
+
+func TestClient_UpdateUser(t *testing.T) {
+	newClient := func(rows [][]any) Client {
+		return Client{
+			c: &mockDbClient{
+				v: &mockRows{tag: pgconn.NewCommandTag("UPDATE"), s: &sync.RWMutex{}, v: rows},
+			},
+			tableUsers: "users",
+		}
+	}
+
+	for _, tt := range []struct {
+		name      string
+		fn        func(c Client) (bool, error)
+		rows      [][]any
+		wantFound bool
+		wantQuery string
+	}{
+		{
+			name: "shall block the user",
+			fn: func(c Client) (bool, error) {
+				return c.UpdateUserBlocked(context.TODO(), "u0", true)
+			},
+			rows:      [][]any{{"u0"}},
+			wantFound: true,
+			wantQuery: "UPDATE users SET is_blocked = $1, update_at = now() WHERE user_id = $2 RETURNING user_id",
+		},
+		{
+			name: "shall change the role",
+			fn: func(c Client) (bool, error) {
+				return c.UpdateUserRole(context.TODO(), "u0", 2)
+			},
+			rows:      [][]any{{"u0"}},
+			wantFound: true,
+			wantQuery: "UPDATE users SET role = $1, update_at = now() WHERE user_id = $2 RETURNING user_id",
+		},
+		{
+			name: "shall not find the user to change the plan",
+			fn: func(c Client) (bool, error) {
+				return c.UpdateUserPlan(context.TODO(), "u0", true)
+			},
+			wantQuery: "UPDATE users SET is_premium = $1, update_at = now() WHERE user_id = $2 RETURNING user_id",
+		},
+	} {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := newClient(tt.rows)
+				found, err := tt.fn(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found != tt.wantFound {
+					t.Errorf("unexpected found flag: %v", found)
+				}
+				if got := c.c.(*mockDbClient).query; got != tt.wantQuery {
+					t.Errorf("executes wrong query = %s, want = %s", got, tt.wantQuery)
+				}
+			},
+		)
+	}
+
+	t.Run(
+		"unhappy path: no user id", func(t *testing.T) {
+			if _, err := newClient(nil).UpdateUserBlocked(context.TODO(), "", true); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+}
+
+func TestClient_RevokeUserAPITokens(t *testing.T) {
+	const wantQuery = "UPDATE tokens SET is_active = FALSE, updated_at = now() WHERE user_id = $1 AND is_active " +
+		"RETURNING token"
+
+	c := Client{
+		c: &mockDbClient{
+			v: &mockRows{
+				tag: pgconn.NewCommandTag("UPDATE"), s: &sync.RWMutex{}, v: [][]any{{"t0"}, {"t1"}},
+			},
+		},
+		tableTokens: "tokens",
+	}
+	got, err := c.RevokeUserAPITokens(context.TODO(), "u0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 2 {
+		t.Errorf("unexpected number of revoked tokens: %d", got)
+	}
+	if q := c.c.(*mockDbClient).query; q != wantQuery {
+		t.Errorf("RevokeUserAPITokens() executes wrong query = %s, want = %s", q, wantQuery)
+	}
+
+	if _, err := (Client{c: &mockDbClient{err: errors.New("foo")}}).RevokeUserAPITokens(
+		context.TODO(), "u0",
+	); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestClient_ReadUserRequests(t *testing.T) {
+	const wantQuery = "SELECT p.request_id, p.prompt, s.request_id IS NOT NULL, p.timestamp FROM prompts AS p " +
+		"LEFT JOIN success AS s ON p.request_id = s.request_id WHERE p.user_id = $1 ORDER BY p.timestamp DESC LIMIT $2"
+	ts := time.Now().UTC()
+
+	c := Client{
+		c: &mockDbClient{
+			v: &mockRows{
+				tag: pgconn.NewCommandTag("SELECT"),
+				s:   &sync.RWMutex{},
+				v:   [][]any{{"r1", "foo", true, ts}, {"r0", "bar", false, ts}},
+			},
+		},
+		tableWritePrompt:      "prompts",
+		tableWriteSuccessFlag: "success",
+	}
+	got, err := c.ReadUserRequests(context.TODO(), "u0", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RequestRecord{
+		{RequestID: "r1", Prompt: "foo", IsSuccess: true, Timestamp: ts},
+		{RequestID: "r0", Prompt: "bar", IsSuccess: false, Timestamp: ts},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadUserRequests() got = %+v, want %+v", got, want)
+	}
+	if q := c.c.(*mockDbClient).query; q != wantQuery {
+		t.Errorf("ReadUserRequests() executes wrong query = %s, want = %s", q, wantQuery)
+	}
+
+	if _, err := c.ReadUserRequests(context.TODO(), "", 10); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestClient_WriteAuditLog(t *testing.T) {
+	const wantQuery = "INSERT INTO audit_log (admin_id, action, user_id, details) VALUES ($1, $2, $3, $4)"
+
+	for _, tt := range []struct {
+		name      string
+		c         dbClient
+		adminID   string
+		action    string
+		wantErr   bool
+		wantQuery string
+	}{
+		{
+			name:      "shall write the audit log",
+			c:         &mockDbClient{},
+			adminID:   "a0",
+			action:    "user.activate",
+			wantQuery: wantQuery,
+		},
+		{
+			name:    "unhappy path: no admin id",
+			c:       &mockDbClient{},
+			action:  "user.activate",
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: no action",
+			c:       &mockDbClient{},
+			adminID: "a0",
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			adminID:   "a0",
+			action:    "user.activate",
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+	} {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{c: tt.c, tableAuditLog: "audit_log"}
+				if err := c.WriteAuditLog(context.TODO(), tt.adminID, tt.action, "u0", ""); (err != nil) != tt.wantErr {
+					t.Errorf("WriteAuditLog() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got := c.c.(*mockDbClient).query; got != tt.wantQuery {
+					t.Errorf("WriteAuditLog() executes wrong query = %s, want = %s", got, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_ExportUserData(t *testing.T) {
+	const wantQuery = "SELECT json_build_object(" +
+		"'user', json_build_object('user_id', u.user_id, 'email', u.email, 'web_fingerprint', u.web_fingerprint" +
+		", 'role', u.role, 'is_active', u.is_active, 'is_blocked', u.is_blocked, 'is_premium', u.is_premium" +
+		", 'created_at', u.created_at" +
+		", 'updated_at', u.update_at)" +
+		", 'prompts', COALESCE((SELECT json_agg(json_build_object('request_id', request_id, 'prompt', prompt" +
+		", 'timestamp', timestamp) ORDER BY timestamp) FROM prompts WHERE user_id = u.user_id), '[]')" +
+		", 'model_predictions', COALESCE((SELECT json_agg(json_build_object('request_id', request_id" +
+		", 'response_raw', response_raw, 'response', response, 'prompt_tokens', prompt_tokens" +
+		", 'completion_tokens', completion_tokens, 'model_id', model_id, 'timestamp', timestamp)" +
+		" ORDER BY timestamp) FROM predictions WHERE user_id = u.user_id), '[]')" +
+		", 'successful_requests', COALESCE((SELECT json_agg(json_build_object('request_id', request_id" +
+		", 'token', token, 'timestamp', timestamp) ORDER BY timestamp) FROM success" +
+		" WHERE user_id = u.user_id), '[]')" +
+		", 'api_tokens', COALESCE((SELECT json_agg(json_build_object('token', token, 'is_active', is_active" +
+		", 'created_at', created_at, 'updated_at', updated_at) ORDER BY created_at) FROM tokens" +
+		" WHERE user_id = u.user_id), '[]')" +
+		", 'sessions', COALESCE((SELECT json_agg(json_build_object('is_active', is_active" +
+		", 'created_at', created_at, 'expires_at', expires_at) ORDER BY created_at) FROM refresh_tokens" +
+		" WHERE user_id = u.user_id), '[]')" +
+		", 'flagged_prompts', COALESCE((SELECT json_agg(json_build_object('request_id', request_id" +
+		", 'prompt', prompt, 'reason', reason, 'timestamp', timestamp) ORDER BY timestamp) FROM flagged_prompts" +
+		" WHERE user_id = u.user_id), '[]')" +
+		", 'admin_actions', COALESCE((SELECT json_agg(json_build_object('action', action, 'details', details" +
+		", 'created_at', created_at) ORDER BY created_at) FROM admin_audit_log WHERE user_id = u.user_id), '[]')" +
+		")::TEXT FROM users AS u WHERE u.user_id = $1"
+
+	tests := []struct {
+		name      string
+		c         dbClient
+		userID    string
+		wantFound bool
+		wantData  []byte
+		wantErr   bool
+		wantQuery string
+	}{
+		{
+			name: "shall export user's data",
+			c: &mockDbClient{
+				v: &mockRows{
+					tag: pgconn.NewCommandTag("SELECT"),
+					s:   &sync.RWMutex{},
+					v:   [][]any{{`{"user":{"user_id":"foo"}}`}},
+				},
+			},
+			userID:    "foo",
+			wantFound: true,
+			wantData:  []byte(`{"user":{"user_id":"foo"}}`),
+			wantQuery: wantQuery,
+		},
+		{
+			name: "shall not find the user",
+			c: &mockDbClient{
+				v: &mockRows{tag: pgconn.NewCommandTag("SELECT"), s: &sync.RWMutex{}},
+			},
+			userID:    "foo",
+			wantQuery: wantQuery,
+		},
+		{
+			name:    "unhappy path: no user id",
+			c:       &mockDbClient{},
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			userID:    "foo",
+			wantErr:   true,
+			wantQuery: wantQuery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{
+					c:                         tt.c,
+					tableWritePrompt:          "prompts",
+					tableWriteModelPrediction: "predictions",
+					tableWriteSuccessFlag:     "success",
+					tableUsers:                "users",
+					tableTokens:               "tokens",
+					tableRefreshTokens:        "refresh_tokens",
+					tableFlaggedPrompts:       "flagged_prompts",
+					tableAuditLog:             "admin_audit_log",
+				}
+				found, data, err := c.ExportUserData(context.TODO(), tt.userID)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("ExportUserData() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if found != tt.wantFound || !reflect.DeepEqual(data, tt.wantData) {
+					t.Errorf("ExportUserData() got = (%v, %s)", found, data)
+				}
+				if got := c.c.(*mockDbClient).query; got != tt.wantQuery {
+					t.Errorf("ExportUserData() executes wrong query = %s, want = %s", got, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_DeleteUser(t *testing.T) {
+	newClient := func(db *mockDbClient) Client {
+		return Client{
+			c:                         &mockDbClient{tx: mockTx{client: db}},
+			tableWritePrompt:          "prompts",
+			tableWriteModelPrediction: "predictions",
+			tableWriteSuccessFlag:     "success",
+			tableUsers:                "users",
+			tableTokens:               "tokens",
+			tableOneTimeSecret:        "secrets",
+			tableRefreshTokens:        "refresh_tokens",
+			tableFlaggedPrompts:       "flagged_prompts",
+			tableRateLimitCounters:    "rate_limit_counters",
+		}
+	}
+
+	t.Run(
+		"shall delete user's data and anonymize the user in one transaction", func(t *testing.T) {
+			// GIVEN
+			db := &mockDbClient{}
+			c := newClient(db)
+
+			// WHEN
+			err := c.DeleteUser(context.TODO(), "user")
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{
+				"DELETE FROM success WHERE user_id = $1",
+				"DELETE FROM predictions WHERE user_id = $1",
+				"DELETE FROM prompts WHERE user_id = $1",
+				"DELETE FROM tokens WHERE user_id = $1",
+				"DELETE FROM secrets WHERE user_id = $1",
+				"DELETE FROM refresh_tokens WHERE user_id = $1",
+				"DELETE FROM flagged_prompts WHERE user_id = $1",
+				"DELETE FROM rate_limit_counters WHERE key LIKE '%:' || $1 || '/%'",
+				"UPDATE users SET email = NULL, web_fingerprint = NULL, is_active = FALSE, is_premium = FALSE" +
+					", update_at = now() WHERE user_id = $1",
+			}
+			if !reflect.DeepEqual(db.queries, want) {
+				t.Errorf("DeleteUser() executes wrong queries = %v, want = %v", db.queries, want)
+			}
+		},
+	)
+
+	t.Run(
+		"shall stop at the first failed statement", func(t *testing.T) {
+			// GIVEN
+			db := &mockDbClient{err: errors.New("foo")}
+			c := newClient(db)
+
+			// WHEN
+			err := c.DeleteUser(context.TODO(), "user")
+
+			// THEN
+			if err == nil {
+				t.Error("error expected")
+			}
+			if len(db.queries) != 1 {
+				t.Errorf("no statements are expected to be executed after the failure, got: %v", db.queries)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail without user id", func(t *testing.T) {
+			if err := newClient(&mockDbClient{}).DeleteUser(context.TODO(), ""); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+}
+
+func TestClient_PurgeBatch(t *testing.T) {
+	before := time.Date(2023, 4, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		db        *mockDbClient
+		target    string
+		plan      string
+		batchSize uint32
+		wantQuery string
+		wantErr   bool
+	}{
+		{
+			name:      "shall purge prompts with the linked rows",
+			db:        &mockDbClient{},
+			target:    "prompts",
+			batchSize: 100,
+			wantQuery: "WITH batch AS (SELECT t.request_id FROM prompts AS t WHERE t.timestamp < $1 LIMIT $2)" +
+				", predictions AS (DELETE FROM predictions WHERE request_id IN (SELECT request_id FROM batch))" +
+				", statuses AS (DELETE FROM success WHERE request_id IN (SELECT request_id FROM batch))" +
+				" DELETE FROM prompts WHERE request_id IN (SELECT request_id FROM batch)",
+		},
+		{
+			name:      "shall purge model predictions of anonym users",
+			db:        &mockDbClient{},
+			target:    "model_predictions",
+			plan:      "anonym",
+			batchSize: 100,
+			wantQuery: "DELETE FROM predictions WHERE request_id IN (SELECT t.request_id FROM predictions AS t" +
+				" INNER JOIN users AS u ON t.user_id = u.user_id WHERE t.timestamp < $1 AND u.role = 0 LIMIT $2)",
+		},
+		{
+			name:      "shall purge raw model responses of free plan users",
+			db:        &mockDbClient{},
+			target:    "model_predictions_raw",
+			plan:      "free",
+			batchSize: 100,
+			wantQuery: "UPDATE predictions SET response_raw = '' WHERE request_id IN (SELECT t.request_id" +
+				" FROM predictions AS t INNER JOIN users AS u ON t.user_id = u.user_id WHERE t.timestamp < $1" +
+				" AND t.response_raw <> '' AND u.role <> 0 AND NOT u.is_premium LIMIT $2)",
+		},
+		{
+			name:      "shall purge successful requests of premium users",
+			db:        &mockDbClient{},
+			target:    "successful_requests",
+			plan:      "premium",
+			batchSize: 100,
+			wantQuery: "DELETE FROM success WHERE request_id IN (SELECT t.request_id FROM success AS t" +
+				" INNER JOIN users AS u ON t.user_id = u.user_id WHERE t.timestamp < $1 AND u.is_premium LIMIT $2)",
+		},
+		{
+			name:      "unhappy path: unknown target",
+			db:        &mockDbClient{},
+			target:    "foo",
+			batchSize: 100,
+			wantErr:   true,
+		},
+		{
+			name:      "unhappy path: unknown plan",
+			db:        &mockDbClient{},
+			target:    "prompts",
+			plan:      "foo",
+			batchSize: 100,
+			wantErr:   true,
+		},
+		{
+			name:      "shall purge flagged prompts of free users",
+			db:        &mockDbClient{},
+			target:    "flagged_prompts",
+			plan:      "free",
+			batchSize: 100,
+			wantQuery: "DELETE FROM flagged_prompts WHERE request_id IN (SELECT t.request_id FROM flagged_prompts AS t" +
+				" INNER JOIN users AS u ON t.user_id = u.user_id" +
+				" WHERE t.timestamp < $1 AND u.role <> 0 AND NOT u.is_premium LIMIT $2)",
+		},
+		{
+			name:      "shall purge expired refresh tokens",
+			db:        &mockDbClient{},
+			target:    "refresh_tokens",
+			batchSize: 100,
+			wantQuery: "DELETE FROM refresh_tokens WHERE token_id IN (SELECT t.token_id FROM refresh_tokens AS t" +
+				" WHERE t.expires_at < $1 LIMIT $2)",
+		},
+		{
+			name:      "shall purge expired rate limit counters",
+			db:        &mockDbClient{},
+			target:    "rate_limit_counters",
+			batchSize: 100,
+			wantQuery: "DELETE FROM rate_limit_counters WHERE (key, window_start) IN (SELECT key, window_start" +
+				" FROM rate_limit_counters AS t WHERE t.expires_at < $1 LIMIT $2)",
+		},
+		{
+			name:      "shall purge expired OIDC states",
+			db:        &mockDbClient{},
+			target:    "oidc_states",
+			batchSize: 100,
+			wantQuery: "DELETE FROM oidc_states WHERE (state) IN (SELECT state FROM oidc_states AS t" +
+				" WHERE t.expires_at < $1 LIMIT $2)",
+		},
+		{
+			name:      "unhappy path: rate limit counters are not linked to the plan",
+			db:        &mockDbClient{},
+			target:    "rate_limit_counters",
+			plan:      "anonym",
+			batchSize: 100,
+			wantErr:   true,
+		},
+		{
+			name:    "unhappy path: zero batch size",
+			db:      &mockDbClient{},
+			target:  "prompts",
+			wantErr: true,
+		},
+		{
+			name:      "unhappy path: db error",
+			db:        &mockDbClient{err: errors.New("foo")},
+			target:    "successful_requests",
+			batchSize: 100,
+			wantQuery: "DELETE FROM success WHERE request_id IN (SELECT t.request_id FROM success AS t" +
+				" WHERE t.timestamp < $1 LIMIT $2)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{
+					c:                         tt.db,
+					tableWritePrompt:          "prompts",
+					tableWriteModelPrediction: "predictions",
+					tableWriteSuccessFlag:     "success",
+					tableUsers:                "users",
+					tableFlaggedPrompts:       "flagged_prompts",
+					tableRefreshTokens:        "refresh_tokens",
+					tableRateLimitCounters:    "rate_limit_counters",
+					tableOIDCStates:           "oidc_states",
+				}
+				_, err := c.PurgeBatch(context.TODO(), tt.target, tt.plan, before, tt.batchSize)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("PurgeBatch() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.db.query != tt.wantQuery {
+					t.Errorf("PurgeBatch() executes wrong query = %s, want = %s", tt.db.query, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
+
+func TestClient_WriteFlaggedPrompt(t *testing.T) {
+	const (
+		requestID = "693a35ba-e42c-4168-8afc-5a7c359d1d05"
+		userID    = "c40bad11-0822-4d84-9f61-44b9a97b0432"
+		wantQuery = "INSERT INTO flagged_prompts (request_id, user_id, prompt, reason, detector, policy, timestamp)" +
+			" VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	)
+
+	tests := []struct {
+		name      string
+		c         *mockDbClient
+		requestID string
+		reason    string
+		wantQuery string
+		wantErr   error
+	}{
+		{
+			name:      "happy path",
+			c:         &mockDbClient{},
+			requestID: requestID,
+			reason:    "prompt_injection",
+			wantQuery: wantQuery,
+		},
+		{
+			name:    "unhappy path: no request id",
+			c:       &mockDbClient{},
+			reason:  "prompt_injection",
+			wantErr: errors.New("request_id is required"),
+		},
+		{
+			name:      "unhappy path: no reason",
+			c:         &mockDbClient{},
+			requestID: requestID,
+			wantErr:   errors.New("reason is required"),
+		},
+		{
+			name:      "unhappy path: db error",
+			c:         &mockDbClient{err: errors.New("foo")},
+			requestID: requestID,
+			reason:    "off_topic",
+			wantQuery: wantQuery,
+			wantErr:   errors.New("foo"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := Client{c: tt.c, tableFlaggedPrompts: "flagged_prompts"}
+				if err := c.WriteFlaggedPrompt(
+					context.TODO(), tt.requestID, userID, "ignore previous instructions", tt.reason, "heuristic", "block",
+				); !reflect.DeepEqual(err, tt.wantErr) {
+					t.Errorf("WriteFlaggedPrompt() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.c.query != tt.wantQuery {
+					t.Errorf("WriteFlaggedPrompt() executes wrong query = %s, want = %s", tt.c.query, tt.wantQuery)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,49 @@
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MockRepository mocks the Repository.
+type MockRepository struct {
+	// Rows defines the number of rows to purge per target, and plan as "<target>/<plan>".
+	Rows map[string]int64
+	// Calls records the calls of PurgeBatch.
+	Calls []MockPurgeBatchCall
+	Err   error
+	mu    sync.Mutex
+}
+
+// MockPurgeBatchCall defines the arguments of the PurgeBatch call.
+type MockPurgeBatchCall struct {
+	Target    string
+	Plan      string
+	Before    time.Time
+	BatchSize uint32
+}
+
+func (m *MockRepository) PurgeBatch(
+	_ context.Context, target, plan string, before time.Time, batchSize uint32,
+) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Calls = append(
+		m.Calls, MockPurgeBatchCall{Target: target, Plan: plan, Before: before, BatchSize: batchSize},
+	)
+	if m.Err != nil {
+		return 0, m.Err
+	}
+
+	k := target + "/" + plan
+	n := m.Rows[k]
+	if n > int64(batchSize) {
+		n = int64(batchSize)
+	}
+	if n > 0 {
+		m.Rows[k] -= n
+	}
+	return n, nil
+}
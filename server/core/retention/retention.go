@@ -0,0 +1,221 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Targets of the retention rules.
+const (
+	// TargetPrompts defines the users' prompts, the model predictions and the requests' statuses
+	// linked to the prompts are purged alongside.
+	TargetPrompts = "prompts"
+	// TargetModelPredictions defines the model predictions.
+	TargetModelPredictions = "model_predictions"
+	// TargetModelPredictionsRaw defines the raw model responses, the rows are kept while the column is emptied.
+	TargetModelPredictionsRaw = "model_predictions_raw"
+	// TargetSuccessfulRequests defines the statuses of successful requests.
+	TargetSuccessfulRequests = "successful_requests"
+	// TargetFlaggedPrompts defines the prompts flagged by the guard.
+	TargetFlaggedPrompts = "flagged_prompts"
+	// TargetRefreshTokens defines the refresh tokens expired before the retention window.
+	// The rotated and revoked tokens are kept until they expire to detect their reuse.
+	TargetRefreshTokens = "refresh_tokens"
+	// TargetRateLimitCounters defines the rate limit counters expired before the retention window.
+	// The counters are not linked to the users, hence the rule cannot be limited to a plan.
+	TargetRateLimitCounters = "rate_limit_counters"
+	// TargetOIDCStates defines the states of the sign-in with OIDC providers expired before the retention window.
+	// The states are not linked to the users, hence the rule cannot be limited to a plan.
+	TargetOIDCStates = "oidc_states"
+)
+
+// Plans of the users the retention rule applies to.
+const (
+	// PlanAll defines the rule applicable to all users.
+	PlanAll = ""
+	// PlanAnonym defines the rule applicable to anonym users.
+	PlanAnonym = "anonym"
+	// PlanFree defines the rule applicable to registered users on the free plan.
+	PlanFree = "free"
+	// PlanPremium defines the rule applicable to users on the premium plan.
+	PlanPremium = "premium"
+)
+
+// DefaultBatchSize defines the default max number of rows purged by a single statement.
+const DefaultBatchSize uint32 = 1000
+
+// Rule defines the retention window of the target's data for the users on the plan.
+type Rule struct {
+	Target string `json:"target"`
+	Plan   string `json:"plan,omitempty"`
+	Days   uint16 `json:"days"`
+}
+
+func (r Rule) validate() error {
+	switch r.Target {
+	case TargetPrompts, TargetModelPredictions, TargetModelPredictionsRaw, TargetSuccessfulRequests,
+		TargetFlaggedPrompts, TargetRefreshTokens:
+	case TargetRateLimitCounters, TargetOIDCStates:
+		if r.Plan != PlanAll {
+			return errors.New("retention of " + r.Target + " cannot be limited to a plan")
+		}
+	default:
+		return errors.New("unknown retention target " + r.Target)
+	}
+	switch r.Plan {
+	case PlanAll, PlanAnonym, PlanFree, PlanPremium:
+	default:
+		return errors.New("unknown plan " + r.Plan)
+	}
+	if r.Days == 0 {
+		return errors.New("retention window of " + r.Target + " must be positive")
+	}
+	return nil
+}
+
+// Policy defines the retention rules.
+// All rules are applied, hence the shortest window wins if several rules match the same data.
+type Policy []Rule
+
+// Validate validates the policy.
+func (p Policy) Validate() error {
+	seen := map[[2]string]struct{}{}
+	for _, r := range p {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		k := [2]string{r.Target, r.Plan}
+		if _, ok := seen[k]; ok {
+			return errors.New("duplicate retention rule for " + r.Target + " and plan '" + r.Plan + "'")
+		}
+		seen[k] = struct{}{}
+	}
+	return nil
+}
+
+// DefaultPolicy returns the policy to keep raw model responses for 30 days, prompts and flagged prompts
+// for 90 days, expired refresh tokens for 30 days, and expired rate limit counters and OIDC states for 1 day.
+func DefaultPolicy() Policy {
+	return Policy{
+		{Target: TargetModelPredictionsRaw, Days: 30},
+		{Target: TargetPrompts, Days: 90},
+		{Target: TargetFlaggedPrompts, Days: 90},
+		{Target: TargetRefreshTokens, Days: 30},
+		{Target: TargetRateLimitCounters, Days: 1},
+		{Target: TargetOIDCStates, Days: 1},
+	}
+}
+
+// Repository defines the storage to purge the data from.
+type Repository interface {
+	// PurgeBatch purges up to batchSize rows of the target's data created, or expired before the given time
+	// by the users on the plan. It returns the number of purged rows.
+	PurgeBatch(ctx context.Context, target, plan string, before time.Time, batchSize uint32) (int64, error)
+}
+
+// RuleReport defines the number of rows purged according to the rule.
+type RuleReport struct {
+	Rule
+	Before time.Time `json:"before"`
+	Purged int64     `json:"purged"`
+}
+
+// Report defines the purge results.
+type Report struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+	Rules     []RuleReport  `json:"rules"`
+	Purged    int64         `json:"purged"`
+}
+
+// String returns the report summary.
+func (r Report) String() string {
+	o := "purged " + strconv.FormatInt(r.Purged, 10) + " rows in " + r.Duration.String()
+	for _, el := range r.Rules {
+		o += "; " + el.Target
+		if el.Plan != "" {
+			o += "[" + el.Plan + "]"
+		}
+		o += ": " + strconv.FormatInt(el.Purged, 10)
+	}
+	return o
+}
+
+// Purger purges the data beyond the retention windows.
+type Purger struct {
+	repository Repository
+	policy     Policy
+	batchSize  uint32
+	now        func() time.Time
+}
+
+// NewPurger initialises the Purger. The DefaultBatchSize is used if batchSize is zero.
+func NewPurger(repository Repository, policy Policy, batchSize uint32) (*Purger, error) {
+	if repository == nil {
+		return nil, errors.New("retention repository is required")
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Purger{
+		repository: repository,
+		policy:     policy,
+		batchSize:  batchSize,
+		now:        func() time.Time { return time.Now().UTC() },
+	}, nil
+}
+
+// Purge purges the data according to the policy in batches until no rows beyond the retention windows are left.
+// The report includes the rows purged before the failure if an error occurs.
+func (p Purger) Purge(ctx context.Context) (o Report, err error) {
+	o = Report{StartedAt: p.now(), Rules: make([]RuleReport, 0, len(p.policy))}
+	defer func() { o.Duration = p.now().Sub(o.StartedAt) }()
+
+	for _, rule := range p.policy {
+		r := RuleReport{Rule: rule, Before: o.StartedAt.AddDate(0, 0, -int(rule.Days))}
+		for {
+			if err := ctx.Err(); err != nil {
+				o.Rules = append(o.Rules, r)
+				return o, err
+			}
+
+			n, err := p.repository.PurgeBatch(ctx, rule.Target, rule.Plan, r.Before, p.batchSize)
+			r.Purged += n
+			o.Purged += n
+			if err != nil {
+				o.Rules = append(o.Rules, r)
+				return o, err
+			}
+
+			if n < int64(p.batchSize) {
+				break
+			}
+		}
+		o.Rules = append(o.Rules, r)
+	}
+
+	return o, nil
+}
+
+// Run purges the data every interval until the context is cancelled.
+// The callback fn is called with the results of every purge.
+func (p Purger) Run(ctx context.Context, interval time.Duration, fn func(Report, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := p.Purge(ctx)
+			if fn != nil {
+				fn(report, err)
+			}
+		}
+	}
+}
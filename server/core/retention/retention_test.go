@@ -0,0 +1,305 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       Policy
+		wantErr bool
+	}{
+		{
+			name: "shall be valid",
+			p: Policy{
+				{Target: TargetPrompts, Days: 90},
+				{Target: TargetPrompts, Plan: PlanPremium, Days: 365},
+				{Target: TargetModelPredictionsRaw, Plan: PlanAnonym, Days: 1},
+				{Target: TargetModelPredictions, Plan: PlanFree, Days: 30},
+				{Target: TargetSuccessfulRequests, Days: 30},
+				{Target: TargetFlaggedPrompts, Plan: PlanAnonym, Days: 30},
+				{Target: TargetRefreshTokens, Days: 30},
+				{Target: TargetRateLimitCounters, Days: 1},
+				{Target: TargetOIDCStates, Days: 1},
+			},
+		},
+		{
+			name: "shall be valid: empty policy",
+		},
+		{
+			name:    "shall fail: unknown target",
+			p:       Policy{{Target: "foo", Days: 1}},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: unknown plan",
+			p:       Policy{{Target: TargetPrompts, Plan: "foo", Days: 1}},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: rate limit counters are not linked to the plan",
+			p:       Policy{{Target: TargetRateLimitCounters, Plan: PlanFree, Days: 1}},
+			wantErr: true,
+		},
+		{
+			name:    "shall fail: zero window",
+			p:       Policy{{Target: TargetPrompts}},
+			wantErr: true,
+		},
+		{
+			name: "shall fail: duplicate rule",
+			p: Policy{
+				{Target: TargetPrompts, Plan: PlanFree, Days: 1},
+				{Target: TargetPrompts, Plan: PlanFree, Days: 2},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.p.Validate(); (err != nil) != tt.wantErr {
+					t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+
+	t.Run(
+		"shall have valid default policy", func(t *testing.T) {
+			if err := DefaultPolicy().Validate(); err != nil {
+				t.Error(err)
+			}
+		},
+	)
+}
+
+func TestNewPurger(t *testing.T) {
+	t.Run(
+		"shall set default batch size", func(t *testing.T) {
+			p, err := NewPurger(&MockRepository{}, DefaultPolicy(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.batchSize != DefaultBatchSize {
+				t.Errorf("unexpected batch size: %d", p.batchSize)
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail without repository", func(t *testing.T) {
+			if _, err := NewPurger(nil, DefaultPolicy(), 0); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+
+	t.Run(
+		"shall fail for invalid policy", func(t *testing.T) {
+			if _, err := NewPurger(&MockRepository{}, Policy{{Target: "foo"}}, 0); err == nil {
+				t.Error("error expected")
+			}
+		},
+	)
+}
+
+func TestPurger_Purge(t *testing.T) {
+	now := time.Date(2023, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	newPurger := func(t *testing.T, repo Repository, policy Policy, batchSize uint32) *Purger {
+		t.Helper()
+		p, err := NewPurger(repo, policy, batchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.now = func() time.Time { return now }
+		return p
+	}
+
+	t.Run(
+		"shall purge in batches and report purged rows", func(t *testing.T) {
+			// GIVEN
+			repo := &MockRepository{
+				Rows: map[string]int64{
+					"model_predictions_raw/": 5,
+					"prompts/premium":        2,
+				},
+			}
+			p := newPurger(
+				t, repo, Policy{
+					{Target: TargetModelPredictionsRaw, Days: 30},
+					{Target: TargetPrompts, Plan: PlanPremium, Days: 365},
+				}, 2,
+			)
+
+			// WHEN
+			got, err := p.Purge(context.TODO())
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := Report{
+				StartedAt: now,
+				Rules: []RuleReport{
+					{
+						Rule:   Rule{Target: TargetModelPredictionsRaw, Days: 30},
+						Before: time.Date(2023, 4, 10, 0, 0, 0, 0, time.UTC),
+						Purged: 5,
+					},
+					{
+						Rule:   Rule{Target: TargetPrompts, Plan: PlanPremium, Days: 365},
+						Before: time.Date(2022, 5, 10, 0, 0, 0, 0, time.UTC),
+						Purged: 2,
+					},
+				},
+				Purged: 7,
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected report. want: %+v, got: %+v", want, got)
+			}
+
+			// 3 batches of raw responses: 2, 2, 1; and 2 batches of prompts: 2, 0
+			if len(repo.Calls) != 5 {
+				t.Errorf("unexpected number of batches: %d", len(repo.Calls))
+			}
+			for _, c := range repo.Calls {
+				if c.BatchSize != 2 {
+					t.Errorf("unexpected batch size: %d", c.BatchSize)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"shall report the purge duration", func(t *testing.T) {
+			// GIVEN
+			p := newPurger(t, &MockRepository{}, DefaultPolicy(), 0)
+			clock := now
+			p.now = func() time.Time {
+				o := clock
+				clock = clock.Add(time.Second)
+				return o
+			}
+
+			// WHEN
+			got, err := p.Purge(context.TODO())
+
+			// THEN
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.StartedAt != now || got.Duration != time.Second {
+				t.Errorf("unexpected report: %+v", got)
+			}
+		},
+	)
+
+	t.Run(
+		"shall report rows purged before the failure", func(t *testing.T) {
+			// GIVEN
+			repo := &MockRepository{Err: errors.New("foo")}
+			p := newPurger(t, repo, DefaultPolicy(), 0)
+
+			// WHEN
+			got, err := p.Purge(context.TODO())
+
+			// THEN
+			if err == nil {
+				t.Fatal("error expected")
+			}
+			if len(got.Rules) != 1 || got.Purged != 0 {
+				t.Errorf("unexpected report: %+v", got)
+			}
+			if len(repo.Calls) != 1 {
+				t.Errorf("purge is expected to stop after the failure")
+			}
+		},
+	)
+
+	t.Run(
+		"shall stop when the context is cancelled", func(t *testing.T) {
+			// GIVEN
+			repo := &MockRepository{}
+			p := newPurger(t, repo, DefaultPolicy(), 0)
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+
+			// WHEN
+			_, err := p.Purge(ctx)
+
+			// THEN
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if len(repo.Calls) != 0 {
+				t.Errorf("no batches are expected to be purged")
+			}
+		},
+	)
+}
+
+func TestPurger_Run(t *testing.T) {
+	// GIVEN
+	p, err := NewPurger(&MockRepository{}, DefaultPolicy(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	reports := make(chan Report, 1)
+
+	// WHEN
+	done := make(chan struct{})
+	go func() {
+		p.Run(
+			ctx, time.Millisecond, func(report Report, err error) {
+				if err == nil {
+					select {
+					case reports <- report:
+					default:
+					}
+				}
+			},
+		)
+		close(done)
+	}()
+
+	// THEN
+	select {
+	case r := <-reports:
+		if len(r.Rules) != len(DefaultPolicy()) {
+			t.Errorf("unexpected report: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Error("purge is expected to run periodically")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("run is expected to stop when the context is cancelled")
+	}
+}
+
+func TestReport_String(t *testing.T) {
+	r := Report{
+		Duration: time.Second,
+		Rules: []RuleReport{
+			{Rule: Rule{Target: TargetPrompts}, Purged: 1},
+			{Rule: Rule{Target: TargetModelPredictions, Plan: PlanFree}, Purged: 2},
+		},
+		Purged: 3,
+	}
+	const want = "purged 3 rows in 1s; prompts: 1; model_predictions[free]: 2"
+	if got := r.String(); got != want {
+		t.Errorf("unexpected summary. want: %s, got: %s", want, got)
+	}
+}
@@ -8,6 +8,8 @@ CREATE TABLE IF NOT EXISTS user_prompts
     timestamp  TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+CREATE INDEX IF NOT EXISTS ind_user_prompts_timestamp ON user_prompts (timestamp);
+
 CREATE TABLE IF NOT EXISTS openai_responses
 (
     request_id        UUID      NOT NULL PRIMARY KEY REFERENCES user_prompts (request_id),
@@ -20,6 +22,8 @@ CREATE TABLE IF NOT EXISTS openai_responses
     timestamp         TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+CREATE INDEX IF NOT EXISTS ind_openai_responses_timestamp ON openai_responses (timestamp);
+
 CREATE TABLE IF NOT EXISTS users
 (
     user_id         UUID      NOT NULL PRIMARY KEY,
@@ -27,6 +31,7 @@ CREATE TABLE IF NOT EXISTS users
     role            SMALLINT  NOT NULL,
     web_fingerprint TEXT,
     is_active       BOOLEAN   NOT NULL DEFAULT FALSE,
+    is_blocked      BOOLEAN   NOT NULL DEFAULT FALSE,
     is_premium      BOOLEAN   NOT NULL DEFAULT FALSE,
     created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
     update_at       TIMESTAMP NOT NULL DEFAULT NOW()
@@ -74,3 +79,72 @@ CREATE TABLE IF NOT EXISTS user_auth_secrets
     created_at TIMESTAMP NOT NULL
 )
 ;
+
+CREATE TABLE IF NOT EXISTS rate_limit_counters
+(
+    key          VARCHAR(200) NOT NULL,
+    window_start TIMESTAMP    NOT NULL,
+    counter      BIGINT       NOT NULL DEFAULT 0,
+    expires_at   TIMESTAMP    NOT NULL,
+    PRIMARY KEY (key, window_start)
+)
+;
+
+CREATE INDEX IF NOT EXISTS ind_rate_limit_counters_expires_at ON rate_limit_counters (expires_at);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    token_id   UUID      NOT NULL PRIMARY KEY,
+    family_id  UUID      NOT NULL,
+    user_id    UUID      NOT NULL REFERENCES users (user_id),
+    is_active  BOOLEAN   NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP NOT NULL
+)
+;
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at ON refresh_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS oidc_states
+(
+    state         VARCHAR(64)  NOT NULL PRIMARY KEY,
+    provider      VARCHAR(64)  NOT NULL,
+    nonce         VARCHAR(64)  NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    created_at    TIMESTAMP    NOT NULL DEFAULT now(),
+    expires_at    TIMESTAMP    NOT NULL
+)
+;
+
+CREATE INDEX IF NOT EXISTS oidc_states_expires_at ON oidc_states (expires_at);
+
+CREATE TABLE IF NOT EXISTS admin_audit_log
+(
+    id         BIGSERIAL NOT NULL PRIMARY KEY,
+    admin_id   UUID      NOT NULL REFERENCES users (user_id),
+    action     TEXT      NOT NULL,
+    user_id    UUID,
+    details    JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+)
+;
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_user_id ON admin_audit_log (user_id);
+
+CREATE TABLE IF NOT EXISTS flagged_prompts
+(
+    request_id UUID        NOT NULL PRIMARY KEY,
+    user_id    UUID        NOT NULL,
+    prompt     TEXT        NOT NULL,
+    reason     VARCHAR(32) NOT NULL,
+    detector   VARCHAR(32) NOT NULL,
+    policy     VARCHAR(16) NOT NULL,
+    timestamp  TIMESTAMP   NOT NULL DEFAULT now()
+)
+;
+
+CREATE INDEX IF NOT EXISTS flagged_prompts_user_id ON flagged_prompts (user_id);
+CREATE INDEX IF NOT EXISTS flagged_prompts_reason ON flagged_prompts (reason);
+CREATE INDEX IF NOT EXISTS flagged_prompts_timestamp ON flagged_prompts (timestamp);